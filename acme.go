@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"image"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
@@ -16,6 +19,8 @@ import (
 
 	"9fans.net/go/plumb"
 	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/draw/termdraw"
+	"github.com/rjkroege/edwood/draw/webdraw"
 	"github.com/rjkroege/edwood/dumpfile"
 	"github.com/rjkroege/edwood/theme"
 )
@@ -33,6 +38,12 @@ var (
 	ncol              = flag.Int("c", 2, "Number of columns at startup")
 	loadfile          = flag.String("l", "", "Load state from file generated with Dump command")
 	paletteName       = flag.String("palette", theme.DefaultPaletteName, "Colour palette name (acme, vampira)")
+	tuiflag           = flag.Bool("tui", false, "Run in the terminal instead of opening a graphical window")
+	recordfile        = flag.String("record", "", "Record mouse and keyboard input to this file")
+	replayfile        = flag.String("replay", "", "Replay input recorded with -record without a display and write the resulting dump to standard output")
+	webaddr           = flag.String("web", "", "Serve a browser front end on this loopback address (e.g. localhost:8080) instead of opening a graphical window")
+	plumbingfile      = flag.String("plumbing", "", "Plumbing rules used when no plumber is running (default $HOME/lib/plumbing)")
+	lspfile           = flag.String("lsp", "", "Language servers to start for files (default $HOME/lib/lsp)")
 )
 
 func predrawInit() *dumpfile.Content {
@@ -79,23 +90,68 @@ func mainWithDisplay(g *globals, dump *dumpfile.Content, display draw.Display) {
 		log.Fatalf("failed to attach to window %v\n", err)
 	}
 
+	g.row.lk.Lock()
+	g.completion = nil // drawn over below
+	g.row.lk.Unlock()
 	display.ScreenImage().Draw(display.ScreenImage().R(), display.White(), nil, image.Point{})
 
 	g.mousectl = display.InitMouse()
-	g.mouse = &g.mousectl.Mouse
 	g.keyboardctl = display.InitKeyboard()
+	if *recordfile != "" {
+		f, err := os.Create(*recordfile)
+		if err != nil {
+			log.Fatalf("can't record input: %v\n", err)
+		}
+		recordInput(g, display, f)
+	}
+	g.mouse = &g.mousectl.Mouse
 
 	g.iconinit(display)
 
 	startplumbing()
+	loadlsp()
 	fs := fsysinit()
 
+	g.initrow(dump, display)
+	display.Flush()
+
+	// After row is initialized
+	// TODO(rjk): put the globals *in* the ctx?
+	ctx := context.Background()
+	go mousethread(g, display)
+	go keyboardthread(g, display)
+	go waitthread(g, ctx)
+	go newwindowthread(g)
+	go xfidallocthread(g, ctx, display)
+
+	signal.Ignore(ignoreSignals...)
+	signal.Notify(g.csignal, hangupSignals...)
+
+	select {
+	case <-g.cexit:
+		// Do nothing.
+	case <-g.csignal:
+		g.row.lk.Lock()
+		g.row.Dump("")
+		g.row.lk.Unlock()
+	}
+	killprocs(fs)
+	if c, ok := display.(io.Closer); ok {
+		c.Close()
+	}
+	os.Exit(0)
+}
+
+// initrow lays out the row: restored from dump if there is one (from -l),
+// otherwise with empty columns holding the files named on the command
+// line.
+func (g *globals) initrow(dump *dumpfile.Content, display draw.Display) {
 	const WindowsPerCol = 6
 
 	g.row.Init(display.ScreenImage().R(), display)
 
 	// TODO(rjk): Can pull this out into a helper function?
-	if *loadfile == "" || g.row.Load(dump, *loadfile, true) != nil {
+	if dump == nil || g.row.Load(dump, *loadfile, true) != nil {
 		// Open the files from the command line, up to WindowsPerCol each
 		files := flag.Args()
 		if *ncol < 0 {
@@ -128,30 +184,6 @@ func mainWithDisplay(g *globals, dump *dumpfile.Content, display draw.Display) {
 			}
 		}
 	}
-	display.Flush()
-
-	// After row is initialized
-	// TODO(rjk): put the globals *in* the ctx?
-	ctx := context.Background()
-	go mousethread(g, display)
-	go keyboardthread(g, display)
-	go waitthread(g, ctx)
-	go newwindowthread(g)
-	go xfidallocthread(g, ctx, display)
-
-	signal.Ignore(ignoreSignals...)
-	signal.Notify(g.csignal, hangupSignals...)
-
-	select {
-	case <-g.cexit:
-		// Do nothing.
-	case <-g.csignal:
-		g.row.lk.Lock()
-		g.row.Dump("")
-		g.row.lk.Unlock()
-	}
-	killprocs(fs)
-	os.Exit(0)
 }
 
 // paletteFromDump returns a Palette from the dump file if one was saved,
@@ -171,6 +203,33 @@ func paletteFromDump(dump *dumpfile.Content, name string) theme.Palette {
 
 func main() {
 	dump := predrawInit()
+	if *replayfile != "" {
+		replayMain(global, dump, *replayfile)
+		return
+	}
+	if *tuiflag {
+		display, err := termdraw.Open(os.Stdin)
+		if err != nil {
+			log.Fatalf("can't open terminal display: %v\n", err)
+		}
+		global.palette = paletteFromDump(dump, *paletteName)
+		mainWithDisplay(global, dump, display)
+		return
+	}
+	if *webaddr != "" {
+		display := webdraw.NewDisplay(image.Rect(0, 0, 1024, 768))
+		ln, err := listenLoopback(*webaddr)
+		if err != nil {
+			log.Fatalf("can't listen on %s: %v\n", *webaddr, err)
+		}
+		log.Printf("serving on http://%s/", ln.Addr())
+		go func() {
+			log.Fatal(http.Serve(ln, display))
+		}()
+		global.palette = paletteFromDump(dump, *paletteName)
+		mainWithDisplay(global, dump, display)
+		return
+	}
 	// Make the display here in the wrapper to make it possible to provide a
 	// different display for testing.
 	// Create the display within the closure to ensure proper scope
@@ -246,31 +305,39 @@ func mousethread(g *globals, display draw.Display) {
 		display.Flush()
 		select {
 		case <-g.mousectl.Resize:
-			if err := display.Attach(draw.Refnone); err != nil {
-				panic("failed to attach to window")
-			}
-			display.ScreenImage().Draw(display.ScreenImage().R(), display.White(), nil, image.Point{})
-			// TODO(rjk): We appear to have already done this.
-			g.iconinit(display)
-			ScrlResize(display)
-			g.row.Resize(display.ScreenImage().R())
+			g.resize(display)
 		case g.mousectl.Mouse = <-g.mousectl.C:
 			MovedMouse(g, g.mousectl.Mouse)
 		case <-g.cwarn:
 			// Do nothing
+		case o := <-g.coutline:
+			g.row.lk.Lock()
+			o.refresh()
+			g.row.lk.Unlock()
 		case pm := <-g.cplumb:
-			if pm.Type == "text" {
-				act := findattr(pm.Attr, "action")
-				if act == "" || act == "showfile" {
-					plumblook(pm)
-				} else if act == "showdata" {
-					plumbshow(pm)
-				}
-			}
+			plumbrecv(pm)
 		}
 	}
 }
 
+// resize redraws everything after the display has changed size.
+func (g *globals) resize(display draw.Display) {
+	if err := display.Attach(draw.Refnone); err != nil {
+		panic("failed to attach to window")
+	}
+	if g.recorder != nil {
+		g.recorder.record(screenEvent("resize", display))
+	}
+	g.row.lk.Lock()
+	g.completion = nil // drawn over below
+	g.row.lk.Unlock()
+	display.ScreenImage().Draw(display.ScreenImage().R(), display.White(), nil, image.Point{})
+	// TODO(rjk): We appear to have already done this.
+	g.iconinit(display)
+	ScrlResize(display)
+	g.row.Resize(display.ScreenImage().R())
+}
+
 func findattr(attr *plumb.Attribute, s string) string {
 	for attr != nil {
 		if attr.Name == s {
@@ -285,6 +352,10 @@ func MovedMouse(g *globals, m draw.Mouse) {
 	g.row.lk.Lock()
 	defer g.row.lk.Unlock()
 
+	if g.completion != nil && m.Buttons != 0 && g.completion.Mouse(&m) {
+		return
+	}
+
 	t := g.row.Which(m.Point)
 
 	if t != g.mousetext && t != nil && t.w != nil &&
@@ -418,15 +489,8 @@ func keyboardthread(g *globals, display draw.Display) {
 			}
 		case r := <-g.keyboardctl.C:
 			for {
-				typetext = g.row.Type(r, g.mouse.Point)
+				typetext = g.typekey(r)
 				t = typetext
-				if t != nil && t.col != nil && !(r == draw.KeyDown || r == draw.KeyLeft || r == draw.KeyRight) { // scrolling doesn't change activecol
-					g.activecol = t.col
-				}
-				if t != nil && t.w != nil {
-					// In a set of zeroxes, the last typed-in body becomes the currobserver.
-					t.w.body.file.SetCurObserver(&t.w.body)
-				}
 				if timer != nil {
 					timer.Stop()
 				}
@@ -450,6 +514,33 @@ func keyboardthread(g *globals, display draw.Display) {
 
 }
 
+// typekey delivers the keyboard rune r to the text under the mouse and
+// returns that text.
+func (g *globals) typekey(r rune) *Text {
+	t := g.row.Type(r, g.mouse.Point)
+	if t != nil && t.col != nil && !(r == draw.KeyDown || r == draw.KeyLeft || r == draw.KeyRight) { // scrolling doesn't change activecol
+		g.activecol = t.col
+	}
+	if t != nil && t.w != nil {
+		// In a set of zeroxes, the last typed-in body becomes the currobserver.
+		t.w.body.file.SetCurObserver(&t.w.body)
+	}
+	return t
+}
+
+// readmouse flushes the display and waits for the next mouse event.
+// Mousectl.Read does the same but only works with devdraw.
+func (g *globals) readmouse() draw.Mouse {
+	g.row.display.Flush()
+	g.mousectl.Mouse = <-g.mousectl.C
+	return g.mousectl.Mouse
+}
+
+// logexit adds the exit of command c, with status w, to the log file.
+func logexit(c *Command, w ProcessState) {
+	xfidlogf(c.winid, "exit", fmt.Sprintf("%d %d %s", c.pid, w.ExitCode(), c.text))
+}
+
 func waitthread(g *globals, ctx context.Context) {
 	// There is a race between process exiting and our finding out it was ever created.
 	// This structure keeps a list of processes that have exited we haven't heard of.
@@ -515,16 +606,19 @@ func waitthread(g *globals, ctx context.Context) {
 					warning(c.md, "%s: %s\n", c.name, w.String())
 				}
 				g.row.display.Flush()
+				logexit(c, w)
 			}
 			g.row.lk.Unlock()
 			Freecmd(c)
 
 		case c := <-g.ccommand:
 			// has this command already exited?
+			xfidlogf(c.winid, "exec", fmt.Sprintf("%d %s", c.pid, c.text))
 			if p, ok := exited[c.pid]; ok {
 				if msg := p.String(); msg != "" {
 					warning(c.md, "%s\n", msg)
 				}
+				logexit(c, p)
 				delete(exited, c.pid)
 				Freecmd(c)
 				break
@@ -542,9 +636,7 @@ func waitthread(g *globals, ctx context.Context) {
 }
 
 // maintain a linked list of Xfid
-// TODO(flux): It would be more idiomatic to prep one up front, and block on sending
-// it instead of using a send and a receive to get one.
-// Frankly, it would be more idiomatic to let the GC take care of them,
+// TODO(flux): It would be more idiomatic to let the GC take care of them,
 // though that would require an exit signal in xfidctl.
 func xfidallocthread(g *globals, ctx context.Context, d draw.Display) {
 	xfree := (*Xfid)(nil)
@@ -552,7 +644,7 @@ func xfidallocthread(g *globals, ctx context.Context, d draw.Display) {
 		select {
 		case <-ctx.Done():
 			return
-		case <-g.cxfidalloc:
+		case c := <-g.cxfidalloc:
 			x := xfree
 			if x != nil {
 				xfree = x.next
@@ -561,7 +653,7 @@ func xfidallocthread(g *globals, ctx context.Context, d draw.Display) {
 				x.c = make(chan func(*Xfid))
 				go xfidctl(x, d)
 			}
-			g.cxfidalloc <- x
+			c <- x
 		case x := <-g.cxfidfree:
 			x.next = xfree
 			xfree = x
@@ -570,6 +662,14 @@ func xfidallocthread(g *globals, ctx context.Context, d draw.Display) {
 
 }
 
+// allocxfid returns an Xfid from xfidallocthread. Each request carries its
+// own reply channel, so any number of file servers may ask at once.
+func allocxfid() *Xfid {
+	c := make(chan *Xfid, 1)
+	global.cxfidalloc <- c
+	return <-c
+}
+
 func newwindowthread(g *globals) {
 	var w *Window
 
@@ -611,6 +711,7 @@ const MAXSNARF = 10 * 1024
 
 func acmeputsnarf() {
 	global.row.display.WriteSnarf(global.snarfbuf)
+	snarfwatch.changed()
 }
 
 func acmegetsnarf() {
@@ -627,7 +728,7 @@ func acmegetsnarf() {
 		return
 	}
 	if n < len(b) && n == sz {
-		global.snarfbuf = b[0:n]
+		gotsnarf(b[0:n])
 		return
 	}
 
@@ -640,5 +741,5 @@ func acmegetsnarf() {
 	}
 
 	// Trim it: it might have shortened.
-	global.snarfbuf = b[0:n]
+	gotsnarf(b[0:n])
 }
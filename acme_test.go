@@ -247,3 +247,9 @@ func (ps *mockProcessState) String() string {
 	return fmt.Sprintf("pid %v, success %v", ps.pid, ps.success)
 }
 func (ps *mockProcessState) Success() bool { return ps.success }
+func (ps *mockProcessState) ExitCode() int {
+	if ps.success {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/file"
+)
+
+// maxChanges is the number of changes kept for clients that resume
+// reading the changes file.
+const maxChanges = 4096
+
+// change is one insertion or deletion in a window's body as read from
+// the fsys's acme/<id>/changes pseudo-file.
+type change struct {
+	Seq  int    `json:"seq"`
+	Op   string `json:"op"` // insert or delete
+	Q0   int    `json:"q0"` // rune offsets
+	Q1   int    `json:"q1"`
+	B0   int    `json:"b0"` // byte offsets
+	B1   int    `json:"b1"`
+	Text string `json:"text,omitempty"`
+}
+
+// changeFeed holds the changes made to a window's body since its changes
+// file was first opened. Each change is a line of JSON numbered from 1.
+// Every open changes file reads from its own position in the feed; a
+// client can reposition it by writing a sequence number, to resume
+// after reconnecting.
+type changeFeed struct {
+	lk sync.Mutex
+	r  sync.Cond
+
+	start   int // changes[0] has sequence number start
+	changes []string
+	closed  bool
+
+	// active (blocked) reads waiting for changes
+	read []*Xfid
+}
+
+func newChangeFeed() *changeFeed {
+	cf := &changeFeed{start: 1}
+	cf.r.L = &cf.lk
+	return cf
+}
+
+// next returns the sequence number of the next change.
+func (cf *changeFeed) next() int {
+	return cf.start + len(cf.changes)
+}
+
+func (cf *changeFeed) add(c *change) {
+	cf.lk.Lock()
+	defer cf.lk.Unlock()
+	if cf.closed {
+		return
+	}
+	if len(cf.changes) >= maxChanges {
+		n := len(cf.changes) - maxChanges + 1
+		cf.start += n
+		cf.changes = append(cf.changes[:0], cf.changes[n:]...)
+	}
+	c.Seq = cf.next()
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(fmt.Sprintf("can't marshal change: %v", err))
+	}
+	cf.changes = append(cf.changes, string(b)+"\n")
+	cf.r.Broadcast()
+}
+
+// Inserted records the insertion of b at q0. It is called from the
+// window body's BufferObserver implementation.
+func (cf *changeFeed) Inserted(q0 file.OffsetTuple, b []byte, nr int) {
+	cf.add(&change{
+		Op:   "insert",
+		Q0:   q0.R,
+		Q1:   q0.R + nr,
+		B0:   q0.B,
+		B1:   q0.B + len(b),
+		Text: string(b),
+	})
+}
+
+// Deleted records the deletion of [q0, q1).
+func (cf *changeFeed) Deleted(q0, q1 file.OffsetTuple) {
+	cf.add(&change{
+		Op: "delete",
+		Q0: q0.R,
+		Q1: q1.R,
+		B0: q0.B,
+		B1: q1.B,
+	})
+}
+
+// close wakes the readers and makes further reads fail.
+func (cf *changeFeed) close() {
+	cf.lk.Lock()
+	defer cf.lk.Unlock()
+	cf.closed = true
+	cf.r.Broadcast()
+}
+
+// xfidchangesopen starts the feed of w's changes if needed. The new
+// fid reads from the next change.
+func xfidchangesopen(x *Xfid, w *Window) {
+	if w.changes == nil {
+		w.changes = newChangeFeed()
+	}
+	cf := w.changes
+	cf.lk.Lock()
+	defer cf.lk.Unlock()
+	x.f.changeseq = cf.next()
+	x.f.changeoff = 0
+}
+
+// xfidchangesread reads changes, blocking until there is one to read.
+// A change longer than the read count continues in the next read.
+func xfidchangesread(x *Xfid, cf *changeFeed) {
+	var fc plan9.Fcall
+	cf.lk.Lock()
+	defer cf.lk.Unlock()
+
+	cf.read = append(cf.read, x)
+	x.flushed = false
+	for x.f.changeseq >= cf.next() && !x.flushed && !cf.closed {
+		cf.r.Wait()
+	}
+	for i, rx := range cf.read {
+		if rx == x {
+			cf.read[i] = cf.read[len(cf.read)-1]
+			cf.read = cf.read[:len(cf.read)-1]
+			break
+		}
+	}
+
+	switch {
+	case x.flushed:
+		return
+	case cf.closed:
+		x.respond(&fc, fmt.Errorf("window shut down"))
+		return
+	case x.f.changeseq < cf.start:
+		err := fmt.Errorf("changes %d to %d are lost", x.f.changeseq, cf.start-1)
+		x.f.changeseq, x.f.changeoff = cf.start, 0
+		x.respond(&fc, err)
+		return
+	}
+
+	var b []byte
+	for len(b) < int(x.fcall.Count) && x.f.changeseq < cf.next() {
+		s := cf.changes[x.f.changeseq-cf.start][x.f.changeoff:]
+		n := min(len(s), int(x.fcall.Count)-len(b))
+		b = append(b, s[:n]...)
+		if n == len(s) {
+			x.f.changeseq++
+			x.f.changeoff = 0
+		} else {
+			x.f.changeoff += n
+		}
+	}
+	fc.Data = b
+	fc.Count = uint32(len(b))
+	x.respond(&fc, nil)
+}
+
+// xfidchangeswrite repositions the fid to read from the change with the
+// sequence number written.
+func xfidchangeswrite(x *Xfid, cf *changeFeed) {
+	var fc plan9.Fcall
+	seq, err := strconv.Atoi(strings.TrimSpace(string(x.fcall.Data)))
+	if err != nil {
+		x.respond(&fc, ErrBadCtl)
+		return
+	}
+
+	cf.lk.Lock()
+	defer cf.lk.Unlock()
+	if seq < cf.start || seq > cf.next() {
+		x.respond(&fc, fmt.Errorf("change %d is not available; have %d to %d", seq, cf.start, cf.next()-1))
+		return
+	}
+	x.f.changeseq, x.f.changeoff = seq, 0
+	fc.Count = x.fcall.Count
+	x.respond(&fc, nil)
+}
+
+func xfidchangesflush(x *Xfid, cf *changeFeed) {
+	cf.lk.Lock()
+	defer cf.lk.Unlock()
+	for _, rx := range cf.read {
+		if rx.fs == x.fs && rx.fcall.Tag == x.fcall.Oldtag {
+			rx.flushed = true
+			cf.r.Broadcast()
+		}
+	}
+}
+
+// xfidchangeshangup ends the reads left blocked by a client of fs that
+// went away.
+func xfidchangeshangup(fs *fileServer, cf *changeFeed) {
+	cf.lk.Lock()
+	defer cf.lk.Unlock()
+	for _, rx := range cf.read {
+		if rx.fs == fs {
+			rx.flushed = true
+			cf.r.Broadcast()
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"9fans.net/go/plan9"
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/file"
+)
+
+func parseChanges(t *testing.T, s string) []change {
+	t.Helper()
+	var changes []change
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		var c change
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+			t.Fatalf("bad change %q: %v", sc.Text(), err)
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func TestChanges(t *testing.T) {
+	FlexiblyMakeWindowScaffold(t, ScWin("file"), ScBody("file", "héllo\n"))
+	w := global.row.col[0].w[0]
+
+	f := openFid(w, QWchanges)
+	w.body.Insert(6, []rune("wörld\n"), true)
+	w.body.Delete(1, 3, true)
+
+	want := []change{
+		{Seq: 1, Op: "insert", Q0: 6, Q1: 12, B0: 7, B1: 14, Text: "wörld\n"},
+		{Seq: 2, Op: "delete", Q0: 1, Q1: 3, B0: 1, B1: 4},
+	}
+	s, err := readFid(f, 0, 8192)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if diff := cmp.Diff(want, parseChanges(t, s)); diff != "" {
+		t.Errorf("changes mismatch (-want +got):\n%s", diff)
+	}
+
+	// A change continues across short reads.
+	f2 := openFid(w, QWchanges)
+	if err := writeFid(f2, 0, "1\n"); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	var sb strings.Builder
+	for sb.Len() < len(s) {
+		b, err := readFid(f2, 0, 10)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if len(b) > 10 {
+			t.Fatalf("read %d bytes; want at most 10", len(b))
+		}
+		sb.WriteString(b)
+	}
+	if got := sb.String(); got != s {
+		t.Errorf("short reads got %q; want %q", got, s)
+	}
+
+	// Resume from the second change.
+	if err := writeFid(f2, 0, "2"); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	s, _ = readFid(f2, 0, 8192)
+	if diff := cmp.Diff(want[1:], parseChanges(t, s)); diff != "" {
+		t.Errorf("resumed changes mismatch (-want +got):\n%s", diff)
+	}
+	for _, seq := range []string{"0", "4", "x"} {
+		if err := writeFid(f2, 0, seq); err == nil {
+			t.Errorf("resuming from %q succeeded", seq)
+		}
+	}
+
+	// Reads wait for the next change.
+	done := make(chan string)
+	go func() {
+		s, _ := readFid(f, 0, 8192)
+		done <- s
+	}()
+	time.Sleep(10 * time.Millisecond)
+	w.Lock('E')
+	w.body.Insert(0, []rune("x"), true)
+	w.Unlock()
+	select {
+	case s := <-done:
+		want := []change{{Seq: 3, Op: "insert", Q1: 1, B1: 1, Text: "x"}}
+		if diff := cmp.Diff(want, parseChanges(t, s)); diff != "" {
+			t.Errorf("waiting read mismatch (-want +got):\n%s", diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read did not return")
+	}
+
+	// Deleting the window ends reads.
+	go func() {
+		_, err := readFid(f, 0, 8192)
+		done <- err.Error()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	w.Delete()
+	if got, want := <-done, "window shut down"; got != want {
+		t.Errorf("read after delete got %q; want %q", got, want)
+	}
+}
+
+func TestChangesLost(t *testing.T) {
+	cf := newChangeFeed()
+	f := &Fid{changeseq: 1}
+	for i := 0; i < maxChanges+2; i++ {
+		cf.Deleted(file.OffsetTuple{}, file.OffsetTuple{B: 1, R: 1})
+	}
+	mr := new(mockResponder)
+	xfidchangesread(&Xfid{f: f, fcall: plan9.Fcall{Count: 100}, fs: mr}, cf)
+	if mr.err == nil || mr.err.Error() != "changes 1 to 2 are lost" {
+		t.Errorf("got error %v; want changes lost", mr.err)
+	}
+	xfidchangesread(&Xfid{f: f, fcall: plan9.Fcall{Count: 100}, fs: mr}, cf)
+	line, _, _ := strings.Cut(string(mr.fcall.Data), "\n")
+	if got := parseChanges(t, line); len(got) != 1 || got[0].Seq != 3 {
+		t.Errorf("read after loss got %v; want change 3", got)
+	}
+}
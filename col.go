@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"image"
 	"log"
 	"sort"
@@ -510,41 +511,25 @@ Pack:
 }
 
 func (c *Column) DragWin(w *Window, but int) {
-	var (
-		r      image.Rectangle
-		i, b   int
-		p, op  image.Point
-		v, win *Window
-		nc     *Column
-	)
 	clearmouse()
 	c.display.SetCursor(&boxcursor)
-	b = global.mouse.Buttons
-	op = global.mouse.Point
+	b := global.mouse.Buttons
+	op := global.mouse.Point
 	for global.mouse.Buttons == b {
-		global.mousectl.Read()
+		global.readmouse()
 	}
 	c.display.SetCursor(nil)
 	if global.mouse.Buttons != 0 {
 		for global.mouse.Buttons != 0 {
-			global.mousectl.Read()
+			global.readmouse()
 		}
 		return
 	}
 
-	// Make sure our window was in our column
-	for i, win = range c.w {
-		if win == w {
-			goto Found
-		}
-	}
-	log.Panicf("acme: %s: %v\n", "can't find window", nil)
-
-Found:
 	if w.tagexpand { // force recomputation of window tag size
 		w.taglines = 1
 	}
-	p = global.mouse.Point
+	p := global.mouse.Point
 	if max(p.X-op.X, -(p.X-op.X)) < 5 && max(p.Y-op.Y, -(p.Y-op.Y)) < 5 {
 		c.Grow(w, but)
 		w.MouseBut()
@@ -554,35 +539,68 @@ Found:
 	if max(p.Y-op.Y, -(p.Y-op.Y)) < 10 && p.X > op.X+30 && c.row.WhichCol(p) == c {
 		p.X = op.X + w.r.Dx() // yes: toss to next column
 	}
-	nc = c.row.WhichCol(p)
+	nc := c.row.WhichCol(p)
 	if nc != nil && nc != c {
 		c.Close(w, false)
 		nc.Add(w, nil, p.Y)
+		nc.logmove(w)
 		w.MouseBut()
 		return
 	}
+	if c.MoveWin(w, p.Y) {
+		w.MouseBut()
+	}
+}
+
+// logmove logs that w was moved into c.
+func (c *Column) logmove(w *Window) {
+	if c.row != nil {
+		xfidlogf(w.id, "move", fmt.Sprintf("%d %s", c.row.colindex(c), w.body.file.Name()))
+	}
+}
+
+// MoveWin moves the top of window w to y as if its tag had been dragged
+// there within the column. A window moved past one of its neighbours is
+// shuffled into its new place. MoveWin reports whether the layout
+// changed.
+func (c *Column) MoveWin(w *Window, y int) bool {
+	var (
+		r   image.Rectangle
+		i   int
+		v   *Window
+		win *Window
+	)
+	// Make sure our window was in our column
+	for i, win = range c.w {
+		if win == w {
+			goto Found
+		}
+	}
+	log.Panicf("acme: %s: %v\n", "can't find window", nil)
+
+Found:
 	if i == 0 && len(c.w) == 1 {
-		return // can't do it
+		return false // can't do it
 	}
-	if (i > 0 && p.Y < c.w[i-1].r.Min.Y) || (i < len(c.w)-1 && p.Y > w.r.Max.Y || (i == 0 && p.Y > w.r.Max.Y)) {
+	if (i > 0 && y < c.w[i-1].r.Min.Y) || (i < len(c.w)-1 && y > w.r.Max.Y || (i == 0 && y > w.r.Max.Y)) {
 		// shuffle
 		c.Close(w, false)
-		c.Add(w, nil, p.Y)
-		w.MouseBut()
-		return
+		c.Add(w, nil, y)
+		c.logmove(w)
+		return true
 	}
 	if i == 0 {
-		return
+		return false
 	}
 	v = c.w[i-1]
-	if p.Y < v.tagtop.Max.Y {
-		p.Y = v.tagtop.Max.Y
+	if y < v.tagtop.Max.Y {
+		y = v.tagtop.Max.Y
 	}
-	if p.Y > w.r.Max.Y-w.tagtop.Dy()-c.row.display.ScaleSize(Border) {
-		p.Y = w.r.Max.Y - w.tagtop.Dy() - c.row.display.ScaleSize(Border)
+	if y > w.r.Max.Y-w.tagtop.Dy()-c.row.display.ScaleSize(Border) {
+		y = w.r.Max.Y - w.tagtop.Dy() - c.row.display.ScaleSize(Border)
 	}
 	r = v.r
-	r.Max.Y = p.Y
+	r.Max.Y = y
 	if r.Max.Y > v.body.fr.Rect().Min.Y {
 		r.Max.Y -= (r.Max.Y - v.body.fr.Rect().Min.Y) % v.body.fr.DefaultFontHeight()
 		if v.body.fr.Rect().Min.Y == v.body.fr.Rect().Max.Y {
@@ -600,7 +618,31 @@ Found:
 	}
 	w.Resize(r, c.safe, true)
 	c.safe = true
-	w.MouseBut()
+	return true
+}
+
+// SetLines resizes window w to show n lines of its body. The space is
+// taken from, or given to, the window below or, for the last window in
+// the column, the window above. SetLines reports whether the layout
+// changed.
+func (c *Column) SetLines(w *Window, n int) bool {
+	i := 0
+	for i < len(c.w) && c.w[i] != w {
+		i++
+	}
+	if i == len(c.w) {
+		log.Panicf("acme: %s: %v\n", "can't find window", nil)
+	}
+	h := w.body.fr.DefaultFontHeight()
+	if i < len(c.w)-1 {
+		v := c.w[i+1]
+		return c.MoveWin(v, min(w.body.fr.Rect().Min.Y+n*h, v.r.Max.Y))
+	}
+	if i == 0 {
+		return false
+	}
+	y := c.r.Max.Y - (w.body.fr.Rect().Min.Y - w.r.Min.Y) - n*h
+	return c.MoveWin(w, max(y, c.w[i-1].r.Min.Y))
 }
 
 func (c *Column) Which(p image.Point) *Text {
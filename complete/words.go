@@ -0,0 +1,60 @@
+package complete
+
+import (
+	"sort"
+	"strings"
+)
+
+// Words collects candidate words for word completion. Each word carries
+// a score, the sum of the weights of its occurrences, so that words that
+// are frequent or close to the point of completion rank first.
+type Words struct {
+	score map[string]float64
+}
+
+// NewWords returns an empty set of candidate words.
+func NewWords() *Words {
+	return &Words{score: make(map[string]float64)}
+}
+
+// Add records an occurrence of word with the given weight.
+func (ws *Words) Add(word string, weight float64) {
+	ws.score[word] += weight
+}
+
+// Complete implements word completion. Given a word prefix s, it returns
+// an analysis of the collected words that begin with s, other than s
+// itself. Unlike file name completion, a complete match is not suffixed
+// with a blank, and when nothing matches Filename is empty.
+//
+// Filename holds the matching words in rank order: highest score first,
+// with ties broken alphabetically.
+func (ws *Words) Complete(s string) *Completion {
+	var name []string
+	for w := range ws.score {
+		if len(w) > len(s) && strings.HasPrefix(w, s) {
+			name = append(name, w)
+		}
+	}
+	sort.Slice(name, func(i, j int) bool {
+		si, sj := ws.score[name[i]], ws.score[name[j]]
+		if si != sj {
+			return si > sj
+		}
+		return name[i] < name[j]
+	})
+
+	var c Completion
+	if len(name) > 0 {
+		minlen := len(name[0])
+		for i := 1; i < len(name); i++ {
+			minlen = longestPrefixLength(name[0], name[i], min(minlen, len(name[i])))
+		}
+		c.Complete = len(name) == 1
+		c.Advance = c.Complete || minlen > len(s)
+		c.String = name[0][len(s):minlen]
+	}
+	c.NMatch = len(name)
+	c.Filename = name
+	return &c
+}
@@ -0,0 +1,31 @@
+package complete
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	ws := NewWords()
+	for _, w := range []string{"alpha", "alphabet", "alphabet", "alphanumeric", "beta", "ålpha", "ålphabet"} {
+		ws.Add(w, 1)
+	}
+	ws.Add("alphanumeric", 0.5)
+
+	for _, tc := range []struct {
+		s string
+		c Completion
+	}{
+		{"al", Completion{Advance: true, Complete: false, String: "pha", NMatch: 3, Filename: []string{"alphabet", "alphanumeric", "alpha"}}},
+		{"alpha", Completion{Advance: false, Complete: false, String: "", NMatch: 2, Filename: []string{"alphabet", "alphanumeric"}}},
+		{"alphan", Completion{Advance: true, Complete: true, String: "umeric", NMatch: 1, Filename: []string{"alphanumeric"}}},
+		{"ål", Completion{Advance: true, Complete: false, String: "pha", NMatch: 2, Filename: []string{"ålpha", "ålphabet"}}},
+		{"beta", Completion{NMatch: 0}},
+		{"gamma", Completion{NMatch: 0}},
+	} {
+		c := ws.Complete(tc.s)
+		if !reflect.DeepEqual(*c, tc.c) {
+			t.Errorf("Complete of %q is %#v; expected %#v\n", tc.s, c, tc.c)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"image"
+
+	"github.com/rjkroege/edwood/draw"
+)
+
+// maxPopupLines bounds the number of candidates a completion popup shows
+// at once. It scrolls to show the others.
+const maxPopupLines = 10
+
+// completionPopup is a list of completion candidates drawn near the tick
+// of a Text. Choosing a candidate replaces the text between q0 and q1
+// with it. There's at most one popup, global.completion, and it's only
+// accessed with the row lock held.
+type completionPopup struct {
+	t      *Text
+	q0, q1 int
+	items  []string
+	sel    int // selected item
+	top    int // first item shown
+	nline  int // items shown
+	r      image.Rectangle
+}
+
+// popupCompletion shows items, candidates to replace the text between q0
+// and q1, in a popup near the tick of t. It reports false if the popup
+// can't be shown, in which case the caller should list them elsewhere.
+func (t *Text) popupCompletion(q0, q1 int, items []string) bool {
+	if global.completion != nil {
+		global.completion.Close()
+	}
+	if t.display == nil || t.fr == nil || len(items) == 0 {
+		return false
+	}
+	p := &completionPopup{t: t, q0: q0, q1: q1, items: items}
+	if !p.layout() {
+		return false
+	}
+	global.completion = p
+	p.draw()
+	return true
+}
+
+// layout positions the popup under the line holding the tick or, if
+// there is more room there, above it. It reports false if there is no
+// room for even one candidate.
+func (p *completionPopup) layout() bool {
+	t := p.t
+	font := t.getfont()
+	h := font.Height()
+	border := t.display.ScaleSize(1)
+	pad := t.display.ScaleSize(4)
+	fr := t.fr.Rect()
+
+	w := 0
+	for _, s := range p.items {
+		w = max(w, font.StringWidth(s))
+	}
+	w = min(w+2*(pad+border), fr.Dx())
+
+	pt := t.fr.Ptofchar(max(p.q0, t.org) - t.org)
+	below := fr.Max.Y - (pt.Y + h) - 2*border
+	above := pt.Y - fr.Min.Y - 2*border
+	n := min(len(p.items), maxPopupLines)
+	y := pt.Y + h
+	if n*h > below && above > below {
+		n = min(n, above/h)
+		y = pt.Y - n*h - 2*border
+	} else {
+		n = min(n, below/h)
+	}
+	if n <= 0 || w <= 2*(pad+border) {
+		return false
+	}
+	x := min(pt.X, fr.Max.X-w)
+	p.nline = n
+	p.r = image.Rect(x, y, x+w, y+n*h+2*border)
+	return true
+}
+
+func (p *completionPopup) draw() {
+	t := p.t
+	screen := t.display.ScreenImage()
+	font := t.getfont()
+	h := font.Height()
+	border := t.display.ScaleSize(1)
+	pad := t.display.ScaleSize(4)
+
+	screen.Draw(p.r, global.palette.TagBack(), nil, image.Point{})
+	screen.Border(p.r, border, global.palette.TagBord(), image.Point{})
+	r := p.r.Inset(border)
+	r.Max.Y = r.Min.Y + h
+	for i := p.top; i < p.top+p.nline && i < len(p.items); i++ {
+		text := global.palette.TagText()
+		if i == p.sel {
+			screen.Draw(r, global.palette.TagHigh(), nil, image.Point{})
+			text = global.palette.TagHText()
+		}
+		s := []rune(p.items[i])
+		for len(s) > 0 && font.RunesWidth(s) > r.Dx()-2*pad {
+			s = s[:len(s)-1]
+		}
+		screen.Bytes(image.Pt(r.Min.X+pad, r.Min.Y), text, image.Point{}, font, []byte(string(s)))
+		r = r.Add(image.Pt(0, h))
+	}
+}
+
+// Type handles the keyboard rune r typed into the popup's Text. Up and
+// down (or ^F) move through the candidates, tab and newline choose one,
+// and escape dismisses the popup. It reports false for any other rune,
+// which the caller should type as usual after closing the popup.
+func (p *completionPopup) Type(r rune) bool {
+	switch r {
+	case draw.KeyUp:
+		p.move(-1)
+	case draw.KeyDown, 0x06:
+		p.move(1)
+	case '\t', '\n':
+		p.choose(p.sel)
+	case 0x1B:
+		p.Close()
+	default:
+		return false
+	}
+	return true
+}
+
+func (p *completionPopup) move(n int) {
+	p.sel = (p.sel + n + len(p.items)) % len(p.items)
+	if p.sel < p.top {
+		p.top = p.sel
+	}
+	if p.sel >= p.top+p.nline {
+		p.top = p.sel - p.nline + 1
+	}
+	p.draw()
+}
+
+// Mouse handles the mouse event m, which has a button down. A click on
+// a candidate chooses it and the wheel moves through the candidates. A
+// click elsewhere dismisses the popup. It reports whether it consumed m.
+func (p *completionPopup) Mouse(m *draw.Mouse) bool {
+	if w := p.t.w; w != nil {
+		w.Lock('M')
+		defer w.Unlock()
+	}
+	if !m.Point.In(p.r) {
+		p.Close()
+		return false
+	}
+	switch {
+	case m.Buttons&8 != 0:
+		p.move(-1)
+	case m.Buttons&16 != 0:
+		p.move(1)
+	default:
+		i := p.top + (m.Point.Y-p.r.Min.Y-p.t.display.ScaleSize(1))/p.t.getfont().Height()
+		p.choose(max(0, min(i, p.top+p.nline-1, len(p.items)-1)))
+		for global.mouse.Buttons != 0 {
+			global.readmouse()
+		}
+	}
+	return true
+}
+
+// choose replaces the text of the completion with the i'th candidate.
+func (p *completionPopup) choose(i int) {
+	t := p.t
+	p.Close()
+	if p.q1 > t.Nc() || p.q0 > p.q1 {
+		return // the text has changed underneath the popup
+	}
+	s := []rune(p.items[i])
+	t.TypeCommit()
+	if t.what == Body {
+		global.seq++
+		t.file.Mark(global.seq)
+	}
+	if p.q1 > p.q0 {
+		t.Delete(p.q0, p.q1, true)
+	}
+	t.Insert(p.q0, s, true)
+	t.Show(p.q0+len(s), p.q0+len(s), true)
+	if t.w != nil {
+		t.w.Commit(t)
+	}
+}
+
+// Close removes the popup, redrawing the Text underneath it.
+func (p *completionPopup) Close() {
+	if global.completion != p {
+		return
+	}
+	global.completion = nil
+	t := p.t
+	if t.fr != nil {
+		t.Resize(t.all, true, false)
+		if t.w != nil && t.what == Body {
+			t.ScrDraw(t.fr.GetFrameFillStatus().Nchars)
+		}
+	}
+}
+
+// closecompletion closes the completion popup unless it belongs to t.
+// The caller must not hold the lock of the popup's window.
+func closecompletion(t *Text) {
+	p := global.completion
+	if p == nil || p.t == t {
+		return
+	}
+	if w := p.t.w; w != nil {
+		w.Lock('K')
+		defer w.Unlock()
+	}
+	p.Close()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/edwoodtest"
+)
+
+func TestCompletionPopup(t *testing.T) {
+	body := "alphabet alphanumeric alps\nalp"
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	global.configureGlobals(display)
+	global.row.Init(image.Rect(0, 0, 800, 600), display)
+	w := global.row.Add(nil, -1).Add(nil, nil, -1)
+	text := &w.body
+	text.Insert(0, []rune(body), true)
+	text.SetSelect(len(body), len(body))
+	global.mouse = &draw.Mouse{}
+	t.Cleanup(func() { global.completion = nil })
+
+	contents := func() string {
+		buf := make([]rune, text.Nc())
+		text.ReadB(0, buf)
+		return string(buf)
+	}
+
+	text.Type(0x06)
+	p := global.completion
+	if p == nil {
+		t.Fatalf("^F didn't open a popup")
+	}
+	if diff := cmp.Diff([]string{"alps", "alphanumeric", "alphabet"}, p.items); diff != "" {
+		t.Errorf("candidates mismatch (-want +got):\n%s", diff)
+	}
+	if !p.r.In(text.fr.Rect()) || p.r.Empty() {
+		t.Errorf("popup at %v isn't inside the frame %v", p.r, text.fr.Rect())
+	}
+
+	text.Type(draw.KeyUp)
+	text.Type(draw.KeyUp)
+	if got, want := p.sel, 1; got != want {
+		t.Errorf("up twice selected item %d; want %d", got, want)
+	}
+	text.Type('\t')
+	if global.completion != nil {
+		t.Errorf("popup still open after choosing")
+	}
+	if got, want := contents(), "alphabet alphanumeric alps\nalphanumeric"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+	if got, want := text.q0, text.Nc(); got != want {
+		t.Errorf("tick at %d; want %d", got, want)
+	}
+
+	reset := func() {
+		text.Delete(0, text.Nc(), true)
+		text.Insert(0, []rune(body), true)
+		text.SetSelect(len(body), len(body))
+	}
+
+	// Any other key closes the popup and is typed as usual.
+	reset()
+	text.Type(0x06)
+	text.Type('x')
+	if global.completion != nil {
+		t.Errorf("popup still open after typing")
+	}
+	if got, want := contents(), body+"x"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+
+	// A click chooses the candidate under the mouse.
+	reset()
+	text.Type(0x06)
+	p = global.completion
+	if p == nil {
+		t.Fatalf("^F didn't open a popup")
+	}
+	h := text.getfont().Height()
+	if !p.Mouse(&draw.Mouse{Point: image.Pt(p.r.Min.X+2, p.r.Min.Y+2*h+h/2), Buttons: 1}) {
+		t.Errorf("click on the popup wasn't consumed")
+	}
+	if got, want := contents(), "alphabet alphanumeric alps\nalphabet"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"9fans.net/go/plan9"
+)
+
+// completeTimeout is how long ^F waits for a completion provider to
+// answer before falling back to the built-in completion.
+var completeTimeout = 300 * time.Millisecond
+
+// completeRequest asks the provider of a window for the candidates to
+// replace the text between Q0 and Q1, the word ending at the tick. It is
+// read from the fsys's acme/<id>/complete pseudo-file as a line of JSON.
+type completeRequest struct {
+	Seq    int    `json:"seq"`
+	Q0     int    `json:"q0"` // rune offsets
+	Q1     int    `json:"q1"`
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+}
+
+// completeReply answers the completeRequest with the same Seq. It is
+// written to the complete file as a line of JSON. Q0 and Q1 default to
+// those of the request; a provider can set them to replace more or less
+// than the prefix.
+type completeReply struct {
+	Seq        int      `json:"seq"`
+	Q0         *int     `json:"q0,omitempty"`
+	Q1         *int     `json:"q1,omitempty"`
+	Candidates []string `json:"candidates"`
+}
+
+// completer passes the completion requests of a window to the program
+// that has its complete file open, the window's one provider, and its
+// replies back. It's used without the window lock, which ^F holds while
+// it waits for a reply.
+type completer struct {
+	lk sync.Mutex
+	r  sync.Cond
+
+	seq     int
+	req     *completeRequest // waiting to be read
+	pending int              // Seq of the request waiting for a reply
+	reply   chan *completeReply
+	closed  bool
+
+	// active (blocked) reads waiting for a request
+	read []*Xfid
+}
+
+func newCompleter() *completer {
+	c := &completer{}
+	c.r.L = &c.lk
+	return c
+}
+
+// complete sends a request for the completion of the text between q0 and
+// q1 to the provider and waits for the reply until timeout. It returns nil
+// if there's no reply.
+func (c *completer) complete(q0, q1 int, prefix, name string, timeout time.Duration) *completeReply {
+	c.lk.Lock()
+	if c.closed {
+		c.lk.Unlock()
+		return nil
+	}
+	c.seq++
+	c.req = &completeRequest{Seq: c.seq, Q0: q0, Q1: q1, Prefix: prefix, Name: name}
+	c.pending = c.seq
+	reply := make(chan *completeReply, 1)
+	c.reply = reply
+	c.r.Broadcast()
+	c.lk.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var rep *completeReply
+	select {
+	case rep = <-reply:
+	case <-timer.C:
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.pending == c.seq {
+		c.req, c.pending, c.reply = nil, 0, nil
+	}
+	if rep == nil {
+		return nil
+	}
+	if rep.Q0 == nil {
+		rep.Q0 = &q0
+	}
+	if rep.Q1 == nil {
+		rep.Q1 = &q1
+	}
+	return rep
+}
+
+// close wakes the readers and makes further reads fail.
+func (c *completer) close() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.closed = true
+	c.r.Broadcast()
+}
+
+// xfidcompleteread reads the next completion request, blocking until
+// there is one. A request longer than the read count continues in the
+// next read.
+func xfidcompleteread(x *Xfid, c *completer) {
+	var fc plan9.Fcall
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if len(x.f.completebuf) == 0 {
+		c.read = append(c.read, x)
+		x.flushed = false
+		for c.req == nil && !x.flushed && !c.closed {
+			c.r.Wait()
+		}
+		for i, rx := range c.read {
+			if rx == x {
+				c.read[i] = c.read[len(c.read)-1]
+				c.read = c.read[:len(c.read)-1]
+				break
+			}
+		}
+		switch {
+		case x.flushed:
+			return
+		case c.closed:
+			x.respond(&fc, fmt.Errorf("window shut down"))
+			return
+		}
+		b, err := json.Marshal(c.req)
+		if err != nil {
+			panic(fmt.Sprintf("can't marshal completion request: %v", err))
+		}
+		x.f.completebuf = append(b, '\n')
+		c.req = nil
+	}
+
+	n := min(len(x.f.completebuf), int(x.fcall.Count))
+	fc.Data = x.f.completebuf[:n]
+	fc.Count = uint32(n)
+	x.f.completebuf = x.f.completebuf[n:]
+	x.respond(&fc, nil)
+}
+
+// xfidcompletewrite accepts a reply, which may span several writes
+// and ends with a newline.
+func xfidcompletewrite(x *Xfid, c *completer) {
+	var fc plan9.Fcall
+	x.f.completewbuf = append(x.f.completewbuf, x.fcall.Data...)
+	fc.Count = x.fcall.Count
+	if !bytes.HasSuffix(x.f.completewbuf, []byte("\n")) {
+		x.respond(&fc, nil)
+		return
+	}
+	var rep completeReply
+	err := json.Unmarshal(x.f.completewbuf, &rep)
+	x.f.completewbuf = nil
+	if err != nil {
+		x.respond(&fc, fmt.Errorf("bad completion reply: %v", err))
+		return
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if rep.Seq == 0 || rep.Seq != c.pending {
+		x.respond(&fc, fmt.Errorf("no completion request %d", rep.Seq))
+		return
+	}
+	c.reply <- &rep
+	c.pending, c.reply = 0, nil
+	x.respond(&fc, nil)
+}
+
+func xfidcompleteflush(x *Xfid, c *completer) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	for _, rx := range c.read {
+		if rx.fs == x.fs && rx.fcall.Tag == x.fcall.Oldtag {
+			rx.flushed = true
+			c.r.Broadcast()
+		}
+	}
+}
+
+// xfidcompletehangup ends the reads left blocked by a client of fs that
+// went away.
+func xfidcompletehangup(fs *fileServer, c *completer) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	for _, rx := range c.read {
+		if rx.fs == fs {
+			rx.flushed = true
+			c.r.Broadcast()
+		}
+	}
+}
+
+// completeFromProvider asks the program reading t's complete file to
+// complete the word ending at the tick. It returns the text to insert at
+// the tick, or nil if the provider had no candidates or showed them in
+// the completion popup. ok reports whether the provider answered with at
+// least one candidate.
+func (t *Text) completeFromProvider() (r []rune, ok bool) {
+	w := t.w
+	if w == nil || t.what != Body || w.completer == nil || w.nopen[QWcomplete] == 0 {
+		return nil, false
+	}
+	prefix := t.wordPrefix()
+	q0 := t.q0 - len([]rune(prefix))
+	rep := w.completer.complete(q0, t.q0, prefix, t.file.Name(), completeTimeout)
+	if rep == nil || len(rep.Candidates) == 0 {
+		return nil, false
+	}
+	rq0, rq1 := *rep.Q0, *rep.Q1
+	if rq0 < 0 || rq0 > rq1 || rq1 > t.Nc() {
+		warning(nil, "bad completion range %d,%d\n", rq0, rq1)
+		return nil, false
+	}
+	if len(rep.Candidates) == 1 && rq1 == t.q0 {
+		// Insert what the candidate adds to the text at the tick.
+		text := make([]rune, rq1-rq0)
+		t.file.Read(rq0, text)
+		if s, found := strings.CutPrefix(rep.Candidates[0], string(text)); found {
+			return []rune(s), true
+		}
+	}
+	if !t.popupCompletion(rq0, rq1, rep.Candidates) {
+		for _, s := range rep.Candidates {
+			warning(nil, " %s\n", s)
+		}
+	}
+	return nil, true
+}
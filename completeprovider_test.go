@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"9fans.net/go/plan9"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompleteProvider(t *testing.T) {
+	body := "alphabet alphanumeric\nalpha"
+	FlexiblyMakeWindowScaffold(t,
+		ScWin("/a/file"),
+		ScBody("/a/file", body),
+		ScBodyRange("/a/file", Range{len(body), len(body)}),
+	)
+	w := global.row.col[0].w[0]
+	text := &w.body
+	defer func(d time.Duration) { completeTimeout = d }(completeTimeout)
+	completeTimeout = 5 * time.Second
+
+	complete := func() string {
+		w.Lock('K')
+		defer w.Unlock()
+		return string(text.Complete())
+	}
+
+	f := openFid(w, QWcomplete)
+	// The window has one provider.
+	mr := new(mockResponder)
+	xfidopen(&Xfid{f: &Fid{qid: plan9.Qid{Path: QID(w.id, QWcomplete)}, w: w}, fs: mr})
+	if mr.err != ErrInUse {
+		t.Errorf("second open of complete got error %v; want %v", mr.err, ErrInUse)
+	}
+
+	// provide answers the next request with reply, written in pieces.
+	provide := func(want completeRequest, reply ...string) chan error {
+		errc := make(chan error, 1)
+		go func() {
+			s, err := readFid(f, 0, 10)
+			if err != nil {
+				errc <- err
+				return
+			}
+			for len(s) == 0 || s[len(s)-1] != '\n' {
+				b, err := readFid(f, 0, 10)
+				if err != nil {
+					errc <- err
+					return
+				}
+				s += b
+			}
+			var req completeRequest
+			if err := json.Unmarshal([]byte(s), &req); err != nil {
+				errc <- err
+				return
+			}
+			if diff := cmp.Diff(want, req); diff != "" {
+				t.Errorf("request mismatch (-want +got):\n%s", diff)
+			}
+			for _, r := range reply {
+				if err := writeFid(f, 0, r); err != nil {
+					errc <- err
+					return
+				}
+			}
+			errc <- nil
+		}()
+		return errc
+	}
+
+	errc := provide(completeRequest{Seq: 1, Q0: 22, Q1: 27, Prefix: "alpha", Name: "/a/file"},
+		`{"seq":1,"candidates":`, `["alphanumeric"]}`+"\n")
+	if got, want := complete(), "numeric"; got != want {
+		t.Errorf("Complete returned %q; want %q", got, want)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("provider failed: %v", err)
+	}
+
+	// A reply with no candidates falls back to the built-in completion,
+	// which has two candidates and so can only list them.
+	errc = provide(completeRequest{Seq: 2, Q0: 22, Q1: 27, Prefix: "alpha", Name: "/a/file"},
+		`{"seq":2,"candidates":[]}`+"\n")
+	if got, want := complete(), ""; got != want {
+		t.Errorf("Complete returned %q; want %q", got, want)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("provider failed: %v", err)
+	}
+
+	// A provider can replace more than the prefix.
+	errc = provide(completeRequest{Seq: 3, Q0: 22, Q1: 27, Prefix: "alpha", Name: "/a/file"},
+		`{"seq":3,"q0":9,"q1":27,"candidates":["beta"]}`+"\n")
+	if got, want := complete(), ""; got != want {
+		t.Errorf("Complete returned %q; want %q", got, want)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("provider failed: %v", err)
+	}
+
+	// Replies to requests that aren't pending fail.
+	for _, reply := range []string{`{"seq":3,"candidates":["x"]}`, `{"candidates":["x"]}`, `frob`} {
+		if err := writeFid(f, 0, reply+"\n"); err == nil {
+			t.Errorf("reply %q succeeded", reply)
+		}
+	}
+
+	// Without an answer, ^F falls back after the timeout.
+	completeTimeout = 10 * time.Millisecond
+	text.Insert(text.Nc(), []rune("n"), true)
+	text.SetSelect(text.Nc(), text.Nc())
+	if got, want := complete(), "umeric"; got != want {
+		t.Errorf("Complete returned %q; want %q", got, want)
+	}
+
+	// Deleting the window ends reads.
+	done := make(chan error)
+	go func() {
+		_, err := readFid(f, 0, 8192)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	w.Lock('E')
+	w.Delete()
+	w.Unlock()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("read of deleted window succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read did not return")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+
+	"github.com/rjkroege/edwood/complete"
+	"github.com/rjkroege/edwood/file"
+)
+
+// maxWordScan bounds how many runes of each buffer word completion reads,
+// so that ^F stays quick with huge files open.
+const maxWordScan = 1 << 20
+
+// wordNear is the distance in runes at which an occurrence of a word in
+// the text being completed counts half as much as an adjacent one.
+const wordNear = 1000
+
+// wordPrefix returns the word that ends at t.q0.
+func (t *Text) wordPrefix() string {
+	q := t.q0
+	for q > 0 && isalnum(t.file.ReadC(q-1)) {
+		q--
+	}
+	r := make([]rune, t.q0-q)
+	t.file.Read(q, r)
+	return string(r)
+}
+
+// completeWord completes the word prefix ending at t.q0 from the words of
+// t and of the bodies of all the open windows. Occurrences in t are
+// weighted by their proximity to t.q0 and other occurrences count one
+// each, so nearby and frequent words rank first.
+func (t *Text) completeWord(prefix string) *complete.Completion {
+	ws := complete.NewWords()
+
+	start := t.q0 - len([]rune(prefix))
+	q0 := max(0, t.q0-maxWordScan/2)
+	q1 := min(t.file.Nr(), q0+maxWordScan)
+	addWords(ws, t.file, q0, q1, func(q int) float64 {
+		if q == start {
+			return 0 // the word being completed
+		}
+		d := q - t.q0
+		if d < 0 {
+			d = -d
+		}
+		return 1 + 4*wordNear/float64(wordNear+d)
+	})
+
+	seen := map[*file.ObservableEditableBuffer]bool{t.file: true}
+	global.row.AllWindows(func(w *Window) {
+		f := w.body.file
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		addWords(ws, f, 0, min(f.Nr(), maxWordScan), func(int) float64 { return 1 })
+	})
+	return ws.Complete(prefix)
+}
+
+// addWords adds the words of f between q0 and q1 to ws, weighting the
+// word starting at q by weight(q).
+func addWords(ws *complete.Words, f *file.ObservableEditableBuffer, q0, q1 int, weight func(q int) float64) {
+	br := bufio.NewReader(f.Reader(q0, q1))
+	var word strings.Builder
+	wq := q0 // start of word
+	for q := q0; ; q++ {
+		r, _, err := br.ReadRune()
+		if err == nil && isalnum(r) {
+			if word.Len() == 0 {
+				wq = q
+			}
+			word.WriteRune(r)
+			continue
+		}
+		if word.Len() > 0 {
+			if w := weight(wq); w > 0 {
+				ws.Add(word.String(), w)
+			}
+			word.Reset()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
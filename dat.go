@@ -3,6 +3,7 @@ package main
 import (
 	"math"
 	"os"
+	"sync/atomic"
 	"unicode/utf8"
 
 	"9fans.net/go/plan9"
@@ -18,21 +19,31 @@ import (
 const (
 	Qdir uint64 = iota
 	Qacme
+	Qcol
 	Qcons
 	Qconsctl
+	Qctl
 	Qdraw
 	Qeditout
 	Qindex
+	Qindexjson
 	Qlabel
 	Qlog
 	Qnew
+	Qsnarf
+	QCdir
+	QCctl
 	QWaddr
 	QWbody
+	QWchanges
+	QWcomplete
 	QWctl
+	QWctljson
 	QWdata
 	QWeditout
 	QWerrors
 	QWevent
+	QWeventjson
 	QWrdsel
 	QWwrsel
 	QWtag
@@ -71,6 +82,7 @@ type ProcessState interface {
 	Pid() int
 	String() string
 	Success() bool
+	ExitCode() int
 }
 
 type Range struct {
@@ -85,6 +97,7 @@ type Command struct {
 	av            []string
 	iseditcommand bool
 	md            *MntDir
+	winid         int // window the command was run from, or 0
 }
 
 // DirTab describes a file or directory in file server.
@@ -118,11 +131,30 @@ type Fid struct {
 	open   bool // true after Topen; false after Tcluck
 	qid    plan9.Qid
 	w      *Window
+	col    *Column // of a col/N directory, as it was when walked to
 	dir    *DirTab // Used for stat, and open permission check.
 	mntdir *MntDir
+	caps   *fsysCaps // what the client that attached this fid may do
+	attach int       // numbers the attach of the client, which clones share
 	nrpart int
 	rpart  [utf8.UTFMax]byte
 	logoff int
+
+	// position in the window's changeFeed
+	changeseq int
+	changeoff int
+
+	// unread part of a completion request and unfinished reply
+	completebuf  []byte
+	completewbuf []byte
+
+	// transaction begun by writing begin to this ctl fid
+	txn *bodyTxn
+
+	// copy of the snarf buffer being read
+	snarf     []byte
+	snarfvers int
+	snarfeof  bool // all of snarf has been read
 }
 
 type Xfid struct {
@@ -152,15 +184,16 @@ type Expand struct {
 	a1    int            // end of address
 }
 
-type Ref int
+// Ref is a reference count. It's changed atomically as the file servers
+// take references to windows while they're locked by others.
+type Ref int32
 
 func (r *Ref) Inc() {
-	*r++
+	atomic.AddInt32((*int32)(r), 1)
 }
 
 func (r *Ref) Dec() int {
-	*r--
-	return int(*r)
+	return int(atomic.AddInt32((*int32)(r), -1))
 }
 
 // WIN returns the window ID contained in a Qid.
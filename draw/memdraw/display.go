@@ -0,0 +1,196 @@
+// Package memdraw is a pure-Go software implementation of draw.Display.
+// All drawing happens in memory on image.RGBA buffers and text is rendered
+// with Plan 9 bitmap fonts loaded from disk (or an embedded fallback
+// font). It needs neither devdraw nor a window system and so can run the
+// complete editor headless, take PNG screenshots of the screen image and
+// support pixel-level golden tests.
+//
+// Input is synthesized: SendMouse, SendKey and Resize deliver events on
+// the channels returned by InitMouse and InitKeyboard.
+package memdraw
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"sync"
+
+	"github.com/rjkroege/edwood/draw"
+)
+
+var _ = draw.Display((*Display)(nil))
+
+// Display implements draw.Display in memory.
+type Display struct {
+	mu       sync.Mutex
+	screen   *memImage
+	snarfbuf []byte
+	cursor   *draw.Cursor
+	mouse    draw.Mouse // last mouse state
+	fonts    map[string]*font
+
+	white, black, opaque, transparent *memImage
+
+	mousec  chan draw.Mouse
+	resizec chan bool
+	keyc    chan rune
+}
+
+// NewDisplay returns a Display whose screen image covers r.
+func NewDisplay(r image.Rectangle) *Display {
+	d := &Display{
+		fonts:   make(map[string]*font),
+		mousec:  make(chan draw.Mouse),
+		resizec: make(chan bool, 2),
+		keyc:    make(chan rune, 20),
+	}
+	d.screen = d.newImage(r, false, draw.White)
+	d.white = d.newImage(image.Rect(0, 0, 1, 1), true, draw.White)
+	d.black = d.newImage(image.Rect(0, 0, 1, 1), true, draw.Black)
+	d.opaque = d.newImage(image.Rect(0, 0, 1, 1), true, draw.Opaque)
+	d.transparent = d.newImage(image.Rect(0, 0, 1, 1), true, draw.Transparent)
+	return d
+}
+
+func (d *Display) ScreenImage() draw.Image {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.screen
+}
+
+func (d *Display) White() draw.Image       { return d.white }
+func (d *Display) Black() draw.Image       { return d.black }
+func (d *Display) Opaque() draw.Image      { return d.opaque }
+func (d *Display) Transparent() draw.Image { return d.transparent }
+
+func (d *Display) InitKeyboard() *draw.Keyboardctl {
+	return &draw.Keyboardctl{C: d.keyc}
+}
+
+func (d *Display) InitMouse() *draw.Mousectl {
+	return &draw.Mousectl{C: d.mousec, Resize: d.resizec}
+}
+
+// OpenFont opens the named Plan 9 font. Names beginning with /lib/font/bit
+// are resolved against $PLAN9 (as plan9port does). A font that can't be
+// found is replaced by the embedded fallback font so that a headless
+// Edwood always has something to draw with.
+func (d *Display) OpenFont(name string) (draw.Font, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.fonts[name]; ok {
+		return f, nil
+	}
+	f, err := openFont(name)
+	if err != nil {
+		f, err = fallbackFont(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.fonts[name] = f
+	return f, nil
+}
+
+func (d *Display) AllocImage(r image.Rectangle, pix draw.Pix, repl bool, val draw.Color) (draw.Image, error) {
+	if r.Empty() && !repl {
+		return nil, errors.New("memdraw: empty image rectangle")
+	}
+	i := d.newImage(r, repl, val)
+	i.pix = pix
+	return i, nil
+}
+
+// AllocImageMix mirrors 9fans.net/go/draw: a 1x1 replicated image holding
+// a quarter of color1 and three quarters of color3.
+func (d *Display) AllocImageMix(color1, color3 draw.Color) draw.Image {
+	c1 := draw.WithAlpha(color1, 0x3f) >> 8
+	c3 := draw.WithAlpha(color3, 0xbf) >> 8
+	c := ((c1 + c3) << 8) | 0xff
+	return d.newImage(image.Rect(0, 0, 1, 1), true, c)
+}
+
+func (d *Display) Attach(ref int) error { return nil }
+func (d *Display) Flush() error         { return nil }
+func (d *Display) ScaleSize(n int) int  { return n }
+
+// ReadSnarf reads the snarf buffer into buf, returning the number of bytes
+// read and the total size of the snarf buffer.
+func (d *Display) ReadSnarf(buf []byte) (int, int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := copy(buf, d.snarfbuf)
+	return n, len(d.snarfbuf), nil
+}
+
+// WriteSnarf writes the data to the snarf buffer.
+func (d *Display) WriteSnarf(data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.snarfbuf = append([]byte(nil), data...)
+	return nil
+}
+
+// MoveTo moves the mouse to pt. Like devdraw, it generates a mouse event
+// with the new position, which is delivered asynchronously.
+func (d *Display) MoveTo(pt image.Point) error {
+	d.mu.Lock()
+	d.mouse.Point = pt
+	m := d.mouse
+	d.mu.Unlock()
+	go func() { d.mousec <- m }()
+	return nil
+}
+
+func (d *Display) SetCursor(c *draw.Cursor) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cursor = c
+	return nil
+}
+
+// MousePoint returns the last position set with MoveTo or SendMouse.
+func (d *Display) MousePoint() image.Point {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mouse.Point
+}
+
+// SendMouse delivers m to the Mousectl returned by InitMouse. It blocks
+// until the event has been received.
+func (d *Display) SendMouse(m draw.Mouse) {
+	d.mu.Lock()
+	d.mouse = m
+	d.mu.Unlock()
+	d.mousec <- m
+}
+
+// SendKey delivers r to the Keyboardctl returned by InitKeyboard.
+func (d *Display) SendKey(r rune) {
+	d.keyc <- r
+}
+
+// Resize replaces the screen image with a blank one covering r and
+// signals the resize on the Mousectl.
+func (d *Display) Resize(r image.Rectangle) {
+	d.mu.Lock()
+	d.screen = d.newImage(r, false, draw.White)
+	d.mu.Unlock()
+	d.resizec <- true
+}
+
+// Screen returns a copy of the current contents of the screen image.
+func (d *Display) Screen() *image.RGBA {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.screen.rgba
+	c := image.NewRGBA(s.Rect)
+	copy(c.Pix, s.Pix)
+	return c
+}
+
+// WritePNG writes the screen image to w in PNG format.
+func (d *Display) WritePNG(w io.Writer) error {
+	return png.Encode(w, d.Screen())
+}
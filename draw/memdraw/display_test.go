@@ -0,0 +1,198 @@
+package memdraw
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/rjkroege/edwood/draw"
+)
+
+func TestAllocImageFill(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 100, 50))
+	red, err := d.AllocImage(image.Rect(0, 0, 1, 1), d.ScreenImage().Pix(), true, 0xFF0000FF)
+	if err != nil {
+		t.Fatalf("AllocImage failed: %v", err)
+	}
+
+	screen := d.ScreenImage()
+	screen.Draw(image.Rect(10, 10, 20, 20), red, nil, image.Point{})
+
+	s := d.Screen()
+	for _, tc := range []struct {
+		pt   image.Point
+		want color.RGBA
+	}{
+		{image.Pt(10, 10), color.RGBA{0xFF, 0, 0, 0xFF}},
+		{image.Pt(19, 19), color.RGBA{0xFF, 0, 0, 0xFF}},
+		{image.Pt(20, 20), color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}},
+		{image.Pt(9, 15), color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}},
+	} {
+		if got := s.RGBAAt(tc.pt.X, tc.pt.Y); got != tc.want {
+			t.Errorf("pixel at %v is %v; want %v", tc.pt, got, tc.want)
+		}
+	}
+}
+
+func TestDrawNilSourceIsBlack(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 10, 10))
+	d.ScreenImage().Draw(image.Rect(0, 0, 5, 5), nil, nil, image.Point{})
+	if got, want := d.Screen().RGBAAt(2, 2), (color.RGBA{0, 0, 0, 0xFF}); got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestDrawMask(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 10, 10))
+	mask, _ := d.AllocImage(image.Rect(0, 0, 4, 4), 0, false, draw.Transparent)
+	mask.Draw(image.Rect(1, 0, 2, 4), d.Opaque(), nil, image.Point{})
+
+	d.ScreenImage().Draw(image.Rect(0, 0, 4, 4), d.Black(), mask, image.Point{})
+	s := d.Screen()
+	for x := 0; x < 4; x++ {
+		want := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+		if x == 1 {
+			want = color.RGBA{0, 0, 0, 0xFF}
+		}
+		if got := s.RGBAAt(x, 2); got != want {
+			t.Errorf("pixel at x=%d is %v; want %v", x, got, want)
+		}
+	}
+}
+
+func TestDrawBlitOverlapping(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 10, 10))
+	screen := d.ScreenImage()
+	screen.Draw(image.Rect(0, 0, 10, 1), d.Black(), nil, image.Point{})
+
+	// Scroll down by one row, as frame does when inserting a line.
+	screen.Draw(image.Rect(0, 1, 10, 10), screen, nil, image.Pt(0, 0))
+
+	s := d.Screen()
+	for y := 0; y < 10; y++ {
+		want := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+		if y < 2 {
+			want = color.RGBA{0, 0, 0, 0xFF}
+		}
+		if got := s.RGBAAt(5, y); got != want {
+			t.Errorf("pixel at y=%d is %v; want %v", y, got, want)
+		}
+	}
+}
+
+func TestReplicatedSource(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 8, 1))
+	stripe, _ := d.AllocImage(image.Rect(0, 0, 2, 1), 0, true, draw.White)
+	stripe.Draw(image.Rect(0, 0, 1, 1), d.Black(), nil, image.Point{})
+
+	d.ScreenImage().Draw(d.ScreenImage().R(), stripe, nil, image.Point{})
+	s := d.Screen()
+	for x := 0; x < 8; x++ {
+		want := uint8(0xFF)
+		if x%2 == 0 {
+			want = 0
+		}
+		if got := s.RGBAAt(x, 0).R; got != want {
+			t.Errorf("pixel at x=%d has red %#x; want %#x", x, got, want)
+		}
+	}
+}
+
+func TestBorder(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 10, 10))
+	d.ScreenImage().Border(image.Rect(2, 2, 8, 8), 1, d.Black(), image.Point{})
+
+	s := d.Screen()
+	black := color.RGBA{0, 0, 0, 0xFF}
+	white := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	for _, tc := range []struct {
+		pt   image.Point
+		want color.RGBA
+	}{
+		{image.Pt(2, 2), black},
+		{image.Pt(7, 7), black},
+		{image.Pt(2, 5), black},
+		{image.Pt(7, 5), black},
+		{image.Pt(5, 2), black},
+		{image.Pt(5, 5), white},
+		{image.Pt(1, 1), white},
+		{image.Pt(8, 8), white},
+	} {
+		if got := s.RGBAAt(tc.pt.X, tc.pt.Y); got != tc.want {
+			t.Errorf("pixel at %v is %v; want %v", tc.pt, got, tc.want)
+		}
+	}
+}
+
+func TestAllocImageMix(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 1, 1))
+	i := d.AllocImageMix(draw.Black, draw.White)
+	d.ScreenImage().Draw(d.ScreenImage().R(), i, nil, image.Point{})
+	if got, want := d.Screen().RGBAAt(0, 0), (color.RGBA{0xbf, 0xbf, 0xbf, 0xff}); got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestSnarf(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 1, 1))
+	if err := d.WriteSnarf([]byte("hello")); err != nil {
+		t.Fatalf("WriteSnarf failed: %v", err)
+	}
+	b := make([]byte, 2)
+	n, sz, err := d.ReadSnarf(b)
+	if err != nil || n != 2 || sz != 5 {
+		t.Errorf("ReadSnarf = %d, %d, %v; want 2, 5, nil", n, sz, err)
+	}
+}
+
+func TestInput(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 10, 10))
+	mc := d.InitMouse()
+	kc := d.InitKeyboard()
+
+	go d.SendMouse(draw.Mouse{Point: image.Pt(3, 4), Buttons: 1})
+	if m := <-mc.C; m.Point != image.Pt(3, 4) || m.Buttons != 1 {
+		t.Errorf("got mouse %v", m)
+	}
+	if got := d.MousePoint(); got != image.Pt(3, 4) {
+		t.Errorf("MousePoint is %v", got)
+	}
+
+	d.MoveTo(image.Pt(5, 6))
+	if m := <-mc.C; m.Point != image.Pt(5, 6) || m.Buttons != 1 {
+		t.Errorf("got mouse %v after MoveTo", m)
+	}
+
+	d.SendKey('x')
+	if r := <-kc.C; r != 'x' {
+		t.Errorf("got key %q", r)
+	}
+
+	d.Resize(image.Rect(0, 0, 20, 30))
+	<-mc.Resize
+	if got, want := d.ScreenImage().R(), image.Rect(0, 0, 20, 30); got != want {
+		t.Errorf("screen is %v after resize; want %v", got, want)
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 16, 8))
+	d.ScreenImage().Draw(image.Rect(0, 0, 8, 8), d.Black(), nil, image.Point{})
+
+	var buf bytes.Buffer
+	if err := d.WritePNG(&buf); err != nil {
+		t.Fatalf("WritePNG failed: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("can't decode PNG: %v", err)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 16, 8); got != want {
+		t.Errorf("PNG bounds %v; want %v", got, want)
+	}
+	if r, _, _, _ := img.At(2, 2).RGBA(); r != 0 {
+		t.Errorf("PNG pixel not black")
+	}
+}
@@ -0,0 +1,190 @@
+package memdraw
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/rjkroege/edwood/draw"
+)
+
+var _ = draw.Font((*font)(nil))
+
+// fallbackSubfont is plan9port's lucsans/lsr.14 covering Latin-1.
+//
+//go:embed lsr.14
+var fallbackSubfont []byte
+
+// font implements draw.Font for a Plan 9 font file: a height, an ascent
+// and a list of subfonts each covering a range of runes. Subfonts are
+// loaded on first use.
+type font struct {
+	name   string
+	height int
+	ascent int
+
+	mu     sync.Mutex
+	ranges []*fontRange
+}
+
+// fontRange maps runes min to max onto a subfont starting at glyph offset.
+type fontRange struct {
+	min, max rune
+	offset   int
+	path     string
+
+	loaded bool
+	sf     *subfont
+}
+
+// Plan9FontPath maps the Plan 9 font directory /lib/font/bit onto
+// $PLAN9/font in the same way as plan9port.
+func Plan9FontPath(name string) string {
+	const prefix = "/lib/font/bit"
+	if strings.HasPrefix(name, prefix) {
+		root := os.Getenv("PLAN9")
+		if root == "" {
+			root = "/usr/local/plan9"
+		}
+		return filepath.Join(root, "font", name[len(prefix):])
+	}
+	return name
+}
+
+// openFont reads and parses the named font file.
+func openFont(name string) (*font, error) {
+	fname := Plan9FontPath(name)
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	return parseFont(name, filepath.Dir(fname), string(b))
+}
+
+// parseFont parses the text of a font file. Relative subfont paths are
+// resolved against dir.
+func parseFont(name, dir, s string) (*font, error) {
+	tok := strings.Fields(s)
+	if len(tok) < 2 {
+		return nil, fmt.Errorf("memdraw: font %q: short header", name)
+	}
+	f := &font{name: name}
+	var err error
+	if f.height, err = strconv.Atoi(tok[0]); err != nil {
+		return nil, fmt.Errorf("memdraw: font %q: bad height: %v", name, err)
+	}
+	if f.ascent, err = strconv.Atoi(tok[1]); err != nil {
+		return nil, fmt.Errorf("memdraw: font %q: bad ascent: %v", name, err)
+	}
+	tok = tok[2:]
+	for len(tok) > 0 {
+		if len(tok) < 3 {
+			return nil, fmt.Errorf("memdraw: font %q: truncated range", name)
+		}
+		min, err1 := strconv.ParseInt(tok[0], 0, 32)
+		max, err2 := strconv.ParseInt(tok[1], 0, 32)
+		if err1 != nil || err2 != nil || max < min {
+			return nil, fmt.Errorf("memdraw: font %q: bad range %s %s", name, tok[0], tok[1])
+		}
+		fr := &fontRange{min: rune(min), max: rune(max)}
+		tok = tok[2:]
+		if off, err := strconv.ParseInt(tok[0], 0, 32); err == nil {
+			fr.offset = int(off)
+			tok = tok[1:]
+			if len(tok) == 0 {
+				return nil, fmt.Errorf("memdraw: font %q: missing subfont name", name)
+			}
+		}
+		fr.path = tok[0]
+		if !filepath.IsAbs(fr.path) {
+			fr.path = filepath.Join(dir, fr.path)
+		}
+		tok = tok[1:]
+		f.ranges = append(f.ranges, fr)
+	}
+	if len(f.ranges) == 0 {
+		return nil, fmt.Errorf("memdraw: font %q: no subfonts", name)
+	}
+	return f, nil
+}
+
+// fallbackFont returns a font using the embedded subfont. It reports
+// itself with the requested name so that callers see what they asked for.
+func fallbackFont(name string) (*font, error) {
+	sf, err := readSubfont(bytes.NewReader(fallbackSubfont))
+	if err != nil {
+		return nil, err
+	}
+	return &font{
+		name:   name,
+		height: sf.height,
+		ascent: sf.ascent,
+		ranges: []*fontRange{{
+			min:    0,
+			max:    rune(len(sf.glyphs) - 1),
+			loaded: true,
+			sf:     sf,
+		}},
+	}, nil
+}
+
+func (f *font) Name() string { return f.name }
+func (f *font) Height() int  { return f.height }
+
+// glyph returns the glyph for r, substituting the replacement character
+// when r is not in the font. It returns nil if neither is available.
+func (f *font) glyph(r rune) *glyph {
+	if g := f.lookup(r); g != nil {
+		return g
+	}
+	return f.lookup(utf8.RuneError)
+}
+
+func (f *font) lookup(r rune) *glyph {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, fr := range f.ranges {
+		if r < fr.min || r > fr.max {
+			continue
+		}
+		if !fr.loaded {
+			fr.loaded = true
+			fr.sf, _ = loadSubfont(fr.path)
+			if fr.sf != nil {
+				fr.sf.align(f.ascent)
+			}
+		}
+		if fr.sf == nil {
+			continue
+		}
+		i := int(r-fr.min) + fr.offset
+		if i < 0 || i >= len(fr.sf.glyphs) {
+			continue
+		}
+		g := fr.sf.glyphs[i]
+		if g.width == 0 && g.mask == nil {
+			continue
+		}
+		return g
+	}
+	return nil
+}
+
+func (f *font) RunesWidth(r []rune) int {
+	w := 0
+	for _, c := range r {
+		if g := f.glyph(c); g != nil {
+			w += g.width
+		}
+	}
+	return w
+}
+
+func (f *font) StringWidth(s string) int { return f.RunesWidth([]rune(s)) }
+func (f *font) BytesWidth(b []byte) int  { return f.RunesWidth([]rune(string(b))) }
@@ -0,0 +1,148 @@
+package memdraw
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+// testFontDir holds the plan9port fonts used for the edwood build.
+const testFontDir = "../../build"
+
+func TestOpenPlan9Font(t *testing.T) {
+	t.Setenv("PLAN9", testFontDir)
+	d := NewDisplay(image.Rect(0, 0, 200, 40))
+
+	f, err := d.OpenFont("/lib/font/bit/lucsans/euro.8.font")
+	if err != nil {
+		t.Fatalf("OpenFont failed: %v", err)
+	}
+	if got, want := f.Name(), "/lib/font/bit/lucsans/euro.8.font"; got != want {
+		t.Errorf("Name is %q; want %q", got, want)
+	}
+	if got, want := f.Height(), 15; got != want {
+		t.Errorf("Height is %d; want %d", got, want)
+	}
+
+	// lucsans is proportional.
+	if wi, wm := f.StringWidth("i"), f.StringWidth("m"); wi <= 0 || wi >= wm {
+		t.Errorf("width of i %d, width of m %d", wi, wm)
+	}
+	if got, want := f.BytesWidth([]byte("hello")), f.RunesWidth([]rune("hello")); got != want {
+		t.Errorf("BytesWidth %d != RunesWidth %d", got, want)
+	}
+	// Greek comes from a second subfont.
+	if f.StringWidth("λ") == 0 {
+		t.Errorf("no width for λ")
+	}
+
+	g, _ := d.OpenFont("/lib/font/bit/lucsans/euro.8.font")
+	if g != f {
+		t.Errorf("OpenFont did not reuse the font")
+	}
+}
+
+func TestBytes(t *testing.T) {
+	t.Setenv("PLAN9", testFontDir)
+	d := NewDisplay(image.Rect(0, 0, 200, 40))
+	f, err := d.OpenFont("/lib/font/bit/lucsans/euro.8.font")
+	if err != nil {
+		t.Fatalf("OpenFont failed: %v", err)
+	}
+
+	pt := image.Pt(10, 10)
+	end := d.ScreenImage().Bytes(pt, d.Black(), image.Point{}, f, []byte("Hello"))
+	if got, want := end, pt.Add(image.Pt(f.StringWidth("Hello"), 0)); got != want {
+		t.Errorf("Bytes returned %v; want %v", got, want)
+	}
+
+	s := d.Screen()
+	inked := 0
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 200; x++ {
+			if s.RGBAAt(x, y).R != 0xFF {
+				r := image.Rect(pt.X, pt.Y, end.X+1, pt.Y+f.Height())
+				if !image.Pt(x, y).In(r) {
+					t.Fatalf("ink at %v outside %v", image.Pt(x, y), r)
+				}
+				inked++
+			}
+		}
+	}
+	if inked == 0 {
+		t.Errorf("no text drawn")
+	}
+}
+
+func TestBytesSource(t *testing.T) {
+	t.Setenv("PLAN9", testFontDir)
+	d := NewDisplay(image.Rect(0, 0, 200, 40))
+	f, err := d.OpenFont("/lib/font/bit/lucsans/euro.8.font")
+	if err != nil {
+		t.Fatalf("OpenFont failed: %v", err)
+	}
+
+	// The source is red under the first two glyphs and blue under the rest.
+	half := f.StringWidth("mm")
+	src, _ := d.AllocImage(image.Rect(0, 0, 2*half, f.Height()), d.ScreenImage().Pix(), false, 0x0000FFFF)
+	red, _ := d.AllocImage(image.Rect(0, 0, 1, 1), d.ScreenImage().Pix(), true, 0xFF0000FF)
+	src.Draw(image.Rect(0, 0, half, f.Height()), red, nil, image.Point{})
+
+	pt := image.Pt(10, 10)
+	d.ScreenImage().Bytes(pt, src, image.Point{}, f, []byte("mmmm"))
+
+	s := d.Screen()
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 200; x++ {
+			c := s.RGBAAt(x, y)
+			if c == (color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+				continue
+			}
+			if left := x < pt.X+half; left && c.B > c.R || !left && c.R > c.B {
+				t.Fatalf("pixel %v at %v comes from the wrong part of the source", c, image.Pt(x, y))
+			}
+		}
+	}
+}
+
+func TestFallbackFont(t *testing.T) {
+	t.Setenv("PLAN9", t.TempDir())
+	d := NewDisplay(image.Rect(0, 0, 100, 20))
+	f, err := d.OpenFont(filepath.Join(t.TempDir(), "missing.font"))
+	if err != nil {
+		t.Fatalf("OpenFont failed: %v", err)
+	}
+	if f.Height() <= 0 {
+		t.Errorf("fallback font has height %d", f.Height())
+	}
+	if f.StringWidth("abc") <= 0 {
+		t.Errorf("fallback font has no widths")
+	}
+	// Runes outside the font take the width of the replacement character.
+	if got, want := f.StringWidth("世"), f.StringWidth("�"); got != want {
+		t.Errorf("width of missing rune %d; want %d", got, want)
+	}
+}
+
+func TestParseFont(t *testing.T) {
+	f, err := parseFont("test", "/fonts", "15 13\n0x0000 0x00FF lsr.14\n0xFFFD 0xFFFD 0x80 /abs/lsr.14\n")
+	if err != nil {
+		t.Fatalf("parseFont failed: %v", err)
+	}
+	if f.height != 15 || f.ascent != 13 || len(f.ranges) != 2 {
+		t.Fatalf("got height %d ascent %d with %d ranges", f.height, f.ascent, len(f.ranges))
+	}
+	if got, want := f.ranges[0].path, "/fonts/lsr.14"; got != want {
+		t.Errorf("path %q; want %q", got, want)
+	}
+	if got, want := f.ranges[1].offset, 0x80; got != want {
+		t.Errorf("offset %#x; want %#x", got, want)
+	}
+
+	for _, bad := range []string{"", "15", "x 13", "15 13 0x10 0x00 f", "15 13 0x00 0xFF"} {
+		if _, err := parseFont("bad", "/", bad); err == nil {
+			t.Errorf("parseFont(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package memdraw
+
+import (
+	"image"
+	"image/color"
+	stddraw "image/draw"
+	"unicode/utf8"
+
+	"github.com/rjkroege/edwood/draw"
+)
+
+var _ = draw.Image((*memImage)(nil))
+
+// memImage implements draw.Image on top of an image.RGBA. As with Plan 9
+// images, colour values are premultiplied by alpha and a replicated image
+// tiles the plane.
+type memImage struct {
+	d    *Display
+	rgba *image.RGBA
+	pix  draw.Pix
+	repl bool
+}
+
+// newImage allocates an image covering r filled with val. Nofill leaves
+// the image transparent.
+func (d *Display) newImage(r image.Rectangle, repl bool, val draw.Color) *memImage {
+	i := &memImage{
+		d:    d,
+		rgba: image.NewRGBA(r),
+		repl: repl,
+	}
+	if val != draw.Nofill {
+		stddraw.Draw(i.rgba, r, image.NewUniform(toRGBA(val)), image.Point{}, stddraw.Src)
+	}
+	return i
+}
+
+// toRGBA converts a Plan 9 colour (0xRRGGBBAA, premultiplied) to Go.
+func toRGBA(c draw.Color) color.RGBA {
+	return color.RGBA{
+		R: uint8(c >> 24),
+		G: uint8(c >> 16),
+		B: uint8(c >> 8),
+		A: uint8(c),
+	}
+}
+
+func (i *memImage) Display() draw.Display { return i.d }
+func (i *memImage) Pix() draw.Pix         { return i.pix }
+func (i *memImage) R() image.Rectangle    { return i.rgba.Rect }
+func (i *memImage) Free() error           { return nil }
+
+// source returns i in a form suitable for use as the source or mask of a
+// draw operation.
+func (i *memImage) source() image.Image {
+	if !i.repl {
+		return i.rgba
+	}
+	if r := i.rgba.Rect; r.Dx() == 1 && r.Dy() == 1 {
+		return image.NewUniform(i.rgba.RGBAAt(r.Min.X, r.Min.Y))
+	}
+	return tiled{i.rgba}
+}
+
+// Draw composites src through mask onto r of i using Plan 9's SoverD
+// rule. src and mask are both aligned with p1. A nil src is black and a
+// nil mask is opaque.
+func (i *memImage) Draw(r image.Rectangle, src, mask draw.Image, p1 image.Point) {
+	i.draw(r, src, p1, mask, p1)
+}
+
+func (i *memImage) draw(r image.Rectangle, src draw.Image, sp image.Point, mask draw.Image, mp image.Point) {
+	if src == nil {
+		src = i.d.black
+	}
+	s := src.(*memImage).source()
+	var m image.Image
+	if mask != nil {
+		m = mask.(*memImage).source()
+	}
+	stddraw.DrawMask(i.rgba, r, s, sp, m, mp, stddraw.Over)
+}
+
+// Border draws an n pixel wide border just inside r (or just outside if n
+// is negative).
+func (i *memImage) Border(r image.Rectangle, n int, color draw.Image, sp image.Point) {
+	if n < 0 {
+		r = r.Inset(n)
+		sp = sp.Add(image.Pt(n, n))
+		n = -n
+	}
+	i.Draw(image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+n), color, nil, sp)
+	i.Draw(image.Rect(r.Min.X, r.Max.Y-n, r.Max.X, r.Max.Y), color, nil, sp.Add(image.Pt(0, r.Dy()-n)))
+	i.Draw(image.Rect(r.Min.X, r.Min.Y+n, r.Min.X+n, r.Max.Y-n), color, nil, sp.Add(image.Pt(0, n)))
+	i.Draw(image.Rect(r.Max.X-n, r.Min.Y+n, r.Max.X, r.Max.Y-n), color, nil, sp.Add(image.Pt(r.Dx()-n, n)))
+}
+
+// Bytes draws the UTF-8 text b in font f with its top left corner at pt,
+// filling the glyphs from src aligned with sp. It returns the point just
+// after the drawn text.
+func (i *memImage) Bytes(pt image.Point, src draw.Image, sp image.Point, f draw.Font, b []byte) image.Point {
+	if src == nil {
+		src = i.d.black
+	}
+	s := src.(*memImage).source()
+	ft := f.(*font)
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		b = b[n:]
+		g := ft.glyph(r)
+		if g == nil {
+			continue
+		}
+		if g.mask != nil {
+			min := pt.Add(image.Pt(g.left, g.top))
+			dr := image.Rectangle{min, min.Add(g.mask.Rect.Size())}
+			stddraw.DrawMask(i.rgba, dr, s, sp.Add(dr.Min.Sub(pt)), g.mask, g.mask.Rect.Min, stddraw.Over)
+		}
+		pt.X += g.width
+		sp.X += g.width
+	}
+	return pt
+}
+
+// tiled presents a replicated image as an image covering the plane.
+type tiled struct {
+	*image.RGBA
+}
+
+func (t tiled) Bounds() image.Rectangle {
+	return image.Rect(-1e9, -1e9, 1e9, 1e9)
+}
+
+func (t tiled) At(x, y int) color.Color {
+	r := t.RGBA.Rect
+	x = r.Min.X + mod(x-r.Min.X, r.Dx())
+	y = r.Min.Y + mod(y-r.Min.Y, r.Dy())
+	return t.RGBA.At(x, y)
+}
+
+func (t tiled) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := t.At(x, y).RGBA()
+	return color.RGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a)}
+}
+
+func mod(a, n int) int {
+	a %= n
+	if a < 0 {
+		a += n
+	}
+	return a
+}
+
+// RGBA returns the pixels backing i if it was allocated by a memdraw
+// Display and nil otherwise. Other software backends use this to inspect
+// what has been drawn.
+func RGBA(i draw.Image) *image.RGBA {
+	if mi, ok := i.(*memImage); ok {
+		return mi.rgba
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package memdraw
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// subfont is a Plan 9 subfont: an image holding a strip of glyphs and the
+// metrics needed to find each one.
+type subfont struct {
+	height int
+	ascent int
+	glyphs []*glyph
+}
+
+// glyph is a single character of a subfont. mask holds its coverage; it is
+// drawn with its top left corner at (left, top) relative to the point
+// where the character starts.
+type glyph struct {
+	mask  *image.Alpha
+	left  int
+	top   int
+	width int
+}
+
+// align shifts the glyphs of sf vertically so that its baseline matches a
+// font with the given ascent.
+func (sf *subfont) align(ascent int) {
+	d := ascent - sf.ascent
+	if d == 0 {
+		return
+	}
+	for _, g := range sf.glyphs {
+		g.top += d
+	}
+	sf.ascent = ascent
+}
+
+// loadSubfont reads the named subfont file.
+func loadSubfont(path string) (*subfont, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return readSubfont(bufio.NewReader(fd))
+}
+
+// readSubfont parses a subfont: an image (in the format of image(6))
+// followed by a three-number header and the Fontchar table.
+func readSubfont(r io.Reader) (*subfont, error) {
+	bits, err := readImage(r)
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 3*12)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("memdraw: subfont header: %v", err)
+	}
+	v, err := atoiFields(hdr, 3)
+	if err != nil {
+		return nil, fmt.Errorf("memdraw: subfont header: %v", err)
+	}
+	n := v[0]
+	if n < 0 || n > 1<<16 {
+		return nil, fmt.Errorf("memdraw: subfont has %d characters", n)
+	}
+	info := make([]byte, 6*(n+1))
+	if _, err := io.ReadFull(r, info); err != nil {
+		return nil, fmt.Errorf("memdraw: subfont info: %v", err)
+	}
+	sf := &subfont{
+		height: v[1],
+		ascent: v[2],
+		glyphs: make([]*glyph, n),
+	}
+	for i := 0; i < n; i++ {
+		p, q := info[6*i:], info[6*(i+1):]
+		x0 := int(p[0]) | int(p[1])<<8
+		x1 := int(q[0]) | int(q[1])<<8
+		top, bottom := int(p[2]), int(p[3])
+		g := &glyph{
+			left:  int(int8(p[4])),
+			top:   top,
+			width: int(p[5]),
+		}
+		gr := image.Rect(x0, top, x1, bottom).Add(bits.Rect.Min)
+		if !gr.Empty() && gr.In(bits.Rect) {
+			g.mask = bits.SubImage(gr).(*image.Alpha)
+		}
+		sf.glyphs[i] = g
+	}
+	return sf, nil
+}
+
+// readImage reads a greyscale image in Plan 9's uncompressed or
+// compressed image format, returning the grey values as coverage.
+func readImage(r io.Reader) (*image.Alpha, error) {
+	hdr := make([]byte, 5*12)
+	if _, err := io.ReadFull(r, hdr[:11]); err != nil {
+		return nil, fmt.Errorf("memdraw: image header: %v", err)
+	}
+	compressed := false
+	if string(hdr[:11]) == "compressed\n" {
+		compressed = true
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return nil, fmt.Errorf("memdraw: image header: %v", err)
+		}
+	} else if _, err := io.ReadFull(r, hdr[11:]); err != nil {
+		return nil, fmt.Errorf("memdraw: image header: %v", err)
+	}
+
+	depth, err := greyDepth(strings.TrimSpace(string(hdr[:12])))
+	if err != nil {
+		return nil, err
+	}
+	v, err := atoiFields(hdr[12:], 4)
+	if err != nil {
+		return nil, fmt.Errorf("memdraw: image header: %v", err)
+	}
+	rect := image.Rect(v[0], v[1], v[2], v[3])
+	if rect.Dx() < 0 || rect.Dy() < 0 || rect.Dx() > 1<<16 || rect.Dy() > 1<<16 {
+		return nil, fmt.Errorf("memdraw: bad image rectangle %v", rect)
+	}
+
+	bpl := bytesPerLine(rect, depth)
+	data := make([]byte, bpl*rect.Dy())
+	if compressed {
+		err = readCompressed(r, data, rect, bpl)
+	} else {
+		_, err = io.ReadFull(r, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("memdraw: image data: %v", err)
+	}
+
+	img := image.NewAlpha(rect)
+	maxv := 1<<depth - 1
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		line := data[(y-rect.Min.Y)*bpl:]
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			bit := x*depth - (rect.Min.X*depth)&^7
+			pv := int(line[bit/8]>>(8-depth-bit%8)) & maxv
+			img.Pix[img.PixOffset(x, y)] = uint8(pv * 255 / maxv)
+		}
+	}
+	return img, nil
+}
+
+// readCompressed decompresses the blocks of a compressed image into data.
+// Each block holds the rows up to some maximum y, encoded as literal runs
+// and back references into the output.
+func readCompressed(r io.Reader, data []byte, rect image.Rectangle, bpl int) error {
+	hdr := make([]byte, 2*12)
+	o := 0
+	for y := rect.Min.Y; y < rect.Max.Y; {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return err
+		}
+		v, err := atoiFields(hdr, 2)
+		if err != nil {
+			return err
+		}
+		maxy, nb := v[0], v[1]
+		if maxy <= y || maxy > rect.Max.Y || nb < 0 {
+			return fmt.Errorf("bad compressed block (maxy %d, %d bytes)", maxy, nb)
+		}
+		buf := make([]byte, nb)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		end := (maxy - rect.Min.Y) * bpl
+		for u := 0; u < len(buf) && o < end; {
+			c := int(buf[u])
+			u++
+			if c >= 128 {
+				for n := c - 128 + 1; n > 0 && u < len(buf) && o < end; n-- {
+					data[o] = buf[u]
+					o++
+					u++
+				}
+				continue
+			}
+			if u >= len(buf) {
+				return fmt.Errorf("truncated back reference")
+			}
+			offs := int(buf[u]) + (c&3)<<8 + 1
+			u++
+			if offs > o {
+				return fmt.Errorf("back reference before start of image")
+			}
+			for n := c>>2 + 3; n > 0 && o < end; n-- {
+				data[o] = data[o-offs]
+				o++
+			}
+		}
+		y = maxy
+	}
+	return nil
+}
+
+// greyDepth returns the depth of a greyscale channel descriptor such as
+// "k1", or of an old-style log2 depth such as "0".
+func greyDepth(chans string) (int, error) {
+	if len(chans) == 1 && chans[0] >= '0' && chans[0] <= '3' {
+		return 1 << (chans[0] - '0'), nil
+	}
+	switch chans {
+	case "k1":
+		return 1, nil
+	case "k2":
+		return 2, nil
+	case "k4":
+		return 4, nil
+	case "k8":
+		return 8, nil
+	}
+	return 0, fmt.Errorf("memdraw: unsupported image channels %q", chans)
+}
+
+// bytesPerLine returns the number of bytes in each row of an image.
+func bytesPerLine(r image.Rectangle, depth int) int {
+	return (r.Max.X*depth+7)/8 - (r.Min.X*depth)/8
+}
+
+// atoiFields parses n blank-padded 12 byte decimal fields from b.
+func atoiFields(b []byte, n int) ([]int, error) {
+	v := make([]int, n)
+	for i := range v {
+		s := strings.TrimSpace(string(b[12*i : 12*(i+1)]))
+		x, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = x
+	}
+	return v, nil
+}
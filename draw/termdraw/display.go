@@ -0,0 +1,173 @@
+// Package termdraw implements draw.Display on an ANSI terminal so that
+// Edwood can run where devdraw can't, for example over ssh.
+//
+// The screen is a grid of character cells. Each cell is treated as a
+// block of CellWidth x CellHeight virtual pixels and all fonts are
+// fixed-width with glyphs exactly one cell in size, so that the
+// row/column/window layout code works unchanged. Pixels are kept by an
+// in-memory memdraw.Display and the text drawn into each cell is tracked
+// separately. Flush samples the colour at the centre of every cell,
+// combines it with the cell's text and writes whatever changed to the
+// terminal using 24-bit colour escape sequences.
+//
+// Mouse reports in SGR 1006 format become draw.Mouse events and other
+// input becomes keyboard runes.
+package termdraw
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/draw/memdraw"
+)
+
+// Size in virtual pixels of one terminal cell.
+const (
+	CellWidth  = 8
+	CellHeight = 16
+)
+
+var _ = draw.Display((*Display)(nil))
+
+// Display implements draw.Display on a terminal.
+type Display struct {
+	mem *memdraw.Display
+
+	mu     sync.Mutex
+	out    *bufio.Writer
+	screen *termImage
+	cols   int
+	rows   int
+	shown  [][]cell // what the terminal currently displays
+	cursor image.Point
+	tickr  image.Rectangle // tick rectangle; empty if not shown
+	closer func() error
+
+	white, black, opaque, transparent *termImage
+}
+
+// cell is the content of one terminal cell.
+type cell struct {
+	r  rune
+	fg draw.Color
+	bg draw.Color
+}
+
+// NewDisplay returns a Display of cols x rows cells that writes to out
+// and reads keyboard and mouse input from in. The caller is responsible
+// for putting the terminal into raw mode; see Open.
+func NewDisplay(in io.Reader, out io.Writer, cols, rows int) *Display {
+	d := &Display{
+		mem:  memdraw.NewDisplay(image.Rect(0, 0, cols*CellWidth, rows*CellHeight)),
+		out:  bufio.NewWriter(out),
+		cols: cols,
+		rows: rows,
+	}
+	d.screen = d.wrap(d.mem.ScreenImage())
+	d.white = d.wrap(d.mem.White())
+	d.black = d.wrap(d.mem.Black())
+	d.opaque = d.wrap(d.mem.Opaque())
+	d.transparent = d.wrap(d.mem.Transparent())
+
+	// Alternate screen, hidden cursor, button-event mouse tracking in SGR format.
+	d.out.WriteString("\x1b[?1049h\x1b[?25l\x1b[?1002h\x1b[?1006h\x1b[2J")
+	if in != nil {
+		go d.readinput(in)
+	}
+	return d
+}
+
+// Close restores the terminal to the state it was in before Open.
+func (d *Display) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.out.WriteString("\x1b[?1006l\x1b[?1002l\x1b[0m\x1b[?25h\x1b[?1049l")
+	err := d.out.Flush()
+	if d.closer != nil {
+		if cerr := d.closer(); err == nil {
+			err = cerr
+		}
+		d.closer = nil
+	}
+	return err
+}
+
+// Resize changes the terminal size to cols x rows cells. The screen is
+// cleared and a resize is signalled on the Mousectl.
+func (d *Display) Resize(cols, rows int) {
+	d.mu.Lock()
+	d.cols = cols
+	d.rows = rows
+	d.shown = nil
+	d.tickr = image.Rectangle{}
+	d.mu.Unlock()
+
+	// memdraw signals the resize; d.screen is swapped by ScreenImage.
+	d.mem.Resize(image.Rect(0, 0, cols*CellWidth, rows*CellHeight))
+}
+
+func (d *Display) ScreenImage() draw.Image {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s := d.mem.ScreenImage(); s != d.screen.img {
+		d.screen = d.wrap(s)
+	}
+	return d.screen
+}
+
+func (d *Display) White() draw.Image       { return d.white }
+func (d *Display) Black() draw.Image       { return d.black }
+func (d *Display) Opaque() draw.Image      { return d.opaque }
+func (d *Display) Transparent() draw.Image { return d.transparent }
+
+func (d *Display) InitKeyboard() *draw.Keyboardctl { return d.mem.InitKeyboard() }
+func (d *Display) InitMouse() *draw.Mousectl       { return d.mem.InitMouse() }
+
+// OpenFont returns a fixed-width font of one cell per rune whatever the
+// name.
+func (d *Display) OpenFont(name string) (draw.Font, error) {
+	return &cellFont{name: name}, nil
+}
+
+func (d *Display) AllocImage(r image.Rectangle, pix draw.Pix, repl bool, val draw.Color) (draw.Image, error) {
+	i, err := d.mem.AllocImage(r, pix, repl, val)
+	if err != nil {
+		return nil, err
+	}
+	return d.wrap(i), nil
+}
+
+func (d *Display) AllocImageMix(color1, color3 draw.Color) draw.Image {
+	return d.wrap(d.mem.AllocImageMix(color1, color3))
+}
+
+func (d *Display) Attach(ref int) error { return nil }
+func (d *Display) ScaleSize(n int) int  { return n }
+
+func (d *Display) ReadSnarf(buf []byte) (int, int, error) { return d.mem.ReadSnarf(buf) }
+
+// WriteSnarf stores data as the snarf buffer and also offers it to the
+// terminal's clipboard with an OSC 52 sequence. This makes snarfed text
+// available on the local machine when running remotely.
+func (d *Display) WriteSnarf(data []byte) error {
+	d.mu.Lock()
+	fmt.Fprintf(d.out, "\x1b]52;c;%s\x07", base64.StdEncoding.EncodeToString(data))
+	d.mu.Unlock()
+	return d.mem.WriteSnarf(data)
+}
+
+func (d *Display) MoveTo(pt image.Point) error    { return d.mem.MoveTo(pt) }
+func (d *Display) SetCursor(c *draw.Cursor) error { return nil }
+
+// Flush brings the terminal up to date with the screen image.
+func (d *Display) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.render()
+	return d.out.Flush()
+}
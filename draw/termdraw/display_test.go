@@ -0,0 +1,141 @@
+package termdraw
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/rjkroege/edwood/draw"
+)
+
+// screenText returns the runes of each row of the cell grid.
+func screenText(d *Display) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var rows []string
+	for _, row := range d.compose() {
+		var sb strings.Builder
+		for _, c := range row {
+			sb.WriteRune(c.r)
+		}
+		rows = append(rows, strings.TrimRight(sb.String(), " "))
+	}
+	return rows
+}
+
+func TestBytesAndBlit(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDisplay(nil, &out, 10, 4)
+	screen := d.ScreenImage()
+	f, _ := d.OpenFont("any")
+
+	pt := image.Pt(CellWidth, 0)
+	end := screen.Bytes(pt, d.Black(), image.Point{}, f, []byte("hi λ"))
+	if got, want := end, pt.Add(image.Pt(4*CellWidth, 0)); got != want {
+		t.Errorf("Bytes returned %v; want %v", got, want)
+	}
+	if got, want := screenText(d)[0], " hi λ"; got != want {
+		t.Errorf("row 0 is %q; want %q", got, want)
+	}
+
+	// Scroll the first two rows down by one, as frame does.
+	screen.Draw(image.Rect(0, CellHeight, 10*CellWidth, 3*CellHeight), screen, nil, image.Pt(0, 0))
+	rows := screenText(d)
+	if rows[0] != " hi λ" || rows[1] != " hi λ" || rows[2] != "" {
+		t.Errorf("after blit rows are %q", rows)
+	}
+
+	// Filling erases text.
+	screen.Draw(image.Rect(0, 0, 10*CellWidth, CellHeight), d.White(), nil, image.Point{})
+	if got := screenText(d)[0]; got != "" {
+		t.Errorf("row 0 is %q after fill; want empty", got)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDisplay(nil, &out, 10, 2)
+	screen := d.ScreenImage()
+	f, _ := d.OpenFont("any")
+	red, _ := d.AllocImage(image.Rect(0, 0, 1, 1), screen.Pix(), true, 0xFF0000FF)
+	screen.Draw(image.Rect(0, CellHeight, 10*CellWidth, 2*CellHeight), red, nil, image.Point{})
+	screen.Bytes(image.Pt(0, CellHeight), d.Black(), image.Point{}, f, []byte("ok"))
+
+	out.Reset()
+	if err := d.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	s := out.String()
+	for _, want := range []string{"\x1b[2;1H", "\x1b[48;2;255;0;0m", "\x1b[38;2;0;0;0m", "ok"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("output %q doesn't contain %q", s, want)
+		}
+	}
+
+	// Nothing changed: only the cursor state is written.
+	out.Reset()
+	d.Flush()
+	if got, want := out.String(), "\x1b[?25l"; got != want {
+		t.Errorf("second flush wrote %q; want %q", got, want)
+	}
+
+	// A single changed cell is written by itself.
+	screen.Bytes(image.Pt(4*CellWidth, 0), d.Black(), image.Point{}, f, []byte("x"))
+	out.Reset()
+	d.Flush()
+	if s := out.String(); !strings.HasPrefix(s, "\x1b[1;5H") || strings.Count(s, "x") != 1 || strings.Contains(s, "ok") {
+		t.Errorf("incremental flush wrote %q", s)
+	}
+}
+
+func TestTickShowsCursor(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDisplay(nil, &out, 10, 2)
+	screen := d.ScreenImage()
+	tick, _ := d.AllocImage(image.Rect(0, 0, 3, CellHeight), screen.Pix(), false, draw.Opaque)
+
+	r := image.Rect(3*CellWidth, CellHeight, 3*CellWidth+3, 2*CellHeight)
+	screen.Draw(r, d.Black(), tick, image.Point{})
+	out.Reset()
+	d.Flush()
+	if s := out.String(); !strings.HasSuffix(s, "\x1b[2;4H\x1b[?25h") {
+		t.Errorf("flush with tick wrote %q", s)
+	}
+
+	screen.Draw(r, d.White(), nil, image.Point{})
+	out.Reset()
+	d.Flush()
+	if s := out.String(); !strings.HasSuffix(s, "\x1b[?25l") {
+		t.Errorf("flush after tick removal wrote %q", s)
+	}
+}
+
+func TestWriteSnarf(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDisplay(nil, &out, 1, 1)
+	d.WriteSnarf([]byte("hello"))
+	d.Flush()
+	if !strings.Contains(out.String(), "\x1b]52;c;aGVsbG8=\x07") {
+		t.Errorf("no OSC 52 in %q", out.String())
+	}
+	b := make([]byte, 10)
+	if n, _, _ := d.ReadSnarf(b); string(b[:n]) != "hello" {
+		t.Errorf("ReadSnarf got %q", b[:n])
+	}
+}
+
+func TestResize(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDisplay(nil, &out, 10, 2)
+	mc := d.InitMouse()
+	d.Resize(20, 5)
+	<-mc.Resize
+	if got, want := d.ScreenImage().R(), image.Rect(0, 0, 20*CellWidth, 5*CellHeight); got != want {
+		t.Errorf("screen %v; want %v", got, want)
+	}
+	d.Flush()
+	if got := len(screenText(d)); got != 5 {
+		t.Errorf("%d rows after resize; want 5", got)
+	}
+}
@@ -0,0 +1,191 @@
+package termdraw
+
+import (
+	"image"
+	"unicode/utf8"
+
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/draw/memdraw"
+)
+
+var _ = draw.Image((*termImage)(nil))
+
+// termImage implements draw.Image. Pixel operations are done by the
+// wrapped memdraw image and text is recorded per cell in text, keyed by
+// the cell that the centre of each glyph falls in.
+type termImage struct {
+	d    *Display
+	img  draw.Image
+	text map[image.Point]textcell
+}
+
+// textcell is a rune drawn into a cell and its colour.
+type textcell struct {
+	r  rune
+	fg draw.Color
+}
+
+func (d *Display) wrap(i draw.Image) *termImage {
+	return &termImage{
+		d:    d,
+		img:  i,
+		text: make(map[image.Point]textcell),
+	}
+}
+
+func unwrap(i draw.Image) draw.Image {
+	if i == nil {
+		return nil
+	}
+	return i.(*termImage).img
+}
+
+func (i *termImage) Display() draw.Display { return i.d }
+func (i *termImage) Pix() draw.Pix         { return i.img.Pix() }
+func (i *termImage) R() image.Rectangle    { return i.img.R() }
+func (i *termImage) Free() error           { return i.img.Free() }
+
+func (i *termImage) Draw(r image.Rectangle, src, mask draw.Image, p1 image.Point) {
+	i.img.Draw(r, unwrap(src), unwrap(mask), p1)
+
+	i.d.mu.Lock()
+	defer i.d.mu.Unlock()
+	if i == i.d.screen {
+		i.d.trackTick(r, mask)
+	}
+
+	// Carry the text of every cell whose centre is covered from src.
+	var s *termImage
+	if src != nil {
+		s = src.(*termImage)
+	}
+	type update struct {
+		at image.Point
+		tc textcell
+		ok bool
+	}
+	var updates []update
+	r = r.Intersect(i.R())
+	for cy := ceildiv(r.Min.Y-CellHeight/2, CellHeight); cy*CellHeight+CellHeight/2 < r.Max.Y; cy++ {
+		for cx := ceildiv(r.Min.X-CellWidth/2, CellWidth); cx*CellWidth+CellWidth/2 < r.Max.X; cx++ {
+			c := image.Pt(cx*CellWidth+CellWidth/2, cy*CellHeight+CellHeight/2)
+			sp := c.Sub(r.Min).Add(p1)
+			if mask != nil && !opaqueAt(mask.(*termImage), sp) {
+				continue
+			}
+			u := update{at: image.Pt(cx, cy)}
+			if s != nil {
+				u.tc, u.ok = s.text[image.Pt(floordiv(sp.X, CellWidth), floordiv(sp.Y, CellHeight))]
+			}
+			updates = append(updates, u)
+		}
+	}
+	for _, u := range updates {
+		if u.ok {
+			i.text[u.at] = u.tc
+		} else {
+			delete(i.text, u.at)
+		}
+	}
+}
+
+// opaqueAt reports whether mask is non-transparent at p.
+func opaqueAt(mask *termImage, p image.Point) bool {
+	return colorAt(mask, p)&0xFF != 0
+}
+
+func (i *termImage) Border(r image.Rectangle, n int, color draw.Image, sp image.Point) {
+	if n < 0 {
+		r = r.Inset(n)
+		sp = sp.Add(image.Pt(n, n))
+		n = -n
+	}
+	i.Draw(image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+n), color, nil, sp)
+	i.Draw(image.Rect(r.Min.X, r.Max.Y-n, r.Max.X, r.Max.Y), color, nil, sp.Add(image.Pt(0, r.Dy()-n)))
+	i.Draw(image.Rect(r.Min.X, r.Min.Y+n, r.Min.X+n, r.Max.Y-n), color, nil, sp.Add(image.Pt(0, n)))
+	i.Draw(image.Rect(r.Max.X-n, r.Min.Y+n, r.Max.X, r.Max.Y-n), color, nil, sp.Add(image.Pt(r.Dx()-n, n)))
+}
+
+// Bytes records each rune of b in the cell at pt, advancing pt by one cell
+// per rune. The foreground colour is taken from src at sp.
+func (i *termImage) Bytes(pt image.Point, src draw.Image, sp image.Point, f draw.Font, b []byte) image.Point {
+	fg := draw.Black
+	if src != nil {
+		fg = colorAt(src.(*termImage), sp)
+	}
+	i.d.mu.Lock()
+	defer i.d.mu.Unlock()
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		b = b[n:]
+		if pt.In(i.R()) {
+			i.text[cellOf(pt)] = textcell{r: r, fg: fg}
+		}
+		pt.X += CellWidth
+	}
+	return pt
+}
+
+// colorAt returns the colour of i at p, treating i as replicated.
+func colorAt(i *termImage, p image.Point) draw.Color {
+	rgba := memdraw.RGBA(i.img)
+	if rgba == nil || rgba.Rect.Empty() {
+		return draw.Black
+	}
+	r := rgba.Rect
+	p.X = r.Min.X + mod(p.X-r.Min.X, r.Dx())
+	p.Y = r.Min.Y + mod(p.Y-r.Min.Y, r.Dy())
+	c := rgba.RGBAAt(p.X, p.Y)
+	return draw.Color(uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A))
+}
+
+// cellOf returns the cell holding a glyph whose top left corner is at pt.
+func cellOf(pt image.Point) image.Point {
+	return image.Pt(floordiv(pt.X+CellWidth/2, CellWidth), floordiv(pt.Y+CellHeight/2, CellHeight))
+}
+
+// trackTick follows frame's tick: a narrow draw through a mask onto the
+// screen shows it and redrawing exactly that rectangle hides it. The
+// terminal's cursor stands in for the tick.
+func (d *Display) trackTick(r image.Rectangle, mask draw.Image) {
+	switch {
+	case mask != nil && r.Dx() < CellWidth/2:
+		d.tickr = r
+		d.cursor = cellOf(r.Min)
+	case mask == nil && r == d.tickr:
+		d.tickr = image.Rectangle{}
+	}
+}
+
+func floordiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func ceildiv(a, b int) int {
+	return -floordiv(-a, b)
+}
+
+func mod(a, n int) int {
+	a %= n
+	if a < 0 {
+		a += n
+	}
+	return a
+}
+
+// cellFont implements draw.Font: every rune is one cell.
+type cellFont struct {
+	name string
+}
+
+var _ = draw.Font((*cellFont)(nil))
+
+func (f *cellFont) Name() string             { return f.name }
+func (f *cellFont) Height() int              { return CellHeight }
+func (f *cellFont) BytesWidth(b []byte) int  { return CellWidth * utf8.RuneCount(b) }
+func (f *cellFont) RunesWidth(r []rune) int  { return CellWidth * len(r) }
+func (f *cellFont) StringWidth(s string) int { return CellWidth * utf8.RuneCountInString(s) }
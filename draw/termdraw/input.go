@@ -0,0 +1,205 @@
+package termdraw
+
+import (
+	"image"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rjkroege/edwood/draw"
+)
+
+// event is a decoded unit of terminal input: a mouse event or a key.
+type event struct {
+	mouse bool
+	m     draw.Mouse
+	key   rune
+}
+
+// decoder turns the bytes read from a terminal into events.
+type decoder struct {
+	buf     []byte
+	buttons int
+	start   time.Time
+}
+
+// readinput feeds terminal input to the mouse and keyboard channels until
+// in returns an error.
+func (d *Display) readinput(in io.Reader) {
+	dec := &decoder{start: time.Now()}
+	b := make([]byte, 1024)
+	for {
+		n, err := in.Read(b)
+		for _, ev := range dec.feed(b[:n]) {
+			if ev.mouse {
+				d.mem.SendMouse(ev.m)
+			} else {
+				d.mem.SendKey(ev.key)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// feed decodes as much of the input so far as possible. A trailing
+// partial escape sequence or UTF-8 sequence is kept for the next call
+// but an escape on its own at the end of the input is taken to be the
+// Esc key.
+func (dec *decoder) feed(b []byte) []event {
+	dec.buf = append(dec.buf, b...)
+	var evs []event
+	for len(dec.buf) > 0 {
+		n, ev, ok := dec.decode(dec.buf)
+		if n == 0 {
+			break
+		}
+		dec.buf = dec.buf[n:]
+		if ok {
+			evs = append(evs, ev...)
+		}
+	}
+	return evs
+}
+
+// decode decodes one input sequence from the start of b, returning the
+// number of bytes used (0 if b holds an incomplete sequence).
+func (dec *decoder) decode(b []byte) (int, []event, bool) {
+	switch b[0] {
+	case 0x1b:
+		if len(b) == 1 {
+			return 1, keys(0x1b), true
+		}
+		switch b[1] {
+		case '[':
+			return dec.csi(b)
+		case 'O':
+			if len(b) < 3 {
+				return 0, nil, false
+			}
+			if r, ok := cursorKey(b[2]); ok {
+				return 3, keys(r), true
+			}
+			return 3, nil, false
+		}
+		return 1, keys(0x1b), true
+	case '\r':
+		return 1, keys('\n'), true
+	case 0x7f:
+		return 1, keys('\b'), true
+	}
+	if !utf8.FullRune(b) {
+		return 0, nil, false
+	}
+	r, n := utf8.DecodeRune(b)
+	return n, keys(r), true
+}
+
+// csi decodes a control sequence starting with ESC [.
+func (dec *decoder) csi(b []byte) (int, []event, bool) {
+	i := 2
+	for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+		i++
+	}
+	if i == len(b) {
+		return 0, nil, false
+	}
+	params, final := string(b[2:i]), b[i]
+	n := i + 1
+
+	if strings.HasPrefix(params, "<") && (final == 'M' || final == 'm') {
+		evs, ok := dec.mouse(params[1:], final == 'm')
+		return n, evs, ok
+	}
+	if r, ok := cursorKey(final); ok && !strings.Contains(params, "~") {
+		return n, keys(r), true
+	}
+	if final == '~' {
+		switch strings.SplitN(params, ";", 2)[0] {
+		case "1", "7":
+			return n, keys(draw.KeyHome), true
+		case "2":
+			return n, keys(draw.KeyInsert), true
+		case "3":
+			return n, keys(0x7f), true
+		case "4", "8":
+			return n, keys(draw.KeyEnd), true
+		case "5":
+			return n, keys(draw.KeyPageUp), true
+		case "6":
+			return n, keys(draw.KeyPageDown), true
+		}
+	}
+	return n, nil, false
+}
+
+// mouse decodes the parameters of an SGR 1006 mouse report.
+func (dec *decoder) mouse(params string, release bool) ([]event, bool) {
+	f := strings.Split(params, ";")
+	if len(f) != 3 {
+		return nil, false
+	}
+	var v [3]int
+	for i := range v {
+		x, err := strconv.Atoi(f[i])
+		if err != nil {
+			return nil, false
+		}
+		v[i] = x
+	}
+	code, x, y := v[0], v[1], v[2]
+	m := draw.Mouse{
+		Point: image.Pt((x-1)*CellWidth+CellWidth/2, (y-1)*CellHeight+CellHeight/2),
+		Msec:  uint32(time.Since(dec.start) / time.Millisecond),
+	}
+
+	if code&64 != 0 {
+		// Wheel: a press and release of button 4 or 5.
+		wheel := 8
+		if code&1 != 0 {
+			wheel = 16
+		}
+		m.Buttons = dec.buttons | wheel
+		up := m
+		up.Buttons = dec.buttons
+		return []event{{mouse: true, m: m}, {mouse: true, m: up}}, true
+	}
+
+	if code&32 == 0 && code&3 != 3 {
+		bit := 1 << uint(code&3)
+		if release {
+			dec.buttons &^= bit
+		} else {
+			dec.buttons |= bit
+		}
+	} else if code&3 == 3 && code&32 == 0 {
+		dec.buttons = 0
+	}
+	m.Buttons = dec.buttons
+	return []event{{mouse: true, m: m}}, true
+}
+
+func cursorKey(c byte) (rune, bool) {
+	switch c {
+	case 'A':
+		return draw.KeyUp, true
+	case 'B':
+		return draw.KeyDown, true
+	case 'C':
+		return draw.KeyRight, true
+	case 'D':
+		return draw.KeyLeft, true
+	case 'H':
+		return draw.KeyHome, true
+	case 'F':
+		return draw.KeyEnd, true
+	}
+	return 0, false
+}
+
+func keys(r rune) []event {
+	return []event{{key: r}}
+}
@@ -0,0 +1,110 @@
+package termdraw
+
+import (
+	"image"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/draw"
+)
+
+// cellpt returns the virtual pixel at the centre of 1-based terminal cell x, y.
+func cellpt(x, y int) image.Point {
+	return image.Pt((x-1)*CellWidth+CellWidth/2, (y-1)*CellHeight+CellHeight/2)
+}
+
+func TestDecodeKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   []string
+		want []rune
+	}{
+		{"ascii", []string{"ab"}, []rune("ab")},
+		{"return", []string{"\r"}, []rune{'\n'}},
+		{"backspace", []string{"\x7f"}, []rune{'\b'}},
+		{"control", []string{"\x06"}, []rune{0x06}},
+		{"utf8", []string{"λ世"}, []rune("λ世")},
+		{"split utf8", []string{"\xce", "\xbb"}, []rune("λ")},
+		{"arrows", []string{"\x1b[A\x1b[B\x1b[C\x1b[D"}, []rune{draw.KeyUp, draw.KeyDown, draw.KeyRight, draw.KeyLeft}},
+		{"application arrows", []string{"\x1bOA"}, []rune{draw.KeyUp}},
+		{"home end", []string{"\x1b[H\x1b[F\x1b[1~\x1b[4~"}, []rune{draw.KeyHome, draw.KeyEnd, draw.KeyHome, draw.KeyEnd}},
+		{"pages", []string{"\x1b[5~\x1b[6~"}, []rune{draw.KeyPageUp, draw.KeyPageDown}},
+		{"delete", []string{"\x1b[3~"}, []rune{0x7f}},
+		{"escape", []string{"\x1b"}, []rune{0x1b}},
+		{"split sequence", []string{"\x1b[", "A"}, []rune{draw.KeyUp}},
+		{"unknown sequence", []string{"\x1b[99zq"}, []rune{'q'}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dec := &decoder{}
+			var got []rune
+			for _, s := range tc.in {
+				for _, ev := range dec.feed([]byte(s)) {
+					if ev.mouse {
+						t.Fatalf("unexpected mouse event %v", ev.m)
+					}
+					got = append(got, ev.key)
+				}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("keys mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeMouse(t *testing.T) {
+	type mev struct {
+		Pt      image.Point
+		Buttons int
+	}
+	for _, tc := range []struct {
+		name string
+		in   string
+		want []mev
+	}{
+		{
+			name: "click button 1",
+			in:   "\x1b[<0;5;3M\x1b[<0;5;3m",
+			want: []mev{{cellpt(5, 3), 1}, {cellpt(5, 3), 0}},
+		},
+		{
+			name: "drag",
+			in:   "\x1b[<0;1;1M\x1b[<32;4;1M\x1b[<0;4;1m",
+			want: []mev{{cellpt(1, 1), 1}, {cellpt(4, 1), 1}, {cellpt(4, 1), 0}},
+		},
+		{
+			name: "chord 1-2",
+			in:   "\x1b[<0;2;2M\x1b[<1;2;2M\x1b[<1;2;2m\x1b[<0;2;2m",
+			want: []mev{{cellpt(2, 2), 1}, {cellpt(2, 2), 3}, {cellpt(2, 2), 1}, {cellpt(2, 2), 0}},
+		},
+		{
+			name: "button 3",
+			in:   "\x1b[<2;7;9M",
+			want: []mev{{cellpt(7, 9), 4}},
+		},
+		{
+			name: "wheel",
+			in:   "\x1b[<64;3;3M\x1b[<65;3;3M",
+			want: []mev{{cellpt(3, 3), 8}, {cellpt(3, 3), 0}, {cellpt(3, 3), 16}, {cellpt(3, 3), 0}},
+		},
+		{
+			name: "modifiers ignored",
+			in:   "\x1b[<16;1;1M",
+			want: []mev{{cellpt(1, 1), 1}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dec := &decoder{}
+			var got []mev
+			for _, ev := range dec.feed([]byte(tc.in)) {
+				if !ev.mouse {
+					t.Fatalf("unexpected key %q", ev.key)
+				}
+				got = append(got, mev{ev.m.Point, ev.m.Buttons})
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mouse mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package termdraw
+
+import (
+	"bufio"
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+func TestOpenPty(t *testing.T) {
+	ptm, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer ptm.Close()
+	defer tty.Close()
+	if err := pty.Setsize(ptm, &pty.Winsize{Cols: 40, Rows: 10}); err != nil {
+		t.Fatalf("Setsize failed: %v", err)
+	}
+
+	d, err := Open(tty)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if got, want := d.ScreenImage().R(), image.Rect(0, 0, 40*CellWidth, 10*CellHeight); got != want {
+		t.Errorf("screen %v; want %v", got, want)
+	}
+
+	output := make(chan string, 100)
+	go func() {
+		r := bufio.NewReader(ptm)
+		b := make([]byte, 4096)
+		for {
+			n, err := r.Read(b)
+			if n > 0 {
+				output <- string(b[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	mc := d.InitMouse()
+	kc := d.InitKeyboard()
+
+	// Raw mode: a return arrives as '\r' and becomes a newline.
+	ptm.Write([]byte("\x1b[<1;3;2Mx\r"))
+	select {
+	case m := <-mc.C:
+		if m.Point != cellpt(3, 2) || m.Buttons != 2 {
+			t.Errorf("got mouse %v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mouse event")
+	}
+	for _, want := range "x\n" {
+		select {
+		case r := <-kc.C:
+			if r != want {
+				t.Errorf("got key %q; want %q", r, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no key")
+		}
+	}
+
+	f, _ := d.OpenFont("any")
+	d.ScreenImage().Bytes(image.Pt(0, 0), d.Black(), image.Point{}, f, []byte("edwood"))
+	d.Flush()
+	d.Close()
+
+	var all strings.Builder
+	deadline := time.After(5 * time.Second)
+	for !strings.Contains(all.String(), "\x1b[?1049l") {
+		select {
+		case s := <-output:
+			all.WriteString(s)
+		case <-deadline:
+			t.Fatalf("terminal not restored; got %q", all.String())
+		}
+	}
+	s := all.String()
+	for _, want := range []string{"\x1b[?1006h", "edwood"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("terminal output doesn't contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package termdraw
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/draw/memdraw"
+)
+
+// compose computes the cell grid for the current screen.
+func (d *Display) compose() [][]cell {
+	pix := memdraw.RGBA(d.screen.img)
+	grid := make([][]cell, d.rows)
+	for y := range grid {
+		grid[y] = make([]cell, d.cols)
+		for x := range grid[y] {
+			c := &grid[y][x]
+			c.r = ' '
+			p := image.Pt(x*CellWidth+CellWidth/2, y*CellHeight+CellHeight/2)
+			if pix != nil && p.In(pix.Rect) {
+				rgba := pix.RGBAAt(p.X, p.Y)
+				c.bg = draw.Color(uint32(rgba.R)<<24 | uint32(rgba.G)<<16 | uint32(rgba.B)<<8 | 0xFF)
+			}
+			if tc, ok := d.screen.text[image.Pt(x, y)]; ok && tc.r >= ' ' {
+				c.r = tc.r
+				c.fg = tc.fg
+			}
+		}
+	}
+	return grid
+}
+
+// render writes the cells that differ from what the terminal shows.
+// It must be called with d.mu held.
+func (d *Display) render() {
+	grid := d.compose()
+	var fg, bg draw.Color
+	colours := false
+	at := image.Pt(-1, -1)
+	for y, row := range grid {
+		for x, c := range row {
+			if d.shown != nil && d.shown[y][x] == c {
+				continue
+			}
+			if at != image.Pt(x, y) {
+				fmt.Fprintf(d.out, "\x1b[%d;%dH", y+1, x+1)
+			}
+			if !colours || c.fg != fg {
+				fmt.Fprintf(d.out, "\x1b[38;2;%d;%d;%dm", uint8(c.fg>>24), uint8(c.fg>>16), uint8(c.fg>>8))
+				fg = c.fg
+			}
+			if !colours || c.bg != bg {
+				fmt.Fprintf(d.out, "\x1b[48;2;%d;%d;%dm", uint8(c.bg>>24), uint8(c.bg>>16), uint8(c.bg>>8))
+				bg = c.bg
+			}
+			colours = true
+			d.out.WriteRune(c.r)
+			at = image.Pt(x+1, y)
+		}
+	}
+	d.shown = grid
+
+	if d.tickr.Empty() {
+		d.out.WriteString("\x1b[?25l")
+	} else {
+		fmt.Fprintf(d.out, "\x1b[%d;%dH\x1b[?25h", d.cursor.Y+1, d.cursor.X+1)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package termdraw
+
+import (
+	"errors"
+	"os"
+)
+
+// Open is only supported on unix systems.
+func Open(tty *os.File) (*Display, error) {
+	return nil, errors.New("termdraw: terminal display not supported on this system")
+}
@@ -0,0 +1,52 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package termdraw
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/term/termios"
+	"golang.org/x/sys/unix"
+)
+
+// Open returns a Display on the terminal tty, which is put into raw mode
+// until the Display is closed. The display follows changes to the
+// terminal's size.
+func Open(tty *os.File) (*Display, error) {
+	var saved unix.Termios
+	if err := termios.Tcgetattr(tty.Fd(), &saved); err != nil {
+		return nil, err
+	}
+	raw := saved
+	termios.Cfmakeraw(&raw)
+	if err := termios.Tcsetattr(tty.Fd(), termios.TCSANOW, &raw); err != nil {
+		return nil, err
+	}
+
+	cols, rows := 80, 24
+	if ws, err := unix.IoctlGetWinsize(int(tty.Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 && ws.Row > 0 {
+		cols, rows = int(ws.Col), int(ws.Row)
+	}
+
+	d := NewDisplay(tty, tty, cols, rows)
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			if ws, err := unix.IoctlGetWinsize(int(tty.Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 && ws.Row > 0 {
+				d.Resize(int(ws.Col), int(ws.Row))
+			}
+		}
+	}()
+
+	d.closer = func() error {
+		signal.Stop(winch)
+		close(winch)
+		return termios.Tcsetattr(tty.Fd(), termios.TCSANOW, &saved)
+	}
+	return d, nil
+}
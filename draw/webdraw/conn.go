@@ -0,0 +1,201 @@
+package webdraw
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/rjkroege/edwood/draw"
+	"golang.org/x/net/websocket"
+)
+
+// clientQueue is the number of batches of operations that may wait to be
+// written to a client. A client that falls further behind is dropped.
+const clientQueue = 256
+
+// op is one canvas operation sent to the clients.
+type op struct {
+	Op    string `json:"op"` // size, fill, copy, put or snarf
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	W     int    `json:"w"`
+	H     int    `json:"h"`
+	SX    int    `json:"sx,omitempty"`    // copy source
+	SY    int    `json:"sy,omitempty"`    // copy source
+	Color string `json:"color,omitempty"` // fill colour as #rrggbb
+	PNG   []byte `json:"png,omitempty"`   // put pixels
+	Text  string `json:"text,omitempty"`  // snarf buffer
+}
+
+// input is an event sent by a client.
+type input struct {
+	Type    string `json:"type"` // mouse, key, resize or snarf
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Buttons int    `json:"buttons"` // Plan 9 button bits
+	Msec    uint32 `json:"msec"`
+	Key     string `json:"key"` // KeyboardEvent.key
+	Ctrl    bool   `json:"ctrl"`
+	W       int    `json:"w"`
+	H       int    `json:"h"`
+	Text    string `json:"text"`
+}
+
+// client is a connected browser.
+type client struct {
+	out  chan []op
+	skip int // number of pending operations already covered by the initial screen
+}
+
+// serveWebSocket accepts a client connection. Only pages served by this
+// Display under a loopback name may connect: any page the user visits
+// could otherwise type commands into the editor, either directly or by
+// rebinding its own name to the loopback address.
+func (d *Display) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	s := websocket.Server{
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			if !loopbackHost(r.Host) {
+				return fmt.Errorf("webdraw: host %q is not a loopback address", r.Host)
+			}
+			origin, err := websocket.Origin(cfg, r)
+			if err != nil {
+				return err
+			}
+			if origin == nil || origin.Host != r.Host {
+				return fmt.Errorf("webdraw: origin %v not allowed", origin)
+			}
+			cfg.Origin = origin
+			return nil
+		},
+		Handler: d.serveConn,
+	}
+	s.ServeHTTP(w, r)
+}
+
+// loopbackHost reports whether host, with an optional port, names the
+// loopback interface.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+func (d *Display) serveConn(ws *websocket.Conn) {
+	c := &client{out: make(chan []op, clientQueue)}
+
+	d.mu.Lock()
+	d.syncScreen()
+	r := d.screen.R()
+	c.skip = len(d.ops)
+	d.clients[c] = true
+	d.send(c, []op{sizeOp(r), d.put(r)})
+	d.mu.Unlock()
+
+	go func() {
+		for batch := range c.out {
+			if err := websocket.JSON.Send(ws, batch); err != nil {
+				break
+			}
+		}
+		ws.Close()
+	}()
+
+	for {
+		var in input
+		if err := websocket.JSON.Receive(ws, &in); err != nil {
+			break
+		}
+		d.handle(&in)
+	}
+
+	d.mu.Lock()
+	if d.clients[c] {
+		delete(d.clients, c)
+		close(c.out)
+	}
+	d.mu.Unlock()
+}
+
+// send queues batch for c, dropping the client if it has fallen too far
+// behind. It must be called with d.mu held.
+func (d *Display) send(c *client, batch []op) {
+	select {
+	case c.out <- batch:
+	default:
+		log.Printf("webdraw: dropping slow client")
+		delete(d.clients, c)
+		close(c.out)
+	}
+}
+
+// handle delivers an input event from a client.
+func (d *Display) handle(in *input) {
+	switch in.Type {
+	case "mouse":
+		d.mem.SendMouse(draw.Mouse{
+			Point:   image.Pt(in.X, in.Y),
+			Buttons: in.Buttons,
+			Msec:    in.Msec,
+		})
+	case "key":
+		if r, ok := keyRune(in.Key, in.Ctrl); ok {
+			d.mem.SendKey(r)
+		}
+	case "resize":
+		if in.W <= 0 || in.H <= 0 {
+			return
+		}
+		r := image.Rect(0, 0, in.W, in.H)
+		if r != d.ScreenImage().R() {
+			d.Resize(r)
+		}
+	case "snarf":
+		d.mem.WriteSnarf([]byte(in.Text))
+	}
+}
+
+var namedKeys = map[string]rune{
+	"Enter":      '\n',
+	"Backspace":  '\b',
+	"Tab":        '\t',
+	"Escape":     0x1b,
+	"Delete":     0x7f,
+	"ArrowUp":    draw.KeyUp,
+	"ArrowDown":  draw.KeyDown,
+	"ArrowLeft":  draw.KeyLeft,
+	"ArrowRight": draw.KeyRight,
+	"Home":       draw.KeyHome,
+	"End":        draw.KeyEnd,
+	"PageUp":     draw.KeyPageUp,
+	"PageDown":   draw.KeyPageDown,
+	"Insert":     draw.KeyInsert,
+}
+
+// keyRune returns the rune for a browser key value.
+func keyRune(key string, ctrl bool) (rune, bool) {
+	if r, ok := namedKeys[key]; ok {
+		return r, true
+	}
+	rs := []rune(key)
+	if len(rs) != 1 {
+		return 0, false
+	}
+	r := rs[0]
+	if ctrl {
+		switch {
+		case r >= 'a' && r <= 'z':
+			r -= 'a' - 1
+		case r >= '@' && r <= '_':
+			r -= '@'
+		}
+	}
+	return r, true
+}
@@ -0,0 +1,210 @@
+// Package webdraw implements draw.Display in a web browser. The Display
+// is an http.Handler that serves a small client page and a WebSocket
+// endpoint; the page draws onto a canvas and sends mouse and keyboard
+// events back.
+//
+// Pixels are kept by an in-memory memdraw.Display so the editor's fonts
+// and drawing look as they do under devdraw. Draw operations on the
+// screen image are streamed to the client at each Flush as a batch of
+// canvas operations: opaque fills become fill operations, scrolls within
+// the screen become copy operations and everything else is sent as PNG
+// encoded rectangles of the changed pixels.
+package webdraw
+
+import (
+	"bytes"
+	_ "embed"
+	"image"
+	"image/png"
+	"net/http"
+	"sync"
+
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/draw/memdraw"
+)
+
+// maxDirty is the number of separate changed rectangles kept before they
+// are merged into one.
+const maxDirty = 32
+
+//go:embed index.html
+var indexHTML []byte
+
+var _ = draw.Display((*Display)(nil))
+
+// Display implements draw.Display for browser clients.
+type Display struct {
+	mem *memdraw.Display
+
+	mu      sync.Mutex
+	screen  *webImage
+	ops     []op              // operations since the last Flush
+	dirty   []image.Rectangle // changed screen rectangles not yet in ops
+	clients map[*client]bool
+
+	white, black, opaque, transparent *webImage
+}
+
+// NewDisplay returns a Display whose screen initially covers r. The
+// screen follows the size of the browser window once a client connects.
+func NewDisplay(r image.Rectangle) *Display {
+	d := &Display{
+		mem:     memdraw.NewDisplay(r),
+		clients: make(map[*client]bool),
+	}
+	d.screen = d.wrap(d.mem.ScreenImage(), false)
+	d.white = d.wrap(d.mem.White(), true)
+	d.black = d.wrap(d.mem.Black(), true)
+	d.opaque = d.wrap(d.mem.Opaque(), true)
+	d.transparent = d.wrap(d.mem.Transparent(), true)
+	return d
+}
+
+// ServeHTTP serves the client page at / and the client connection at /ws.
+func (d *Display) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexHTML)
+	case "/ws":
+		d.serveWebSocket(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Resize replaces the screen with a blank one covering r and signals the
+// resize on the Mousectl. Pending operations for the old screen are
+// dropped.
+func (d *Display) Resize(r image.Rectangle) {
+	d.mem.Resize(r)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.syncScreen()
+	d.dirty = nil
+	d.ops = []op{sizeOp(r)}
+	for c := range d.clients {
+		c.skip = 0
+	}
+}
+
+// syncScreen wraps memdraw's screen image if it has been replaced. It
+// must be called with d.mu held.
+func (d *Display) syncScreen() {
+	if s := d.mem.ScreenImage(); s != d.screen.img {
+		d.screen = d.wrap(s, false)
+	}
+}
+
+func (d *Display) ScreenImage() draw.Image {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.syncScreen()
+	return d.screen
+}
+
+func (d *Display) White() draw.Image       { return d.white }
+func (d *Display) Black() draw.Image       { return d.black }
+func (d *Display) Opaque() draw.Image      { return d.opaque }
+func (d *Display) Transparent() draw.Image { return d.transparent }
+
+func (d *Display) InitKeyboard() *draw.Keyboardctl { return d.mem.InitKeyboard() }
+func (d *Display) InitMouse() *draw.Mousectl       { return d.mem.InitMouse() }
+
+func (d *Display) OpenFont(name string) (draw.Font, error) { return d.mem.OpenFont(name) }
+
+func (d *Display) AllocImage(r image.Rectangle, pix draw.Pix, repl bool, val draw.Color) (draw.Image, error) {
+	i, err := d.mem.AllocImage(r, pix, repl, val)
+	if err != nil {
+		return nil, err
+	}
+	return d.wrap(i, repl), nil
+}
+
+func (d *Display) AllocImageMix(color1, color3 draw.Color) draw.Image {
+	return d.wrap(d.mem.AllocImageMix(color1, color3), true)
+}
+
+func (d *Display) Attach(ref int) error { return nil }
+func (d *Display) ScaleSize(n int) int  { return n }
+
+func (d *Display) ReadSnarf(buf []byte) (int, int, error) { return d.mem.ReadSnarf(buf) }
+
+// WriteSnarf stores data as the snarf buffer and offers it to the
+// clients' clipboards.
+func (d *Display) WriteSnarf(data []byte) error {
+	d.mu.Lock()
+	d.ops = append(d.ops, op{Op: "snarf", Text: string(data)})
+	d.mu.Unlock()
+	return d.mem.WriteSnarf(data)
+}
+
+// MoveTo records pt as the mouse position. Browsers don't allow the
+// pointer to be moved.
+func (d *Display) MoveTo(pt image.Point) error    { return d.mem.MoveTo(pt) }
+func (d *Display) SetCursor(c *draw.Cursor) error { return nil }
+
+// Flush sends the operations since the last Flush to every client.
+func (d *Display) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.capture()
+	for c := range d.clients {
+		if batch := d.ops[c.skip:]; len(batch) > 0 {
+			d.send(c, batch)
+		}
+		c.skip = 0
+	}
+	d.ops = nil
+	return nil
+}
+
+// addDirty records that r of the screen has changed. Overlapping
+// rectangles are merged. It must be called with d.mu held.
+func (d *Display) addDirty(r image.Rectangle) {
+	r = r.Intersect(d.screen.R())
+	if r.Empty() {
+		return
+	}
+	for i := 0; i < len(d.dirty); {
+		if d.dirty[i].Overlaps(r) {
+			r = r.Union(d.dirty[i])
+			d.dirty = append(d.dirty[:i], d.dirty[i+1:]...)
+			i = 0
+			continue
+		}
+		i++
+	}
+	d.dirty = append(d.dirty, r)
+	if len(d.dirty) > maxDirty {
+		u := image.Rectangle{}
+		for _, r := range d.dirty {
+			u = u.Union(r)
+		}
+		d.dirty = []image.Rectangle{u}
+	}
+}
+
+// capture turns the dirty rectangles into put operations holding the
+// current pixels. It must be called with d.mu held.
+func (d *Display) capture() {
+	for _, r := range d.dirty {
+		d.ops = append(d.ops, d.put(r))
+	}
+	d.dirty = nil
+}
+
+// put returns an operation that sets r of the client's canvas to r of
+// the screen.
+func (d *Display) put(r image.Rectangle) op {
+	pix := memdraw.RGBA(d.screen.img)
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	enc.Encode(&buf, pix.SubImage(r))
+	return op{Op: "put", X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy(), PNG: buf.Bytes()}
+}
+
+func sizeOp(r image.Rectangle) op {
+	return op{Op: "size", W: r.Dx(), H: r.Dy()}
+}
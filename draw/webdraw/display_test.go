@@ -0,0 +1,276 @@
+package webdraw
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/draw/memdraw"
+	"golang.org/x/net/websocket"
+)
+
+// connect starts a server for d and connects a client to it. It returns
+// the client connection after reading the initial screen.
+func connect(t *testing.T, d *Display) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(d)
+	t.Cleanup(srv.Close)
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "", srv.URL)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	ops := receive(t, ws)
+	r := d.ScreenImage().R()
+	if len(ops) != 2 || !cmp.Equal(ops[0], sizeOp(r)) || ops[1].Op != "put" {
+		t.Fatalf("initial operations are %v", ops)
+	}
+	checkPut(t, d, ops[1])
+	return ws
+}
+
+func receive(t *testing.T, ws *websocket.Conn) []op {
+	t.Helper()
+	var ops []op
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.JSON.Receive(ws, &ops); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	return ops
+}
+
+// checkPut checks that put holds the screen's pixels.
+func checkPut(t *testing.T, d *Display, put op) {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(put.PNG))
+	if err != nil {
+		t.Fatalf("bad PNG: %v", err)
+	}
+	r := image.Rect(put.X, put.Y, put.X+put.W, put.Y+put.H)
+	if got := img.Bounds().Size(); got != r.Size() {
+		t.Fatalf("PNG size %v; want %v", got, r.Size())
+	}
+	screen := memdraw.RGBA(unwrap(d.ScreenImage()))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r1, g1, b1, _ := img.At(x-r.Min.X, y-r.Min.Y).RGBA()
+			r2, g2, b2, _ := screen.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 {
+				t.Fatalf("pixel %d,%d differs", x, y)
+			}
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	srv := httptest.NewServer(NewDisplay(image.Rect(0, 0, 10, 10)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(b), "new WebSocket(") {
+		t.Errorf("client page not served: %q", b)
+	}
+
+	resp, err = http.Get(srv.URL + "/nothing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %v; want 404", resp.Status)
+	}
+}
+
+func TestForeignOrigin(t *testing.T) {
+	srv := httptest.NewServer(NewDisplay(image.Rect(0, 0, 10, 10)))
+	defer srv.Close()
+	if ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "", "http://example.com/"); err == nil {
+		ws.Close()
+		t.Errorf("connection from another origin was accepted")
+	}
+}
+
+// A page whose name has been rebound to the loopback address is refused.
+func TestForeignHost(t *testing.T) {
+	srv := httptest.NewServer(NewDisplay(image.Rect(0, 0, 10, 10)))
+	defer srv.Close()
+	cfg, err := websocket.NewConfig("ws://rebound.example/ws", "http://rebound.example/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ws, err := websocket.NewClient(cfg, conn); err == nil {
+		ws.Close()
+		t.Errorf("connection for host %v was accepted", cfg.Location.Host)
+	}
+}
+
+func TestLoopbackHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"localhost":         true,
+		"localhost:8080":    true,
+		"127.0.0.1:8080":    true,
+		"[::1]:8080":        true,
+		"::1":               true,
+		"example.com:8080":  false,
+		"192.168.1.2:8080":  false,
+		"localhost.evil.io": false,
+		"":                  false,
+	} {
+		if got := loopbackHost(host); got != want {
+			t.Errorf("loopbackHost(%q) = %v; want %v", host, got, want)
+		}
+	}
+}
+
+func TestOperations(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 100, 60))
+	ws := connect(t, d)
+	screen := d.ScreenImage()
+	red, _ := d.AllocImage(image.Rect(0, 0, 1, 1), screen.Pix(), true, 0xFF0000FF)
+	f, err := d.OpenFont("/lib/font/bit/lucsans/euro.8.font")
+	if err != nil {
+		t.Fatalf("OpenFont failed: %v", err)
+	}
+
+	screen.Draw(image.Rect(-10, 0, 50, 20), red, nil, image.Point{})
+	screen.Bytes(image.Pt(10, 30), d.Black(), image.Point{}, f, []byte("hello"))
+	screen.Draw(image.Rect(0, 10, 100, 60), screen, nil, image.Pt(0, 0))
+	screen.Draw(image.Rect(0, 0, 100, 10), nil, d.Opaque(), image.Point{})
+	d.Flush()
+
+	ops := receive(t, ws)
+	if got, want := len(ops), 4; got != want {
+		t.Fatalf("got %d operations; want %d: %v", got, want, ops)
+	}
+	if diff := cmp.Diff(op{Op: "fill", X: 0, Y: 0, W: 50, H: 20, Color: "#ff0000"}, ops[0]); diff != "" {
+		t.Errorf("fill mismatch (-want +got):\n%s", diff)
+	}
+	if ops[1].Op != "put" || !image.Rect(ops[1].X, ops[1].Y, ops[1].X+ops[1].W, ops[1].Y+ops[1].H).Overlaps(image.Rect(10, 30, 40, 40)) {
+		t.Errorf("got %v; want put of the text", ops[1])
+	}
+	if diff := cmp.Diff(op{Op: "copy", X: 0, Y: 10, W: 100, H: 50, SX: 0, SY: 0}, ops[2]); diff != "" {
+		t.Errorf("copy mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(op{Op: "fill", X: 0, Y: 0, W: 100, H: 10, Color: "#000000"}, ops[3]); diff != "" {
+		t.Errorf("fill mismatch (-want +got):\n%s", diff)
+	}
+
+	// Masked drawing is sent as pixels after the drawing is done.
+	screen.Draw(image.Rect(20, 20, 30, 30), red, d.Transparent(), image.Point{})
+	screen.Bytes(image.Pt(0, 0), red, image.Point{}, f, []byte("x"))
+	d.Flush()
+	ops = receive(t, ws)
+	for _, o := range ops {
+		if o.Op != "put" {
+			t.Errorf("got %v; want put", o)
+			continue
+		}
+		checkPut(t, d, o)
+	}
+
+	// Nothing happens when nothing has been drawn.
+	d.Flush()
+	d.WriteSnarf([]byte("snarfed"))
+	d.Flush()
+	if diff := cmp.Diff([]op{{Op: "snarf", Text: "snarfed"}}, receive(t, ws)); diff != "" {
+		t.Errorf("snarf mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOffscreenNotSent(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 10, 10))
+	ws := connect(t, d)
+	screen := d.ScreenImage()
+	img, _ := d.AllocImage(image.Rect(0, 0, 10, 10), screen.Pix(), false, draw.White)
+	img.Draw(img.R(), d.Black(), nil, image.Point{})
+	d.Flush()
+	screen.Draw(image.Rect(0, 0, 5, 5), img, nil, image.Point{})
+	d.Flush()
+	ops := receive(t, ws)
+	if len(ops) != 1 || ops[0].Op != "put" {
+		t.Fatalf("got %v; want one put", ops)
+	}
+	checkPut(t, d, ops[0])
+}
+
+func TestInput(t *testing.T) {
+	d := NewDisplay(image.Rect(0, 0, 100, 60))
+	mc := d.InitMouse()
+	kc := d.InitKeyboard()
+	ws := connect(t, d)
+
+	send := func(in input) {
+		if err := websocket.JSON.Send(ws, in); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	send(input{Type: "mouse", X: 12, Y: 34, Buttons: 4, Msec: 99})
+	m := <-mc.C
+	if want := (draw.Mouse{Point: image.Pt(12, 34), Buttons: 4, Msec: 99}); m != want {
+		t.Errorf("got mouse %v; want %v", m, want)
+	}
+
+	for _, tc := range []struct {
+		key  string
+		ctrl bool
+		want rune
+	}{
+		{"a", false, 'a'},
+		{"λ", false, 'λ'},
+		{"Enter", false, '\n'},
+		{"ArrowUp", false, draw.KeyUp},
+		{"f", true, 0x06},
+		{"Shift", false, -1},
+		{"Backspace", false, '\b'},
+	} {
+		send(input{Type: "key", Key: tc.key, Ctrl: tc.ctrl})
+		if tc.want < 0 {
+			continue
+		}
+		if r := <-kc.C; r != tc.want {
+			t.Errorf("key %q ctrl %v: got %q; want %q", tc.key, tc.ctrl, r, tc.want)
+		}
+	}
+
+	send(input{Type: "snarf", Text: "from browser"})
+	send(input{Type: "resize", W: 200, H: 80})
+	select {
+	case <-mc.Resize:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no resize")
+	}
+	b := make([]byte, 100)
+	if n, _, _ := d.ReadSnarf(b); string(b[:n]) != "from browser" {
+		t.Errorf("snarf buffer is %q", b[:n])
+	}
+	r := image.Rect(0, 0, 200, 80)
+	if got := d.ScreenImage().R(); got != r {
+		t.Errorf("screen is %v; want %v", got, r)
+	}
+	d.Flush()
+	if diff := cmp.Diff([]op{sizeOp(r)}, receive(t, ws)); diff != "" {
+		t.Errorf("resize mismatch (-want +got):\n%s", diff)
+	}
+}
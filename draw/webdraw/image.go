@@ -0,0 +1,114 @@
+package webdraw
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/draw/memdraw"
+)
+
+var _ = draw.Image((*webImage)(nil))
+
+// webImage implements draw.Image. Drawing is done by the wrapped memdraw
+// image; drawing on the screen is also recorded for the clients.
+type webImage struct {
+	d    *Display
+	img  draw.Image
+	repl bool
+}
+
+func (d *Display) wrap(i draw.Image, repl bool) *webImage {
+	return &webImage{d: d, img: i, repl: repl}
+}
+
+func unwrap(i draw.Image) draw.Image {
+	if i == nil {
+		return nil
+	}
+	return i.(*webImage).img
+}
+
+func (i *webImage) Display() draw.Display { return i.d }
+func (i *webImage) Pix() draw.Pix         { return i.img.Pix() }
+func (i *webImage) R() image.Rectangle    { return i.img.R() }
+func (i *webImage) Free() error           { return i.img.Free() }
+
+func (i *webImage) Draw(r image.Rectangle, src, mask draw.Image, p1 image.Point) {
+	i.img.Draw(r, unwrap(src), unwrap(mask), p1)
+
+	d := i.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if i != d.screen {
+		return
+	}
+	sr := i.R()
+	cr := r.Intersect(sr)
+	if cr.Empty() {
+		return
+	}
+	p1 = p1.Add(cr.Min.Sub(r.Min))
+
+	if mask == nil || isOpaque(mask) {
+		if src == i && (image.Rectangle{p1, p1.Add(cr.Size())}).In(sr) {
+			// A scroll: the client copies from its own canvas so the
+			// changes so far must be sent first.
+			d.capture()
+			d.ops = append(d.ops, op{Op: "copy", X: cr.Min.X, Y: cr.Min.Y, W: cr.Dx(), H: cr.Dy(), SX: p1.X, SY: p1.Y})
+			return
+		}
+		if c, ok := solid(src); ok {
+			d.ops = append(d.ops, op{Op: "fill", X: cr.Min.X, Y: cr.Min.Y, W: cr.Dx(), H: cr.Dy(), Color: c})
+			return
+		}
+	}
+	d.addDirty(cr)
+}
+
+func (i *webImage) Border(r image.Rectangle, n int, color draw.Image, sp image.Point) {
+	if n < 0 {
+		r = r.Inset(n)
+		sp = sp.Add(image.Pt(n, n))
+		n = -n
+	}
+	i.Draw(image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+n), color, nil, sp)
+	i.Draw(image.Rect(r.Min.X, r.Max.Y-n, r.Max.X, r.Max.Y), color, nil, sp.Add(image.Pt(0, r.Dy()-n)))
+	i.Draw(image.Rect(r.Min.X, r.Min.Y+n, r.Min.X+n, r.Max.Y-n), color, nil, sp.Add(image.Pt(0, n)))
+	i.Draw(image.Rect(r.Max.X-n, r.Min.Y+n, r.Max.X, r.Max.Y-n), color, nil, sp.Add(image.Pt(r.Dx()-n, n)))
+}
+
+func (i *webImage) Bytes(pt image.Point, src draw.Image, sp image.Point, f draw.Font, b []byte) image.Point {
+	end := i.img.Bytes(pt, unwrap(src), sp, f, b)
+
+	i.d.mu.Lock()
+	defer i.d.mu.Unlock()
+	if i == i.d.screen {
+		// Glyphs may overhang their advance a little.
+		h := f.Height()
+		i.d.addDirty(image.Rect(pt.X-h, pt.Y, end.X+h, pt.Y+h))
+	}
+	return end
+}
+
+// isOpaque reports whether i is a replicated single opaque pixel.
+func isOpaque(i draw.Image) bool {
+	wi := i.(*webImage)
+	pix := memdraw.RGBA(wi.img)
+	r := wi.R()
+	return wi.repl && pix != nil && r.Dx() == 1 && r.Dy() == 1 && pix.RGBAAt(r.Min.X, r.Min.Y).A == 0xFF
+}
+
+// solid returns the CSS colour of src if it is a replicated single opaque
+// pixel. A nil src is black.
+func solid(src draw.Image) (string, bool) {
+	if src == nil {
+		return "#000000", true
+	}
+	if !isOpaque(src) {
+		return "", false
+	}
+	r := src.R()
+	c := memdraw.RGBA(unwrap(src)).RGBAAt(r.Min.X, r.Min.Y)
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B), true
+}
@@ -144,10 +144,11 @@ func (c *Content) Save(file string) error {
 	}
 	defer f.Close()
 
-	return c.encode(f)
+	return c.Encode(f)
 }
 
-func (c *Content) encode(w io.Writer) error {
+// Encode writes c to w in dump file format.
+func (c *Content) Encode(w io.Writer) error {
 	vc := versionedContent{
 		Version: version,
 		Content: c,
@@ -67,7 +67,7 @@ func TestEncodeDecode(t *testing.T) {
 	for _, tc := range testTab {
 		var b bytes.Buffer
 
-		err := tc.encode(&b)
+		err := tc.Encode(&b)
 		if err != nil {
 			t.Errorf("Marshal failed: %v\n", err)
 			continue
@@ -203,13 +203,20 @@ func editerror(format string, args ...interface{}) {
 }
 
 func editcmd(ct *Text, r []rune) {
+	if err := runedit(ct, r); err != nil {
+		warning(nil, "%v\n", err)
+	}
+}
+
+// runedit runs the Edit command r with ct as the current text and
+// returns any error instead of reporting it.
+func runedit(ct *Text, r []rune) error {
 	if len(r) == 0 {
-		return
+		return nil
 	}
 
 	if len(r) > 2*RBUFSIZE {
-		warning(nil, "string too long\n")
-		return
+		return fmt.Errorf("string too long")
 	}
 
 	global.row.AllWindows(alleditinit)
@@ -230,10 +237,16 @@ func editcmd(ct *Text, r []rune) {
 	err := <-editerrc
 	global.editing = Inactive
 	if err != nil {
-		warning(nil, "Edit: %s\n", err)
+		err = fmt.Errorf("Edit: %s", err)
 	}
 	// update everyone whose edit log has data
 	global.row.AllWindows(allupdate)
+	if ct.w != nil {
+		xfidlog(ct.w, "edit")
+	} else {
+		xfidlogf(0, "edit", "")
+	}
+	return err
 }
 
 func newCmdParser(r []rune) *cmdParser {
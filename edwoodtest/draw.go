@@ -28,6 +28,10 @@ type GettableDrawOps interface {
 	DrawOps() []string
 	Clear()
 
+	// ScreenDrawOps returns the executed draw ops that drew on the
+	// screen image, leaving out those on images such as scratch buffers.
+	ScreenDrawOps() []string
+
 	// SVGDrawOps writes the accumulated SVG format drawops to w where rect
 	// is the area of interest for the drawops.
 	SVGDrawOps(w io.Writer) error
@@ -35,9 +39,10 @@ type GettableDrawOps interface {
 
 // mockDisplay implements draw.Display.
 type mockDisplay struct {
-	snarfbuf []byte
-	mu       sync.Mutex
-	drawops  []string
+	snarfbuf  []byte
+	mu        sync.Mutex
+	drawops   []string
+	screenops []string // the drawops on the screen image
 
 	// TODO(rjk): This is essentially the same as drawops above. Except that
 	// I have pruned the drawops array at various points. And that would mean
@@ -143,7 +148,8 @@ func (d *mockDisplay) WriteSnarf(data []byte) error {
 func (d *mockDisplay) MoveTo(pt image.Point) error    { return nil }
 func (d *mockDisplay) SetCursor(c *draw.Cursor) error { return nil }
 func (d *mockDisplay) DrawOps() []string              { return d.drawops }
-func (d *mockDisplay) Clear()                         { d.drawops = nil }
+func (d *mockDisplay) ScreenDrawOps() []string        { return d.screenops }
+func (d *mockDisplay) Clear()                         { d.drawops, d.screenops = nil, nil }
 
 func (d *mockDisplay) SVGDrawOps(w io.Writer) error {
 	return singlesvgfile(w, d.svgdrawops, d.annotations, d.rectofi)
@@ -171,6 +177,14 @@ func newimageimpl(d *mockDisplay, name string, c draw.Color, r image.Rectangle)
 	}
 }
 
+// SetScreenRect changes the bounds of the screen image of a mock display,
+// as when the window holding it is resized. The change is seen by
+// ScreenImage.
+func SetScreenRect(display draw.Display, r image.Rectangle) {
+	d := display.(*mockDisplay)
+	d.screenimage = newimageimpl(d, fmt.Sprintf("screen-%dx%d", r.Dx(), r.Dy()), draw.Notacolor, r)
+}
+
 // NewImage returns a mock draw.Image with the given bounds.
 func NewImage(display draw.Display, name string, r image.Rectangle) draw.Image {
 	d := display.(*mockDisplay)
@@ -288,7 +302,15 @@ func (i *mockImage) Draw(r image.Rectangle, src, mask draw.Image, p1 image.Point
 			i.d.annotations = append(i.d.annotations, op)
 		}
 	}
+	i.record(op)
+}
+
+// record adds op to the display's draw ops.
+func (i *mockImage) record(op string) {
 	i.d.drawops = append(i.d.drawops, op)
+	if i.d.screenimage == i {
+		i.d.screenops = append(i.d.screenops, op)
+	}
 }
 
 func (i *mockImage) Border(r image.Rectangle, n int, color draw.Image, sp image.Point) {
@@ -304,7 +326,7 @@ func (i *mockImage) Border(r image.Rectangle, n int, color draw.Image, sp image.
 		colorname,
 		sp,
 	)
-	i.d.drawops = append(i.d.drawops, op)
+	i.record(op)
 }
 
 func (i *mockImage) Bytes(pt image.Point, src draw.Image, sp image.Point, f draw.Font, b []byte) image.Point {
@@ -320,7 +342,7 @@ func (i *mockImage) Bytes(pt image.Point, src draw.Image, sp image.Point, f draw
 		pointochars(pt),
 		srcname,
 	)
-	i.d.drawops = append(i.d.drawops, op)
+	i.record(op)
 
 	// TODO(rjk): Remove this duplication when I've switched to always using
 	// the SVG path for baselines and such.
@@ -56,6 +56,7 @@ type Exectab struct {
 var globalexectab = []Exectab{
 	//	{ "Abort",		doabort,	false,	true /*unused*/,		true /*unused*/,		},
 	{"Cut", cut, true, true, true},
+	{"Def", lspdef, false, true /*unused*/, true /*unused*/},
 	{"Del", del, false, false, true /*unused*/},
 	{"Delcol", delcol, false, true /*unused*/, true /*unused*/},
 	{"Delete", del, false, true, true /*unused*/},
@@ -63,7 +64,10 @@ var globalexectab = []Exectab{
 	{"Edit", edit, false, true /*unused*/, true /*unused*/},
 	{"Exit", xexit, false, true /*unused*/, true /*unused*/},
 	{"Font", fontx, false, true /*unused*/, true /*unused*/},
+	{"Format", lspformat, false, true /*unused*/, true /*unused*/},
 	{"Get", get, false, true, true /*unused*/},
+	{"Grow", grow, false, true /*unused*/, true /*unused*/},
+	{"Hover", lsphover, false, true /*unused*/, true /*unused*/},
 	{"ID", id, false, true /*unused*/, true /*unused*/},
 	//	{ "Incl",		incl,		false,	true /*unused*/,		true /*unused*/		},
 	{"Indent", indent, false, true /*unused*/, true /*unused*/},
@@ -73,10 +77,13 @@ var globalexectab = []Exectab{
 	{"Look", look, false, true /*unused*/, true /*unused*/},
 	{"New", newx, false, true /*unused*/, true /*unused*/},
 	{"Newcol", newcol, false, true /*unused*/, true /*unused*/},
+	{"Outline", outlinex, false, true /*unused*/, true /*unused*/},
 	{"Paste", paste, true, true, true /*unused*/},
 	{"Put", put, false, true /*unused*/, true /*unused*/},
 	{"Putall", putall, false, true /*unused*/, true /*unused*/},
 	{"Redo", undo, false, false, true /*unused*/},
+	{"Refs", lsprefs, false, true /*unused*/, true /*unused*/},
+	{"Rename", lsprename, false, true /*unused*/, true /*unused*/},
 	{"Send", sendx, true, true /*unused*/, true /*unused*/},
 	{"Snarf", cut, false, true, false},
 	{"Sort", sortx, false, true /*unused*/, true /*unused*/},
@@ -234,6 +241,24 @@ func delegateExecution(t *Text, e *Exectab, aq0, aq1, q0, q1 int, argt *Text) {
 			t.w.Eventf("%c0 0 0 0 \n", c)
 		}
 	}
+
+	if t.w.nopen[QWeventjson] > 0 {
+		ev := &Event{
+			Type: string(c),
+			Q0:   aq0,
+			Q1:   aq1,
+			Flag: f,
+			Text: t.file.StringSlice(aq0, aq1),
+			Arg:  a,
+		}
+		if q0 != aq0 || q1 != aq1 {
+			ev.Expansion = &EventRange{Q0: q0, Q1: q1, Text: t.file.StringSlice(q0, q1)}
+		}
+		if a != "" {
+			ev.ArgLoc = aa
+		}
+		t.w.EventJSON(ev)
+	}
 }
 
 // execute must run with an existing lock on t's Window
@@ -246,7 +271,7 @@ func execute(t *Text, aq0 int, aq1 int, external bool, argt *Text) {
 
 	// Send commands to external client if the target window's event file is
 	// in use.
-	if !external && t.w != nil && t.w.nopen[QWevent] > 0 {
+	if !external && t.w != nil && t.w.eventsopen() {
 		delegateExecution(t, e, aq0, aq1, q0, q1, argt)
 		return
 	}
@@ -384,7 +409,7 @@ func delcol(et *Text, _ *Text, _ *Text, _, _ bool, _ string) {
 	}
 	for i := 0; i < len(c.w); i++ {
 		w := c.w[i]
-		if w.nopen[QWevent]+w.nopen[QWaddr]+w.nopen[QWdata]+w.nopen[QWxdata] > 0 {
+		if w.eventsopen() || w.nopen[QWaddr]+w.nopen[QWdata]+w.nopen[QWxdata] > 0 {
 			warning(nil, "can't delete column; %s is running an external command\n", w.body.file.Name())
 			return
 		}
@@ -603,14 +628,16 @@ func put(et *Text, _0 *Text, argt *Text, _1 bool, _2 bool, arg string) {
 		return
 	}
 	name = UnquoteFilename(name)
-	putfile(w.body.file, 0, f.Nr(), name)
+	if putfile(w.body.file, 0, f.Nr(), name) == nil {
+		lspsaved(&w.body)
+	}
 	xfidlog(w, "put")
 }
 
 func putall(et, _, _ *Text, _, _ bool, arg string) {
 	for _, col := range global.row.col {
 		for _, w := range col.w {
-			if w.nopen[QWevent] > 0 {
+			if w.eventsopen() {
 				continue
 			}
 			a := w.body.file.Name()
@@ -1001,6 +1028,7 @@ func runproc(win *Window, s string, dir string, newns bool, argaddr string, arg
 		}
 		// fsunmount(fs); looks like with plan9.client you just drop it on the floor.
 		fs = nil
+		c.winid = winid
 	} else {
 		// TODO(fhs): If runtime.GOOS is plan9, we need to execute the command in
 		// Edwood's file name space and environment variable group.
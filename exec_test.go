@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"image"
 	"os"
 	"path/filepath"
@@ -817,8 +818,9 @@ func TestDelegateExecution(t *testing.T) {
 	// TODO(rjk): Test that the Edwood sets the source letters correctly.
 	w.owner = 'T'
 
-	// Pretend that we have an event reader.
+	// Pretend that we have event readers.
 	w.nopen[QWevent] = 1
+	w.nopen[QWeventjson] = 1
 
 	w.tag.q0 = 8
 	w.tag.q1 = 10
@@ -831,12 +833,28 @@ func TestDelegateExecution(t *testing.T) {
 		t, argt  *Text
 		want     string
 		q0, q1   int
+		json     Event
 	}{
 		// Correctness is based on equivalence to Acme.
-		{nil, 0, 0, &w.body, nil, "TX0 0 2 0 \nTX0 3 0 3 Bye\n", 0, 0},
-		{nil, 1, 1, &w.body, &w.tag, "TX1 1 10 0 \nTX0 3 0 3 Bye\nTX0 0 0 2 世界\nTX0 0 0 0 \n", 0, 0},
-		{nil, 1, 1, &w.tag, nil, "Tx1 1 2 0 \nTx0 6 0 6 /Hello\n", 0, 0},
-		{nil, 1, 1, &w.body, &w.body, "TX1 1 10 0 \nTX0 3 0 3 Bye\nTX0 0 0 5 さようなら\nTX0 0 0 15 hello_世界:#5,#10\n", 5, 10},
+		{nil, 0, 0, &w.body, nil, "TX0 0 2 0 \nTX0 3 0 3 Bye\n", 0, 0, Event{
+			Origin: "T", Type: "X", Flag: 2,
+			Expansion: &EventRange{Q0: 0, Q1: 3, Text: "Bye"},
+		}},
+		{nil, 1, 1, &w.body, &w.tag, "TX1 1 10 0 \nTX0 3 0 3 Bye\nTX0 0 0 2 世界\nTX0 0 0 0 \n", 0, 0, Event{
+			Origin: "T", Type: "X", Q0: 1, Q1: 1, Flag: 10,
+			Expansion: &EventRange{Q0: 0, Q1: 3, Text: "Bye"},
+			Arg:       "世界",
+		}},
+		{nil, 1, 1, &w.tag, nil, "Tx1 1 2 0 \nTx0 6 0 6 /Hello\n", 0, 0, Event{
+			Origin: "T", Type: "x", Q0: 1, Q1: 1, Flag: 2,
+			Expansion: &EventRange{Q0: 0, Q1: 6, Text: "/Hello"},
+		}},
+		{nil, 1, 1, &w.body, &w.body, "TX1 1 10 0 \nTX0 3 0 3 Bye\nTX0 0 0 5 さようなら\nTX0 0 0 15 hello_世界:#5,#10\n", 5, 10, Event{
+			Origin: "T", Type: "X", Q0: 1, Q1: 1, Flag: 10,
+			Expansion: &EventRange{Q0: 0, Q1: 3, Text: "Bye"},
+			Arg:       "さようなら",
+			ArgLoc:    "hello_世界:#5,#10",
+		}},
 	} {
 		w.body.q0, w.body.q1 = tc.q0, tc.q1
 		q0, q1 := expandRuneOffsetsToWord(tc.t, tc.aq0, tc.aq1)
@@ -847,6 +865,14 @@ func TestDelegateExecution(t *testing.T) {
 			t.Log(got)
 			t.Errorf("delegateExection mismatch (-want +got):\n%s", diff)
 		}
+		var ev Event
+		if err := json.Unmarshal(w.jsonevents, &ev); err != nil {
+			t.Errorf("bad JSON event %q: %v", w.jsonevents, err)
+		}
+		if diff := cmp.Diff(tc.json, ev); diff != "" {
+			t.Errorf("delegateExection JSON mismatch (-want +got):\n%s", diff)
+		}
 		w.events = w.events[0:0]
+		w.jsonevents = w.jsonevents[0:0]
 	}
 }
@@ -567,6 +567,15 @@ func (b *Buffer) RedoSeq() int {
 	return 0
 }
 
+// UndoSeq returns the seq of the action that Undo would undo next, or 0
+// if there's nothing to undo.
+func (b *Buffer) UndoSeq() int {
+	if b.head > 0 {
+		return b.actions[b.head-1].seq
+	}
+	return 0
+}
+
 func (b *Buffer) shiftAction() *action {
 	if b.head > len(b.actions)-1 {
 		return nil
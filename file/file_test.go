@@ -361,6 +361,9 @@ func TestFileRedoSeq(t *testing.T) {
 	if got, want := f.RedoSeq(), 0; got != want {
 		t.Errorf("TestFileRedoSeq no redo. got %#v want %#v", got, want)
 	}
+	if got, want := f.UndoSeq(), 1; got != want {
+		t.Errorf("TestFileRedoSeq undo. got %#v want %#v", got, want)
+	}
 
 	f.checkedUndo(true, t, undoexpectation{
 		ok: true,
@@ -373,6 +376,9 @@ func TestFileRedoSeq(t *testing.T) {
 	if got, want := f.RedoSeq(), 1; got != want {
 		t.Errorf("TestFileRedoSeq no redo. got %#v want %#v", got, want)
 	}
+	if got, want := f.UndoSeq(), 0; got != want {
+		t.Errorf("TestFileRedoSeq no undo. got %#v want %#v", got, want)
+	}
 }
 
 func TestFileUpdateInfo(t *testing.T) {
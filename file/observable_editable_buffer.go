@@ -436,6 +436,12 @@ func (e *ObservableEditableBuffer) RedoSeq() int {
 	return e.f.RedoSeq()
 }
 
+// UndoSeq finds the seq of the record that Undo would undo next. Forwards
+// its implementation to file.Buffer.
+func (e *ObservableEditableBuffer) UndoSeq() int {
+	return e.f.UndoSeq()
+}
+
 // inserted is a package-only entry point from the underlying
 // buffer (file.Buffer or file.File) to run the registered observers
 // on a change in the buffer.
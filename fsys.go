@@ -8,6 +8,7 @@ import (
 	"os/user"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,6 +23,11 @@ type fileServer struct {
 	closing     bool
 	username    string
 	messagesize int
+	caps        *fsysCaps      // limits every attach; nil for none
+	client      bool           // conn is one client from fsysaccept; losing it is not fatal
+	dotl        bool           // the client negotiated 9P2000.L
+	inflight    sync.WaitGroup // requests being run by Xfids
+	nattach     int            // attaches so far, numbering the clients
 }
 
 const DEBUG = false
@@ -43,43 +49,61 @@ func (fs *fileServer) initfcall() {
 	fs.fcall[plan9.Tremove] = fs.remove
 	fs.fcall[plan9.Tstat] = fs.stat
 	fs.fcall[plan9.Twstat] = fs.wstat
+	fs.initfcallL()
 }
 
 // Errors returned by file server.
 var (
-	ErrPermission = os.ErrPermission
-	ErrNotExist   = os.ErrNotExist
-	ErrNotDir     = fmt.Errorf("not a directory")
+	ErrPermission  = os.ErrPermission
+	ErrNotExist    = os.ErrNotExist
+	ErrNotDir      = fmt.Errorf("not a directory")
+	ErrNewInWindow = fmt.Errorf("walk to new in a window directory")
 )
 
 var dirtab = []*DirTab{
 	{".", plan9.QTDIR, Qdir, 0500 | plan9.DMDIR},
 	{"acme", plan9.QTDIR, Qacme, 0500 | plan9.DMDIR},
+	{"col", plan9.QTDIR, Qcol, 0500 | plan9.DMDIR},
 	{"cons", plan9.QTFILE, Qcons, 0600},
 	{"consctl", plan9.QTFILE, Qconsctl, 0000},
+	{"ctl", plan9.QTFILE, Qctl, 0600},
 	{"draw", plan9.QTDIR, Qdraw, 0000 | plan9.DMDIR}, // to suppress graphics progs started in acme
 	{"editout", plan9.QTFILE, Qeditout, 0200},
 	{"index", plan9.QTFILE, Qindex, 0400},
+	{"index.json", plan9.QTFILE, Qindexjson, 0400},
 	{"label", plan9.QTFILE, Qlabel, 0600},
 	{"log", plan9.QTFILE, Qlog, 0400},
 	{"new", plan9.QTDIR, Qnew, 0500 | plan9.DMDIR},
+	{"snarf", plan9.QTFILE, Qsnarf, 0600},
 }
 
 var dirtabw = []*DirTab{
 	{".", plan9.QTDIR, Qdir, 0500 | plan9.DMDIR},
 	{"addr", plan9.QTFILE, QWaddr, 0600},
 	{"body", plan9.QTAPPEND, QWbody, 0600 | plan9.DMAPPEND},
+	{"changes", plan9.QTFILE, QWchanges, 0600},
+	{"complete", plan9.QTFILE, QWcomplete, 0600},
 	{"ctl", plan9.QTFILE, QWctl, 0600},
+	{"ctl.json", plan9.QTFILE, QWctljson, 0400},
 	{"data", plan9.QTFILE, QWdata, 0600},
 	{"editout", plan9.QTFILE, QWeditout, 0200},
 	{"errors", plan9.QTFILE, QWerrors, 0200},
 	{"event", plan9.QTFILE, QWevent, 0600},
+	{"event.json", plan9.QTFILE, QWeventjson, 0600},
 	{"rdsel", plan9.QTFILE, QWrdsel, 0400},
 	{"wrsel", plan9.QTFILE, QWwrsel, 0200},
 	{"tag", plan9.QTAPPEND, QWtag, 0600 | plan9.DMAPPEND},
 	{"xdata", plan9.QTFILE, QWxdata, 0600},
 }
 
+// dirtabc is the contents of a column's directory, col/N, where N is the
+// index of the column in the row. The index takes the place of the window
+// ID in Qid.Path.
+var dirtabc = []*DirTab{
+	{".", plan9.QTDIR, QCdir, 0500 | plan9.DMDIR},
+	{"ctl", plan9.QTFILE, QCctl, 0600},
+}
+
 // windowDirTab returns the DirTab entry for window directory for the window with given id.
 func windowDirTab(id int) *DirTab {
 	return &DirTab{
@@ -90,6 +114,16 @@ func windowDirTab(id int) *DirTab {
 	}
 }
 
+// columnDirTab returns the DirTab entry for the directory of the i'th column.
+func columnDirTab(i int) *DirTab {
+	return &DirTab{
+		name: fmt.Sprintf("%d", i),
+		t:    plan9.QTDIR,
+		qid:  QCdir,
+		perm: plan9.DMDIR | 0500,
+	}
+}
+
 // Mnt is a collection of reference counted MntDir.
 // It is used to pass information from Edwood's 9p client to
 // Edwood's 9p file server.
@@ -109,17 +143,34 @@ func fsysinit() *fileServer {
 	if err := post9pservice(p0, "acme", *mtpt); err != nil {
 		log.Panicf("acme: %s: %v\n", "can't post service", err)
 	}
+	if err := fsyslisten(); err != nil {
+		log.Panicf("acme: %s: %v\n", "can't listen", err)
+	}
+	if err := httplisten(); err != nil {
+		log.Panicf("acme: %s: %v\n", "can't listen", err)
+	}
+	if err := rpclisten(); err != nil {
+		log.Panicf("acme: %s: %v\n", "can't listen", err)
+	}
+
+	fs := newFileServer(p1, nil)
+	go fs.fsysproc()
+	return fs
+}
 
+// newFileServer returns a file server speaking 9P on conn whose clients
+// are limited to caps.
+func newFileServer(conn io.ReadWriteCloser, caps *fsysCaps) *fileServer {
 	fs := &fileServer{
-		conn:        p1,
+		conn:        conn,
 		fids:        make(map[uint32]*Fid),
 		fcall:       nil, // initialized by initfcall
 		closing:     false,
 		username:    getuser(),
 		messagesize: 0, // we'll know after Tversion
+		caps:        caps,
 	}
 	fs.initfcall()
-	go fs.fsysproc()
 	return fs
 }
 
@@ -127,22 +178,35 @@ func (fs *fileServer) fsysproc() {
 	x := (*Xfid)(nil)
 	var f *Fid
 	for {
-		fc, err := plan9.ReadFcall(fs.conn)
+		var fc *plan9.Fcall
+		var err error
+		if fs.dotl {
+			fc, err = ninep.ReadFcallL(fs.conn)
+		} else {
+			fc, err = plan9.ReadFcall(fs.conn)
+		}
 		if err != nil || fc == nil {
 			if fs.closing {
 				break
 			}
+			if fs.client {
+				fs.hangup()
+				break
+			}
 			log.Panicf("acme: %s: %v\n", "fsysproc", err)
 		}
 		if DEBUG {
 			fmt.Fprintf(os.Stderr, "<-- %v\n", fc)
 		}
 		if x == nil {
-			global.cxfidalloc <- nil
-			x = <-global.cxfidalloc
+			x = allocxfid()
 		}
 		x.fcall = *fc
 		x.fs = fs
+		if int(x.fcall.Type) >= len(fs.fcall) || fs.fcall[x.fcall.Type] == nil {
+			x = fs.respond(x, nil, fmt.Errorf("unsupported message type %d", x.fcall.Type))
+			continue
+		}
 		switch x.fcall.Type {
 		case plan9.Tversion:
 			fallthrough
@@ -165,6 +229,51 @@ func (fs *fileServer) fsysproc() {
 	}
 }
 
+// dispatch runs f on x's goroutine, counting it as in flight until it
+// returns.
+func (fs *fileServer) dispatch(x *Xfid, f func(*Xfid)) {
+	fs.inflight.Add(1)
+	x.c <- func(x *Xfid) {
+		defer fs.inflight.Done()
+		f(x)
+	}
+}
+
+// hangup clunks the fids left behind by a client that went away, as
+// 9pserve does for the clients it multiplexes. It first ends the client's
+// waiting reads, and waits for its other requests, so that no request is
+// using a fid as it's clunked. A read that had yet to start waiting when
+// the reads were ended is ended on a later pass. It returns once the
+// clunks are done.
+func (fs *fileServer) hangup() {
+	fs.closing = true
+	fs.conn.Close()
+	done := make(chan struct{})
+	go func() {
+		fs.inflight.Wait()
+		close(done)
+	}()
+	for ended := false; !ended; {
+		xfidhangup(fs)
+		select {
+		case <-done:
+			ended = true
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	for _, f := range fs.fids {
+		if !f.busy {
+			continue
+		}
+		x := allocxfid()
+		x.fcall = plan9.Fcall{Type: plan9.Tclunk, Fid: f.fid}
+		x.fs = fs
+		x.f = f
+		fs.clunk(x, f)
+	}
+	fs.inflight.Wait()
+}
+
 // Add creates a new MntDir and returns a new reference to it.
 func (mnt *Mnt) Add(dir string, incl []string) *MntDir {
 	mnt.lk.Lock()
@@ -232,15 +341,26 @@ func (fs *fileServer) respond(x *Xfid, t *plan9.Fcall, err error) *Xfid {
 	if t == nil {
 		t = &plan9.Fcall{}
 	}
-	if err != nil {
+	switch {
+	case err != nil && fs.dotl:
+		t.Type = ninep.Rlerror
+		t.Errno = errno(err)
+	case err != nil:
 		t.Type = plan9.Rerror
 		t.Ename = err.Error()
-	} else {
+	default:
 		t.Type = x.fcall.Type + 1
 	}
 	t.Fid = x.fcall.Fid
 	t.Tag = x.fcall.Tag
-	if err := plan9.WriteFcall(fs.conn, t); err != nil {
+	write := plan9.WriteFcall
+	if fs.dotl {
+		write = ninep.WriteFcallL
+	}
+	if err := write(fs.conn, t); err != nil {
+		if fs.client {
+			return x // the client has gone; hangup cleans up
+		}
 		log.Panicf("acme: %s: %v\n", "write error in respond", err)
 	}
 	if DEBUG {
@@ -257,10 +377,15 @@ func (fs *fileServer) version(x *Xfid, f *Fid) *Xfid {
 	var t plan9.Fcall
 	fs.messagesize = int(x.fcall.Msize)
 	t.Msize = x.fcall.Msize
-	if x.fcall.Version != "9P2000" {
+	switch x.fcall.Version {
+	case "9P2000":
+		fs.dotl = false
+	case ninep.VersionL:
+		fs.dotl = true
+	default:
 		return fs.respond(x, &t, fmt.Errorf("unrecognized 9P version"))
 	}
-	t.Version = "9P2000"
+	t.Version = x.fcall.Version
 	return fs.respond(x, &t, nil)
 }
 
@@ -270,7 +395,7 @@ func (fs *fileServer) auth(x *Xfid, f *Fid) *Xfid {
 }
 
 func (fs *fileServer) flush(x *Xfid, f *Fid) *Xfid {
-	x.c <- xfidflush
+	fs.dispatch(x, xfidflush)
 	return nil
 }
 
@@ -283,21 +408,36 @@ func (fs *fileServer) attach(x *Xfid, f *Fid) *Xfid {
 		log.Printf("attach from uname %q does not match %q but allowing anyway",
 			x.fcall.Uname, fs.username)
 	}
-	var id uint64
+	// The Aname holds an optional MntDir id and capabilities, separated
+	// by commas.
+	var words []string
 	if x.fcall.Aname != "" {
-		var err error
-		id, err = strconv.ParseUint(x.fcall.Aname, 10, 32)
+		words = strings.Split(x.fcall.Aname, ",")
+	}
+	caps, words, err := parseCaps(words)
+	if err != nil {
+		return fs.respond(x, nil, fmt.Errorf("bad Aname: %v", err))
+	}
+	if len(words) > 1 {
+		return fs.respond(x, nil, fmt.Errorf("bad Aname: %q", x.fcall.Aname))
+	}
+	var m *MntDir
+	if len(words) == 1 {
+		id, err := strconv.ParseUint(words[0], 10, 32)
 		if err != nil {
 			err = fmt.Errorf("bad Aname: %v", err)
 			return fs.respond(x, nil, err)
 		}
-	}
-	m := mnt.GetFromID(id) // DecRef in clunk
-	if m == nil && x.fcall.Aname != "" {
-		err := fmt.Errorf("unknown id %q in Aname", x.fcall.Aname)
-		return fs.respond(x, nil, err)
+		m = mnt.GetFromID(id) // DecRef in clunk
+		if m == nil {
+			err := fmt.Errorf("unknown id %q in Aname", words[0])
+			return fs.respond(x, nil, err)
+		}
 	}
 	f.mntdir = m
+	f.caps = fs.caps.restrict(caps)
+	fs.nattach++
+	f.attach = fs.nattach
 	f.busy = true
 	f.open = false
 	f.qid.Path = Qdir
@@ -327,12 +467,15 @@ func (fs *fileServer) walk(x *Xfid, f *Fid) *Xfid {
 		nf.busy = true
 		nf.open = false
 		nf.mntdir = f.mntdir
+		nf.caps = f.caps
+		nf.attach = f.attach
 		if f.mntdir != nil {
 			mnt.IncRef(f.mntdir) // DecRef in clunk
 		}
 		nf.dir = f.dir
 		nf.qid = f.qid
 		nf.w = f.w
+		nf.col = f.col
 		nf.nrpart = 0 // not open, so must be zero
 		if nf.w != nil {
 			nf.w.lk.Lock()
@@ -348,6 +491,7 @@ func (fs *fileServer) walk(x *Xfid, f *Fid) *Xfid {
 	wf := &Fid{
 		qid: f.qid,
 		w:   nil,
+		col: f.col,
 		dir: nil,
 	}
 
@@ -356,6 +500,10 @@ func (fs *fileServer) walk(x *Xfid, f *Fid) *Xfid {
 		for i = 0; i < len(x.fcall.Wname); i++ {
 			wname := x.fcall.Wname[i]
 
+			if !f.caps.canwalk(wf.qid, wname) {
+				err = ErrPermission
+				break
+			}
 			var found bool
 			found, err = wf.Walk1(wname)
 			if err != nil || !found {
@@ -387,6 +535,7 @@ func (fs *fileServer) walk(x *Xfid, f *Fid) *Xfid {
 		if wf.dir != nil {
 			f.dir = wf.dir
 		}
+		f.col = wf.col
 		f.qid = wf.qid
 	}
 
@@ -397,6 +546,16 @@ func (fs *fileServer) walk(x *Xfid, f *Fid) *Xfid {
 	return fs.respond(x, &t, err)
 }
 
+// lookupdirtab returns the entry of d with the given name, or nil.
+func lookupdirtab(d []*DirTab, name string) *DirTab {
+	for _, de := range d {
+		if de.name == name {
+			return de
+		}
+	}
+	return nil
+}
+
 // Walk1 walks fid to path name element wname.
 // Found is set to true iff wname was found.
 func (f *Fid) Walk1(wname string) (found bool, err error) {
@@ -409,12 +568,52 @@ func (f *Fid) Walk1(wname string) (found bool, err error) {
 			f.w.Close()
 			f.w = nil
 		}
+		if FILE(f.qid) == QCdir { // col/N/.. is col
+			f.col = nil
+			f.dir = lookupdirtab(dirtab, "col")
+			f.qid.Type = plan9.QTDIR
+			f.qid.Vers = 0
+			f.qid.Path = QID(0, Qcol)
+			return true, nil
+		}
 		f.qid.Type = plan9.QTDIR
 		f.qid.Vers = 0
 		f.qid.Path = QID(0, Qdir)
 		return true, nil
 	}
 
+	// Columns are named by their index in the row. The fid keeps the
+	// column it was walked to, wherever it moves in the row.
+	switch FILE(f.qid) {
+	case Qcol:
+		i, err := strconv.Atoi(wname)
+		if err != nil {
+			return false, nil
+		}
+		global.row.lk.Lock()
+		if i < 0 || i >= len(global.row.col) {
+			global.row.lk.Unlock()
+			return false, nil
+		}
+		f.col = global.row.col[i]
+		global.row.lk.Unlock()
+		f.dir = dirtabc[0] // '.'
+		f.qid.Type = plan9.QTDIR
+		f.qid.Vers = 0
+		f.qid.Path = QID(i, QCdir)
+		return true, nil
+	case QCdir:
+		de := lookupdirtab(dirtabc[1:], wname)
+		if de == nil {
+			return false, nil
+		}
+		f.dir = de
+		f.qid.Type = de.t
+		f.qid.Vers = 0
+		f.qid.Path = QID(WIN(f.qid), de.qid)
+		return true, nil
+	}
+
 	// is it a numeric name?
 	_, err = strconv.ParseInt(wname, 10, 32)
 	if err == nil {
@@ -447,7 +646,7 @@ func (f *Fid) Walk1(wname string) (found bool, err error) {
 	err = nil
 	if wname == "new" {
 		if f.w != nil {
-			log.Panicf("acme: %s: %v\n", "w set in walk to new", nil)
+			return false, ErrNewInWindow
 		}
 		global.cnewwindow <- nil  // signal newwindowthread
 		f.w = <-global.cnewwindow // receive new window
@@ -497,7 +696,10 @@ func (fs *fileServer) open(x *Xfid, f *Fid) *Xfid {
 	if ((f.dir.perm &^ (plan9.DMDIR | plan9.DMAPPEND)) & m) != m {
 		goto Deny
 	}
-	x.c <- xfidopen
+	if !f.caps.canopen(f.qid, m) {
+		goto Deny
+	}
+	fs.dispatch(x, xfidopen)
 	return nil
 
 Deny:
@@ -520,35 +722,11 @@ func (fs *fileServer) read(x *Xfid, f *Fid) *Xfid {
 			fs.respond(x, &t, nil)
 			return x
 		}
-		clock := getclock()
-		id := WIN(f.qid)
-		d := dirtab
-		if id > 0 {
-			d = dirtabw
-		}
-		d = d[1:] // Skip '.'
-
-		var ids []int // for window sub-directories
-		if id == 0 {
-			global.row.lk.Lock()
-			for _, c := range global.row.col {
-				for _, w := range c.w {
-					ids = append(ids, w.id)
-				}
-			}
-			global.row.lk.Unlock()
-			sort.Ints(ids)
-		}
-
+		dirs := fs.dirents(f)
 		var t plan9.Fcall
 		ninep.DirRead(&t, &x.fcall, func(i int) *plan9.Dir {
-			if i < len(d) {
-				return d[i].Dir(id, fs.username, clock)
-			}
-			i -= len(d)
-			if i < len(ids) {
-				k := ids[i]
-				return windowDirTab(k).Dir(k, fs.username, clock)
+			if i < len(dirs) {
+				return dirs[i]
 			}
 			return nil
 		})
@@ -556,18 +734,76 @@ func (fs *fileServer) read(x *Xfid, f *Fid) *Xfid {
 		fs.respond(x, &t, nil)
 		return x
 	}
-	x.c <- xfidread
+	fs.dispatch(x, xfidread)
 	return nil
 }
 
+// dirents returns the entries of the directory f.
+func (fs *fileServer) dirents(f *Fid) []*plan9.Dir {
+	if FILE(f.qid) == Qacme { // empty dir
+		return nil
+	}
+	clock := getclock()
+	id := WIN(f.qid)
+	d := dirtab
+	switch {
+	case FILE(f.qid) == Qcol:
+		d = dirtab[:1] // only column sub-directories
+	case FILE(f.qid) == QCdir:
+		d = dirtabc
+	case id > 0:
+		d = dirtabw
+	}
+	d = d[1:] // Skip '.'
+
+	var ids []int // for window or column sub-directories
+	subdir := windowDirTab
+	switch {
+	case FILE(f.qid) == Qcol:
+		global.row.lk.Lock()
+		for i := range global.row.col {
+			ids = append(ids, i)
+		}
+		global.row.lk.Unlock()
+		subdir = columnDirTab
+	case FILE(f.qid) == Qdir && id == 0:
+		global.row.lk.Lock()
+		for _, c := range global.row.col {
+			for _, w := range c.w {
+				if f.caps.canreach(plan9.Qid{Path: QID(w.id, Qdir)}) {
+					ids = append(ids, w.id)
+				}
+			}
+		}
+		global.row.lk.Unlock()
+		sort.Ints(ids)
+		if f.caps != nil && f.caps.wins != nil {
+			d = nil // only the permitted windows
+		}
+	}
+
+	dirs := make([]*plan9.Dir, 0, len(d)+len(ids))
+	for _, de := range d {
+		dirs = append(dirs, de.Dir(id, fs.username, clock))
+	}
+	for _, k := range ids {
+		dirs = append(dirs, subdir(k).Dir(k, fs.username, clock))
+	}
+	return dirs
+}
+
 func (fs *fileServer) write(x *Xfid, f *Fid) *Xfid {
-	x.c <- xfidwrite
+	if !f.caps.canopen(f.qid, 0200) {
+		var t plan9.Fcall
+		return fs.respond(x, &t, ErrPermission)
+	}
+	fs.dispatch(x, xfidwrite)
 	return nil
 }
 
 func (fs *fileServer) clunk(x *Xfid, f *Fid) *Xfid {
 	mnt.DecRef(f.mntdir) // IncRef in attach/walk
-	x.c <- xfidclose
+	fs.dispatch(x, xfidclose)
 	return nil
 }
 
@@ -0,0 +1,133 @@
+package main
+
+// Support for 9P2000.L, which lets the Linux kernel's v9fs client mount
+// the file server directly. A client that asks for 9P2000.L in Tversion
+// gets it. Requests it shares with 9P2000 are served as before; the
+// Linux-specific ones are mapped onto the same files: Tlopen is Topen,
+// Treaddir lists a directory, and Tgetattr reports what Tstat would.
+// Nothing can be created, removed or renamed, and attribute changes, such
+// as the truncation of a file opened for writing, are ignored.
+
+import (
+	"errors"
+	"os"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/ninep"
+)
+
+// v9fsMagic is the file system type reported by Rstatfs.
+const v9fsMagic = 0x01021997
+
+func (fs *fileServer) initfcallL() {
+	fs.fcall[ninep.Tlopen] = fs.open
+	fs.fcall[ninep.Tgetattr] = fs.getattr
+	fs.fcall[ninep.Tsetattr] = fs.setattr
+	fs.fcall[ninep.Treaddir] = fs.readdir
+	fs.fcall[ninep.Tstatfs] = fs.statfs
+	fs.fcall[ninep.Txattrwalk] = fs.xattrwalk
+	fs.fcall[ninep.Tfsync] = fs.fsync
+	fs.fcall[ninep.Tlock] = fs.lock
+	fs.fcall[ninep.Tgetlock] = fs.getlock
+	for _, typ := range []uint8{
+		ninep.Tlcreate, ninep.Tsymlink, ninep.Tmknod, ninep.Trename,
+		ninep.Treadlink, ninep.Txattrcreate, ninep.Tlink, ninep.Tmkdir,
+		ninep.Trenameat, ninep.Tunlinkat,
+	} {
+		fs.fcall[typ] = fs.create // all refused
+	}
+}
+
+// errno converts an error from the file server to a Linux errno value.
+func errno(err error) uint32 {
+	switch {
+	case errors.Is(err, ErrPermission):
+		return ninep.EACCES
+	case errors.Is(err, ErrNotExist):
+		return ninep.ENOENT
+	case errors.Is(err, ErrNotDir):
+		return ninep.ENOTDIR
+	case errors.Is(err, errNotSupported):
+		return ninep.EOPNOTSUPP
+	}
+	return ninep.EIO
+}
+
+var errNotSupported = errors.New("operation not supported")
+
+func (fs *fileServer) getattr(x *Xfid, f *Fid) *Xfid {
+	d := f.dir.Dir(WIN(f.qid), fs.username, getclock())
+	mode := uint32(d.Mode & 0777)
+	if d.Mode&plan9.DMDIR != 0 {
+		mode |= ninep.SIFDIR
+	} else {
+		mode |= ninep.SIFREG
+	}
+	a := ninep.Attr{
+		Qid:   f.qid,
+		Mode:  mode,
+		UID:   uint32(os.Getuid()),
+		GID:   uint32(os.Getgid()),
+		Nlink: 1,
+		Size:  d.Length,
+		Atime: int64(d.Atime),
+		Mtime: int64(d.Mtime),
+		Ctime: int64(d.Mtime),
+	}
+	t := plan9.Fcall{
+		Data: a.Bytes(),
+	}
+	return fs.respond(x, &t, nil)
+}
+
+func (fs *fileServer) setattr(x *Xfid, f *Fid) *Xfid {
+	var t plan9.Fcall
+	return fs.respond(x, &t, nil)
+}
+
+func (fs *fileServer) readdir(x *Xfid, f *Fid) *Xfid {
+	var t plan9.Fcall
+	if f.qid.Type&plan9.QTDIR == 0 {
+		return fs.respond(x, &t, ErrNotDir)
+	}
+	dirs := fs.dirents(f)
+	ninep.Readdir(&t, &x.fcall, func(i int) *plan9.Dir {
+		if i < len(dirs) {
+			return dirs[i]
+		}
+		return nil
+	})
+	return fs.respond(x, &t, nil)
+}
+
+func (fs *fileServer) statfs(x *Xfid, f *Fid) *Xfid {
+	t := plan9.Fcall{
+		Data: ninep.StatfsBytes(v9fsMagic),
+	}
+	return fs.respond(x, &t, nil)
+}
+
+func (fs *fileServer) xattrwalk(x *Xfid, f *Fid) *Xfid {
+	var t plan9.Fcall
+	return fs.respond(x, &t, errNotSupported)
+}
+
+func (fs *fileServer) fsync(x *Xfid, f *Fid) *Xfid {
+	var t plan9.Fcall
+	return fs.respond(x, &t, nil)
+}
+
+// lock grants every lock: nothing else can share the file server's files.
+func (fs *fileServer) lock(x *Xfid, f *Fid) *Xfid {
+	t := plan9.Fcall{
+		Data: []byte{0}, // P9_LOCK_SUCCESS
+	}
+	return fs.respond(x, &t, nil)
+}
+
+func (fs *fileServer) getlock(x *Xfid, f *Fid) *Xfid {
+	var t plan9.Fcall
+	var err error
+	t.Data, err = ninep.UnlockedBytes(x.fcall.Data)
+	return fs.respond(x, &t, err)
+}
@@ -0,0 +1,330 @@
+package main
+
+// Extra listeners for the file server. Besides the service posted by
+// post9pservice, the file server can listen on further Unix sockets and
+// on loopback TCP. Each listener may carry capabilities that limit every
+// client attached through it: ro makes the file system read-only and
+// win=N restricts it to window N (repeat for several windows). A client
+// can narrow its own access further by naming capabilities in the attach
+// name, separated by commas, but never widen it. TCP clients must first
+// send the shared secret from the -fsys.secret file, followed by a
+// newline, before speaking 9P.
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+var (
+	fsysListen listenFlag
+	fsysSecret = flag.String("fsys.secret", "", "File holding the shared secret for TCP listeners; created if missing")
+)
+
+func init() {
+	flag.Var(&fsysListen, "fsys.listen", "Also serve the 9P file system on `addr[,cap...]`, where addr is unix!path or tcp!host!port on loopback and cap is ro or win=N (repeatable)")
+}
+
+// listenFlag collects the -fsys.listen flags.
+type listenFlag []string
+
+func (lf *listenFlag) String() string { return strings.Join(*lf, " ") }
+
+func (lf *listenFlag) Set(s string) error {
+	*lf = append(*lf, s)
+	return nil
+}
+
+// fsysCaps limits what a client attached to the file server may do. A
+// nil *fsysCaps allows everything.
+type fsysCaps struct {
+	readonly bool
+	wins     map[int]bool // if non-nil, the only windows the client may reach
+}
+
+// parseCaps parses capability words: ro or win=N. Other words are returned
+// unparsed in rest.
+func parseCaps(words []string) (caps *fsysCaps, rest []string, err error) {
+	for _, w := range words {
+		switch {
+		case w == "ro":
+			if caps == nil {
+				caps = &fsysCaps{}
+			}
+			caps.readonly = true
+		case strings.HasPrefix(w, "win="):
+			id, err := strconv.Atoi(w[4:])
+			if err != nil || id <= 0 {
+				return nil, nil, fmt.Errorf("bad window in capability %q", w)
+			}
+			if caps == nil {
+				caps = &fsysCaps{}
+			}
+			if caps.wins == nil {
+				caps.wins = make(map[int]bool)
+			}
+			caps.wins[id] = true
+		default:
+			rest = append(rest, w)
+		}
+	}
+	return caps, rest, nil
+}
+
+// restrict returns the capabilities allowed by both c and d.
+func (c *fsysCaps) restrict(d *fsysCaps) *fsysCaps {
+	if c == nil {
+		return d
+	}
+	if d == nil {
+		return c
+	}
+	r := &fsysCaps{
+		readonly: c.readonly || d.readonly,
+		wins:     c.wins,
+	}
+	if d.wins != nil {
+		if c.wins == nil {
+			r.wins = d.wins
+		} else {
+			r.wins = make(map[int]bool)
+			for id := range d.wins {
+				if c.wins[id] {
+					r.wins[id] = true
+				}
+			}
+		}
+	}
+	return r
+}
+
+// canwalk reports whether a fid at q may walk to wname. Clients limited
+// to some windows see only those windows' directories at the root.
+// Neither they nor read-only clients can create windows by walking to
+// new, in any directory.
+func (c *fsysCaps) canwalk(q plan9.Qid, wname string) bool {
+	if c == nil {
+		return true
+	}
+	if wname == "new" && (c.readonly || c.wins != nil) {
+		return false
+	}
+	if wname == ".." || FILE(q) != Qdir || WIN(q) != 0 {
+		return true
+	}
+	if c.wins != nil {
+		id, err := strconv.Atoi(wname)
+		return err == nil && c.wins[id]
+	}
+	return true
+}
+
+// canopen reports whether the file at q may be opened with permission m.
+func (c *fsysCaps) canopen(q plan9.Qid, m plan9.Perm) bool {
+	if c == nil {
+		return true
+	}
+	if c.readonly && m&0200 != 0 {
+		return false
+	}
+	if c.readonly && (FILE(q) == QWevent || FILE(q) == QWeventjson) {
+		return false // holding the event file diverts the window's actions
+	}
+	if c.readonly && FILE(q) == QWcomplete {
+		return false // completion providers edit the window
+	}
+	return c.canreach(q)
+}
+
+// canreach reports whether the file at q belongs to a permitted window.
+func (c *fsysCaps) canreach(q plan9.Qid) bool {
+	if c == nil || c.wins == nil {
+		return true
+	}
+	if FILE(q) == Qdir && WIN(q) == 0 {
+		return true // the root, which lists only permitted windows
+	}
+	if FILE(q) == QCdir || FILE(q) == QCctl {
+		return false // WIN holds a column index
+	}
+	return c.wins[WIN(q)]
+}
+
+// fsyslisten starts the listeners named by -fsys.listen.
+func fsyslisten() error {
+	for _, spec := range fsysListen {
+		l, caps, secret, err := listenSpec(spec)
+		if err != nil {
+			return fmt.Errorf("-fsys.listen %q: %v", spec, err)
+		}
+		go fsysaccept(l, caps, secret)
+	}
+	return nil
+}
+
+// listenSpec listens on the address named by spec, addr[,cap...], and
+// returns the capabilities it carries. Clients of a TCP listener must
+// present the returned secret.
+func listenSpec(spec string) (l net.Listener, caps *fsysCaps, secret []byte, err error) {
+	words := strings.Split(spec, ",")
+	caps, rest, err := parseCaps(words[1:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(rest) > 0 {
+		return nil, nil, nil, fmt.Errorf("unknown capability %q", rest[0])
+	}
+	addr := strings.Split(words[0], "!")
+	switch {
+	case len(addr) == 2 && addr[0] == "unix":
+		l, err = listenUnix(addr[1])
+	case len(addr) == 3 && addr[0] == "tcp":
+		if secret, err = readSecret(*fsysSecret); err != nil {
+			return nil, nil, nil, err
+		}
+		l, err = listenLoopback(net.JoinHostPort(addr[1], addr[2]))
+	default:
+		err = fmt.Errorf("bad address %q", words[0])
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return l, caps, secret, nil
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path) // stale socket from an earlier run
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func listenLoopback(addr string) (net.Listener, error) {
+	ta, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if !ta.IP.IsLoopback() {
+		return nil, fmt.Errorf("%v is not a loopback address", ta.IP)
+	}
+	return net.ListenTCP("tcp", ta)
+}
+
+// readSecret returns the shared secret held in file, creating the file
+// with a random secret if it doesn't exist.
+func readSecret(file string) ([]byte, error) {
+	if file == "" {
+		return nil, errors.New("TCP listeners need -fsys.secret")
+	}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		r := make([]byte, 32)
+		if _, err := rand.Read(r); err != nil {
+			return nil, err
+		}
+		b = []byte(hex.EncodeToString(r) + "\n")
+		err = os.WriteFile(file, b, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return nil, fmt.Errorf("empty secret in %v", file)
+	}
+	return []byte(s), nil
+}
+
+// fsysaccept serves each connection accepted by l with its own
+// fileServer limited to caps. If secret is non-nil, connections must
+// present it first.
+func fsysaccept(l net.Listener, caps *fsysCaps, secret []byte) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("fsys: accept on %v: %v", l.Addr(), err)
+			return
+		}
+		go func() {
+			if secret != nil {
+				if err := checkSecret(conn, secret); err != nil {
+					log.Printf("fsys: %v: %v", conn.RemoteAddr(), err)
+					conn.Close()
+					return
+				}
+			}
+			fs := newFileServer(conn, caps)
+			fs.client = true
+			fs.fsysproc()
+		}()
+	}
+}
+
+// fsysattach attaches to a new fileServer, served in-process, whose
+// clients are limited to caps. done is closed when the server has ended,
+// once conn is closed.
+func fsysattach(caps *fsysCaps) (conn *client.Conn, fsys *client.Fsys, done <-chan struct{}, err error) {
+	p0, p1 := net.Pipe()
+	fs := newFileServer(p1, caps)
+	fs.client = true
+	d := make(chan struct{})
+	go func() {
+		fs.fsysproc()
+		close(d)
+	}()
+
+	conn, err = client.NewConn(p0)
+	if err != nil {
+		p0.Close()
+		return nil, nil, d, err
+	}
+	fsys, err = conn.Attach(nil, getuser(), "")
+	if err != nil {
+		conn.Close()
+		return nil, nil, d, err
+	}
+	return conn, fsys, d, nil
+}
+
+// checkSecret reads a newline-terminated secret from conn, a byte at a
+// time so that none of the 9P stream that follows is consumed.
+func checkSecret(conn net.Conn, secret []byte) error {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var got []byte
+	b := make([]byte, 1)
+	for len(got) <= 2*len(secret) {
+		if _, err := conn.Read(b); err != nil {
+			return err
+		}
+		if b[0] == '\n' {
+			if subtle.ConstantTimeCompare(got, secret) != 1 {
+				return errors.New("wrong secret")
+			}
+			return nil
+		}
+		got = append(got, b[0])
+	}
+	return errors.New("wrong secret")
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
 	"image"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -752,6 +755,108 @@ func TestFileServerAttach(t *testing.T) {
 	})
 }
 
+func TestFileServerAttachCaps(t *testing.T) {
+	mc := new(mockConn)
+	fs := &fileServer{
+		conn:     mc,
+		username: "gopher",
+		caps:     &fsysCaps{wins: map[int]bool{3: true, 5: true}},
+	}
+	x := &Xfid{
+		fcall: plan9.Fcall{
+			Type:  plan9.Tattach,
+			Uname: fs.username,
+			Aname: "ro,win=3,win=4",
+		},
+		f: &Fid{},
+	}
+	fs.attach(x, x.f)
+	if got := mc.ReadFcall(t); got.Type != plan9.Rattach {
+		t.Fatalf("got response %v; want Rattach", got)
+	}
+	want := &fsysCaps{readonly: true, wins: map[int]bool{3: true}}
+	if diff := cmp.Diff(want, x.f.caps, cmp.AllowUnexported(fsysCaps{})); diff != "" {
+		t.Errorf("caps mismatch (-want +got):\n%s", diff)
+	}
+
+	x.fcall.Aname = "win=x"
+	fs.attach(x, &Fid{})
+	if got, want := mc.ReadFcall(t), errorFcall(fmt.Errorf(`bad Aname: bad window in capability "win=x"`)); !cmp.Equal(got, want) {
+		t.Errorf("got response %v; want %v", got, want)
+	}
+}
+
+func TestFsysCaps(t *testing.T) {
+	root := plan9.Qid{Type: plan9.QTDIR, Path: Qdir}
+	win := func(id int, q uint64) plan9.Qid { return plan9.Qid{Path: QID(id, q)} }
+	ro := &fsysCaps{readonly: true}
+	win3 := &fsysCaps{wins: map[int]bool{3: true}}
+
+	for _, tc := range []struct {
+		name string
+		caps *fsysCaps
+		ok   bool
+		f    func(c *fsysCaps) bool
+	}{
+		{"NoCaps/WalkNew", nil, true, func(c *fsysCaps) bool { return c.canwalk(root, "new") }},
+		{"NoCaps/WriteCtl", nil, true, func(c *fsysCaps) bool { return c.canopen(win(3, QWctl), 0200) }},
+		{"RO/WalkNew", ro, false, func(c *fsysCaps) bool { return c.canwalk(root, "new") }},
+		{"RO/WalkAcmeNew", ro, false, func(c *fsysCaps) bool { return c.canwalk(plan9.Qid{Path: Qacme}, "new") }},
+		{"RO/WalkIndex", ro, true, func(c *fsysCaps) bool { return c.canwalk(root, "index") }},
+		{"RO/ReadBody", ro, true, func(c *fsysCaps) bool { return c.canopen(win(3, QWbody), 0400) }},
+		{"RO/WriteBody", ro, false, func(c *fsysCaps) bool { return c.canopen(win(3, QWbody), 0200) }},
+		{"RO/ReadEvent", ro, false, func(c *fsysCaps) bool { return c.canopen(win(3, QWevent), 0400) }},
+		{"Win/WalkOwn", win3, true, func(c *fsysCaps) bool { return c.canwalk(root, "3") }},
+		{"Win/WalkOther", win3, false, func(c *fsysCaps) bool { return c.canwalk(root, "4") }},
+		{"Win/WalkIndex", win3, false, func(c *fsysCaps) bool { return c.canwalk(root, "index") }},
+		{"Win/WalkNewInWindow", win3, false, func(c *fsysCaps) bool { return c.canwalk(win(3, Qdir), "new") }},
+		{"Win/WalkInWindow", win3, true, func(c *fsysCaps) bool { return c.canwalk(win(3, Qdir), "body") }},
+		{"Win/OpenRoot", win3, true, func(c *fsysCaps) bool { return c.canopen(root, 0400) }},
+		{"Win/WriteOwn", win3, true, func(c *fsysCaps) bool { return c.canopen(win(3, QWbody), 0200) }},
+		{"Win/WriteOther", win3, false, func(c *fsysCaps) bool { return c.canopen(win(4, QWbody), 0200) }},
+		{"Win/ColumnCtl", win3, false, func(c *fsysCaps) bool { return c.canopen(win(3, QCctl), 0400) }},
+		{"Restrict", win3.restrict(ro), false, func(c *fsysCaps) bool { return c.canopen(win(3, QWbody), 0200) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.f(tc.caps); got != tc.ok {
+				t.Errorf("got %v; want %v", got, tc.ok)
+			}
+		})
+	}
+}
+
+func TestCheckSecret(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		sent string
+		ok   bool
+	}{
+		{"Right", "sesame\nTversion", true},
+		{"Wrong", "sesamx\n", false},
+		{"Prefix", "ses\n", false},
+		{"TooLong", strings.Repeat("s", 100), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			go func() {
+				c2.Write([]byte(tc.sent))
+				c2.Close()
+			}()
+			err := checkSecret(c1, []byte("sesame"))
+			if got := err == nil; got != tc.ok {
+				t.Fatalf("checkSecret returned %v", err)
+			}
+			if tc.ok {
+				rest, _ := io.ReadAll(c1)
+				if got, want := string(rest), "Tversion"; got != want {
+					t.Errorf("left %q after the secret; want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestFileServerWalk(t *testing.T) {
 	global.WinID = 0
 	global.row = Row{
@@ -1039,6 +1144,58 @@ func TestFileServerWalk(t *testing.T) {
 				},
 			},
 		},
+		{
+			"col/0/ctl",
+			Xfid{
+				fcall: plan9.Fcall{
+					Wname: []string{"col", "0", "ctl"},
+				},
+			},
+			nil,
+			plan9.Fcall{
+				Type: plan9.Rwalk,
+				Wqid: []plan9.Qid{
+					{
+						Path: QID(0, Qcol),
+						Type: plan9.QTDIR,
+					},
+					{
+						Path: QID(0, QCdir),
+						Type: plan9.QTDIR,
+					},
+					{
+						Path: QID(0, QCctl),
+						Type: plan9.QTFILE,
+					},
+				},
+			},
+		},
+		{
+			"col/0/../1",
+			Xfid{
+				fcall: plan9.Fcall{
+					Wname: []string{"col", "0", "..", "1"},
+				},
+			},
+			nil,
+			plan9.Fcall{
+				Type: plan9.Rwalk,
+				Wqid: []plan9.Qid{
+					{
+						Path: QID(0, Qcol),
+						Type: plan9.QTDIR,
+					},
+					{
+						Path: QID(0, QCdir),
+						Type: plan9.QTDIR,
+					},
+					{
+						Path: QID(0, Qcol),
+						Type: plan9.QTDIR,
+					},
+				},
+			},
+		},
 		{
 			"new",
 			Xfid{
@@ -1096,20 +1253,7 @@ func TestFileServerWalk(t *testing.T) {
 	}
 }
 
-func TestFidWalk1Panic(t *testing.T) {
-	done := make(chan struct{})
-	defer func() {
-		r := recover()
-		got, ok := r.(string)
-		if !ok {
-			t.Fatalf("recovered a %T; want string", got)
-		}
-		want := "acme: w set in walk to new: <nil>\n"
-		if got != want {
-			t.Errorf("recovered string %q; want %q", got, want)
-		}
-		close(done)
-	}()
+func TestFidWalk1NewInWindow(t *testing.T) {
 	f := &Fid{
 		qid: plan9.Qid{
 			Path: QID(0, Qdir),
@@ -1117,8 +1261,9 @@ func TestFidWalk1Panic(t *testing.T) {
 		},
 		w: &Window{},
 	}
-	f.Walk1("new")
-	<-done
+	if _, err := f.Walk1("new"); err != ErrNewInWindow {
+		t.Errorf("walk to new in a window gave error %v; want %v", err, ErrNewInWindow)
+	}
 }
 
 func TestFileServerOpen(t *testing.T) {
@@ -1355,6 +1500,7 @@ func TestFileServerStat(t *testing.T) {
 	}
 	checkDirTab(t, "", dirtab)
 	checkDirTab(t, "winid/", dirtabw)
+	checkDirTab(t, "col/N/", dirtabc)
 }
 
 func TestFileServerStatSmallMsize(t *testing.T) {
@@ -1390,3 +1536,101 @@ func TestFileServerWstat(t *testing.T) {
 		t.Fatalf("got response %v; want %v", got, want)
 	}
 }
+
+// TestFileServerDotL replays a 9P2000.L session like the one the Linux
+// v9fs client starts when mounting the file server.
+func TestFileServerDotL(t *testing.T) {
+	useFixedClock = true
+	global.row.col = []*Column{{}, {}}
+	defer func() {
+		useFixedClock = false
+		global.row = Row{}
+	}()
+
+	le32 := func(v int) string {
+		return fmt.Sprintf("%02x%02x%02x%02x", v&0xff, v>>8&0xff, v>>16&0xff, v>>24&0xff)
+	}
+	const (
+		clock = "70f0f94a00000000 0000000000000000"
+		zero  = "0000000000000000"
+	)
+	rgetattr := "a0000000 19 0500 ff07000000000000 80 00000000 0000000000000000 40410000 " +
+		le32(os.Getuid()) + le32(os.Getgid()) + " 0100000000000000 " + zero + zero +
+		" 0020000000000000 " + zero + clock + clock + clock + zero + zero + zero + zero
+
+	mc := new(mockConn)
+	fs := newFileServer(mc, nil)
+	fs.username = "gopher"
+	for _, tc := range []struct {
+		name     string
+		request  string
+		response string
+	}{
+		{"Tversion",
+			"15000000 64 ffff 00200000 0800 3950323030302e4c",
+			"15000000 65 ffff 00200000 0800 3950323030302e4c"},
+		{"Tattach",
+			"1d000000 68 0100 00000000 ffffffff 0600 676f70686572 0000 e8030000",
+			"14000000 69 0100 80 00000000 0000000000000000"},
+		{"Twalk",
+			"16000000 6e 0200 00000000 01000000 0100 0300 636f6c",
+			"16000000 6f 0200 0100 80 00000000 0200000000000000"},
+		{"Twalk/NotExist",
+			"17000000 6e 0300 00000000 02000000 0100 0400 6e6f7065",
+			"0b000000 07 0300 02000000"},
+		{"Treaddir",
+			"17000000 28 0400 01000000 0000000000000000 00100000",
+			"3d000000 29 0400 32000000 " +
+				"80 00000000 0e00000000000000 0100000000000000 04 0100 30 " +
+				"80 00000000 0e01000000000000 0200000000000000 04 0100 31"},
+		{"Treaddir/End",
+			"17000000 28 0400 01000000 0200000000000000 00100000",
+			"0b000000 29 0400 00000000"},
+		{"Tgetattr",
+			"13000000 18 0500 00000000 ff3f000000000000",
+			rgetattr},
+		{"Tstatfs",
+			"0b000000 08 0600 00000000",
+			"43000000 09 0600 97190201 00200000 " + strings.Repeat(zero, 6) + " ff000000"},
+		{"Txattrwalk",
+			"11000000 1e 0700 00000000 02000000 0000",
+			"0b000000 07 0700 5f000000"},
+		{"Tmkdir",
+			"16000000 48 0800 00000000 0100 78 ed010000 00000000",
+			"0b000000 07 0800 0d000000"},
+		{"Tlock",
+			"26000000 34 0900 00000000 01 00000000 " + zero + zero + " 01000000 0000",
+			"08000000 35 0900 00"},
+		{"Tfsync",
+			"0f000000 32 0a00 01000000 00000000",
+			"07000000 33 0a00"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, err := ninep.UnmarshalFcallL(unhex(t, tc.request))
+			if err != nil {
+				t.Fatalf("failed to unmarshal request: %v", err)
+			}
+			x := &Xfid{fcall: *fc, fs: fs}
+			var f *Fid
+			if fc.Type != plan9.Tversion {
+				f = fs.newfid(fc.Fid)
+			}
+			x.f = f
+			fs.fcall[fc.Type](x, f)
+
+			if got, want := mc.Next(mc.Len()), unhex(t, tc.response); !bytes.Equal(got, want) {
+				t.Errorf("got response\n% x\nwant\n% x", got, want)
+			}
+		})
+	}
+}
+
+// unhex decodes a hex string that may contain spaces.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
@@ -9,6 +9,7 @@ import (
 	"9fans.net/go/plumb"
 	"github.com/rjkroege/edwood/draw"
 	"github.com/rjkroege/edwood/frame"
+	"github.com/rjkroege/edwood/plumber"
 	"github.com/rjkroege/edwood/theme"
 )
 
@@ -24,6 +25,7 @@ type globals struct {
 	mouse       *draw.Mouse
 	mousectl    *draw.Mousectl
 	keyboardctl *draw.Keyboardctl
+	recorder    *inputRecorder // for -record
 
 	modbutton draw.Image
 	colbutton draw.Image
@@ -40,6 +42,8 @@ type globals struct {
 	typetext  *Text // global because Text.Close needs to clear it
 	barttext  *Text // shared between mousethread and keyboardthread
 
+	completion *completionPopup // open completion popup, if any
+
 	activewin *Window
 	activecol *Column
 	snarfbuf  []byte
@@ -49,11 +53,12 @@ type globals struct {
 	wdir      string
 	editing   int
 
+	plumbrules *plumber.Rules // built-in rules used when no plumber is running
 	cplumb     chan *plumb.Message
 	cwait      chan ProcessState
 	ccommand   chan *Command
 	ckill      chan string
-	cxfidalloc chan *Xfid
+	cxfidalloc chan chan *Xfid // requests carry the channel for the reply
 	cxfidfree  chan *Xfid
 	cnewwindow chan *Window
 	cexit      chan struct{}
@@ -61,6 +66,7 @@ type globals struct {
 	cerr       chan error
 	cedit      chan int
 	cwarn      chan uint
+	coutline   chan *outline
 
 	editoutlk chan bool
 
@@ -84,7 +90,7 @@ func makeglobals() *globals {
 		cwait:      make(chan ProcessState),
 		ccommand:   make(chan *Command),
 		ckill:      make(chan string),
-		cxfidalloc: make(chan *Xfid),
+		cxfidalloc: make(chan chan *Xfid),
 		cxfidfree:  make(chan *Xfid),
 		cnewwindow: make(chan *Window),
 		csignal:    make(chan os.Signal, 1),
@@ -92,6 +98,7 @@ func makeglobals() *globals {
 		cedit:      make(chan int),
 		cexit:      make(chan struct{}),
 		cwarn:      make(chan uint),
+		coutline:   make(chan *outline, 16),
 	}
 
 	if home, err := os.UserHomeDir(); err == nil {
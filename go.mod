@@ -10,6 +10,7 @@ require (
 	github.com/ktye/duitdraw v0.0.0-20190328070634-a54e9bd5a862
 	github.com/pkg/term v1.1.0
 	github.com/sanity-io/litter v1.1.0
+	golang.org/x/net v0.47.0
 	golang.org/x/sys v0.39.0
 )
 
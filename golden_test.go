@@ -0,0 +1,249 @@
+package main
+
+// Golden-file tests of what Edwood draws. Each test lays out a row on
+// edwoodtest's mock display, applies edits and compares the resulting
+// draw operations with files checked in under testdata/golden: a text
+// transcript of the operations drawn on the screen and, where asked for,
+// their SVG visualization. After an intended change to the display,
+// rewrite the golden files with
+//
+//	go test -run TestGolden -update
+//
+// and review the differences.
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/dumpfile"
+	"github.com/rjkroege/edwood/edwoodtest"
+	"github.com/rjkroege/edwood/theme"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenScene is a headless Edwood whose drawing is being recorded.
+type goldenScene struct {
+	t          *testing.T
+	h          *headless
+	display    draw.Display
+	transcript strings.Builder
+}
+
+// newGoldenScene starts a headless Edwood with one column holding a
+// window for each body. The transcript begins with the operations that
+// draw the initial layout. The SVG visualization is relative to rectofi.
+func newGoldenScene(t *testing.T, rectofi image.Rectangle, bodies ...string) *goldenScene {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	t.Cleanup(func(g *globals) func() { return func() { global = g } }(global))
+	t.Cleanup(func(varfont, fixedfont string) func() {
+		return func() { *varfontflag, *fixedfontflag = varfont, fixedfont }
+	}(*varfontflag, *fixedfontflag)) // set by loading the dump
+	global = makeglobals()
+	global.palette = theme.Light
+	warnings = nil // left by other tests
+
+	dump := &dumpfile.Content{
+		CurrentDir: cwd,
+		VarFont:    edwoodtest.MockFontName,
+		FixedFont:  edwoodtest.MockFontName,
+		RowTag:     dumpfile.Text{Buffer: "Newcol Kill Putall Dump Exit "},
+		Columns: []dumpfile.Column{
+			{Tag: dumpfile.Text{Buffer: "New Cut Paste Snarf Sort Zerox Delcol "}},
+		},
+	}
+	for i, b := range bodies {
+		dump.Windows = append(dump.Windows, &dumpfile.Window{
+			Type:     dumpfile.Unsaved,
+			Position: float64(100 * i / len(bodies)),
+			Tag:      dumpfile.Text{Buffer: fmt.Sprintf("/golden/%d Del Snarf | Look ", i)},
+			Body:     dumpfile.Text{Buffer: b},
+		})
+	}
+
+	s := &goldenScene{
+		t:       t,
+		display: edwoodtest.NewDisplay(rectofi),
+	}
+	s.transcript.WriteString("# layout\n")
+	s.h = startHeadless(global, dump, s.display)
+	t.Cleanup(func() {
+		if _, err := s.h.finish(); err != nil {
+			t.Errorf("finish failed: %v", err)
+		}
+	})
+	return s
+}
+
+// step records the operations drawn since the last step and starts
+// recording the operations for the step called title.
+func (s *goldenScene) step(title string) {
+	s.h.do(func() {})
+	gdo := s.display.(edwoodtest.GettableDrawOps)
+	for _, op := range gdo.ScreenDrawOps() {
+		s.transcript.WriteString(op)
+		s.transcript.WriteByte('\n')
+	}
+	gdo.Clear()
+	fmt.Fprintf(&s.transcript, "# %s\n", title)
+}
+
+// window returns the i'th window in the column.
+func (s *goldenScene) window(i int) *Window {
+	return global.row.col[0].w[i]
+}
+
+// at returns a point within the q'th character of t.
+func (s *goldenScene) at(t *Text, q int) image.Point {
+	return t.fr.Ptofchar(q).Add(image.Pt(1, 1))
+}
+
+// mouse plays a mouse event at pt.
+func (s *goldenScene) mouse(pt image.Point, buttons int) {
+	s.h.play(mouseEvent(draw.Mouse{Point: pt, Buttons: buttons}))
+}
+
+// click clicks button 1 at pt.
+func (s *goldenScene) click(pt image.Point) {
+	s.mouse(pt, 1)
+	s.mouse(pt, 0)
+	s.mouse(pt, 0)
+}
+
+// sweep selects from p0 to p1 with button 1.
+func (s *goldenScene) sweep(p0, p1 image.Point) {
+	s.mouse(p0, 1)
+	s.mouse(p1, 1)
+	s.mouse(p1, 0)
+	s.mouse(p1, 0)
+}
+
+// typestring types str at the mouse position.
+func (s *goldenScene) typestring(str string) {
+	for _, r := range str {
+		s.h.play(inputEvent{Kind: "key", Rune: r})
+	}
+}
+
+// check compares the transcript, and the SVG visualization if svg is
+// true, with the golden files for the test.
+func (s *goldenScene) check(svg bool) {
+	s.t.Helper()
+	s.step("end")
+	checkGolden(s.t, s.t.Name()+".txt", []byte(s.transcript.String()))
+	if svg {
+		var b bytes.Buffer
+		if err := s.display.(edwoodtest.GettableDrawOps).SVGDrawOps(&b); err != nil {
+			s.t.Fatalf("SVGDrawOps failed: %v", err)
+		}
+		checkGolden(s.t, s.t.Name()+".html", b.Bytes())
+	}
+}
+
+// checkGolden compares got with the golden file name, or rewrites the
+// file with got if -update is set.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if diff := cmp.Diff(string(want), string(got)); diff != "" {
+		t.Errorf("%s mismatch (-want +got):\n%s\nIf the change is intended, run the test with -update.", path, diff)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		bodies []string
+		svg    bool
+		edit   func(s *goldenScene)
+	}{
+		{
+			name:   "layout",
+			bodies: []string{"hello world\n", "one\ntwo\nthree\n"},
+			edit:   func(s *goldenScene) {},
+		},
+		{
+			name:   "type",
+			bodies: []string{"hello world\n"},
+			svg:    true,
+			edit: func(s *goldenScene) {
+				body := &s.window(0).body
+				s.step("click after hello")
+				s.click(s.at(body, 6))
+				s.step("type")
+				s.typestring("big ")
+				s.step("backspace")
+				s.typestring("\b\b")
+			},
+		},
+		{
+			name:   "select",
+			bodies: []string{"hello world\nsecond line\n"},
+			svg:    true,
+			edit: func(s *goldenScene) {
+				body := &s.window(0).body
+				s.step("sweep across lines")
+				s.sweep(s.at(body, 6), s.at(body, 18))
+				s.step("click")
+				s.click(s.at(body, 2))
+			},
+		},
+		{
+			name:   "tag",
+			bodies: []string{"hello\n"},
+			edit: func(s *goldenScene) {
+				tag := &s.window(0).tag
+				s.step("type in tag")
+				s.click(s.at(tag, tag.Nc()))
+				s.typestring("Undo ")
+			},
+		},
+		{
+			name:   "insert",
+			bodies: []string{"one\ntwo\nthree\n", "other\n"},
+			edit: func(s *goldenScene) {
+				w := s.window(0)
+				s.step("insert lines")
+				s.h.do(func() {
+					w.body.Insert(4, []rune(strings.Repeat("wrap ", 20)+"\n"), true)
+				})
+				s.step("delete lines")
+				s.h.do(func() {
+					w.body.Delete(0, 8, true)
+				})
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newGoldenScene(t, image.Rect(0, 0, 800, 120), tc.bodies...)
+			tc.edit(s)
+			s.check(tc.svg)
+		})
+	}
+}
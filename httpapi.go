@@ -0,0 +1,443 @@
+package main
+
+// An HTTP/JSON interface to the file server, for programs written in
+// languages without a 9P client. Each -http.listen flag serves it on a
+// Unix socket or loopback TCP port, with the same capabilities as
+// -fsys.listen; TCP clients must send the -fsys.secret as a bearer token.
+// Every request is carried out over its own 9P connection to the file
+// server, so it behaves exactly as the equivalent file operations:
+//
+//	GET    /windows               index.json
+//	POST   /windows               create a window; returns its ctl.json
+//	GET    /windows/{id}          ctl.json
+//	DELETE /windows/{id}          write delete to ctl
+//	GET    /windows/{id}/ctl      ctl.json
+//	POST   /windows/{id}/ctl      write the request body to ctl
+//	GET    /windows/{id}/addr     the ranges selected by ?addr=, as JSON
+//	GET    /windows/{id}/body     the body, or the text at ?addr=
+//	POST   /windows/{id}/body     append the request body to the body
+//	PUT    /windows/{id}/body     replace the text at ?addr= (default all)
+//	GET    /windows/{id}/tag      the tag
+//	POST   /windows/{id}/tag      append the request body to the tag
+//	GET    /windows/{id}/events   event.json as server-sent events
+//	POST   /windows/{id}/events   write events back to event.json
+//	GET    /log                   the log file as server-sent events
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+var httpListen listenFlag
+
+func init() {
+	flag.Var(&httpListen, "http.listen", "Also serve an HTTP/JSON API to the file system on `addr[,cap...]`, as for -fsys.listen (repeatable)")
+}
+
+// httplisten starts the HTTP servers named by -http.listen.
+func httplisten() error {
+	for _, spec := range httpListen {
+		l, caps, secret, err := listenSpec(spec)
+		if err != nil {
+			return fmt.Errorf("-http.listen %q: %v", spec, err)
+		}
+		api := newHTTPAPI(func() (*client.Fsys, func(), error) {
+			return httpmount(caps)
+		}, secret)
+		go func() {
+			if err := http.Serve(l, api); err != nil {
+				log.Printf("http: serve on %v: %v", l.Addr(), err)
+			}
+		}()
+	}
+	return nil
+}
+
+// httpmount attaches to a new in-process file server limited to caps.
+// Closing the connection waits for the server to end.
+func httpmount(caps *fsysCaps) (*client.Fsys, func(), error) {
+	conn, fsys, done, err := fsysattach(caps)
+	if err != nil {
+		<-done
+		return nil, nil, err
+	}
+	return fsys, func() {
+		conn.Close()
+		<-done
+	}, nil
+}
+
+// httpAPI serves the HTTP/JSON interface.
+type httpAPI struct {
+	mount  func() (*client.Fsys, func(), error) // a new 9P connection and its close
+	secret []byte                               // bearer token; nil if none needed
+	mux    *http.ServeMux
+}
+
+func newHTTPAPI(mount func() (*client.Fsys, func(), error), secret []byte) *httpAPI {
+	api := &httpAPI{
+		mount:  mount,
+		secret: secret,
+		mux:    http.NewServeMux(),
+	}
+	api.handle("GET /windows", api.index)
+	api.handle("POST /windows", api.newwin)
+	api.handle("GET /windows/{id}", api.ctl)
+	api.handle("DELETE /windows/{id}", api.del)
+	api.handle("GET /windows/{id}/ctl", api.ctl)
+	api.handle("POST /windows/{id}/ctl", api.ctlwrite)
+	api.handle("GET /windows/{id}/addr", api.addr)
+	api.handle("GET /windows/{id}/body", api.body)
+	api.handle("POST /windows/{id}/body", api.appendfile("body"))
+	api.handle("PUT /windows/{id}/body", api.bodyreplace)
+	api.handle("GET /windows/{id}/tag", api.tag)
+	api.handle("POST /windows/{id}/tag", api.appendfile("tag"))
+	api.handle("GET /windows/{id}/events", api.events)
+	api.handle("POST /windows/{id}/events", api.eventswrite)
+	api.handle("GET /log", api.logstream)
+	return api
+}
+
+func (api *httpAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if api.secret != nil {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), api.secret) != 1 {
+			http.Error(w, "wrong secret", http.StatusUnauthorized)
+			return
+		}
+	}
+	api.mux.ServeHTTP(w, r)
+}
+
+// httpFunc handles a request using fsys, attached for the request alone.
+type httpFunc func(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error
+
+// errBadWindow is returned for a malformed window id in the URL.
+var errBadWindow = errors.New("bad window id")
+
+func (api *httpAPI) handle(pattern string, h httpFunc) {
+	api.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		fsys, unmount, err := api.mount()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer unmount()
+
+		// Closing the connection ends reads blocked on events.
+		stop := context.AfterFunc(r.Context(), unmount)
+		defer stop()
+
+		if err := h(w, r, fsys); err != nil {
+			httpError(w, err)
+		}
+	})
+}
+
+// httpError reports err, which is usually an error returned by the file
+// server, with a matching status.
+func httpError(w http.ResponseWriter, err error) {
+	code := http.StatusBadRequest
+	switch err.Error() {
+	case errBadWindow.Error(), ErrNotExist.Error():
+		code = http.StatusNotFound
+	case ErrPermission.Error():
+		code = http.StatusForbidden
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// winfile returns the path of the file name in the window named by the
+// request URL.
+func winfile(r *http.Request, name string) (string, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return "", errBadWindow
+	}
+	return fmt.Sprintf("/%d/%s", id, name), nil
+}
+
+func fsysread(fsys *client.Fsys, name string) ([]byte, error) {
+	fid, err := fsys.Open(name, plan9.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+	return io.ReadAll(fid)
+}
+
+func fsyswrite(fsys *client.Fsys, name string, r io.Reader) error {
+	fid, err := fsys.Open(name, plan9.OWRITE)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	_, err = io.Copy(fid, r)
+	return err
+}
+
+// reply writes the contents of the file name as the response.
+func reply(w http.ResponseWriter, fsys *client.Fsys, name, ctype string) error {
+	b, err := fsysread(fsys, name)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Write(b)
+	return nil
+}
+
+func (api *httpAPI) index(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	return reply(w, fsys, "/index.json", "application/json")
+}
+
+func (api *httpAPI) newwin(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	fid, err := fsys.Open("/new/ctl", plan9.OREAD)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	b, err := io.ReadAll(fid)
+	if err != nil {
+		return err
+	}
+	f := strings.Fields(string(b))
+	if len(f) == 0 {
+		return fmt.Errorf("short read of new window's ctl")
+	}
+	ctl, err := fsysread(fsys, "/"+f[0]+"/ctl.json")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(ctl)
+	return nil
+}
+
+func (api *httpAPI) ctl(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	name, err := winfile(r, "ctl.json")
+	if err != nil {
+		return err
+	}
+	return reply(w, fsys, name, "application/json")
+}
+
+func (api *httpAPI) ctlwrite(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	name, err := winfile(r, "ctl")
+	if err != nil {
+		return err
+	}
+	if err := fsyswrite(fsys, name, r.Body); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (api *httpAPI) del(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	name, err := winfile(r, "ctl")
+	if err != nil {
+		return err
+	}
+	if err := fsyswrite(fsys, name, strings.NewReader("delete")); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// setaddr opens the window's addr file and writes expr, if any, to it.
+// The address stays set until the returned fid is closed.
+func setaddr(r *http.Request, fsys *client.Fsys, expr string) (*client.Fid, error) {
+	name, err := winfile(r, "addr")
+	if err != nil {
+		return nil, err
+	}
+	fid, err := fsys.Open(name, plan9.ORDWR)
+	if err != nil {
+		return nil, err
+	}
+	if expr != "" {
+		if _, err := fid.Write([]byte(expr)); err != nil {
+			fid.Close()
+			return nil, err
+		}
+	}
+	return fid, nil
+}
+
+// textRange is a range of runes in a window's body, as reported to
+// HTTP and RPC clients.
+type textRange struct {
+	Q0 int `json:"q0"`
+	Q1 int `json:"q1"`
+}
+
+func (api *httpAPI) addr(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	fid, err := setaddr(r, fsys, r.URL.Query().Get("addr"))
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	b := make([]byte, 8192)
+	n, err := fid.ReadAt(b, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	f := strings.Fields(string(b[:n]))
+	ranges := []textRange{}
+	for i := 0; i+1 < len(f); i += 2 {
+		q0, err0 := strconv.Atoi(f[i])
+		q1, err1 := strconv.Atoi(f[i+1])
+		if err0 != nil || err1 != nil {
+			return fmt.Errorf("bad addr %q", b[:n])
+		}
+		ranges = append(ranges, textRange{q0, q1})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(ranges)
+}
+
+func (api *httpAPI) body(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	expr := r.URL.Query().Get("addr")
+	if expr == "" {
+		name, err := winfile(r, "body")
+		if err != nil {
+			return err
+		}
+		return reply(w, fsys, name, "text/plain; charset=utf-8")
+	}
+	fid, err := setaddr(r, fsys, expr)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	name, err := winfile(r, "xdata")
+	if err != nil {
+		return err
+	}
+	return reply(w, fsys, name, "text/plain; charset=utf-8")
+}
+
+func (api *httpAPI) bodyreplace(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	expr := r.URL.Query().Get("addr")
+	if expr == "" {
+		expr = ","
+	}
+	fid, err := setaddr(r, fsys, expr)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	name, err := winfile(r, "data")
+	if err != nil {
+		return err
+	}
+	if err := fsyswrite(fsys, name, r.Body); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (api *httpAPI) tag(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	name, err := winfile(r, "tag")
+	if err != nil {
+		return err
+	}
+	return reply(w, fsys, name, "text/plain; charset=utf-8")
+}
+
+// appendfile returns a handler that appends the request body to the
+// window's file called name.
+func (api *httpAPI) appendfile(name string) httpFunc {
+	return func(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+		name, err := winfile(r, name)
+		if err != nil {
+			return err
+		}
+		if err := fsyswrite(fsys, name, r.Body); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+func (api *httpAPI) events(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	name, err := winfile(r, "event.json")
+	if err != nil {
+		return err
+	}
+	return stream(w, r, fsys, name, func(line string) (string, error) {
+		return line, nil
+	})
+}
+
+func (api *httpAPI) eventswrite(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	name, err := winfile(r, "event.json")
+	if err != nil {
+		return err
+	}
+	if err := fsyswrite(fsys, name, r.Body); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (api *httpAPI) logstream(w http.ResponseWriter, r *http.Request, fsys *client.Fsys) error {
+	return stream(w, r, fsys, "/log", func(line string) (string, error) {
+		b, err := json.Marshal(parseLogEntry(line))
+		return string(b), err
+	})
+}
+
+// stream sends the lines read from the file name, converted to JSON by
+// conv, as server-sent events until the client goes away.
+func stream(w http.ResponseWriter, r *http.Request, fsys *client.Fsys, name string, conv func(string) (string, error)) error {
+	fid, err := fsys.Open(name, plan9.OREAD)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush() // the file is open: events from now on will be sent
+	}
+	br := bufio.NewReader(fid)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil // client or window gone; the response has begun
+		}
+		data, err := conv(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			return nil
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return nil
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"9fans.net/go/plan9/client"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHTTPAPI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping on windows")
+	}
+	sock := filepath.Join(t.TempDir(), "http")
+	a := startAcme(t, "-http.listen", "unix!"+sock+",ro")
+	defer a.Cleanup()
+
+	api := newHTTPAPI(func() (*client.Fsys, func(), error) {
+		conn, err := client.DialService("acme")
+		if err != nil {
+			return nil, nil, err
+		}
+		fsys, err := conn.Attach(nil, getuser(), "")
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		return fsys, func() { conn.Close() }, nil
+	}, nil)
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	do := func(method, path, body string, wantCode int) string {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%v %v failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("%v %v: reading response failed: %v", method, path, err)
+		}
+		if resp.StatusCode != wantCode {
+			t.Fatalf("%v %v: got status %v (%q); want %v", method, path, resp.StatusCode, b, wantCode)
+		}
+		return string(b)
+	}
+
+	// sse opens a stream of server-sent events and returns its data lines.
+	sse := func(path string) (<-chan string, func()) {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %v failed: %v", path, err)
+		}
+		if got, want := resp.Header.Get("Content-Type"), "text/event-stream"; got != want {
+			t.Fatalf("GET %v: got content type %q; want %q", path, got, want)
+		}
+		c := make(chan string, 100)
+		go func() {
+			defer close(c)
+			s := bufio.NewScanner(resp.Body)
+			for s.Scan() {
+				if data, ok := strings.CutPrefix(s.Text(), "data: "); ok {
+					c <- data
+				}
+			}
+		}()
+		return c, func() { resp.Body.Close() }
+	}
+
+	logc, stoplog := sse("/log")
+	defer stoplog()
+
+	var ctl WindowCtl
+	if err := json.Unmarshal([]byte(do("POST", "/windows", "", http.StatusCreated)), &ctl); err != nil {
+		t.Fatalf("bad ctl.json for new window: %v", err)
+	}
+	id := "/windows/" + strconv.Itoa(ctl.ID)
+
+	var entry logEntry
+	if err := json.Unmarshal([]byte(<-logc), &entry); err != nil {
+		t.Fatalf("bad log event: %v", err)
+	}
+	if want := (logEntry{ID: ctl.ID, Op: "new"}); entry != want {
+		t.Errorf("got log event %+v; want %+v", entry, want)
+	}
+
+	do("POST", id+"/ctl", "name /tmp/httpapi\n", http.StatusNoContent)
+	do("POST", id+"/body", "hello world\n", http.StatusNoContent)
+	if got, want := do("GET", id+"/body", "", http.StatusOK), "hello world\n"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+	if got, want := do("GET", id+"/body?addr=/wor/", "", http.StatusOK), "wor"; got != want {
+		t.Errorf("got text at address %q; want %q", got, want)
+	}
+
+	var ranges []textRange
+	if err := json.Unmarshal([]byte(do("GET", id+"/addr?addr=/world/", "", http.StatusOK)), &ranges); err != nil {
+		t.Fatalf("bad addr response: %v", err)
+	}
+	if diff := cmp.Diff([]textRange{{6, 11}}, ranges); diff != "" {
+		t.Errorf("addr mismatch (-want +got):\n%s", diff)
+	}
+
+	events, stopevents := sse(id + "/events")
+	do("PUT", id+"/body?addr=/world/", "there", http.StatusNoContent)
+	if got, want := do("GET", id+"/body", "", http.StatusOK), "hello there\n"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+	var ev Event
+	for ev.Type != "I" {
+		if err := json.Unmarshal([]byte(<-events), &ev); err != nil {
+			t.Fatalf("bad event: %v", err)
+		}
+	}
+	if want := (Event{Origin: "F", Type: "I", Q0: 6, Q1: 11, Text: "there"}); ev != want {
+		t.Errorf("got event %+v; want %+v", ev, want)
+	}
+	stopevents()
+
+	if tag := do("GET", id+"/tag", "", http.StatusOK); !strings.HasPrefix(tag, "/tmp/httpapi ") {
+		t.Errorf("tag %q does not start with the window name", tag)
+	}
+	if index := do("GET", "/windows", "", http.StatusOK); !strings.Contains(index, `"name":"/tmp/httpapi"`) {
+		t.Errorf("window missing from index %q", index)
+	}
+
+	do("GET", id+"/body?addr=/nothere/", "", http.StatusBadRequest)
+	do("GET", "/windows/x/body", "", http.StatusNotFound)
+	do("GET", "/windows/9999/body", "", http.StatusNotFound)
+
+	// The -http.listen server is read-only.
+	uc := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", id + "/body", http.StatusOK},
+		{"POST", id + "/body", http.StatusForbidden},
+		{"GET", id + "/events", http.StatusForbidden},
+		{"POST", "/windows", http.StatusForbidden},
+	} {
+		req, err := http.NewRequest(tc.method, "http://edwood"+tc.path, strings.NewReader("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := uc.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v over %v failed: %v", tc.method, tc.path, sock, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%v %v over %v: got status %v; want %v", tc.method, tc.path, sock, resp.StatusCode, tc.want)
+		}
+	}
+
+	do("DELETE", id, "", http.StatusNoContent)
+	do("GET", id, "", http.StatusNotFound)
+}
+
+func TestHTTPAPISecret(t *testing.T) {
+	api := newHTTPAPI(func() (*client.Fsys, func(), error) {
+		t.Fatalf("request without the secret reached the file server")
+		return nil, nil, nil
+	}, []byte("sesame"))
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	for _, auth := range []string{"", "Bearer", "Bearer open", "sesame"} {
+		req, err := http.NewRequest("GET", srv.URL+"/windows", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusUnauthorized; got != want {
+			t.Errorf("Authorization %q: got status %v; want %v", auth, got, want)
+		}
+	}
+}
+
+// Requests are served at once, each over its own in-process file server.
+func TestHTTPAPIConcurrent(t *testing.T) {
+	FlexiblyMakeWindowScaffold(t, ScWin("/a/file"), ScBody("/a/file", "one\n"))
+	global.row.col[0].w[0].id = 1
+	startXfidThreads(t)
+	srv := httptest.NewServer(newHTTPAPI(func() (*client.Fsys, func(), error) {
+		return httpmount(nil)
+	}, nil))
+	t.Cleanup(srv.Close) // before the Xfids stop
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := srv.Client().Get(srv.URL + "/windows/1/body")
+			if err != nil {
+				t.Errorf("GET failed: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil || resp.StatusCode != http.StatusOK || string(b) != "one\n" {
+				t.Errorf("GET gave status %v, body %q, error %v", resp.StatusCode, b, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 
 	"9fans.net/go/plan9"
@@ -88,14 +90,47 @@ func xfidlogflush(x *Xfid) {
 	defer eventlog.lk.Unlock()
 	for i := 0; i < len(eventlog.read); i++ {
 		rx := eventlog.read[i]
-		if rx.fcall.Tag == x.fcall.Oldtag {
+		if rx.fs == x.fs && rx.fcall.Tag == x.fcall.Oldtag {
 			rx.flushed = true
 			eventlog.r.Broadcast()
 		}
 	}
 }
 
+// xfidloghangup ends the log reads left blocked by a client of fs that
+// went away.
+func xfidloghangup(fs *fileServer) {
+	eventlog.lk.Lock()
+	defer eventlog.lk.Unlock()
+	for _, rx := range eventlog.read {
+		if rx.fs == fs {
+			rx.flushed = true
+			eventlog.r.Broadcast()
+		}
+	}
+}
+
+// logEntry is an entry of the log file in a form for JSON encoding.
+type logEntry struct {
+	ID   int    `json:"id"`
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// parseLogEntry parses a line read from the log file.
+func parseLogEntry(line string) *logEntry {
+	f := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
+	for len(f) < 3 {
+		f = append(f, "")
+	}
+	id, _ := strconv.Atoi(f[0])
+	return &logEntry{ID: id, Op: f[1], Text: f[2]}
+}
+
 // add a log entry for op on w.
+// Each entry is a line holding an ID, the op and a final free-text field
+// that runs to the end of the line. For window events, the ID is the
+// window's and the text is its name unless noted.
 // expected calls:
 //
 // op == "new" for each new window
@@ -114,7 +149,38 @@ func xfidlogflush(x *Xfid) {
 //
 // op == "del" for deleted window
 // - called from winclose
+//
+// op == "dirty" or "clean" when the window's Put state changes
+// - called from Window.UpdateTag
+//
+// op == "rename" for each window whose file is renamed; text is the new name
+// - called from Window.SetName
+//
+// op == "move" when a window is moved to another place; text is
+// "col name"
+// - called from Column.DragWin, Column.MoveWin and movewindow
+//
+// op == "edit" when an Edit command finishes; ID 0 if run outside a window
+// - called from editcmd
+//
+// op == "exec" and "exit" when an external command starts and exits; ID
+// is the window it was run from or 0, text is "pid command" for exec and
+// "pid status command" for exit, with status the exit code
+// - called from waitthread
+//
+// op == "coladd" and "coldel" when a column is added or removed; ID is 0
+// and text is the column's index in the row
+// - called from Row.Add and Row.Close
+//
+// op == "colmove" when a column moves past its neighbours; ID is 0 and
+// text is "from to", its old and new indexes in the row
+// - called from Row.MoveCol
 func xfidlog(w *Window, op string) {
+	xfidlogf(w.id, op, w.body.file.Name())
+}
+
+// xfidlogf adds a log entry for op on the object with the given id.
+func xfidlogf(id int, op string, text string) {
 	eventlog.lk.Lock()
 	defer eventlog.lk.Unlock()
 	if len(eventlog.ev) >= cap(eventlog.ev) {
@@ -132,8 +198,8 @@ func xfidlog(w *Window, op string) {
 			eventlog.ev = eventlog.ev[:len(eventlog.ev)-n] // TODO(flux) fussy, might have messed this up
 		}
 	}
-	name := w.body.file.Name()
-	eventlog.ev = append(eventlog.ev, fmt.Sprintf("%d %s %s\n", w.id, op, name))
+	text = strings.ReplaceAll(text, "\n", " ") // Keep one entry per line.
+	eventlog.ev = append(eventlog.ev, fmt.Sprintf("%d %s %s\n", id, op, text))
 	if eventlog.r.L == nil {
 		eventlog.r.L = &eventlog.lk
 	}
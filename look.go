@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"image"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime/debug"
@@ -14,6 +15,7 @@ import (
 	"9fans.net/go/plan9/client"
 	"9fans.net/go/plumb"
 	"github.com/rjkroege/edwood/file"
+	"github.com/rjkroege/edwood/plumber"
 	"github.com/rjkroege/edwood/util"
 )
 
@@ -58,9 +60,69 @@ func handlePlumb(fsys plumbClient) {
 
 func startplumbing() {
 	global.cplumb = make(chan *plumb.Message)
+	loadplumbing()
 	go plumbthread()
 }
 
+// loadplumbing reads the rules of the built-in plumber. A missing rule
+// file leaves edwood interpreting button-3 clicks by itself.
+func loadplumbing() {
+	name := *plumbingfile
+	if name == "" {
+		name = filepath.Join(global.home, "lib", "plumbing")
+	}
+	rs, err := plumber.Load(name)
+	if err != nil {
+		if *plumbingfile != "" || !os.IsNotExist(err) {
+			warning(nil, "plumbing: %v\n", err)
+		}
+		return
+	}
+	global.plumbrules = rs
+}
+
+// plumbrecv acts on a message sent to the edit port.
+func plumbrecv(m *plumb.Message) {
+	if m.Type != "text" {
+		return
+	}
+	act := findattr(m.Attr, "action")
+	if act == "" || act == "showfile" {
+		plumblook(m)
+	} else if act == "showdata" {
+		plumbshow(m)
+	}
+}
+
+// plumbbuiltin routes m through the built-in plumbing rules and reports
+// whether a rule handled it. Messages for the edit port are opened here;
+// for other ports the rule's start or client program is run.
+func plumbbuiltin(m *plumb.Message) bool {
+	a := global.plumbrules.Match(m)
+	if a == nil {
+		return false
+	}
+	if a.Port == "edit" {
+		plumbrecv(a.Msg)
+		return true
+	}
+	argv := a.Start
+	if len(argv) == 0 {
+		argv = a.Client
+	}
+	if len(argv) == 0 {
+		return false
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = a.Msg.Dir
+	if err := cmd.Start(); err != nil {
+		warning(nil, "plumb: %v\n", err)
+		return true
+	}
+	go cmd.Wait()
+	return true
+}
+
 func look3(t *Text, q0 int, q1 int, external bool) {
 	var (
 		n, c, f int
@@ -75,7 +137,7 @@ func look3(t *Text, q0 int, q1 int, external bool) {
 		global.seltext = t
 	}
 	e, expanded := expand(t, q0, q1)
-	if !external && t.w != nil && t.w.nopen[QWevent] > 0 {
+	if !external && t.w != nil && t.w.eventsopen() {
 		// send alphanumeric expansion to external client
 		if !expanded {
 			return
@@ -102,7 +164,9 @@ func look3(t *Text, q0 int, q1 int, external bool) {
 		} else {
 			t.w.Eventf("%c%d %d %d 0 \n", c, q0, q1, f)
 		}
+		ev := &Event{Type: string(rune(c)), Q0: q0, Q1: q1, Flag: f, Text: t.file.StringSlice(q0, q1)}
 		if q0 == e.q0 && q1 == e.q1 {
+			t.w.EventJSON(ev)
 			return
 		}
 		if len(e.name) > 0 {
@@ -128,6 +192,8 @@ func look3(t *Text, q0 int, q1 int, external bool) {
 		} else {
 			t.w.Eventf("%c%d %d %d 0 \n", c, e.q0, e.q1, f)
 		}
+		ev.Expansion = &EventRange{Q0: e.q0, Q1: e.q1, Text: string(r)}
+		t.w.EventJSON(ev)
 		return
 	}
 	if plumbsendfid != nil {
@@ -138,6 +204,10 @@ func look3(t *Text, q0 int, q1 int, external bool) {
 		if m.Send(plumbsendfid) == nil {
 			return
 		}
+	} else if global.plumbrules != nil {
+		if m, err := look3Message(t, q0, q1); err == nil && plumbbuiltin(m) {
+			return
+		}
 	}
 	// interpret alphanumeric string ourselves
 	if !expanded {
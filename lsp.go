@@ -0,0 +1,715 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rjkroege/edwood/file"
+	"github.com/rjkroege/edwood/lsp"
+)
+
+// lspTimeout bounds how long starting a language server and the LSP
+// commands wait for an answer.
+var lspTimeout = 10 * time.Second
+
+// lspStart starts the language server described by conf for the
+// workspace rooted at root. Tests replace it.
+var lspStart = func(conf *lsp.Server, root string, handle lsp.Handler) (*lsp.Client, error) {
+	return lsp.Start(conf.Command, root, handle)
+}
+
+// lspServer is a language server running for the files of one
+// language under one root directory.
+type lspServer struct {
+	conf *lsp.Server
+	root string
+	md   *MntDir // where the server's messages are shown
+
+	// The fields below are guarded by lspstate.lk.
+	client *lsp.Client // nil until initialized
+	sync   int         // how the server wants documents synchronized
+	diags  map[string]string
+}
+
+// lspDoc is a file open in a language server. The server is sent the
+// changes made to the file's buffer by one of the bodies showing it,
+// the owner, and keeps a copy of the text it has been sent so that
+// the positions of deletions can be computed after the fact.
+type lspDoc struct {
+	server  *lspServer
+	owner   *Text
+	uri     string
+	version int
+	text    []rune
+
+	// The start of a line of text and its number, from which the
+	// positions of changes are found. It follows the changes, so that
+	// typing costs the length of the line rather than of the text.
+	bol, line int
+}
+
+// lspstate holds the language servers and the documents open in them.
+// The documents are changed by the main thread with the body's window
+// locked, and the servers by the goroutines reading their connections.
+var lspstate struct {
+	lk      sync.Mutex
+	config  *lsp.Config
+	servers map[string]*lspServer // by language and root
+	docs    map[*file.ObservableEditableBuffer]*lspDoc
+	calls   sync.WaitGroup // the calls started by lspgo
+}
+
+// loadlsp reads the configuration of the language servers.
+func loadlsp() {
+	name := *lspfile
+	if name == "" {
+		name = filepath.Join(global.home, "lib", "lsp")
+	}
+	c, err := lsp.LoadConfig(name)
+	if err != nil {
+		if *lspfile != "" || !os.IsNotExist(err) {
+			warning(nil, "lsp: %v\n", err)
+		}
+		return
+	}
+	lspstate.config = c
+}
+
+// lsproot returns the root of the workspace holding directory dir: the
+// nearest directory holding a go.mod or a .git, or dir if there's none.
+func lsproot(dir string) string {
+	for d := dir; ; {
+		for _, marker := range []string{"go.mod", ".git"} {
+			if _, err := os.Stat(filepath.Join(d, marker)); err == nil {
+				return d
+			}
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// lspopen tells the language server for the file loaded into body t
+// about it, starting the server if need be.
+func lspopen(t *Text) {
+	if t.what != Body || t.file.IsDir() || lspstate.config == nil {
+		return
+	}
+	name := t.file.Name()
+	if name == "" || !filepath.IsAbs(name) {
+		return
+	}
+	lspstate.lk.Lock()
+	defer lspstate.lk.Unlock()
+
+	if d := lspstate.docs[t.file]; d != nil {
+		d.owner = t
+		if d.uri == lsp.URI(name) {
+			// Reloaded: the server has followed the changes, but be sure.
+			d.sync(t.file)
+			return
+		}
+		d.close()
+	}
+
+	conf := lspstate.config.Match(name)
+	if conf == nil {
+		return
+	}
+	root := lsproot(filepath.Dir(name))
+	key := conf.Language + " " + root
+	s := lspstate.servers[key]
+	if s == nil {
+		s = &lspServer{
+			conf:  conf,
+			root:  root,
+			md:    mnt.Add(root, nil),
+			diags: make(map[string]string),
+		}
+		if lspstate.servers == nil {
+			lspstate.servers = make(map[string]*lspServer)
+		}
+		lspstate.servers[key] = s
+		go s.run(key)
+	}
+	text := make([]rune, t.file.Nr())
+	t.file.Read(0, text)
+	d := &lspDoc{server: s, owner: t, uri: lsp.URI(name), version: 1, text: text}
+	if lspstate.docs == nil {
+		lspstate.docs = make(map[*file.ObservableEditableBuffer]*lspDoc)
+	}
+	lspstate.docs[t.file] = d
+	d.open()
+}
+
+// run starts the server, opens the documents waiting for it and waits
+// for it to exit.
+func (s *lspServer) run(key string) {
+	c, err := lspStart(s.conf, s.root, s.handle)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), lspTimeout)
+		if err = c.Initialize(ctx, s.root); err != nil {
+			c.Close(ctx)
+		}
+		cancel()
+	}
+	if err != nil {
+		warning(s.md, "lsp: can't start %s server for %s: %v\n", s.conf.Language, s.root, err)
+		s.detach(key)
+		return
+	}
+
+	lspstate.lk.Lock()
+	s.client = c
+	s.sync = c.Capabilities.SyncKind()
+	for _, d := range lspstate.docs {
+		if d.server == s {
+			d.open()
+		}
+	}
+	lspstate.lk.Unlock()
+
+	<-c.Done()
+	warning(s.md, "lsp: %s server for %s exited: %v\n", s.conf.Language, s.root, c.Err())
+	s.detach(key)
+}
+
+// detach forgets the server and its documents, so that the server is
+// started again when one of them is next loaded.
+func (s *lspServer) detach(key string) {
+	lspstate.lk.Lock()
+	defer lspstate.lk.Unlock()
+	if lspstate.servers[key] == s {
+		delete(lspstate.servers, key)
+	}
+	for f, d := range lspstate.docs {
+		if d.server == s {
+			delete(lspstate.docs, f)
+		}
+	}
+	mnt.DecRef(s.md) // mnt.Add in lspopen
+}
+
+// handle handles the requests and notifications from the server.
+func (s *lspServer) handle(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "textDocument/publishDiagnostics":
+		var p lsp.PublishDiagnosticsParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		s.diagnostics(&p)
+	case "window/showMessage":
+		var p struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		warning(s.md, "%s: %s\n", s.conf.Language, p.Message)
+	case "workspace/configuration":
+		var p struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return make([]any, len(p.Items)), nil
+	case "window/workDoneProgress/create", "client/registerCapability", "client/unregisterCapability":
+		return nil, nil
+	default:
+		return nil, lsp.ErrMethodNotFound
+	}
+	return nil, nil
+}
+
+// diagnostics shows the diagnostics of a document as file:line:col
+// addresses, unless they're those last shown for it.
+func (s *lspServer) diagnostics(p *lsp.PublishDiagnosticsParams) {
+	diags := p.Diagnostics
+	sort.SliceStable(diags, func(i, j int) bool {
+		pi, pj := diags[i].Range.Start, diags[j].Range.Start
+		return pi.Line < pj.Line || (pi.Line == pj.Line && pi.Character < pj.Character)
+	})
+	var b strings.Builder
+	for _, d := range diags {
+		addr, _ := s.addr(lsp.Location{URI: p.URI, Range: d.Range})
+		msg := strings.ReplaceAll(strings.TrimSpace(d.Message), "\n", "\n\t")
+		fmt.Fprintf(&b, "%s: %s\n", addr, msg)
+	}
+
+	lspstate.lk.Lock()
+	same := s.diags[p.URI] == b.String()
+	s.diags[p.URI] = b.String()
+	lspstate.lk.Unlock()
+	if !same && b.Len() > 0 {
+		warning(s.md, "%s", b.String())
+	}
+}
+
+// addr returns the address of loc as file:line:col, with the file name
+// relative to the server's root if it's inside it and the column
+// counted in runes, and the text of the line.
+func (s *lspServer) addr(loc lsp.Location) (string, string) {
+	name, err := lsp.Filename(loc.URI)
+	if err != nil {
+		return loc.URI, ""
+	}
+	if rel, err := filepath.Rel(s.root, name); err == nil && !strings.HasPrefix(rel, "..") {
+		name = rel
+	}
+	pos := loc.Range.Start
+
+	var text []rune
+	lspstate.lk.Lock()
+	for _, d := range lspstate.docs {
+		if d.uri == loc.URI {
+			text = d.text
+			break
+		}
+	}
+	lspstate.lk.Unlock()
+	if text == nil {
+		b, err := os.ReadFile(filepath.Join(s.root, name))
+		if err != nil {
+			return fmt.Sprintf("%s:%d:%d", name, pos.Line+1, pos.Character+1), ""
+		}
+		text = []rune(string(b))
+	}
+	bol := lsp.Offset(lsp.Runes(text), lsp.Position{Line: pos.Line})
+	q := lsp.Offset(lsp.Runes(text), pos)
+	eol := bol
+	for eol < len(text) && text[eol] != '\n' {
+		eol++
+	}
+	return fmt.Sprintf("%s:%d:%d", name, pos.Line+1, q-bol+1), string(text[bol:eol])
+}
+
+// open sends the document to its server once the server is running.
+func (d *lspDoc) open() {
+	c := d.server.client
+	if c == nil {
+		return
+	}
+	c.Notify("textDocument/didOpen", &lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{
+			URI:        d.uri,
+			LanguageID: d.server.conf.Language,
+			Version:    d.version,
+			Text:       string(d.text),
+		},
+	})
+}
+
+func (d *lspDoc) close() {
+	for f, od := range lspstate.docs {
+		if od == d {
+			delete(lspstate.docs, f)
+		}
+	}
+	if c := d.server.client; c != nil {
+		c.Notify("textDocument/didClose", &lsp.DidCloseTextDocumentParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: d.uri},
+		})
+	}
+}
+
+// change sends the server the change ch, which has already been made to
+// d.text. The whole text is sent to servers that want it.
+func (d *lspDoc) change(ch lsp.TextDocumentContentChangeEvent) {
+	d.version++
+	s := d.server
+	if s.client == nil || s.sync == lsp.SyncNone {
+		return
+	}
+	if s.sync == lsp.SyncFull {
+		ch = lsp.TextDocumentContentChangeEvent{Text: string(d.text)}
+	}
+	s.client.Notify("textDocument/didChange", &lsp.DidChangeTextDocumentParams{
+		TextDocument:   lsp.VersionedTextDocumentIdentifier{URI: d.uri, Version: d.version},
+		ContentChanges: []lsp.TextDocumentContentChangeEvent{ch},
+	})
+}
+
+// sync sends the server the whole text of f if it isn't the text the
+// server has.
+func (d *lspDoc) sync(f *file.ObservableEditableBuffer) {
+	text := make([]rune, f.Nr())
+	f.Read(0, text)
+	if string(text) != string(d.text) {
+		d.text = text
+		d.bol, d.line = 0, 0
+		d.change(lsp.TextDocumentContentChangeEvent{Text: string(text)})
+	}
+}
+
+// position returns the position of rune offset q in d.text, moving from
+// the line last looked at.
+func (d *lspDoc) position(q int) lsp.Position {
+	q = min(q, len(d.text))
+	for d.bol > q {
+		d.line--
+		d.bol--
+		for d.bol > 0 && d.text[d.bol-1] != '\n' {
+			d.bol--
+		}
+	}
+	for i := d.bol; i < q; i++ {
+		if d.text[i] == '\n' {
+			d.line++
+			d.bol = i + 1
+		}
+	}
+	p := lsp.PositionOf(lsp.Runes(d.text[d.bol:q]), q-d.bol)
+	p.Line = d.line
+	return p
+}
+
+// lspsync brings the language server up to date with t's file after an
+// Undo or Redo, which doesn't report replacements change by change.
+func lspsync(t *Text) {
+	lspstate.lk.Lock()
+	defer lspstate.lk.Unlock()
+	if d := lspstate.docs[t.file]; d != nil {
+		d.sync(t.file)
+	}
+}
+
+// lspinserted forwards the insertion of the text b at q0 into t's file
+// to its language server.
+func lspinserted(t *Text, q0 int, b []byte) {
+	lspstate.lk.Lock()
+	defer lspstate.lk.Unlock()
+	d := lspstate.docs[t.file]
+	if d == nil || d.owner != t || q0 > len(d.text) {
+		return
+	}
+	p := d.position(q0) // the line changed starts before q0
+	d.text = slices.Insert(d.text, q0, []rune(string(b))...)
+	d.change(lsp.TextDocumentContentChangeEvent{Range: &lsp.Range{Start: p, End: p}, Text: string(b)})
+}
+
+// lspdeleted forwards the deletion of the text between q0 and q1 from
+// t's file to its language server.
+func lspdeleted(t *Text, q0, q1 int) {
+	lspstate.lk.Lock()
+	defer lspstate.lk.Unlock()
+	d := lspstate.docs[t.file]
+	if d == nil || d.owner != t || q1 > len(d.text) {
+		return
+	}
+	var r lsp.Range
+	r.End = d.position(q1)
+	r.Start = d.position(q0) // last, so that the line changed starts before q0
+	d.text = slices.Delete(d.text, q0, q1)
+	d.change(lsp.TextDocumentContentChangeEvent{Range: &r})
+}
+
+// lspsaved tells the language server that t's file has been written.
+func lspsaved(t *Text) {
+	lspstate.lk.Lock()
+	defer lspstate.lk.Unlock()
+	d := lspstate.docs[t.file]
+	if d == nil || d.server.client == nil {
+		return
+	}
+	d.server.client.Notify("textDocument/didSave", &lsp.DidSaveTextDocumentParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: d.uri},
+	})
+}
+
+// lspclose hands the document of the closing body t to another body on
+// the same file or, if there's none, closes it in its server. t must no
+// longer observe its file.
+func lspclose(t *Text) {
+	lspstate.lk.Lock()
+	defer lspstate.lk.Unlock()
+	d := lspstate.docs[t.file]
+	if d == nil || d.owner != t {
+		return
+	}
+	var next *Text
+	t.file.AllObservers(func(i interface{}) {
+		if u, ok := i.(*Text); ok && u != t && u.what == Body && next == nil {
+			next = u
+		}
+	})
+	if next != nil {
+		d.owner = next
+		return
+	}
+	d.close()
+}
+
+// lspbody returns the body of et's window and the language server
+// its file is open in, warning if there's none.
+func lspbody(et *Text) (*Text, *lspDoc, *lsp.Client) {
+	if et == nil || et.w == nil {
+		return nil, nil, nil
+	}
+	t := &et.w.body
+	lspstate.lk.Lock()
+	defer lspstate.lk.Unlock()
+	d := lspstate.docs[t.file]
+	switch {
+	case d == nil:
+		warning(nil, "%s: no language server\n", t.file.Name())
+		return nil, nil, nil
+	case d.server.client == nil:
+		warning(nil, "%s: language server is starting\n", t.file.Name())
+		return nil, nil, nil
+	}
+	return t, d, d.server.client
+}
+
+// lspcall calls method on the language server of et's body with the
+// position of its selection, handing the raw result to done as lspgo
+// does.
+func lspcall(et *Text, method string, params func(pos lsp.TextDocumentPositionParams) any, done func(d *lspDoc, result json.RawMessage)) {
+	t, d, c := lspbody(et)
+	if c == nil {
+		return
+	}
+	t.TypeCommit()
+	pos := lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: d.uri},
+		Position:     lsp.PositionOf(t, t.q0),
+	}
+	lspgo(t.w, d, c, method, params(pos), done)
+}
+
+// lspgo calls method on the language server c in the background, so that
+// a slow server doesn't hold up the editor. When the answer comes, done
+// is called with the row and w locked, unless the call failed, w has
+// been closed or d has changed, making the answer out of date.
+func lspgo(w *Window, d *lspDoc, c *lsp.Client, method string, params any, done func(d *lspDoc, result json.RawMessage)) {
+	lspstate.lk.Lock()
+	version := d.version
+	lspstate.lk.Unlock()
+	lspstate.calls.Add(1)
+	go func() {
+		defer lspstate.calls.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), lspTimeout)
+		defer cancel()
+		var result json.RawMessage
+		if err := c.Call(ctx, method, params, &result); err != nil {
+			warning(d.server.md, "%s: %v\n", method, err)
+			return
+		}
+		global.row.lk.Lock()
+		defer global.row.lk.Unlock()
+		if w.col == nil {
+			return
+		}
+		lspstate.lk.Lock()
+		changed := d.version != version
+		lspstate.lk.Unlock()
+		if changed {
+			warning(d.server.md, "%s: %s changed while waiting for the server\n", method, w.body.file.Name())
+			return
+		}
+		w.Lock('L')
+		done(d, result)
+		w.Unlock()
+		global.row.display.Flush()
+	}()
+}
+
+func positionParams(pos lsp.TextDocumentPositionParams) any {
+	return &pos
+}
+
+// lspdef shows the definition of the identifier at the selection.
+func lspdef(et, _, _ *Text, _, _ bool, _ string) {
+	lspcall(et, "textDocument/definition", positionParams, func(d *lspDoc, result json.RawMessage) {
+		locs, err := lsp.Locations(result)
+		if err != nil {
+			warning(d.server.md, "Def: %v\n", err)
+			return
+		}
+		if len(locs) == 0 {
+			warning(d.server.md, "Def: no definition found\n")
+			return
+		}
+		lspshow(et, locs[0])
+	})
+}
+
+// lspshow opens the file of loc and selects its range.
+func lspshow(et *Text, loc lsp.Location) {
+	name, err := lsp.Filename(loc.URI)
+	if err != nil {
+		warning(nil, "%v\n", err)
+		return
+	}
+	addr := []rune(strconv.Itoa(loc.Range.Start.Line + 1))
+	w := openfile(et, &Expand{
+		name:  name,
+		jump:  true,
+		a1:    len(addr),
+		agetc: func(q int) rune { return addr[q] },
+	})
+	if w == nil {
+		return
+	}
+	t := &w.body
+	t.Show(lsp.Offset(t, loc.Range.Start), lsp.Offset(t, loc.Range.End), true)
+}
+
+// lsprefs lists the references to the identifier at the selection.
+func lsprefs(et, _, _ *Text, _, _ bool, _ string) {
+	params := func(pos lsp.TextDocumentPositionParams) any {
+		return &lsp.ReferenceParams{
+			TextDocumentPositionParams: pos,
+			Context:                    lsp.ReferenceContext{IncludeDeclaration: true},
+		}
+	}
+	lspcall(et, "textDocument/references", params, func(d *lspDoc, result json.RawMessage) {
+		locs, err := lsp.Locations(result)
+		if err != nil {
+			warning(d.server.md, "Refs: %v\n", err)
+			return
+		}
+		if len(locs) == 0 {
+			warning(d.server.md, "Refs: no references found\n")
+			return
+		}
+		var b strings.Builder
+		for _, loc := range locs {
+			addr, line := d.server.addr(loc)
+			fmt.Fprintf(&b, "%s: %s\n", addr, strings.TrimSpace(line))
+		}
+		warning(d.server.md, "%s", b.String())
+	})
+}
+
+// lsphover describes the identifier at the selection.
+func lsphover(et, _, _ *Text, _, _ bool, _ string) {
+	lspcall(et, "textDocument/hover", positionParams, func(d *lspDoc, result json.RawMessage) {
+		var h lsp.Hover
+		if string(result) != "null" {
+			if err := json.Unmarshal(result, &h); err != nil {
+				warning(d.server.md, "Hover: %v\n", err)
+				return
+			}
+		}
+		s := h.Text()
+		if s == "" {
+			warning(d.server.md, "Hover: nothing to show\n")
+			return
+		}
+		warning(d.server.md, "%s\n", s)
+	})
+}
+
+// lsprename renames the identifier at the selection to the argument
+// throughout the workspace.
+func lsprename(et, _, argt *Text, _, _ bool, arg string) {
+	name, _ := getarg(argt, false, false)
+	if name == "" {
+		name = strings.TrimSpace(arg)
+	}
+	if name == "" {
+		warning(nil, "Rename: no new name\n")
+		return
+	}
+	params := func(pos lsp.TextDocumentPositionParams) any {
+		return &lsp.RenameParams{TextDocumentPositionParams: pos, NewName: name}
+	}
+	lspcall(et, "textDocument/rename", params, func(d *lspDoc, result json.RawMessage) {
+		var we lsp.WorkspaceEdit
+		if err := json.Unmarshal(result, &we); err != nil {
+			warning(d.server.md, "Rename: %v\n", err)
+			return
+		}
+		edits, err := we.Edits()
+		if err != nil {
+			warning(d.server.md, "Rename: %v\n", err)
+			return
+		}
+		lspapply(et, edits)
+	})
+}
+
+// lspformat formats the body.
+func lspformat(et, _, _ *Text, _, _ bool, _ string) {
+	t, d, c := lspbody(et)
+	if c == nil {
+		return
+	}
+	t.TypeCommit()
+	params := &lsp.DocumentFormattingParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: d.uri},
+		Options:      lsp.FormattingOptions{TabSize: t.tabstop},
+	}
+	lspgo(t.w, d, c, "textDocument/formatting", params, func(d *lspDoc, result json.RawMessage) {
+		var edits []lsp.TextEdit
+		if err := json.Unmarshal(result, &edits); err != nil {
+			warning(d.server.md, "Format: %v\n", err)
+			return
+		}
+		lspapply(et, map[string][]lsp.TextEdit{d.uri: edits})
+	})
+}
+
+// lspapply makes the edits to the files with the given URIs, opening
+// windows on those not shown. The edits are undone together.
+func lspapply(et *Text, edits map[string][]lsp.TextEdit) {
+	uris := make([]string, 0, len(edits))
+	for uri := range edits {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	global.seq++
+	for _, uri := range uris {
+		te := edits[uri]
+		if len(te) == 0 {
+			continue
+		}
+		name, err := lsp.Filename(uri)
+		if err != nil {
+			warning(nil, "%v\n", err)
+			continue
+		}
+		w := lookfile(name)
+		if w == nil {
+			w = openfile(et, &Expand{name: name, jump: true})
+		}
+		if w == nil {
+			continue
+		}
+		t := &w.body
+		t.TypeCommit()
+		t.file.Mark(global.seq)
+
+		// Edits at the same position apply in order, so make them from the
+		// end of the file back.
+		type span struct {
+			q0, q1 int
+			text   []rune
+		}
+		spans := make([]span, len(te))
+		for i, e := range te {
+			spans[i] = span{lsp.Offset(t, e.Range.Start), lsp.Offset(t, e.Range.End), []rune(e.NewText)}
+		}
+		sort.SliceStable(spans, func(i, j int) bool { return spans[i].q0 < spans[j].q0 })
+		for i := len(spans) - 1; i >= 0; i-- {
+			s := spans[i]
+			t.Delete(s.q0, s.q1, true)
+			t.Insert(s.q0, s.text, true)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+// Package lsp implements a client of the Language Server Protocol: the
+// JSON-RPC 2.0 connection, the subset of the protocol used by Edwood and
+// the configuration that says which server to start for which files.
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrMethodNotFound is returned by a Handler for requests and
+// notifications it doesn't implement.
+var ErrMethodNotFound = errors.New("method not found")
+
+// ErrClosed is returned for calls on a closed connection.
+var ErrClosed = errors.New("lsp: connection closed")
+
+// Handler handles the requests and notifications sent by the server. The
+// result is sent back for requests and ignored for notifications. It's
+// called from the goroutine reading the connection, so it mustn't call
+// the server.
+type Handler func(method string, params json.RawMessage) (any, error)
+
+// Error is an error response from the server.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("lsp: %v (%d)", e.Message, e.Code)
+}
+
+// message is any JSON-RPC 2.0 message.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// Client is a connection to a language server.
+type Client struct {
+	rwc    io.ReadWriteCloser
+	handle Handler
+
+	// Capabilities are those the server returned from Initialize.
+	Capabilities ServerCapabilities
+
+	lk      sync.Mutex
+	seq     int64
+	pending map[int64]chan *message
+	err     error // why the connection closed
+
+	// outgoing messages, written in order by writeloop
+	wlk    sync.Mutex
+	wcond  sync.Cond
+	wq     [][]byte
+	wdone  bool
+	closed chan struct{}
+}
+
+// NewClient returns a client talking to a server over rwc. Requests and
+// notifications from the server are passed to handle.
+func NewClient(rwc io.ReadWriteCloser, handle Handler) *Client {
+	c := &Client{
+		rwc:     rwc,
+		handle:  handle,
+		pending: make(map[int64]chan *message),
+		closed:  make(chan struct{}),
+	}
+	c.wcond.L = &c.wlk
+	go c.readloop()
+	go c.writeloop()
+	return c
+}
+
+// Start starts the server command argv in dir and returns a client
+// talking to it over its standard input and output. The server's
+// standard error goes to ours.
+func Start(argv []string, dir string, handle Handler) (*Client, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("lsp: no server command")
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go cmd.Wait()
+	return NewClient(&stdio{in, out}, handle), nil
+}
+
+// stdio is the connection to a server's standard input and output.
+type stdio struct {
+	io.WriteCloser
+	r io.ReadCloser
+}
+
+func (s *stdio) Read(b []byte) (int, error) { return s.r.Read(b) }
+
+func (s *stdio) Close() error {
+	err := s.WriteCloser.Close()
+	s.r.Close()
+	return err
+}
+
+// Call sends a request and waits for its result, which is decoded into
+// result unless that's nil.
+func (c *Client) Call(ctx context.Context, method string, params, result any) error {
+	c.lk.Lock()
+	if c.err != nil {
+		c.lk.Unlock()
+		return c.err
+	}
+	c.seq++
+	id := c.seq
+	reply := make(chan *message, 1)
+	c.pending[id] = reply
+	c.lk.Unlock()
+
+	rawid := json.RawMessage(strconv.FormatInt(id, 10))
+	if err := c.send(&message{ID: &rawid, Method: method}, params); err != nil {
+		return err
+	}
+	select {
+	case m, ok := <-reply:
+		if !ok {
+			return c.closeErr()
+		}
+		if m.Error != nil {
+			return m.Error
+		}
+		if result == nil || len(m.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(m.Result, result)
+	case <-ctx.Done():
+		c.lk.Lock()
+		delete(c.pending, id)
+		c.lk.Unlock()
+		c.send(&message{Method: "$/cancelRequest"}, map[string]any{"id": id})
+		return ctx.Err()
+	}
+}
+
+// Notify sends a notification.
+func (c *Client) Notify(method string, params any) error {
+	return c.send(&message{Method: method}, params)
+}
+
+// send queues m, with params, for writing.
+func (c *Client) send(m *message, params any) error {
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		m.Params = b
+	}
+	m.JSONRPC = "2.0"
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	c.wlk.Lock()
+	defer c.wlk.Unlock()
+	if c.wdone {
+		return c.closeErr()
+	}
+	c.wq = append(c.wq, b)
+	c.wcond.Signal()
+	return nil
+}
+
+// writeloop writes the queued messages, so that a server that is slow
+// to read doesn't hold up the sender.
+func (c *Client) writeloop() {
+	w := bufio.NewWriter(c.rwc)
+	for {
+		c.wlk.Lock()
+		for len(c.wq) == 0 && !c.wdone {
+			c.wcond.Wait()
+		}
+		q := c.wq
+		c.wq = nil
+		done := c.wdone
+		c.wlk.Unlock()
+
+		for _, b := range q {
+			fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(b))
+			w.Write(b)
+		}
+		if err := w.Flush(); err != nil {
+			c.shut(err)
+			return
+		}
+		if done {
+			return
+		}
+	}
+}
+
+func (c *Client) readloop() {
+	r := textproto.NewReader(bufio.NewReader(c.rwc))
+	for {
+		b, err := readMessage(r)
+		if err != nil {
+			c.shut(err)
+			return
+		}
+		var m message
+		if err := json.Unmarshal(b, &m); err != nil {
+			c.shut(fmt.Errorf("lsp: bad message: %v", err))
+			return
+		}
+		switch {
+		case m.Method != "":
+			result, err := c.handle(m.Method, m.Params)
+			if m.ID == nil {
+				break // notification
+			}
+			reply := &message{ID: m.ID}
+			switch {
+			case errors.Is(err, ErrMethodNotFound):
+				reply.Error = &Error{Code: -32601, Message: fmt.Sprintf("method %q not found", m.Method)}
+			case err != nil:
+				reply.Error = &Error{Code: -32603, Message: err.Error()}
+			default:
+				reply.Result, err = json.Marshal(result)
+				if err != nil {
+					reply.Result, reply.Error = nil, &Error{Code: -32603, Message: err.Error()}
+				}
+			}
+			c.send(reply, nil)
+		case m.ID != nil:
+			id, err := strconv.ParseInt(string(*m.ID), 10, 64)
+			if err != nil {
+				break // not one of ours
+			}
+			c.lk.Lock()
+			reply, ok := c.pending[id]
+			delete(c.pending, id)
+			c.lk.Unlock()
+			if ok {
+				reply <- &m
+			}
+		}
+	}
+}
+
+// readMessage reads the content of the next message.
+func readMessage(r *textproto.Reader) ([]byte, error) {
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(h.Get("Content-Length")))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("lsp: bad Content-Length %q", h.Get("Content-Length"))
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.R, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// shut fails the pending calls with err and stops writing.
+func (c *Client) shut(err error) {
+	c.lk.Lock()
+	if c.err != nil {
+		c.lk.Unlock()
+		return
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, os.ErrClosed) {
+		err = ErrClosed
+	}
+	c.err = err
+	for id, reply := range c.pending {
+		close(reply)
+		delete(c.pending, id)
+	}
+	c.lk.Unlock()
+
+	c.wlk.Lock()
+	c.wdone = true
+	c.wcond.Signal()
+	c.wlk.Unlock()
+	close(c.closed)
+	c.rwc.Close()
+}
+
+func (c *Client) closeErr() error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.err == nil {
+		return ErrClosed
+	}
+	return c.err
+}
+
+// Done returns a channel that's closed when the connection closes.
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns why the connection closed, or nil if it's open.
+func (c *Client) Err() error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.err
+}
+
+// Initialize performs the initialization handshake for the workspace
+// rooted at directory root.
+func (c *Client) Initialize(ctx context.Context, root string) error {
+	params := &InitializeParams{
+		ProcessID:    os.Getpid(),
+		ClientInfo:   &ClientInfo{Name: "edwood"},
+		RootURI:      URI(root),
+		Capabilities: clientCapabilities,
+		WorkspaceFolders: []WorkspaceFolder{
+			{URI: URI(root), Name: root},
+		},
+	}
+	var result InitializeResult
+	if err := c.Call(ctx, "initialize", params, &result); err != nil {
+		return err
+	}
+	c.Capabilities = result.Capabilities
+	return c.Notify("initialized", struct{}{})
+}
+
+// Close shuts the server down and closes the connection.
+func (c *Client) Close(ctx context.Context) error {
+	err := c.Call(ctx, "shutdown", nil, nil)
+	if err == nil {
+		err = c.Notify("exit", nil)
+	}
+	c.wlk.Lock()
+	c.wdone = true
+	c.wcond.Signal()
+	c.wlk.Unlock()
+	select {
+	case <-c.closed:
+	case <-ctx.Done():
+		c.shut(ErrClosed)
+	}
+	if err == ErrClosed {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package lsp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Server describes a language server: the language it serves, the files
+// it serves it for and the command that starts it.
+type Server struct {
+	Language string   // language identifier, as in didOpen
+	Patterns []string // file name patterns, as for filepath.Match
+	Command  []string
+}
+
+// Config is the set of language servers to use.
+type Config struct {
+	Servers []*Server
+}
+
+// ParseConfig parses a configuration. Each line describes a Server with
+// blank-separated fields: the language identifier, a comma-separated
+// list of file name patterns and the server command with its arguments.
+// For example,
+//
+//	go	*.go,go.mod	gopls serve
+//
+// Text from a # to the end of a line and blank lines are ignored.
+func ParseConfig(r io.Reader) (*Config, error) {
+	var c Config
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) < 3 {
+			return nil, fmt.Errorf("line %d: want language, patterns and command", n)
+		}
+		pats := strings.Split(f[1], ",")
+		for _, p := range pats {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("line %d: bad pattern %q", n, p)
+			}
+		}
+		c.Servers = append(c.Servers, &Server{
+			Language: f[0],
+			Patterns: pats,
+			Command:  f[2:],
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// LoadConfig reads and parses the configuration file name.
+func LoadConfig(name string) (*Config, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v:%v", name, err)
+	}
+	return c, nil
+}
+
+// Match returns the first server with a pattern matching the base of the
+// file name, or nil if there's none.
+func (c *Config) Match(name string) *Server {
+	base := filepath.Base(name)
+	for _, s := range c.Servers {
+		for _, p := range s.Patterns {
+			if ok, _ := filepath.Match(p, base); ok {
+				return s
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package lsp_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/lsp"
+	"github.com/rjkroege/edwood/lsp/lsptest"
+)
+
+// TestMain lets the test binary run as the fake language server, which
+// TestClient starts as a stdio server.
+func TestMain(m *testing.M) {
+	if os.Getenv("LSPTEST_SERVER") != "" {
+		if err := lsptest.NewServer().Serve(os.Stdin, os.Stdout); err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestParseConfig(t *testing.T) {
+	c, err := lsp.ParseConfig(strings.NewReader(`
+# language servers
+go	*.go,go.mod	gopls serve -rpc.trace
+python	*.py	pylsp	# comment
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	want := []*lsp.Server{
+		{Language: "go", Patterns: []string{"*.go", "go.mod"}, Command: []string{"gopls", "serve", "-rpc.trace"}},
+		{Language: "python", Patterns: []string{"*.py"}, Command: []string{"pylsp"}},
+	}
+	if diff := cmp.Diff(want, c.Servers); diff != "" {
+		t.Errorf("servers mismatch (-want +got):\n%s", diff)
+	}
+
+	for name, lang := range map[string]string{
+		"/a/b/x.go":  "go",
+		"go.mod":     "go",
+		"/a/y.py":    "python",
+		"/a/go.sum":  "",
+		"/a.go/x.py": "python",
+	} {
+		got := ""
+		if s := c.Match(name); s != nil {
+			got = s.Language
+		}
+		if got != lang {
+			t.Errorf("Match(%q) gave %q, want %q", name, got, lang)
+		}
+	}
+
+	for _, bad := range []string{"go *.go\n", "go [ gopls\n"} {
+		if _, err := lsp.ParseConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseConfig(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	text := lsp.Runes("ab\n𝄞c\n\nd")
+	for _, tc := range []struct {
+		q int
+		p lsp.Position
+	}{
+		{0, lsp.Position{0, 0}},
+		{2, lsp.Position{0, 2}},
+		{3, lsp.Position{1, 0}},
+		{4, lsp.Position{1, 2}}, // 𝄞 is two UTF-16 code units
+		{5, lsp.Position{1, 3}},
+		{6, lsp.Position{2, 0}},
+		{7, lsp.Position{3, 0}},
+		{8, lsp.Position{3, 1}},
+	} {
+		if got := lsp.PositionOf(text, tc.q); got != tc.p {
+			t.Errorf("PositionOf(%d) = %v, want %v", tc.q, got, tc.p)
+		}
+		if got := lsp.Offset(text, tc.p); got != tc.q {
+			t.Errorf("Offset(%v) = %d, want %d", tc.p, got, tc.q)
+		}
+	}
+	// Positions beyond the line or the text are clamped.
+	if got := lsp.Offset(text, lsp.Position{0, 10}); got != 2 {
+		t.Errorf("Offset past end of line = %d, want 2", got)
+	}
+	if got := lsp.Offset(text, lsp.Position{10, 0}); got != 8 {
+		t.Errorf("Offset past end of text = %d, want 8", got)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := lsp.URI("/tmp/a b/c.go")
+	if want := "file:///tmp/a%20b/c.go"; uri != want {
+		t.Errorf("URI gave %q, want %q", uri, want)
+	}
+	name, err := lsp.Filename(uri)
+	if err != nil || name != "/tmp/a b/c.go" {
+		t.Errorf("Filename(%q) = %q, %v", uri, name, err)
+	}
+	if _, err := lsp.Filename("http://x/y"); err == nil {
+		t.Errorf("Filename accepted an http URI")
+	}
+}
+
+func TestHoverText(t *testing.T) {
+	for _, tc := range []struct{ contents, want string }{
+		{`"plain"`, "plain"},
+		{`{"kind":"markdown","value":"**x**\n"}`, "**x**"},
+		{`{"language":"go","value":"func f()"}`, "func f()"},
+		{`["a",{"language":"go","value":"b"}]`, "a\nb"},
+	} {
+		h := lsp.Hover{Contents: json.RawMessage(tc.contents)}
+		if got := h.Text(); got != tc.want {
+			t.Errorf("Text of %s = %q, want %q", tc.contents, got, tc.want)
+		}
+	}
+}
+
+func TestWorkspaceEdits(t *testing.T) {
+	var e lsp.WorkspaceEdit
+	err := json.Unmarshal([]byte(`{"documentChanges":[
+		{"textDocument":{"uri":"file:///a","version":1},"edits":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}},"newText":"x"}]}
+	]}`), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edits, err := e.Edits()
+	if err != nil {
+		t.Fatalf("Edits failed: %v", err)
+	}
+	if len(edits["file:///a"]) != 1 || edits["file:///a"][0].NewText != "x" {
+		t.Errorf("Edits gave %v", edits)
+	}
+
+	e = lsp.WorkspaceEdit{DocumentChanges: []json.RawMessage{json.RawMessage(`{"kind":"create","uri":"file:///b"}`)}}
+	if _, err := e.Edits(); err == nil {
+		t.Errorf("Edits accepted a create operation")
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Setenv("LSPTEST_SERVER", "1")
+	dir := t.TempDir()
+
+	diags := make(chan *lsp.PublishDiagnosticsParams, 10)
+	handle := func(method string, params json.RawMessage) (any, error) {
+		switch method {
+		case "textDocument/publishDiagnostics":
+			var p lsp.PublishDiagnosticsParams
+			if err := json.Unmarshal(params, &p); err != nil {
+				t.Errorf("bad diagnostics: %v", err)
+			}
+			diags <- &p
+			return nil, nil
+		case "client/registerCapability":
+			return nil, nil
+		}
+		return nil, lsp.ErrMethodNotFound
+	}
+	c, err := lsp.Start([]string{os.Args[0]}, dir, handle)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.Initialize(ctx, dir); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if got := c.Capabilities.SyncKind(); got != lsp.SyncIncremental {
+		t.Errorf("got sync kind %d, want %d", got, lsp.SyncIncremental)
+	}
+
+	nextDiags := func() *lsp.PublishDiagnosticsParams {
+		t.Helper()
+		select {
+		case p := <-diags:
+			return p
+		case <-ctx.Done():
+			t.Fatalf("no diagnostics")
+		}
+		return nil
+	}
+
+	uri := lsp.URI(filepath.Join(dir, "a.go"))
+	text := "func f() {}\n// TODO\nf()\n"
+	if err := c.Notify("textDocument/didOpen", &lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{URI: uri, LanguageID: "go", Version: 1, Text: text},
+	}); err != nil {
+		t.Fatalf("didOpen failed: %v", err)
+	}
+	p := nextDiags()
+	if p.URI != uri || len(p.Diagnostics) != 1 || p.Diagnostics[0].Range.Start != (lsp.Position{1, 3}) {
+		t.Errorf("got diagnostics %+v", p)
+	}
+
+	// Remove the TODO.
+	if err := c.Notify("textDocument/didChange", &lsp.DidChangeTextDocumentParams{
+		TextDocument: lsp.VersionedTextDocumentIdentifier{URI: uri, Version: 2},
+		ContentChanges: []lsp.TextDocumentContentChangeEvent{
+			{Range: &lsp.Range{Start: lsp.Position{1, 0}, End: lsp.Position{2, 0}}, Text: ""},
+		},
+	}); err != nil {
+		t.Fatalf("didChange failed: %v", err)
+	}
+	if p := nextDiags(); len(p.Diagnostics) != 0 || p.Version != 2 {
+		t.Errorf("got diagnostics %+v after change", p)
+	}
+
+	pos := lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+		Position:     lsp.Position{1, 0},
+	}
+	var locs []lsp.Location
+	if err := c.Call(ctx, "textDocument/definition", &pos, &locs); err != nil {
+		t.Fatalf("definition failed: %v", err)
+	}
+	want := []lsp.Location{{URI: uri, Range: lsp.Range{Start: lsp.Position{0, 5}, End: lsp.Position{0, 6}}}}
+	if diff := cmp.Diff(want, locs); diff != "" {
+		t.Errorf("definition mismatch (-want +got):\n%s", diff)
+	}
+
+	var h lsp.Hover
+	if err := c.Call(ctx, "textDocument/hover", &pos, &h); err != nil {
+		t.Fatalf("hover failed: %v", err)
+	}
+	if got := h.Text(); got != "identifier f" {
+		t.Errorf("hover gave %q", got)
+	}
+
+	if err := c.Call(ctx, "textDocument/frobnicate", &pos, nil); !errors.As(err, new(*lsp.Error)) {
+		t.Errorf("unknown method gave error %v, want an *lsp.Error", err)
+	}
+
+	if err := c.Close(ctx); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if err := c.Call(ctx, "textDocument/hover", &pos, &h); err == nil {
+		t.Errorf("Call succeeded after Close")
+	}
+}
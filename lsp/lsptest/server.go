@@ -0,0 +1,352 @@
+// Package lsptest provides a fake language server for testing clients of
+// the Language Server Protocol.
+package lsptest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode"
+
+	"github.com/rjkroege/edwood/lsp"
+)
+
+// Server is a fake language server. It keeps the documents it's sent and
+// answers requests about them in a toy way, taking any run of letters,
+// digits and underscores as an identifier:
+//
+//   - every "TODO" in a document is diagnosed as a warning
+//   - the definition of an identifier follows the first "func "
+//   - its references are all its occurrences
+//   - hovering over it gives "identifier" and its name
+//   - renaming it replaces all its occurrences
+//   - formatting removes trailing blanks from lines
+type Server struct {
+	// Sync is the text document synchronization kind announced to the
+	// client. It defaults to lsp.SyncIncremental.
+	Sync int
+
+	lk   sync.Mutex
+	docs map[string][]rune
+	w    *bufio.Writer
+}
+
+// NewServer returns a server with no documents.
+func NewServer() *Server {
+	return &Server{Sync: lsp.SyncIncremental, docs: make(map[string][]rune)}
+}
+
+// Text returns the text of the open document with the given URI.
+func (s *Server) Text(uri string) (string, bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	d, ok := s.docs[uri]
+	return string(d), ok
+}
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *lsp.Error       `json:"error,omitempty"`
+}
+
+// Serve reads messages from r and writes replies to w until the client
+// sends exit or r ends.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = bufio.NewWriter(w)
+	tr := textproto.NewReader(bufio.NewReader(r))
+	for {
+		h, err := tr.ReadMIMEHeader()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		n, err := strconv.Atoi(h.Get("Content-Length"))
+		if err != nil {
+			return fmt.Errorf("bad Content-Length: %v", err)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(tr.R, b); err != nil {
+			return err
+		}
+		var m message
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+		switch {
+		case m.Method == "exit":
+			return nil
+		case m.Method == "":
+			// a reply to one of our requests
+		case m.ID == nil:
+			if err := s.notification(m.Method, m.Params); err != nil {
+				return err
+			}
+		default:
+			result, err := s.request(m.Method, m.Params)
+			reply := &message{ID: m.ID, Result: result}
+			if err != nil {
+				reply.Result, reply.Error = nil, &lsp.Error{Code: -32601, Message: err.Error()}
+			} else if result == nil {
+				reply.Result = json.RawMessage("null")
+			}
+			if err := s.send(reply); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) send(m *message) error {
+	m.JSONRPC = "2.0"
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(b))
+	s.w.Write(b)
+	return s.w.Flush()
+}
+
+func (s *Server) notify(method string, params any) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.send(&message{Method: method, Params: b})
+}
+
+func (s *Server) notification(method string, params json.RawMessage) error {
+	switch method {
+	case "initialized":
+		// Servers typically ask the client for things; check that it answers.
+		id := json.RawMessage(`"register"`)
+		return s.send(&message{ID: &id, Method: "client/registerCapability", Params: json.RawMessage(`{"registrations":[]}`)})
+	case "textDocument/didOpen":
+		var p lsp.DidOpenTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return err
+		}
+		s.lk.Lock()
+		s.docs[p.TextDocument.URI] = []rune(p.TextDocument.Text)
+		s.lk.Unlock()
+		return s.publish(p.TextDocument.URI, p.TextDocument.Version)
+	case "textDocument/didChange":
+		var p lsp.DidChangeTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return err
+		}
+		s.lk.Lock()
+		d, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			s.lk.Unlock()
+			return fmt.Errorf("change to unopened document %v", p.TextDocument.URI)
+		}
+		for _, c := range p.ContentChanges {
+			if c.Range == nil {
+				d = []rune(c.Text)
+				continue
+			}
+			q0 := lsp.Offset(lsp.Runes(d), c.Range.Start)
+			q1 := lsp.Offset(lsp.Runes(d), c.Range.End)
+			d = append(d[:q0:q0], append([]rune(c.Text), d[q1:]...)...)
+		}
+		s.docs[p.TextDocument.URI] = d
+		s.lk.Unlock()
+		return s.publish(p.TextDocument.URI, p.TextDocument.Version)
+	case "textDocument/didClose":
+		var p lsp.DidCloseTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return err
+		}
+		s.lk.Lock()
+		delete(s.docs, p.TextDocument.URI)
+		s.lk.Unlock()
+		return s.notify("textDocument/publishDiagnostics", &lsp.PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []lsp.Diagnostic{}})
+	}
+	return nil
+}
+
+// publish sends the diagnostics of the document uri.
+func (s *Server) publish(uri string, version int) error {
+	s.lk.Lock()
+	d := s.docs[uri]
+	diags := []lsp.Diagnostic{}
+	for _, q := range occurrences(d, "TODO") {
+		diags = append(diags, lsp.Diagnostic{
+			Range:    rangeOf(d, q, q+4),
+			Severity: lsp.SeverityWarning,
+			Source:   "lsptest",
+			Message:  "TODO remains",
+		})
+	}
+	s.lk.Unlock()
+	return s.notify("textDocument/publishDiagnostics", &lsp.PublishDiagnosticsParams{URI: uri, Version: version, Diagnostics: diags})
+}
+
+func (s *Server) request(method string, params json.RawMessage) (any, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	switch method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":           s.Sync,
+				"definitionProvider":         true,
+				"referencesProvider":         true,
+				"hoverProvider":              true,
+				"renameProvider":             true,
+				"documentFormattingProvider": true,
+			},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/definition":
+		word, err := s.word(params)
+		if err != nil || word == "" {
+			return nil, err
+		}
+		for _, uri := range s.uris() {
+			d := s.docs[uri]
+			for _, q := range occurrences(d, word) {
+				if q >= 5 && string(d[q-5:q]) == "func " {
+					return []lsp.Location{{URI: uri, Range: rangeOf(d, q, q+len([]rune(word)))}}, nil
+				}
+			}
+		}
+		return nil, nil
+	case "textDocument/references":
+		word, err := s.word(params)
+		if err != nil || word == "" {
+			return nil, err
+		}
+		locs := []lsp.Location{}
+		for _, uri := range s.uris() {
+			d := s.docs[uri]
+			for _, q := range occurrences(d, word) {
+				locs = append(locs, lsp.Location{URI: uri, Range: rangeOf(d, q, q+len([]rune(word)))})
+			}
+		}
+		return locs, nil
+	case "textDocument/hover":
+		word, err := s.word(params)
+		if err != nil || word == "" {
+			return nil, err
+		}
+		return map[string]any{
+			"contents": map[string]string{"kind": "plaintext", "value": "identifier " + word},
+		}, nil
+	case "textDocument/rename":
+		var p lsp.RenameParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		word, err := s.word(params)
+		if err != nil || word == "" {
+			return nil, err
+		}
+		edit := &lsp.WorkspaceEdit{Changes: make(map[string][]lsp.TextEdit)}
+		for _, uri := range s.uris() {
+			d := s.docs[uri]
+			for _, q := range occurrences(d, word) {
+				edit.Changes[uri] = append(edit.Changes[uri], lsp.TextEdit{
+					Range:   rangeOf(d, q, q+len([]rune(word))),
+					NewText: p.NewName,
+				})
+			}
+		}
+		return edit, nil
+	case "textDocument/formatting":
+		var p lsp.DocumentFormattingParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		d, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return nil, fmt.Errorf("no document %v", p.TextDocument.URI)
+		}
+		edits := []lsp.TextEdit{}
+		for q := 0; q <= len(d); q++ {
+			if q < len(d) && d[q] != '\n' {
+				continue
+			}
+			q0 := q
+			for q0 > 0 && (d[q0-1] == ' ' || d[q0-1] == '\t') {
+				q0--
+			}
+			if q0 < q {
+				edits = append(edits, lsp.TextEdit{Range: rangeOf(d, q0, q)})
+			}
+		}
+		return edits, nil
+	}
+	return nil, fmt.Errorf("method %q not found", method)
+}
+
+// uris returns the URIs of the open documents in order.
+func (s *Server) uris() []string {
+	var uris []string
+	for uri := range s.docs {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+// word returns the identifier at the position given by the
+// TextDocumentPositionParams params.
+func (s *Server) word(params json.RawMessage) (string, error) {
+	var p lsp.TextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return "", err
+	}
+	d, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return "", fmt.Errorf("no document %v", p.TextDocument.URI)
+	}
+	q := lsp.Offset(lsp.Runes(d), p.Position)
+	q0, q1 := q, q
+	for q0 > 0 && isident(d[q0-1]) {
+		q0--
+	}
+	for q1 < len(d) && isident(d[q1]) {
+		q1++
+	}
+	return string(d[q0:q1]), nil
+}
+
+// occurrences returns the offsets of the occurrences of the identifier
+// word in d.
+func occurrences(d []rune, word string) []int {
+	w := []rune(word)
+	var qs []int
+	for q := 0; q+len(w) <= len(d); q++ {
+		if string(d[q:q+len(w)]) != word {
+			continue
+		}
+		if (q > 0 && isident(d[q-1])) || (q+len(w) < len(d) && isident(d[q+len(w)])) {
+			continue
+		}
+		qs = append(qs, q)
+	}
+	return qs
+}
+
+func rangeOf(d []rune, q0, q1 int) lsp.Range {
+	return lsp.Range{Start: lsp.PositionOf(lsp.Runes(d), q0), End: lsp.PositionOf(lsp.Runes(d), q1)}
+}
+
+func isident(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,366 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// The protocol types below are the subset of those of the Language Server
+// Protocol specification that Edwood uses.
+
+// Position is a position in a document: a zero-based line and a
+// zero-based offset in UTF-16 code units within the line.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is a range in a document; End is exclusive.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Location is a range in the document with the given URI.
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// Locations decodes the result of textDocument/definition and the like:
+// null, a Location, an array of Locations or an array of LocationLinks.
+func Locations(raw json.RawMessage) ([]Location, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] == '{' {
+		var loc Location
+		err := json.Unmarshal(raw, &loc)
+		return []Location{loc}, err
+	}
+	var links []struct {
+		Location
+		TargetURI            string `json:"targetUri"`
+		TargetSelectionRange Range  `json:"targetSelectionRange"`
+	}
+	if err := json.Unmarshal(raw, &links); err != nil {
+		return nil, err
+	}
+	locs := make([]Location, len(links))
+	for i, l := range links {
+		locs[i] = l.Location
+		if l.TargetURI != "" {
+			locs[i] = Location{URI: l.TargetURI, Range: l.TargetSelectionRange}
+		}
+	}
+	return locs, nil
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type VersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+}
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+// TextDocumentContentChangeEvent replaces Range with Text or, if Range is
+// nil, replaces the whole document.
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// Diagnostic severities.
+const (
+	SeverityError       = 1
+	SeverityWarning     = 2
+	SeverityInformation = 3
+	SeverityHint        = 4
+)
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Message  string `json:"message"`
+}
+
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Version     int          `json:"version,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type ReferenceContext struct {
+	IncludeDeclaration bool `json:"includeDeclaration"`
+}
+
+type ReferenceParams struct {
+	TextDocumentPositionParams
+	Context ReferenceContext `json:"context"`
+}
+
+type RenameParams struct {
+	TextDocumentPositionParams
+	NewName string `json:"newName"`
+}
+
+type FormattingOptions struct {
+	TabSize      int  `json:"tabSize"`
+	InsertSpaces bool `json:"insertSpaces"`
+}
+
+type DocumentFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Options      FormattingOptions      `json:"options"`
+}
+
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type TextDocumentEdit struct {
+	TextDocument VersionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []TextEdit                      `json:"edits"`
+}
+
+// WorkspaceEdit is a set of edits to several documents, given either as
+// Changes or as DocumentChanges.
+type WorkspaceEdit struct {
+	Changes         map[string][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []json.RawMessage     `json:"documentChanges,omitempty"`
+}
+
+// Edits returns the text edits of e by document URI. It fails if e
+// creates, renames or deletes files.
+func (e *WorkspaceEdit) Edits() (map[string][]TextEdit, error) {
+	edits := make(map[string][]TextEdit)
+	for uri, te := range e.Changes {
+		edits[uri] = append(edits[uri], te...)
+	}
+	for _, raw := range e.DocumentChanges {
+		var op struct {
+			Kind string `json:"kind"`
+			TextDocumentEdit
+		}
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return nil, err
+		}
+		if op.Kind != "" {
+			return nil, fmt.Errorf("lsp: unsupported %s operation in edit", op.Kind)
+		}
+		uri := op.TextDocument.URI
+		edits[uri] = append(edits[uri], op.Edits...)
+	}
+	return edits, nil
+}
+
+// Hover is the result of textDocument/hover. Contents is one of the
+// several forms allowed by the specification; see Text.
+type Hover struct {
+	Contents json.RawMessage `json:"contents"`
+	Range    *Range          `json:"range,omitempty"`
+}
+
+// Text returns the contents of h as text.
+func (h *Hover) Text() string {
+	return strings.TrimSpace(markedText(h.Contents))
+}
+
+// markedText returns the text of a MarkupContent, a MarkedString or an
+// array of MarkedStrings.
+func markedText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var a []json.RawMessage
+	if json.Unmarshal(raw, &a) == nil {
+		var b strings.Builder
+		for i, m := range a {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(markedText(m))
+		}
+		return b.String()
+	}
+	var m struct {
+		Value string `json:"value"`
+	}
+	json.Unmarshal(raw, &m)
+	return m.Value
+}
+
+type WorkspaceFolder struct {
+	URI  string `json:"uri"`
+	Name string `json:"name"`
+}
+
+type ClientInfo struct {
+	Name string `json:"name"`
+}
+
+type InitializeParams struct {
+	ProcessID        int               `json:"processId"`
+	ClientInfo       *ClientInfo       `json:"clientInfo,omitempty"`
+	RootURI          string            `json:"rootUri"`
+	Capabilities     json.RawMessage   `json:"capabilities"`
+	WorkspaceFolders []WorkspaceFolder `json:"workspaceFolders,omitempty"`
+}
+
+// clientCapabilities are those of Edwood: positions in UTF-16, hover
+// text in plain text and workspace edits as text edits only.
+var clientCapabilities = json.RawMessage(`{
+	"general": {"positionEncodings": ["utf-16"]},
+	"workspace": {"workspaceEdit": {"documentChanges": true}},
+	"textDocument": {
+		"synchronization": {"didSave": true},
+		"hover": {"contentFormat": ["plaintext"]},
+		"definition": {},
+		"references": {},
+		"rename": {},
+		"formatting": {},
+		"publishDiagnostics": {}
+	}
+}`)
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+}
+
+// Text document synchronization kinds.
+const (
+	SyncNone        = 0
+	SyncFull        = 1
+	SyncIncremental = 2
+)
+
+type ServerCapabilities struct {
+	// TextDocumentSync is either a kind or a TextDocumentSyncOptions.
+	TextDocumentSync json.RawMessage `json:"textDocumentSync,omitempty"`
+}
+
+// SyncKind returns how the server wants documents to be synchronized.
+func (c *ServerCapabilities) SyncKind() int {
+	var kind int
+	if json.Unmarshal(c.TextDocumentSync, &kind) == nil {
+		return kind
+	}
+	var opts struct {
+		Change int `json:"change"`
+	}
+	json.Unmarshal(c.TextDocumentSync, &opts)
+	return opts.Change
+}
+
+// URI returns the file URI of the file name.
+func URI(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(name)}
+	return u.String()
+}
+
+// Filename returns the file name of a file URI.
+func Filename(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("lsp: not a file URI: %v", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// Text is the text of a document, indexed by rune.
+type Text interface {
+	Nc() int
+	ReadC(q int) rune
+}
+
+// Runes is a Text held in a slice.
+type Runes []rune
+
+func (r Runes) Nc() int          { return len(r) }
+func (r Runes) ReadC(q int) rune { return r[q] }
+
+// PositionOf returns the position of rune offset q in t.
+func PositionOf(t Text, q int) Position {
+	var p Position
+	for i := 0; i < q && i < t.Nc(); i++ {
+		switch c := t.ReadC(i); {
+		case c == '\n':
+			p.Line++
+			p.Character = 0
+		case c >= 0x10000:
+			p.Character += 2
+		default:
+			p.Character++
+		}
+	}
+	return p
+}
+
+// Offset returns the rune offset of p in t. A Character past the end of
+// its line gives the end of the line, and a Line past the end of t gives
+// the end of t.
+func Offset(t Text, p Position) int {
+	q, n := 0, t.Nc()
+	for line := 0; line < p.Line; q++ {
+		if q == n {
+			return n
+		}
+		if t.ReadC(q) == '\n' {
+			line++
+		}
+	}
+	for col := 0; col < p.Character && q < n; q++ {
+		c := t.ReadC(q)
+		if c == '\n' {
+			break
+		}
+		col += len(utf16.Encode([]rune{c}))
+	}
+	return q
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"image"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/edwoodtest"
+	"github.com/rjkroege/edwood/lsp"
+	"github.com/rjkroege/edwood/lsp/lsptest"
+)
+
+// fakeLSP makes the language server for Go files a fake one served over
+// a pipe, until the test ends.
+func fakeLSP(t *testing.T) *lsptest.Server {
+	t.Helper()
+	conf, err := lsp.ParseConfig(strings.NewReader("go *.go fake\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := lsptest.NewServer()
+	start, config := lspStart, lspstate.config
+	lspstate.config = conf
+	lspStart = func(_ *lsp.Server, _ string, handle lsp.Handler) (*lsp.Client, error) {
+		c, s := net.Pipe()
+		go srv.Serve(s, s)
+		return lsp.NewClient(c, handle), nil
+	}
+	t.Cleanup(func() {
+		lspstate.lk.Lock()
+		for _, s := range lspstate.servers {
+			if s.client != nil {
+				s.client.Close(t.Context())
+			}
+		}
+		lspstate.lk.Unlock()
+		waitFor(t, "servers to exit", func() bool {
+			lspstate.lk.Lock()
+			defer lspstate.lk.Unlock()
+			return len(lspstate.servers) == 0
+		})
+		lspStart, lspstate.config = start, config
+		warningsMu.Lock()
+		warnings = nil
+		warningsMu.Unlock()
+	})
+	return srv
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// waitWarning waits for a warning holding s and clears the warnings.
+func waitWarning(t *testing.T, s string) {
+	t.Helper()
+	var all string
+	waitFor(t, "warning "+s, func() bool {
+		warningsMu.Lock()
+		defer warningsMu.Unlock()
+		all = ""
+		for _, w := range warnings {
+			all += w.buf.String()
+		}
+		if !strings.Contains(all, s) {
+			return false
+		}
+		warnings = nil
+		return true
+	})
+}
+
+func TestLSP(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module a\n",
+		"a.go":   "package a\n\nfunc f() {}\n\n// TODO\nvar x = f\n",
+		"b.go":   "package a\n\nvar y = f\n",
+	}
+	for name, s := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := fakeLSP(t)
+	t.Cleanup(lspstate.calls.Wait) // before the server goes
+
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	global.configureGlobals(display)
+	global.row.Init(image.Rect(0, 0, 800, 600), display)
+	global.mouse = &draw.Mouse{}
+	col := global.row.Add(nil, -1)
+	open := func(name string) *Window {
+		w := col.Add(nil, nil, -1)
+		name = filepath.Join(dir, name)
+		w.SetName(name)
+		w.body.Load(0, name, true)
+		uri := lsp.URI(name)
+		waitFor(t, name+" to open", func() bool {
+			s, _ := srv.Text(uri)
+			return s == w.body.file.String()
+		})
+		return w
+	}
+	// synced waits for the server to have the text of w's body.
+	synced := func(t *testing.T, w *Window) {
+		t.Helper()
+		uri := lsp.URI(w.body.file.Name())
+		waitFor(t, "server to follow changes", func() bool {
+			s, _ := srv.Text(uri)
+			return s == w.body.file.String()
+		})
+	}
+	// answered waits for the answer to an LSP command, applied with the
+	// row locked, to make cond true.
+	answered := func(t *testing.T, cond func() bool) {
+		t.Helper()
+		waitFor(t, "the answer", func() bool {
+			global.row.lk.Lock()
+			defer global.row.lk.Unlock()
+			return cond()
+		})
+	}
+	selectWord := func(w *Window, before string) {
+		q := strings.Index(w.body.file.String(), before) + len(before)
+		w.body.SetSelect(q, q)
+	}
+
+	wa := open("a.go")
+	waitWarning(t, "a.go:5:4: TODO remains\n")
+
+	t.Run("Sync", func(t *testing.T) {
+		wa.body.Insert(0, []rune("// 𝄞x\n"), true)
+		synced(t, wa)
+		wa.body.Delete(3, 5, true)
+		synced(t, wa)
+		wa.body.Delete(0, 4, true)
+		synced(t, wa)
+		if got, want := wa.body.file.String(), files["a.go"]; got != want {
+			t.Fatalf("body is %q; want %q", got, want)
+		}
+	})
+
+	t.Run("Hover", func(t *testing.T) {
+		selectWord(wa, "var x = ")
+		lsphover(&wa.tag, nil, nil, false, false, "")
+		waitWarning(t, "identifier f\n")
+	})
+
+	wb := open("b.go")
+
+	t.Run("Refs", func(t *testing.T) {
+		selectWord(wb, "var y = ")
+		lsprefs(&wb.tag, nil, nil, false, false, "")
+		waitWarning(t, "a.go:3:6: func f() {}\na.go:6:9: var x = f\nb.go:3:9: var y = f\n")
+	})
+
+	t.Run("Def", func(t *testing.T) {
+		selectWord(wb, "var y = ")
+		wa.body.SetSelect(0, 0)
+		lspdef(&wb.tag, nil, nil, false, false, "")
+		q := strings.Index(files["a.go"], "f()")
+		answered(t, func() bool { return wa.body.q0 == q && wa.body.q1 == q+1 })
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		selectWord(wa, "func ")
+		lsprename(&wa.tag, nil, nil, false, false, "gee")
+		answered(t, func() bool { return wa.body.file.String() != files["a.go"] })
+		if got, want := wa.body.file.String(), "package a\n\nfunc gee() {}\n\n// TODO\nvar x = gee\n"; got != want {
+			t.Errorf("a.go is %q; want %q", got, want)
+		}
+		if got, want := wb.body.file.String(), "package a\n\nvar y = gee\n"; got != want {
+			t.Errorf("b.go is %q; want %q", got, want)
+		}
+		synced(t, wa)
+		synced(t, wb)
+
+		// One Undo reverts the whole rename.
+		undo(&wa.tag, nil, nil, true, false, "")
+		if got, want := wa.body.file.String(), files["a.go"]; got != want {
+			t.Errorf("after Undo a.go is %q; want %q", got, want)
+		}
+		if got, want := wb.body.file.String(), files["b.go"]; got != want {
+			t.Errorf("after Undo b.go is %q; want %q", got, want)
+		}
+		synced(t, wa)
+		synced(t, wb)
+	})
+
+	t.Run("Format", func(t *testing.T) {
+		wb.body.Insert(wb.body.Nc()-1, []rune(" \t "), true)
+		synced(t, wb)
+		lspformat(&wb.tag, nil, nil, false, false, "")
+		answered(t, func() bool { return wb.body.Nc() == len(files["b.go"]) })
+		if got, want := wb.body.file.String(), files["b.go"]; got != want {
+			t.Errorf("formatted b.go is %q; want %q", got, want)
+		}
+		synced(t, wb)
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		// An answer to a question about text since changed is dropped.
+		selectWord(wb, "var y = ")
+		global.row.lk.Lock()
+		lsprename(&wb.tag, nil, nil, false, false, "gee")
+		wb.body.Insert(0, []rune("\n"), true)
+		global.row.lk.Unlock()
+		waitWarning(t, "textDocument/rename: "+wb.body.file.Name()+" changed while waiting for the server\n")
+		lspstate.calls.Wait()
+		if got, want := wb.body.file.String(), "\n"+files["b.go"]; got != want {
+			t.Errorf("b.go is %q; want %q", got, want)
+		}
+		wb.body.Delete(0, 1, true)
+		synced(t, wb)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		uri := lsp.URI(wb.body.file.Name())
+		col.Close(wb, true)
+		waitFor(t, "b.go to close", func() bool {
+			_, ok := srv.Text(uri)
+			return !ok
+		})
+	})
+}
+
+func TestLSPDocPosition(t *testing.T) {
+	text := []rune("ab\ncd𝄞e\n\nfgh\n")
+	d := &lspDoc{text: text}
+	// Moving forward and back over lines, and to the ends.
+	for _, q := range []int{5, 0, 8, 3, 13, 12, 2, 9, 14, 7, 10} {
+		if got, want := d.position(q), lsp.PositionOf(lsp.Runes(text), q); got != want {
+			t.Errorf("position of %d is %v; want %v", q, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want any
+	}{
+		{"nil", "c0", nil},
+		{"true", "c3", true},
+		{"fixint", "7f", int64(127)},
+		{"negfixint", "e0", int64(-32)},
+		{"uint16", "cd0100", int64(256)},
+		{"uint64", "cfffffffffffffffff", uint64(1<<64 - 1)},
+		{"int8", "d080", int64(-128)},
+		{"int32", "d2fffffffe", int64(-2)},
+		{"float32", "ca3fc00000", 1.5},
+		{"float64", "cb3ff8000000000000", 1.5},
+		{"fixstr", "a3616263", "abc"},
+		{"str8", "d903616263", "abc"},
+		{"bin8", "c4020102", []byte{1, 2}},
+		{"request", "94 00 01 ab77696e646f772e6c697374 90",
+			[]any{int64(0), int64(1), "window.list", []any{}}},
+		{"map", "82 a1 61 01 01 a1 62", map[string]any{"a": int64(1), "1": "b"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := hex.DecodeString(strings.ReplaceAll(tc.in, " ", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := NewDecoder(bytes.NewReader(b)).Decode()
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("value mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, in := range []string{
+		"c1",         // never used
+		"d4 01 02",   // fixext
+		"a3 6162",    // short string
+		"92 01",      // short array
+		"dbffffffff", // huge string
+	} {
+		b, _ := hex.DecodeString(strings.ReplaceAll(in, " ", ""))
+		if _, err := NewDecoder(bytes.NewReader(b)).Decode(); err == nil || err == io.EOF {
+			t.Errorf("Decode(%s) returned %v; want error", in, err)
+		}
+	}
+	if _, err := NewDecoder(bytes.NewReader(nil)).Decode(); err != io.EOF {
+		t.Errorf("Decode of empty input returned %v; want io.EOF", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	for _, v := range []any{
+		nil,
+		false,
+		int64(-33),
+		int64(200),
+		int64(-40000),
+		int64(1 << 40),
+		uint64(1<<64 - 1),
+		2.25,
+		"",
+		long,
+		[]byte(long),
+		[]any{int64(1), "two", []any{3.5}},
+		make([]any, 20),
+		map[string]any{"q0": int64(10), "q1": int64(20), "text": "hello"},
+	} {
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal(%v) failed: %v", v, err)
+		}
+		got, err := NewDecoder(bytes.NewReader(b)).Decode()
+		if err != nil {
+			t.Fatalf("Decode(Marshal(%v)) failed: %v", v, err)
+		}
+		if diff := cmp.Diff(v, got); diff != "" {
+			t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestMarshalJSONNumber(t *testing.T) {
+	for _, tc := range []struct {
+		n    json.Number
+		want string
+	}{
+		{"5", "05"},
+		{"-1", "ff"},
+		{"0.5", "cb3fe0000000000000"},
+	} {
+		b, err := Marshal(tc.n)
+		if err != nil {
+			t.Fatalf("Marshal(%v) failed: %v", tc.n, err)
+		}
+		if got := hex.EncodeToString(b); got != tc.want {
+			t.Errorf("Marshal(%v) = %v; want %v", tc.n, got, tc.want)
+		}
+	}
+	if _, err := Marshal(struct{}{}); err == nil {
+		t.Errorf("Marshal of a struct succeeded; want error")
+	}
+}
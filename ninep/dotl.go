@@ -0,0 +1,322 @@
+package ninep
+
+// Encoding and decoding of 9P2000.L, the dialect of 9P spoken by the
+// Linux kernel's v9fs client. Messages shared with 9P2000 (Tversion,
+// Twalk, Tread, Twrite, Tclunk, Tflush, Tremove) have the same wire format
+// and are handled by package plan9. The messages added by 9P2000.L are
+// carried in a plan9.Fcall as follows:
+//
+//   - Tattach and Tauth set Uid to n_uname.
+//   - Tlopen translates the Linux open flags to the 9P2000 Mode.
+//   - Treaddir sets Offset and Count.
+//   - Txattrwalk sets Newfid and Name.
+//   - The other requests set Fid from the first field and leave the rest
+//     of the message, undecoded, in Data.
+//   - Rlerror sets Errno, and Rlopen sets Qid and Iounit.
+//   - The other responses take their body, already encoded with the
+//     helpers below, from Data.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"9fans.net/go/plan9"
+)
+
+// Message types added by 9P2000.L.
+const (
+	Rlerror      = 7
+	Tstatfs      = 8
+	Rstatfs      = 9
+	Tlopen       = 12
+	Rlopen       = 13
+	Tlcreate     = 14
+	Rlcreate     = 15
+	Tsymlink     = 16
+	Rsymlink     = 17
+	Tmknod       = 18
+	Rmknod       = 19
+	Trename      = 20
+	Rrename      = 21
+	Treadlink    = 22
+	Rreadlink    = 23
+	Tgetattr     = 24
+	Rgetattr     = 25
+	Tsetattr     = 26
+	Rsetattr     = 27
+	Txattrwalk   = 30
+	Rxattrwalk   = 31
+	Txattrcreate = 32
+	Rxattrcreate = 33
+	Treaddir     = 40
+	Rreaddir     = 41
+	Tfsync       = 50
+	Rfsync       = 51
+	Tlock        = 52
+	Rlock        = 53
+	Tgetlock     = 54
+	Rgetlock     = 55
+	Tlink        = 70
+	Rlink        = 71
+	Tmkdir       = 72
+	Rmkdir       = 73
+	Trenameat    = 74
+	Rrenameat    = 75
+	Tunlinkat    = 76
+	Runlinkat    = 77
+)
+
+// VersionL is the version string of 9P2000.L.
+const VersionL = "9P2000.L"
+
+// Linux open flags used by Tlopen.
+const (
+	lOACCMODE = 0x3
+	lOTRUNC   = 0x200
+)
+
+// Linux errno values for Rlerror.
+const (
+	EPERM      = 1
+	ENOENT     = 2
+	EIO        = 5
+	EBADF      = 9
+	EACCES     = 13
+	ENOTDIR    = 20
+	EINVAL     = 22
+	EOPNOTSUPP = 95
+)
+
+// ReadFcallL reads a 9P2000.L message from r.
+func ReadFcallL(r io.Reader) (*plan9.Fcall, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[:])
+	if n < 7 {
+		return nil, plan9.ProtocolError("invalid length")
+	}
+	b := make([]byte, n)
+	copy(b, hdr[:])
+	if _, err := io.ReadFull(r, b[4:]); err != nil {
+		return nil, err
+	}
+	return UnmarshalFcallL(b)
+}
+
+// UnmarshalFcallL decodes the 9P2000.L message in b.
+func UnmarshalFcallL(b []byte) (*plan9.Fcall, error) {
+	if len(b) < 7 || binary.LittleEndian.Uint32(b) != uint32(len(b)) {
+		return nil, plan9.ProtocolError("malformed Fcall")
+	}
+	d := &decoder{b: b[7:]}
+	f := &plan9.Fcall{
+		Type: b[4],
+		Tag:  binary.LittleEndian.Uint16(b[5:]),
+	}
+	switch f.Type {
+	case plan9.Tauth:
+		f.Afid = d.bit32()
+		f.Uname = d.string()
+		f.Aname = d.string()
+		f.Uid = d.bit32()
+	case plan9.Tattach:
+		f.Fid = d.bit32()
+		f.Afid = d.bit32()
+		f.Uname = d.string()
+		f.Aname = d.string()
+		f.Uid = d.bit32()
+	case Tlopen:
+		f.Fid = d.bit32()
+		flags := d.bit32()
+		f.Mode = uint8(flags & lOACCMODE)
+		if flags&lOTRUNC != 0 {
+			f.Mode |= plan9.OTRUNC
+		}
+	case Treaddir:
+		f.Fid = d.bit32()
+		f.Offset = d.bit64()
+		f.Count = d.bit32()
+	case Txattrwalk:
+		f.Fid = d.bit32()
+		f.Newfid = d.bit32()
+		f.Name = d.string()
+	case Tstatfs, Tlcreate, Tsymlink, Tmknod, Trename, Treadlink, Tgetattr,
+		Tsetattr, Txattrcreate, Tfsync, Tlock, Tgetlock, Tlink, Tmkdir,
+		Trenameat, Tunlinkat:
+		f.Fid = d.bit32()
+		f.Data = d.b
+		d.b = nil
+	default:
+		return plan9.UnmarshalFcall(b)
+	}
+	if d.err != nil || len(d.b) != 0 {
+		return nil, plan9.ProtocolError("malformed Fcall")
+	}
+	return f, nil
+}
+
+// MarshalFcallL encodes f as a 9P2000.L message.
+func MarshalFcallL(f *plan9.Fcall) ([]byte, error) {
+	b := make([]byte, 7, 7+len(f.Data)+32)
+	b[4] = f.Type
+	binary.LittleEndian.PutUint16(b[5:], f.Tag)
+	switch f.Type {
+	case Rlerror:
+		b = binary.LittleEndian.AppendUint32(b, f.Errno)
+	case Rlopen, Rlcreate:
+		b = appendQid(b, f.Qid)
+		b = binary.LittleEndian.AppendUint32(b, f.Iounit)
+	case Rstatfs, Rgetattr, Rsetattr, Rxattrwalk, Rxattrcreate, Rreaddir,
+		Rfsync, Rlock, Rgetlock:
+		b = append(b, f.Data...)
+	default:
+		return f.Bytes()
+	}
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	return b, nil
+}
+
+// WriteFcallL writes f to w as a 9P2000.L message.
+func WriteFcallL(w io.Writer, f *plan9.Fcall) error {
+	b, err := MarshalFcallL(f)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Attr holds the attributes returned by Rgetattr.
+type Attr struct {
+	Qid   plan9.Qid
+	Mode  uint32 // Linux file type and permission bits
+	UID   uint32
+	GID   uint32
+	Nlink uint64
+	Size  uint64
+	Atime int64 // seconds
+	Mtime int64 // seconds
+	Ctime int64 // seconds
+}
+
+// Linux file types for Attr.Mode.
+const (
+	SIFDIR = 0040000
+	SIFREG = 0100000
+)
+
+// getattrBasic is the mask of attributes filled in by Attr.
+const getattrBasic = 0x7ff
+
+// Bytes returns the body of an Rgetattr holding a.
+func (a *Attr) Bytes() []byte {
+	b := binary.LittleEndian.AppendUint64(nil, getattrBasic)
+	b = appendQid(b, a.Qid)
+	b = binary.LittleEndian.AppendUint32(b, a.Mode)
+	b = binary.LittleEndian.AppendUint32(b, a.UID)
+	b = binary.LittleEndian.AppendUint32(b, a.GID)
+	b = binary.LittleEndian.AppendUint64(b, a.Nlink)
+	b = binary.LittleEndian.AppendUint64(b, 0) // rdev
+	b = binary.LittleEndian.AppendUint64(b, a.Size)
+	b = binary.LittleEndian.AppendUint64(b, 8192)             // blksize
+	b = binary.LittleEndian.AppendUint64(b, (a.Size+511)/512) // blocks
+	for _, t := range []int64{a.Atime, a.Mtime, a.Ctime, 0} {
+		b = binary.LittleEndian.AppendUint64(b, uint64(t))
+		b = binary.LittleEndian.AppendUint64(b, 0) // nanoseconds
+	}
+	b = binary.LittleEndian.AppendUint64(b, 0) // gen
+	b = binary.LittleEndian.AppendUint64(b, 0) // data_version
+	return b
+}
+
+// StatfsBytes returns the body of an Rstatfs describing a file system of
+// the given type that has no blocks to spare.
+func StatfsBytes(fstype uint32) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, fstype)
+	b = binary.LittleEndian.AppendUint32(b, 8192) // bsize
+	for i := 0; i < 6; i++ {
+		b = binary.LittleEndian.AppendUint64(b, 0) // blocks, bfree, bavail, files, ffree, fsid
+	}
+	return binary.LittleEndian.AppendUint32(b, 255) // namelen
+}
+
+// Linux directory entry types for Readdir.
+const (
+	DTDIR = 4
+	DTREG = 8
+)
+
+// Readdir sets ofcall.Data to the body of an Rreaddir holding at most
+// ifcall.Count bytes of directory entries, starting with the one at index
+// ifcall.Offset. The function gen is called to obtain the n-th entry and
+// should return nil at the end of the directory. Each entry's offset is
+// the index of the entry after it.
+func Readdir(ofcall, ifcall *plan9.Fcall, gen func(i int) *plan9.Dir) {
+	var data []byte
+	for i := int(ifcall.Offset); ; i++ {
+		d := gen(i)
+		if d == nil {
+			break
+		}
+		typ := uint8(DTREG)
+		if d.Qid.Type&plan9.QTDIR != 0 {
+			typ = DTDIR
+		}
+		e := appendQid(nil, d.Qid)
+		e = binary.LittleEndian.AppendUint64(e, uint64(i+1))
+		e = append(e, typ)
+		e = binary.LittleEndian.AppendUint16(e, uint16(len(d.Name)))
+		e = append(e, d.Name...)
+		if len(data)+len(e) > int(ifcall.Count) {
+			break
+		}
+		data = append(data, e...)
+	}
+	ofcall.Data = binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	ofcall.Data = append(ofcall.Data, data...)
+}
+
+// UnlockedBytes returns the body of an Rgetlock reporting that the lock
+// described by the Tgetlock body req is not held.
+func UnlockedBytes(req []byte) ([]byte, error) {
+	const unlck = 2
+	if len(req) < 1+8+8+4+2 {
+		return nil, fmt.Errorf("short Tgetlock")
+	}
+	b := append([]byte{unlck}, req[1:]...)
+	return b, nil
+}
+
+func appendQid(b []byte, q plan9.Qid) []byte {
+	b = append(b, q.Type)
+	b = binary.LittleEndian.AppendUint32(b, q.Vers)
+	return binary.LittleEndian.AppendUint64(b, q.Path)
+}
+
+// decoder reads little-endian fields from b, recording in err any
+// attempt to read past its end.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = plan9.ProtocolError("malformed Fcall")
+		return make([]byte, n)
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *decoder) bit32() uint32 { return binary.LittleEndian.Uint32(d.take(4)) }
+func (d *decoder) bit64() uint64 { return binary.LittleEndian.Uint64(d.take(8)) }
+
+func (d *decoder) string() string {
+	n := binary.LittleEndian.Uint16(d.take(2))
+	return string(d.take(int(n)))
+}
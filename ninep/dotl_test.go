@@ -0,0 +1,173 @@
+package ninep
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/google/go-cmp/cmp"
+)
+
+// unhex decodes a hex string that may contain spaces.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestUnmarshalFcallL(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  string
+		want *plan9.Fcall
+	}{
+		{
+			"Tattach",
+			"1d000000 68 0100 00000000 ffffffff 0600 676c656e6461 0000 e8030000",
+			&plan9.Fcall{Type: plan9.Tattach, Tag: 1, Fid: 0, Afid: plan9.NOFID, Uname: "glenda", Uid: 1000},
+		},
+		{
+			"Tlopen/RDWR|TRUNC",
+			"0f000000 0c 0200 05000000 02020000",
+			&plan9.Fcall{Type: Tlopen, Tag: 2, Fid: 5, Mode: plan9.ORDWR | plan9.OTRUNC},
+		},
+		{
+			"Tlopen/DIRECTORY",
+			"0f000000 0c 0200 05000000 00800100",
+			&plan9.Fcall{Type: Tlopen, Tag: 2, Fid: 5, Mode: plan9.OREAD},
+		},
+		{
+			"Treaddir",
+			"17000000 28 0300 01000000 0200000000000000 00100000",
+			&plan9.Fcall{Type: Treaddir, Tag: 3, Fid: 1, Offset: 2, Count: 4096},
+		},
+		{
+			"Txattrwalk",
+			"18000000 1e 0400 01000000 02000000 0700 757365722e6162",
+			&plan9.Fcall{Type: Txattrwalk, Tag: 4, Fid: 1, Newfid: 2, Name: "user.ab"},
+		},
+		{
+			"Tgetattr",
+			"13000000 18 0500 01000000 ff3f000000000000",
+			&plan9.Fcall{Type: Tgetattr, Tag: 5, Fid: 1, Data: []byte{0xff, 0x3f, 0, 0, 0, 0, 0, 0}},
+		},
+		{
+			"Tclunk",
+			"0b000000 78 0600 01000000",
+			&plan9.Fcall{Type: plan9.Tclunk, Tag: 6, Fid: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := UnmarshalFcallL(unhex(t, tc.msg))
+			if err != nil {
+				t.Fatalf("UnmarshalFcallL failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Fcall mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnmarshalFcallLMalformed(t *testing.T) {
+	for _, msg := range []string{
+		"0e000000 0c 0200 05000000 0202",             // Tlopen cut short
+		"10000000 0c 0200 05000000 02020000 00",      // trailing byte
+		"1d000000 68 0100 00000000 ffffffff 0600 67", // length mismatch
+	} {
+		if _, err := UnmarshalFcallL(unhex(t, msg)); err == nil {
+			t.Errorf("UnmarshalFcallL(%q) succeeded; want error", msg)
+		}
+	}
+}
+
+func TestMarshalFcallL(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		f    *plan9.Fcall
+		want string
+	}{
+		{
+			"Rlerror",
+			&plan9.Fcall{Type: Rlerror, Tag: 1, Errno: ENOENT},
+			"0b000000 07 0100 02000000",
+		},
+		{
+			"Rlopen",
+			&plan9.Fcall{Type: Rlopen, Tag: 2, Qid: plan9.Qid{Type: plan9.QTAPPEND, Vers: 1, Path: 0x0305}, Iounit: 8192},
+			"18000000 0d 0200 40 01000000 0503000000000000 00200000",
+		},
+		{
+			"Rsetattr",
+			&plan9.Fcall{Type: Rsetattr, Tag: 3},
+			"07000000 1b 0300",
+		},
+		{
+			"Rclunk",
+			&plan9.Fcall{Type: plan9.Rclunk, Tag: 4},
+			"07000000 79 0400",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteFcallL(&buf, tc.f); err != nil {
+				t.Fatalf("WriteFcallL failed: %v", err)
+			}
+			if got, want := buf.Bytes(), unhex(t, tc.want); !bytes.Equal(got, want) {
+				t.Errorf("got % x; want % x", got, want)
+			}
+		})
+	}
+}
+
+func TestReaddir(t *testing.T) {
+	dirs := []*plan9.Dir{
+		{Name: "a", Qid: plan9.Qid{Type: plan9.QTDIR, Path: 1}},
+		{Name: "bc", Qid: plan9.Qid{Path: 2}},
+	}
+	gen := func(i int) *plan9.Dir {
+		if i < len(dirs) {
+			return dirs[i]
+		}
+		return nil
+	}
+	const (
+		a  = "80 00000000 0100000000000000 0100000000000000 04 0100 61"
+		bc = "00 00000000 0200000000000000 0200000000000000 08 0200 6263"
+	)
+	for _, tc := range []struct {
+		name   string
+		offset uint64
+		count  uint32
+		want   string
+	}{
+		{"All", 0, 100, "33000000 " + a + " " + bc},
+		{"FromOffset", 1, 100, "1a000000 " + bc},
+		{"Short", 0, 30, "19000000 " + a},
+		{"End", 2, 100, "00000000"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var ofcall plan9.Fcall
+			Readdir(&ofcall, &plan9.Fcall{Offset: tc.offset, Count: tc.count}, gen)
+			if got, want := ofcall.Data, unhex(t, tc.want); !bytes.Equal(got, want) {
+				t.Errorf("got % x; want % x", got, want)
+			}
+		})
+	}
+}
+
+func TestAttrBytes(t *testing.T) {
+	a := &Attr{Qid: plan9.Qid{Type: plan9.QTDIR}, Mode: SIFDIR | 0500, Nlink: 1}
+	b := a.Bytes()
+	if got, want := len(b), 153; got != want {
+		t.Fatalf("Rgetattr body is %d bytes; want %d", got, want)
+	}
+	if got, want := b[21:25], unhex(t, "40410000"); !bytes.Equal(got, want) {
+		t.Errorf("mode is % x; want % x", got, want)
+	}
+}
@@ -1,4 +1,5 @@
-// Package ninep contains helper routines for implementing a 9P2000 protocol server.
+// Package ninep contains helper routines for implementing a 9P2000 or
+// 9P2000.L protocol server.
 package ninep
 
 import (
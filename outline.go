@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// outlineDelay is how long an outline waits after an edit to its file
+// before it's refreshed.
+var outlineDelay = 500 * time.Millisecond
+
+// outline is a +Outline window listing the declarations of the Go file
+// in the body of another window, src. It's refreshed as src changes.
+type outline struct {
+	src     *Window
+	w       *Window
+	pending atomic.Bool // a refresh is scheduled
+}
+
+// outlinex implements the Outline command: it opens the +Outline window
+// of the Go file in et's window, or refreshes it if et is in the
+// +Outline window.
+func outlinex(et, _, _ *Text, _, _ bool, _ string) {
+	if et == nil || et.w == nil {
+		return
+	}
+	if o := outlineof(et.w); o != nil {
+		if o.src.col != nil {
+			o.update()
+		}
+		return
+	}
+	src := et.w
+	name := src.body.file.Name()
+	if !strings.HasSuffix(name, ".go") {
+		warning(nil, "Outline: %s is not a Go file\n", name)
+		return
+	}
+
+	oname := filepath.Join(filepath.Dir(name), "+Outline")
+	w := lookfile(oname)
+	if w == nil {
+		w = makenewwindow(&src.body)
+		w.filemenu = false
+		w.SetName(oname)
+		xfidlog(w, "new")
+	}
+	// A +Outline window follows one file at a time.
+	o := outlineof(w)
+	if o == nil {
+		o = &outline{w: w}
+	} else if o.src != src {
+		o.src.outline = nil
+	}
+	o.src = src
+	src.outline = o
+	o.update()
+}
+
+// outlineof returns the outline shown in w, if it's a +Outline window.
+func outlineof(w *Window) *outline {
+	for _, c := range global.row.col {
+		for _, sw := range c.w {
+			if sw.outline != nil && sw.outline.w == w {
+				return sw.outline
+			}
+		}
+	}
+	return nil
+}
+
+// changed schedules a refresh of the outline after its file changed.
+// Nothing may be taking refreshes (as in a headless run), so the timer
+// never waits to hand one over: if it can't, the refresh is dropped and
+// the next change schedules another.
+func (o *outline) changed() {
+	if o.pending.CompareAndSwap(false, true) {
+		time.AfterFunc(outlineDelay, func() {
+			select {
+			case global.coutline <- o:
+			default:
+				o.pending.Store(false)
+			}
+		})
+	}
+}
+
+// refresh brings the outline up to date. It's called with the row lock
+// held once the delay after a change has passed.
+func (o *outline) refresh() {
+	o.pending.Store(false)
+	if o.src.col == nil || o.w.col == nil || o.src.outline != o {
+		// Either window has gone, or the +Outline follows another file.
+		if o.src.outline == o {
+			o.src.outline = nil
+		}
+		return
+	}
+	o.src.Lock('O')
+	text := o.src.body.file.String()
+	o.src.Unlock()
+	o.w.Lock('O')
+	o.show(text)
+	o.w.Unlock()
+}
+
+// update refreshes the outline; the caller holds the windows' locks.
+func (o *outline) update() {
+	o.show(o.src.body.file.String())
+}
+
+// show replaces the contents of the +Outline window with the outline of
+// the source text, keeping its place if it can.
+func (o *outline) show(text string) {
+	name := o.src.body.file.Name()
+	s := []rune(goOutline(filepath.Base(name), []byte(text)))
+	t := &o.w.body
+	if t.file.String() == string(s) {
+		return
+	}
+	org, q0 := t.org, t.q0
+	// Refreshes aren't edits: a zero sequence flattens them out of the
+	// undo history.
+	t.file.Mark(0)
+	t.Delete(0, t.Nc(), true)
+	t.Insert(0, s, true)
+	t.file.TreatAsClean()
+	q0 = min(q0, t.Nc())
+	t.SetSelect(q0, q0)
+	if t.fr != nil {
+		t.SetOrigin(min(org, t.Nc()), true)
+	}
+}
+
+// goOutline returns the outline of the Go source src of the file name: a
+// line for each type, function and method giving its name and its address
+// as name:#offset, indented by nesting. Methods are listed under the types
+// of their receivers when those are declared in src, and the types
+// declared in functions under the functions. Syntax errors are tolerated,
+// outlining what can be parsed.
+func goOutline(name string, src []byte) string {
+	type entry struct {
+		desc     string
+		off      int // in bytes
+		children []*entry
+	}
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, name, src, parser.SkipObjectResolution)
+	if f == nil {
+		return ""
+	}
+	offset := func(n ast.Node) int { return fset.Position(n.Pos()).Offset }
+
+	typeEntry := func(ts *ast.TypeSpec) *entry {
+		desc := "type " + ts.Name.Name
+		switch ts.Type.(type) {
+		case *ast.StructType:
+			desc += " struct"
+		case *ast.InterfaceType:
+			desc += " interface"
+		}
+		return &entry{desc: desc, off: offset(ts.Name)}
+	}
+
+	var top []*entry
+	typ := make(map[string]*entry)
+	var methods []*ast.FuncDecl
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if d.Tok != token.TYPE {
+				continue
+			}
+			for _, s := range d.Specs {
+				e := typeEntry(s.(*ast.TypeSpec))
+				typ[s.(*ast.TypeSpec).Name.Name] = e
+				top = append(top, e)
+			}
+		case *ast.FuncDecl:
+			e := &entry{desc: "func " + d.Name.Name, off: offset(d.Name)}
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				e.desc = fmt.Sprintf("func (%s) %s", types.ExprString(d.Recv.List[0].Type), d.Name.Name)
+				methods = append(methods, d)
+			}
+			if d.Body != nil {
+				ast.Inspect(d.Body, func(n ast.Node) bool {
+					if ts, ok := n.(*ast.TypeSpec); ok {
+						e.children = append(e.children, typeEntry(ts))
+					}
+					return true
+				})
+			}
+			top = append(top, e)
+		}
+	}
+
+	// Move the methods under their types.
+	for _, m := range methods {
+		r := m.Recv.List[0].Type
+		for {
+			switch x := r.(type) {
+			case *ast.StarExpr:
+				r = x.X
+				continue
+			case *ast.IndexExpr:
+				r = x.X
+				continue
+			case *ast.IndexListExpr:
+				r = x.X
+				continue
+			case *ast.ParenExpr:
+				r = x.X
+				continue
+			}
+			break
+		}
+		id, ok := r.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		te := typ[id.Name]
+		if te == nil {
+			continue
+		}
+		off := offset(m.Name)
+		for i, e := range top {
+			if e.off == off {
+				te.children = append(te.children, e)
+				top = append(top[:i], top[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// Addresses are in runes.
+	var offs []int
+	var collect func(es []*entry)
+	collect = func(es []*entry) {
+		for _, e := range es {
+			offs = append(offs, e.off)
+			collect(e.children)
+		}
+	}
+	collect(top)
+	sort.Ints(offs)
+	runeoff := make(map[int]int, len(offs))
+	b, r := 0, 0
+	for _, off := range offs {
+		r += utf8.RuneCount(src[b:off])
+		b = off
+		runeoff[off] = r
+	}
+
+	var sb strings.Builder
+	var write func(es []*entry, depth int)
+	write = func(es []*entry, depth int) {
+		for _, e := range es {
+			fmt.Fprintf(&sb, "%s%s\t%s:#%d\n", strings.Repeat("\t", depth), e.desc, QuoteFilename(name), runeoff[e.off])
+			write(e.children, depth+1)
+		}
+	}
+	write(top, 0)
+	return sb.String()
+}
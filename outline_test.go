@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGoOutline(t *testing.T) {
+	for _, tc := range []struct {
+		name, src, want string
+	}{
+		{
+			"decls",
+			`package p
+
+type T struct{}
+
+func (t *T) M() {}
+
+func F() {
+	type local int
+}
+
+type (
+	I interface{}
+	G[K comparable] map[K]int
+)
+
+func (g G[K]) N() {}
+
+func (Other) O() {}
+`,
+			"type T struct\tx.go:#16\n" +
+				"\tfunc (*T) M\tx.go:#40\n" +
+				"func F\tx.go:#53\n" +
+				"\ttype local\tx.go:#65\n" +
+				"type I interface\tx.go:#86\n" +
+				"type G\tx.go:#101\n" +
+				"\tfunc (G[K]) N\tx.go:#144\n" +
+				"func (Other) O\tx.go:#165\n",
+		},
+		{
+			// Offsets count runes.
+			"runes",
+			"package p\n\n// ☺☺\nfunc F() {}\n",
+			"func F\tx.go:#22\n",
+		},
+		{
+			"errors",
+			"package p\n\nfunc F() {}\n\nvar = 3\n\nfunc G() {}\n\ntype T int\n",
+			"func F\tx.go:#16\nfunc G\tx.go:#38\ntype T\tx.go:#51\n",
+		},
+		{"not go", "this isn't Go", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := goOutline("x.go", []byte(tc.src))
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("outline mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOutline(t *testing.T) {
+	FlexiblyMakeWindowScaffold(t,
+		ScWin("/a/x.go"),
+		ScBody("/a/x.go", "package p\n\nfunc F() {}\n"),
+		ScWin("/a/notgo.c"),
+	)
+	src := global.row.col[0].w[0]
+	global.activecol, global.seltext = nil, nil // left by other tests
+	defer func(d time.Duration) { outlineDelay = d }(outlineDelay)
+	outlineDelay = time.Millisecond
+	warnings = nil
+
+	outlinex(&global.row.col[0].w[1].tag, nil, nil, false, false, "")
+	if len(warnings) != 1 || lookfile("/a/+Outline") != nil {
+		t.Errorf("Outline of a C file didn't just warn")
+	}
+	warnings = nil
+
+	outlinex(&src.tag, nil, nil, false, false, "")
+	w := lookfile("/a/+Outline")
+	if w == nil {
+		t.Fatalf("Outline didn't open /a/+Outline")
+	}
+	if got, want := w.body.file.String(), "func F\tx.go:#16\n"; got != want {
+		t.Errorf("got outline %q; want %q", got, want)
+	}
+	if w.body.file.Dirty() {
+		t.Errorf("+Outline is dirty")
+	}
+	// Button 3 on an entry finds its file and address.
+	e, ok := expand(&w.body, 10, 10)
+	if !ok || e.name != "/a/x.go" || e.a1-e.a0 != 3 {
+		t.Errorf("expanding the entry gave %+v, %v", e, ok)
+	}
+
+	// Edits are followed.
+	src.body.Insert(src.body.Nc(), []rune("\nfunc G() {}\n"), true)
+	select {
+	case o := <-global.coutline:
+		o.refresh()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no refresh after an edit")
+	}
+	if got, want := w.body.file.String(), "func F\tx.go:#16\nfunc G\tx.go:#29\n"; got != want {
+		t.Errorf("got outline %q after edit; want %q", got, want)
+	}
+	if w.body.file.HasUndoableChanges() {
+		t.Errorf("refreshing +Outline left undoable changes")
+	}
+
+	// Outline in the +Outline window refreshes it too.
+	src.body.Delete(0, src.body.Nc(), true)
+	src.body.Insert(0, []rune("package p\ntype T int\n"), true)
+	outlinex(&w.tag, nil, nil, false, false, "")
+	if got, want := w.body.file.String(), "type T\tx.go:#15\n"; got != want {
+		t.Errorf("got outline %q after Outline in +Outline; want %q", got, want)
+	}
+	(<-global.coutline).refresh() // the refresh scheduled by the edit
+
+	// With nothing taking refreshes, the timer drops the refresh rather
+	// than waiting and the next change schedules another.
+	defer func(c chan *outline) { global.coutline = c }(global.coutline)
+	global.coutline = make(chan *outline)
+	o := src.outline
+	o.changed()
+	for deadline := time.Now().Add(5 * time.Second); o.pending.Load(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("refresh still pending with nothing taking it")
+		}
+	}
+}
@@ -0,0 +1,656 @@
+// Package plumber implements an in-process subset of the Plan 9 plumber.
+//
+// It reads rules written in the language of plumb(7) and matches them
+// against plumb messages. Edwood uses it to interpret button-3 clicks
+// when no external plumber is running.
+//
+// Rule sets are separated by blank lines. Supported objects are src,
+// dst, wdir, type, data, attr and arg; supported verbs are is, isdir,
+// isfile, matches, set, add and delete. A rule set ends with plumb to,
+// plumb start or plumb client. Variable assignments and include lines
+// are also understood.
+package plumber
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"9fans.net/go/plumb"
+)
+
+// Rules holds a parsed plumbing rule file.
+type Rules struct {
+	sets []*ruleset
+	vars map[string]string
+}
+
+type ruleset struct {
+	pats []*rule
+	acts []*rule
+}
+
+type rule struct {
+	obj  string
+	verb string
+	arg  []word
+	line int
+}
+
+// A word is an argument of a rule: a run of literal and variable
+// segments that is not split by unquoted blanks.
+type word []segment
+
+type segment struct {
+	text  string
+	isvar bool // text names a variable expanded when matching
+}
+
+// Action is the result of a successful match.
+type Action struct {
+	// Port is the destination named by plumb to.
+	Port string
+
+	// Start and Client hold the argument vectors of plumb start and
+	// plumb client, if present.
+	Start  []string
+	Client []string
+
+	// Msg is the message as rewritten by the matching rule set.
+	Msg *plumb.Message
+}
+
+// special names variables that take their values from the message being
+// matched rather than from assignments in the rule file.
+var special = map[string]bool{
+	"src": true, "dst": true, "wdir": true, "type": true, "data": true,
+	"attr": true, "file": true, "dir": true, "plumb": true,
+}
+
+var objects = map[string]bool{
+	"src": true, "dst": true, "wdir": true, "type": true, "data": true,
+	"attr": true, "arg": true, "plumb": true,
+}
+
+// Load parses the rule file filename.
+func Load(filename string) (*Rules, error) {
+	rs := &Rules{vars: map[string]string{}}
+	if err := rs.load(filename, 0); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Parse parses rules from r. Relative include lines are resolved against
+// the current directory.
+func Parse(r io.Reader) (*Rules, error) {
+	rs := &Rules{vars: map[string]string{}}
+	if err := rs.parse(r, "<input>", ".", 0); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func (rs *Rules) load(filename string, depth int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return rs.parse(f, filename, filepath.Dir(filename), depth)
+}
+
+func (rs *Rules) parse(r io.Reader, name, dir string, depth int) error {
+	if depth > 10 {
+		return fmt.Errorf("%s: include nested too deeply", name)
+	}
+	var cur *ruleset
+	end := func() error {
+		if cur == nil {
+			return nil
+		}
+		if len(cur.acts) == 0 {
+			return fmt.Errorf("%s:%d: rule set has no plumb action", name, cur.pats[len(cur.pats)-1].line)
+		}
+		rs.sets = append(rs.sets, cur)
+		cur = nil
+		return nil
+	}
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+			if err := end(); err != nil {
+				return err
+			}
+			continue
+		case line[0] == '#':
+			continue
+		case strings.HasPrefix(line, "include") && len(line) > 7 && (line[7] == ' ' || line[7] == '\t'):
+			if err := end(); err != nil {
+				return err
+			}
+			words, err := rs.split(line[8:])
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", name, n, err)
+			}
+			if err := rs.include(join(words, nil), dir, depth); err != nil {
+				return fmt.Errorf("%s:%d: %v", name, n, err)
+			}
+			continue
+		}
+		if v, val, ok := assignment(line); ok {
+			words, err := rs.split(val)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", name, n, err)
+			}
+			rs.vars[v] = join(words, nil)
+			continue
+		}
+
+		obj, rest := field(line)
+		verb, rest := field(rest)
+		if !objects[obj] {
+			return fmt.Errorf("%s:%d: unknown object %q", name, n, obj)
+		}
+		arg, err := rs.split(rest)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		ru := &rule{obj: obj, verb: verb, arg: arg, line: n}
+		if err := ru.check(); err != nil {
+			return fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		if cur == nil {
+			cur = &ruleset{}
+		}
+		if obj == "plumb" {
+			cur.acts = append(cur.acts, ru)
+		} else {
+			if len(cur.acts) > 0 {
+				return fmt.Errorf("%s:%d: pattern follows plumb action", name, n)
+			}
+			cur.pats = append(cur.pats, ru)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return end()
+}
+
+func (rs *Rules) include(file, dir string, depth int) error {
+	if filepath.IsAbs(file) {
+		return rs.load(file, depth+1)
+	}
+	cands := []string{filepath.Join(dir, file)}
+	if p9 := os.Getenv("PLAN9"); p9 != "" {
+		cands = append(cands, filepath.Join(p9, "plumb", file))
+	}
+	for _, c := range cands {
+		if _, err := os.Stat(c); err == nil {
+			return rs.load(c, depth+1)
+		}
+	}
+	return fmt.Errorf("can't find include file %q", file)
+}
+
+func (ru *rule) check() error {
+	var ok bool
+	switch ru.obj {
+	case "plumb":
+		ok = ru.verb == "to" || ru.verb == "start" || ru.verb == "client"
+	case "attr":
+		ok = ru.verb == "is" || ru.verb == "matches" || ru.verb == "add" || ru.verb == "delete"
+	case "arg":
+		ok = ru.verb == "is" || ru.verb == "isfile" || ru.verb == "isdir"
+	default:
+		ok = ru.verb == "is" || ru.verb == "isfile" || ru.verb == "isdir" || ru.verb == "matches" || ru.verb == "set"
+	}
+	if !ok {
+		return fmt.Errorf("bad verb %q for object %s", ru.verb, ru.obj)
+	}
+	if len(ru.arg) == 0 {
+		return fmt.Errorf("missing argument to %s %s", ru.obj, ru.verb)
+	}
+	if ru.verb == "matches" && !ru.dynamic() {
+		if _, err := regexp.Compile(join(ru.arg, nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dynamic reports whether the rule's argument refers to match-time variables.
+func (ru *rule) dynamic() bool {
+	for _, w := range ru.arg {
+		for _, s := range w {
+			if s.isvar {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// assignment splits a line of the form name=value.
+func assignment(line string) (string, string, bool) {
+	i := strings.IndexByte(line, '=')
+	if i <= 0 {
+		return "", "", false
+	}
+	for j, c := range line[:i] {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || j > 0 && c >= '0' && c <= '9') {
+			return "", "", false
+		}
+	}
+	return line[:i], line[i+1:], true
+}
+
+func field(s string) (string, string) {
+	s = strings.TrimLeft(s, " \t")
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimLeft(s[i:], " \t")
+}
+
+// split breaks s into words. Text inside single quotes is taken
+// literally, with a doubled quote standing for one. Outside quotes, $name
+// refers to a variable: assignments made earlier in the file are
+// substituted now and the others are kept for expansion at match time.
+func (rs *Rules) split(s string) ([]word, error) {
+	var (
+		words []word
+		w     word
+		lit   strings.Builder
+		inw   bool
+	)
+	flush := func() {
+		if lit.Len() > 0 {
+			w = append(w, segment{text: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inw {
+				flush()
+				words = append(words, w)
+				w, inw = nil, false
+			}
+			i++
+		case c == '\'':
+			inw = true
+			i++
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("unterminated quoted string")
+				}
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						lit.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				lit.WriteByte(s[i])
+				i++
+			}
+		case c == '$':
+			inw = true
+			j := i + 1
+			if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			} else {
+				for j < len(s) && isvarchar(s[j]) {
+					j++
+				}
+			}
+			if j == i+1 {
+				lit.WriteByte('$')
+				i++
+				continue
+			}
+			v := s[i+1 : j]
+			if _, err := strconv.Atoi(v); err == nil || special[v] {
+				flush()
+				w = append(w, segment{text: v, isvar: true})
+			} else {
+				lit.WriteString(rs.vars[v])
+			}
+			i = j
+		default:
+			inw = true
+			lit.WriteByte(c)
+			i++
+		}
+	}
+	if inw {
+		flush()
+		words = append(words, w)
+	}
+	return words, nil
+}
+
+func isvarchar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// join expands each word with lookup and joins the results with blanks.
+func join(words []word, lookup func(string) string) string {
+	return strings.Join(expand(words, lookup), " ")
+}
+
+func expand(words []word, lookup func(string) string) []string {
+	var out []string
+	for _, w := range words {
+		var b strings.Builder
+		for _, s := range w {
+			if s.isvar {
+				if lookup != nil {
+					b.WriteString(lookup(s.text))
+				}
+			} else {
+				b.WriteString(s.text)
+			}
+		}
+		out = append(out, b.String())
+	}
+	return out
+}
+
+// state is the evolving state of a message while a rule set is matched.
+type state struct {
+	m     *plumb.Message
+	match []string
+	file  string
+	dir   string
+	port  string
+	click int // rune offset of the click attribute or -1
+}
+
+func (st *state) lookup(v string) string {
+	if n, err := strconv.Atoi(v); err == nil {
+		if n < len(st.match) {
+			return st.match[n]
+		}
+		return ""
+	}
+	switch v {
+	case "src":
+		return st.m.Src
+	case "dst":
+		return st.m.Dst
+	case "wdir":
+		return st.m.Dir
+	case "type":
+		return st.m.Type
+	case "data":
+		return string(st.m.Data)
+	case "attr":
+		return attrString(st.m.Attr)
+	case "file":
+		return st.file
+	case "dir":
+		return st.dir
+	case "plumb":
+		return st.port
+	}
+	return ""
+}
+
+// Match runs m through the rule sets in order and returns the action of
+// the first one that matches, or nil if none does. m is not modified.
+func (rs *Rules) Match(m *plumb.Message) *Action {
+	if rs == nil {
+		return nil
+	}
+	for _, set := range rs.sets {
+		if a := set.match(m); a != nil {
+			return a
+		}
+	}
+	return nil
+}
+
+func (set *ruleset) match(m *plumb.Message) *Action {
+	st := &state{m: copyMessage(m), click: -1}
+	if c := findattr(m.Attr, "click"); c != "" {
+		if n, err := strconv.Atoi(c); err == nil {
+			st.click = n
+		}
+	}
+	for _, ru := range set.pats {
+		if !st.pattern(ru) {
+			return nil
+		}
+	}
+	a := &Action{Msg: st.m}
+	for _, ru := range set.acts {
+		switch ru.verb {
+		case "to":
+			a.Port = join(ru.arg, st.lookup)
+			st.port = a.Port
+		case "start":
+			a.Start = expand(ru.arg, st.lookup)
+		case "client":
+			a.Client = expand(ru.arg, st.lookup)
+		}
+	}
+	if m.Dst != "" && a.Port != m.Dst {
+		return nil
+	}
+	if a.Msg.Dst == "" {
+		a.Msg.Dst = a.Port
+	}
+	return a
+}
+
+func (st *state) object(obj string) string {
+	switch obj {
+	case "src":
+		return st.m.Src
+	case "dst":
+		return st.m.Dst
+	case "wdir":
+		return st.m.Dir
+	case "type":
+		return st.m.Type
+	case "data":
+		return string(st.m.Data)
+	case "attr":
+		return attrString(st.m.Attr)
+	}
+	return ""
+}
+
+func (st *state) setobject(obj, val string) {
+	switch obj {
+	case "src":
+		st.m.Src = val
+	case "dst":
+		st.m.Dst = val
+	case "wdir":
+		st.m.Dir = val
+	case "type":
+		st.m.Type = val
+	case "data":
+		st.m.Data = []byte(val)
+	}
+}
+
+func (st *state) pattern(ru *rule) bool {
+	arg := join(ru.arg, st.lookup)
+	switch ru.verb {
+	case "is":
+		if ru.obj == "arg" {
+			return true
+		}
+		return st.object(ru.obj) == arg
+	case "isfile", "isdir":
+		name := arg
+		if ru.obj != "arg" {
+			name = st.object(ru.obj)
+		}
+		if !filepath.IsAbs(name) && st.m.Dir != "" {
+			name = filepath.Join(st.m.Dir, name)
+		}
+		fi, err := os.Stat(name)
+		if err != nil || fi.IsDir() != (ru.verb == "isdir") {
+			return false
+		}
+		if ru.verb == "isdir" {
+			st.dir = filepath.Clean(name)
+		} else {
+			st.file = filepath.Clean(name)
+		}
+		return true
+	case "matches":
+		return st.matches(ru.obj, arg)
+	case "set":
+		st.setobject(ru.obj, arg)
+		return true
+	case "add":
+		for _, w := range expand(ru.arg, st.lookup) {
+			if i := strings.IndexByte(w, '='); i > 0 {
+				st.m.Attr = addattr(st.m.Attr, w[:i], w[i+1:])
+			}
+		}
+		return true
+	case "delete":
+		st.m.Attr = delattr(st.m.Attr, arg)
+		return true
+	}
+	return false
+}
+
+// matches matches the regular expression expr against the whole of obj.
+// If the message was generated by a click in data, the match need only
+// contain the click, and data is then narrowed to the matched text.
+func (st *state) matches(obj, expr string) bool {
+	s := st.object(obj)
+	if obj == "data" && st.click >= 0 {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return false
+		}
+		re.Longest()
+		click := runeOffset(s, st.click)
+		for i := 0; i <= click; i++ {
+			if i < len(s) && !utf8.RuneStart(s[i]) {
+				continue
+			}
+			loc := re.FindStringSubmatchIndex(s[i:])
+			if loc == nil || i+loc[0] > click || i+loc[1] < click {
+				continue
+			}
+			st.setmatch(s[i:], loc)
+			st.m.Data = []byte(st.match[0])
+			st.m.Attr = delattr(st.m.Attr, "click")
+			st.click = -1
+			return true
+		}
+		return false
+	}
+	re, err := regexp.Compile(`^(?:` + expr + `)$`)
+	if err != nil {
+		return false
+	}
+	re.Longest()
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return false
+	}
+	st.setmatch(s, loc)
+	return true
+}
+
+func (st *state) setmatch(s string, loc []int) {
+	st.match = make([]string, len(loc)/2)
+	for i := range st.match {
+		if loc[2*i] >= 0 {
+			st.match[i] = s[loc[2*i]:loc[2*i+1]]
+		}
+	}
+}
+
+// runeOffset returns the byte offset of the n-th rune of s.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
+
+func copyMessage(m *plumb.Message) *plumb.Message {
+	c := *m
+	c.Data = append([]byte(nil), m.Data...)
+	c.Attr = nil
+	tail := &c.Attr
+	for a := m.Attr; a != nil; a = a.Next {
+		*tail = &plumb.Attribute{Name: a.Name, Value: a.Value}
+		tail = &(*tail).Next
+	}
+	return &c
+}
+
+func findattr(attr *plumb.Attribute, name string) string {
+	for ; attr != nil; attr = attr.Next {
+		if attr.Name == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func addattr(attr *plumb.Attribute, name, value string) *plumb.Attribute {
+	attr = delattr(attr, name)
+	a := &plumb.Attribute{Name: name, Value: value}
+	if attr == nil {
+		return a
+	}
+	last := attr
+	for last.Next != nil {
+		last = last.Next
+	}
+	last.Next = a
+	return attr
+}
+
+func delattr(attr *plumb.Attribute, name string) *plumb.Attribute {
+	var head *plumb.Attribute
+	tail := &head
+	for a := attr; a != nil; a = a.Next {
+		if a.Name != name {
+			*tail = a
+			tail = &a.Next
+		}
+	}
+	*tail = nil
+	return head
+}
+
+func attrString(attr *plumb.Attribute) string {
+	var parts []string
+	for a := attr; a != nil; a = a.Next {
+		parts = append(parts, a.Name+"="+a.Value)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,134 @@
+package plumber
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plumb"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testRules = `# test rules
+editor=acme
+addrelem='((#?[0-9]+)|(/[A-Za-z0-9_\^]+/?)|[.$])'
+addr=:($addrelem([,;+\-]$addrelem)*)
+
+type is text
+data matches 'https?://[a-zA-Z0-9_@\-]+([.:][a-zA-Z0-9_@\-]+)*/?[a-zA-Z0-9_?,%#~&/\-+=]*'
+plumb to web
+plumb start open $0
+
+type is text
+data matches '([.a-zA-Z¡-￿0-9_/\-]*[a-zA-Z¡-￿0-9_/\-])('$addr')?'
+arg isfile $1
+data set $file
+attr add addr=$3
+plumb to edit
+plumb client $editor
+
+type is text
+data matches '([a-zA-Z0-9_\-./]+)\(([1-8])\)'
+plumb start rc -c 'man '$2' '$1' >[2=1]'
+`
+
+func TestMatch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.go"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := Parse(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		data   string
+		click  string
+		port   string
+		start  []string
+		client []string
+		out    string
+		addr   string
+	}{
+		{"url", "https://example.com/x", "", "web", []string{"open", "https://example.com/x"}, nil, "https://example.com/x", ""},
+		{"file", "hello.go", "", "edit", nil, []string{"acme"}, filepath.Join(dir, "hello.go"), ""},
+		{"fileaddr", "hello.go:12", "", "edit", nil, []string{"acme"}, filepath.Join(dir, "hello.go"), "12"},
+		{"click", "see hello.go:3 here", "7", "edit", nil, []string{"acme"}, filepath.Join(dir, "hello.go"), "3"},
+		{"man", "cat(1)", "", "", []string{"rc", "-c", "man 1 cat >[2=1]"}, nil, "cat(1)", ""},
+		{"missing", "nothere.go", "", "", nil, nil, "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &plumb.Message{Src: "acme", Dir: dir, Type: "text", Data: []byte(tc.data)}
+			if tc.click != "" {
+				m.Attr = &plumb.Attribute{Name: "click", Value: tc.click}
+			}
+			a := rs.Match(m)
+			if tc.out == "" {
+				if a != nil {
+					t.Fatalf("got match %+v, want none", a)
+				}
+				return
+			}
+			if a == nil {
+				t.Fatalf("no match")
+			}
+			if a.Port != tc.port {
+				t.Errorf("port: got %q, want %q", a.Port, tc.port)
+			}
+			if diff := cmp.Diff(tc.start, a.Start); diff != "" {
+				t.Errorf("start mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.client, a.Client); diff != "" {
+				t.Errorf("client mismatch (-want +got):\n%s", diff)
+			}
+			if got := string(a.Msg.Data); got != tc.out {
+				t.Errorf("data: got %q, want %q", got, tc.out)
+			}
+			if got := findattr(a.Msg.Attr, "addr"); got != tc.addr {
+				t.Errorf("addr: got %q, want %q", got, tc.addr)
+			}
+			if findattr(a.Msg.Attr, "click") != "" {
+				t.Errorf("click attribute not removed")
+			}
+			if string(m.Data) != tc.data {
+				t.Errorf("Match modified its argument")
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"type is text\n",
+		"bogus is text\nplumb to edit\n",
+		"type frob text\nplumb to edit\n",
+		"data matches '(\nplumb to edit\n",
+		"data matches '([a-z]+)(\nplumb to edit\n",
+		"plumb to edit\ntype is text\n",
+	} {
+		if _, err := Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "basic"), []byte("type is text\nplumb to edit\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "plumbing")
+	if err := os.WriteFile(main, []byte("include basic\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := Load(main)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if a := rs.Match(&plumb.Message{Type: "text"}); a == nil || a.Port != "edit" {
+		t.Errorf("included rule did not match: %+v", a)
+	}
+}
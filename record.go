@@ -0,0 +1,302 @@
+package main
+
+// Recording and replay of input sessions. With -record, the screen
+// rectangle and every mouse, resize and keyboard event is written to a
+// file as it is delivered, one JSON object per line. With -replay, such a file is fed into a
+// headless Edwood running on edwoodtest's mock display and the resulting
+// state is written to standard output in dump file format. This makes
+// hard-to-reproduce mouse-chord and selection bugs into regression tests.
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/dumpfile"
+	"github.com/rjkroege/edwood/edwoodtest"
+)
+
+// inputEvent is one recorded input event.
+type inputEvent struct {
+	T       int64            `json:"t"`    // milliseconds since recording started
+	Kind    string           `json:"kind"` // screen, mouse, key or resize
+	X       int              `json:"x,omitempty"`
+	Y       int              `json:"y,omitempty"`
+	Buttons int              `json:"buttons,omitempty"`
+	Msec    uint32           `json:"msec,omitempty"`
+	Rune    rune             `json:"rune,omitempty"`
+	R       *image.Rectangle `json:"r,omitempty"` // the screen, for screen and resize
+}
+
+func screenEvent(kind string, display draw.Display) inputEvent {
+	r := display.ScreenImage().R()
+	return inputEvent{Kind: kind, R: &r}
+}
+
+func mouseEvent(m draw.Mouse) inputEvent {
+	return inputEvent{Kind: "mouse", X: m.Point.X, Y: m.Point.Y, Buttons: m.Buttons, Msec: m.Msec}
+}
+
+func (ev *inputEvent) mouse() draw.Mouse {
+	return draw.Mouse{Point: image.Pt(ev.X, ev.Y), Buttons: ev.Buttons, Msec: ev.Msec}
+}
+
+// inputRecorder writes timestamped events.
+type inputRecorder struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+}
+
+func (r *inputRecorder) record(ev inputEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ev.T = time.Since(r.start).Milliseconds()
+	if err := r.enc.Encode(&ev); err != nil {
+		log.Printf("recording input: %v", err)
+	}
+}
+
+// recordInput writes the rectangle of display's screen to w and
+// interposes on g's mouse and keyboard channels so that every event is
+// written to w before it is delivered. Resizes are written by
+// globals.resize, once the new screen is known.
+func recordInput(g *globals, display draw.Display, w io.Writer) {
+	rec := &inputRecorder{
+		enc:   json.NewEncoder(w),
+		start: time.Now(),
+	}
+	rec.record(screenEvent("screen", display))
+	mouse := make(chan draw.Mouse)
+	kbd := make(chan rune, 20)
+
+	go func(in <-chan draw.Mouse) {
+		for m := range in {
+			rec.record(mouseEvent(m))
+			mouse <- m
+		}
+	}(g.mousectl.C)
+	go func(in <-chan rune) {
+		for r := range in {
+			rec.record(inputEvent{Kind: "key", Rune: r})
+			kbd <- r
+		}
+	}(g.keyboardctl.C)
+
+	mc := *g.mousectl
+	mc.C = mouse
+	g.mousectl = &mc
+	g.keyboardctl = &draw.Keyboardctl{C: kbd}
+	g.recorder = rec
+}
+
+// readInputEvents reads events written by recordInput.
+func readInputEvents(r io.Reader) ([]inputEvent, error) {
+	var events []inputEvent
+	dec := json.NewDecoder(r)
+	for {
+		var ev inputEvent
+		err := dec.Decode(&ev)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad input event %d: %v", len(events)+1, err)
+		}
+		switch ev.Kind {
+		case "mouse", "key":
+		case "screen", "resize":
+			if ev.R == nil {
+				return nil, fmt.Errorf("bad input event %d: %s without a rectangle", len(events)+1, ev.Kind)
+			}
+		default:
+			return nil, fmt.Errorf("bad input event %d: unknown kind %q", len(events)+1, ev.Kind)
+		}
+		events = append(events, ev)
+	}
+}
+
+// headless runs Edwood's row on a display with input supplied by play.
+// There is no file server or plumber so only built-in commands can be
+// executed.
+type headless struct {
+	g       *globals
+	display draw.Display
+	mouse   chan draw.Mouse
+	resize  chan bool
+	kbd     chan rune
+	call    chan func()
+	done    chan struct{}
+}
+
+// startHeadless lays out the row as for mainWithDisplay and starts
+// handling input.
+func startHeadless(g *globals, dump *dumpfile.Content, display draw.Display) *headless {
+	h := &headless{
+		g:       g,
+		display: display,
+		mouse:   make(chan draw.Mouse),
+		resize:  make(chan bool, 2),
+		kbd:     make(chan rune, 1024),
+		call:    make(chan func()),
+		done:    make(chan struct{}),
+	}
+	display.ScreenImage().Draw(display.ScreenImage().R(), display.White(), nil, image.Point{})
+	g.mousectl = &draw.Mousectl{C: h.mouse, Resize: h.resize}
+	g.mouse = &g.mousectl.Mouse
+	g.keyboardctl = &draw.Keyboardctl{C: h.kbd}
+	g.iconinit(display)
+	ScrlResize(display)
+	g.initrow(dump, display)
+	display.Flush()
+
+	go h.run()
+	return h
+}
+
+// run combines mousethread and keyboardthread so that events are handled
+// one at a time and in the order they were recorded. Keys typed while a
+// mouse button is held wait until the mouse is released, as they do when
+// keyboardthread blocks on the row lock.
+func (h *headless) run() {
+	g := h.g
+	for {
+		g.row.lk.Lock()
+		flushwarnings()
+		g.row.lk.Unlock()
+		h.display.Flush()
+
+		select {
+		case r := <-g.keyboardctl.C:
+			h.typekey(r)
+			continue
+		default:
+		}
+
+		select {
+		case <-g.mousectl.Resize:
+			g.resize(h.display)
+		case g.mousectl.Mouse = <-g.mousectl.C:
+			MovedMouse(g, g.mousectl.Mouse)
+		case r := <-g.keyboardctl.C:
+			h.typekey(r)
+		case f := <-h.call:
+			h.drainkeys()
+			g.row.lk.Lock()
+			f()
+			g.row.lk.Unlock()
+		case <-h.done:
+			h.drainkeys()
+			h.done <- struct{}{}
+			return
+		}
+	}
+}
+
+func (h *headless) drainkeys() {
+	for len(h.kbd) > 0 {
+		h.typekey(<-h.kbd)
+	}
+}
+
+// typekey types r. Tags are committed at once instead of after
+// keyboardthread's delay.
+func (h *headless) typekey(r rune) {
+	if t := h.g.typekey(r); t != nil && t.what == Tag {
+		t.w.Lock('K')
+		t.w.Commit(t)
+		t.w.Unlock()
+	}
+}
+
+// play delivers ev. A resize gives the display its recorded screen, if
+// the display is edwoodtest's.
+func (h *headless) play(ev inputEvent) {
+	switch ev.Kind {
+	case "mouse":
+		h.mouse <- ev.mouse()
+	case "key":
+		h.kbd <- ev.Rune
+	case "screen", "resize":
+		if ev.R != nil {
+			h.do(func() { setScreenRect(h.display, *ev.R) })
+		}
+		h.resize <- true
+	}
+}
+
+// setScreenRect sets the screen of display to r if it's edwoodtest's mock
+// display.
+func setScreenRect(display draw.Display, r image.Rectangle) {
+	if _, ok := display.(edwoodtest.GettableDrawOps); ok {
+		edwoodtest.SetScreenRect(display, r)
+	}
+}
+
+// do runs f with the row locked after the events played so far have
+// been handled.
+func (h *headless) do(f func()) {
+	done := make(chan struct{})
+	h.call <- func() {
+		f()
+		close(done)
+	}
+	<-done
+}
+
+// finish waits for the events played so far to be handled and returns
+// the resulting state.
+func (h *headless) finish() (*dumpfile.Content, error) {
+	h.done <- struct{}{}
+	<-h.done
+
+	g := h.g
+	g.row.lk.Lock()
+	defer g.row.lk.Unlock()
+	flushwarnings()
+	return g.row.dump()
+}
+
+// replay plays events into a headless Edwood on display, started from
+// dump (if not nil), and returns the resulting state. The display starts
+// with the recorded screen.
+func replay(g *globals, dump *dumpfile.Content, display draw.Display, events []inputEvent) (*dumpfile.Content, error) {
+	if len(events) > 0 && events[0].Kind == "screen" {
+		setScreenRect(display, *events[0].R)
+		events = events[1:]
+	}
+	h := startHeadless(g, dump, display)
+	for _, ev := range events {
+		h.play(ev)
+	}
+	return h.finish()
+}
+
+// replayMain implements -replay: file is replayed on edwoodtest's mock
+// display and the resulting dump is written to standard output.
+func replayMain(g *globals, dump *dumpfile.Content, file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("can't replay input: %v\n", err)
+	}
+	events, err := readInputEvents(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("can't replay %s: %v\n", file, err)
+	}
+
+	g.palette = paletteFromDump(dump, *paletteName)
+	content, err := replay(g, dump, edwoodtest.NewDisplay(image.Rectangle{}), events)
+	if err != nil {
+		log.Fatalf("replay failed: %v\n", err)
+	}
+	if err := content.Encode(os.Stdout); err != nil {
+		log.Fatalf("can't write dump: %v\n", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/dumpfile"
+	"github.com/rjkroege/edwood/edwoodtest"
+	"github.com/rjkroege/edwood/theme"
+)
+
+func TestRecordInput(t *testing.T) {
+	mc := make(chan draw.Mouse)
+	rc := make(chan bool)
+	kc := make(chan rune)
+	g := &globals{
+		mousectl:    &draw.Mousectl{C: mc, Resize: rc},
+		keyboardctl: &draw.Keyboardctl{C: kc},
+	}
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	var buf bytes.Buffer
+	recordInput(g, display, &buf)
+
+	m := draw.Mouse{Point: image.Pt(10, 20), Buttons: 5, Msec: 1234}
+	mc <- m
+	if got := <-g.mousectl.C; got != m {
+		t.Errorf("got mouse %v; want %v", got, m)
+	}
+	kc <- 'λ'
+	if got := <-g.keyboardctl.C; got != 'λ' {
+		t.Errorf("got key %q; want 'λ'", got)
+	}
+	// globals.resize records the new screen.
+	edwoodtest.SetScreenRect(display, image.Rect(0, 0, 1024, 768))
+	g.recorder.record(screenEvent("resize", display))
+
+	events, err := readInputEvents(&buf)
+	if err != nil {
+		t.Fatalf("readInputEvents failed: %v", err)
+	}
+	want := []inputEvent{
+		{Kind: "screen", R: &image.Rectangle{Max: image.Pt(800, 600)}},
+		{Kind: "mouse", X: 10, Y: 20, Buttons: 5, Msec: 1234},
+		{Kind: "key", Rune: 'λ'},
+		{Kind: "resize", R: &image.Rectangle{Max: image.Pt(1024, 768)}},
+	}
+	if diff := cmp.Diff(want, events, cmpopts.IgnoreFields(inputEvent{}, "T")); diff != "" {
+		t.Errorf("recorded events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadInputEventsError(t *testing.T) {
+	for _, s := range []string{
+		`{"kind":"mouse"}{`,
+		`{"kind":"wheel"}`,
+		`{"kind":"resize"}`,
+	} {
+		if _, err := readInputEvents(strings.NewReader(s)); err == nil {
+			t.Errorf("readInputEvents(%q) succeeded", s)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(g *globals) { global = g }(global)
+	global = makeglobals()
+	global.palette = theme.Light
+	warnings = nil // left by other tests
+
+	dump := &dumpfile.Content{
+		CurrentDir: cwd,
+		VarFont:    edwoodtest.MockFontName,
+		FixedFont:  edwoodtest.MockFontName,
+		RowTag:     dumpfile.Text{Buffer: "Newcol Kill Putall Dump Exit "},
+		Columns: []dumpfile.Column{
+			{Tag: dumpfile.Text{Buffer: "New Cut Paste Snarf Sort Zerox Delcol "}},
+		},
+		Windows: []*dumpfile.Window{{
+			Type: dumpfile.Unsaved,
+			Tag:  dumpfile.Text{Buffer: filepath.Join(cwd, "scratch") + " Del Snarf | Look "},
+			Body: dumpfile.Text{Buffer: "hello world\n"},
+		}},
+	}
+	h := startHeadless(global, dump, edwoodtest.NewDisplay(image.Rectangle{}))
+
+	fr := global.row.col[0].w[0].body.fr
+	cw := fr.Ptofchar(1).X - fr.Ptofchar(0).X
+	at := func(q int) image.Point {
+		return fr.Ptofchar(0).Add(image.Pt(q*cw+1, 1))
+	}
+	var events []inputEvent
+	mouse := func(q, buttons int, msec uint32) {
+		events = append(events, mouseEvent(draw.Mouse{Point: at(q), Buttons: buttons, Msec: msec}))
+	}
+
+	// As with devdraw, each release is followed by motion. Text.Select
+	// only returns on the event after the one that ends frame's selection.
+	//
+	// Click after "hello " and type.
+	mouse(6, 1, 100)
+	mouse(6, 0, 150)
+	mouse(6, 0, 160)
+	for _, r := range "big " {
+		events = append(events, inputEvent{Kind: "key", Rune: r})
+	}
+	// Sweep "big " and cut it with a 1-2 chord.
+	mouse(6, 1, 1000)
+	mouse(10, 1, 1050)
+	mouse(10, 3, 1100)
+	mouse(10, 3, 1110)
+	mouse(10, 1, 1150)
+	mouse(10, 0, 1200)
+	mouse(10, 0, 1210)
+	// Sweep "world".
+	mouse(6, 1, 2000)
+	mouse(9, 1, 2050)
+	mouse(11, 1, 2100)
+	mouse(11, 0, 2150)
+	mouse(11, 0, 2160)
+
+	for _, ev := range events {
+		h.play(ev)
+	}
+	got, err := h.finish()
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	if len(got.Windows) != 1 {
+		t.Fatalf("got %d windows; want 1", len(got.Windows))
+	}
+	if diff := cmp.Diff(dumpfile.Text{Buffer: "hello world\n", Q0: 6, Q1: 11}, got.Windows[0].Body); diff != "" {
+		t.Errorf("body mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := string(global.snarfbuf), "big "; got != want {
+		t.Errorf("snarf buffer is %q; want %q", got, want)
+	}
+}
+
+// The row is laid out on the recorded screen, at start and on resize.
+func TestReplayScreen(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(g *globals) { global = g }(global)
+	warnings = nil // left by other tests
+
+	dump := &dumpfile.Content{
+		CurrentDir: cwd,
+		VarFont:    edwoodtest.MockFontName,
+		FixedFont:  edwoodtest.MockFontName,
+		RowTag:     dumpfile.Text{Buffer: "Newcol Kill Putall Dump Exit "},
+		Columns: []dumpfile.Column{
+			{Tag: dumpfile.Text{Buffer: "New Cut Paste Snarf Sort Zerox Delcol "}},
+		},
+	}
+	start, resized := image.Rect(0, 0, 1024, 768), image.Rect(0, 0, 640, 480)
+	for _, tc := range []struct {
+		events []inputEvent
+		want   image.Rectangle
+	}{
+		{nil, image.Rect(0, 0, 800, 600)},
+		{[]inputEvent{{Kind: "screen", R: &start}}, start},
+		{[]inputEvent{{Kind: "screen", R: &start}, {Kind: "resize", R: &resized}}, resized},
+	} {
+		global = makeglobals()
+		global.palette = theme.Light
+		if _, err := replay(global, dump, edwoodtest.NewDisplay(image.Rectangle{}), tc.events); err != nil {
+			t.Fatalf("replay failed: %v", err)
+		}
+		if got := global.row.r; got != tc.want {
+			t.Errorf("replay of %d events laid out the row on %v; want %v", len(tc.events), got, tc.want)
+		}
+	}
+}
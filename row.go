@@ -7,6 +7,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"unicode/utf8"
@@ -90,6 +91,7 @@ func (row *Row) Add(c *Column, x int) *Column {
 		row.display.ScreenImage().Draw(r1, row.display.Black(), nil, image.Point{})
 		r.Min.X = r1.Max.X
 	}
+	moved := c != nil // by MoveCol, which logs it
 	if c == nil {
 		c = &Column{}
 		c.Init(r, row.display)
@@ -101,6 +103,9 @@ func (row *Row) Add(c *Column, x int) *Column {
 	row.col = append(row.col, nil)
 	copy(row.col[colidx+1:], row.col[colidx:])
 	row.col[colidx] = c
+	if !moved {
+		xfidlogf(0, "coladd", strconv.Itoa(colidx))
+	}
 	clearmouse()
 	return c
 }
@@ -137,27 +142,39 @@ func (r *Row) Resize(rect image.Rectangle) {
 }
 
 func (row *Row) DragCol(c *Column, _ int) {
-	var (
-		r       image.Rectangle
-		i, b, x int
-		p, op   image.Point
-		d       *Column
-	)
 	clearmouse()
 	row.display.SetCursor(&boxcursor)
-	b = global.mouse.Buttons
-	op = global.mouse.Point
+	b := global.mouse.Buttons
+	op := global.mouse.Point
 	for global.mouse.Buttons == b {
-		global.mousectl.Read()
+		global.readmouse()
 	}
 	row.display.SetCursor(nil)
 	if global.mouse.Buttons != 0 {
 		for global.mouse.Buttons != 0 {
-			global.mousectl.Read()
+			global.readmouse()
 		}
 		return
 	}
 
+	p := global.mouse.Point
+	if max(p.X-op.X, -(p.X-op.X)) < 5 && max(p.Y-op.Y, -(p.Y-op.Y)) < 5 {
+		return
+	}
+	if row.MoveCol(c, p.X) {
+		c.MouseBut()
+	}
+}
+
+// MoveCol moves the left edge of column c to x as if it had been dragged
+// there. A column moved past one of its neighbours is shuffled into its
+// new place. MoveCol reports whether the layout changed.
+func (row *Row) MoveCol(c *Column, x int) bool {
+	var (
+		r image.Rectangle
+		i int
+		d *Column
+	)
 	for i = 0; i < len(row.col); i++ {
 		if row.col[i] == c {
 			goto Found
@@ -166,47 +183,43 @@ func (row *Row) DragCol(c *Column, _ int) {
 	log.Panicf("acme: %s: %v\n", "can't find column", nil)
 
 Found:
-	p = global.mouse.Point
-	if max(p.X-op.X, -(p.X-op.X)) < 5 && max(p.Y-op.Y, -(p.Y-op.Y)) < 5 {
-		return
-	}
-	if (i > 0 && p.X < row.col[i-1].r.Min.X) || (i < len(row.col)-1 && p.X > c.r.Max.X) {
+	if (i > 0 && x < row.col[i-1].r.Min.X) || (i < len(row.col)-1 && x > c.r.Max.X) {
 		// shuffle
-		x = c.r.Min.X
+		ox := c.r.Min.X
 		row.Close(c, false)
-		if (row.Add(c, p.X) == nil) && // whoops!
-			(row.Add(c, x) == nil) && // WHOOPS!
+		if (row.Add(c, x) == nil) && // whoops!
+			(row.Add(c, ox) == nil) && // WHOOPS!
 			(row.Add(c, -1) == nil) { // shit!
 			row.Close(c, true)
-			return
+			return false
 		}
-		c.MouseBut()
-		return
+		xfidlogf(0, "colmove", fmt.Sprintf("%d %d", i, row.colindex(c)))
+		return true
 	}
 	if i == 0 {
-		return
+		return false
 	}
 	d = row.col[i-1]
-	if p.X < d.r.Min.X+row.display.ScaleSize(80+Scrollwid) {
-		p.X = d.r.Min.X + row.display.ScaleSize(80+Scrollwid)
+	if x < d.r.Min.X+row.display.ScaleSize(80+Scrollwid) {
+		x = d.r.Min.X + row.display.ScaleSize(80+Scrollwid)
 	}
-	if p.X > c.r.Max.X-row.display.ScaleSize(80-Scrollwid) {
-		p.X = c.r.Max.X - row.display.ScaleSize(80-Scrollwid)
+	if x > c.r.Max.X-row.display.ScaleSize(80-Scrollwid) {
+		x = c.r.Max.X - row.display.ScaleSize(80-Scrollwid)
 	}
 	r = d.r
 	r.Max.X = c.r.Max.X
 	row.display.ScreenImage().Draw(r, row.display.White(), nil, image.Point{})
-	r.Max.X = p.X
+	r.Max.X = x
 	d.Resize(r)
 	r = c.r
-	r.Min.X = p.X
+	r.Min.X = x
 	r.Max.X = r.Min.X
 	r.Max.X += row.display.ScaleSize(Border)
 	row.display.ScreenImage().Draw(r, row.display.Black(), nil, image.Point{})
 	r.Min.X = r.Max.X
 	r.Max.X = c.r.Max.X
 	c.Resize(r)
-	c.MouseBut()
+	return true
 }
 
 func (row *Row) Close(c *Column, dofree bool) {
@@ -227,6 +240,9 @@ Found:
 		c.CloseAll()
 	}
 	row.col = append(row.col[:i], row.col[i+1:]...)
+	if dofree { // otherwise MoveCol is moving c, and logs it
+		xfidlogf(0, "coldel", strconv.Itoa(i))
+	}
 	if len(row.col) == 0 {
 		row.display.ScreenImage().Draw(r, row.display.White(), nil, image.Point{})
 		return
@@ -243,6 +259,16 @@ Found:
 	c.Resize(r)
 }
 
+// colindex returns the index of c in the row or -1 if c is not there.
+func (row *Row) colindex(c *Column) int {
+	for i, d := range row.col {
+		if d == c {
+			return i
+		}
+	}
+	return -1
+}
+
 func (r *Row) WhichCol(p image.Point) *Column {
 	for i := 0; i < len(global.row.col); i++ {
 		c := global.row.col[i]
@@ -281,6 +307,7 @@ func (row *Row) Type(r rune, p image.Point) *Text {
 	} else {
 		t = row.Which(p)
 	}
+	closecompletion(t)
 	if t != nil && !(t.what == Tag && p.In(t.scrollr)) {
 		w = t.w
 		if w == nil {
@@ -371,7 +398,7 @@ func (r *Row) dump() (*dumpfile.Content, error) {
 			},
 		}
 		for _, w := range c.w {
-			if w.nopen[QWevent] != 0 {
+			if w.eventsopen() {
 				// Mark zeroxes of external windows specially.
 				dumpid[w.body.file] = -1
 			}
@@ -385,14 +412,14 @@ func (r *Row) dump() (*dumpfile.Content, error) {
 			t := &w.body
 
 			// External windows can't be recreated so skip them.
-			if w.nopen[QWevent] > 0 {
+			if w.eventsopen() {
 				if w.dumpstr == "" {
 					continue
 				}
 			}
 
 			// zeroxes of external windows are tossed
-			if dumpid[t.file] < 0 && w.nopen[QWevent] == 0 {
+			if dumpid[t.file] < 0 && !w.eventsopen() {
 				continue
 			}
 
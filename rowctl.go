@@ -0,0 +1,280 @@
+package main
+
+// Control of the row's layout through the file server. The row's ctl
+// file, at the root, lists the columns and adds new ones or dumps and
+// loads the row. Each column has a directory col/N, where N is its index
+// in the row, holding a ctl file that lists the column's windows and
+// moves, grows and deletes them. A fid walked to col/N stays with that
+// column as others are added or deleted. Positions are percentages of the row's
+// width or the column's height, as in dump files.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/ninep"
+)
+
+// colposition returns the position of c's left edge in the row.
+func colposition(row *Row, c *Column) float64 {
+	pos := 100.0 * float64(c.r.Min.X-row.r.Min.X) / float64(row.r.Dx())
+	if math.IsNaN(pos) || math.IsInf(pos, 0) {
+		pos = 0.
+	}
+	return pos
+}
+
+// winposition returns the position of w's top edge in column c.
+func winposition(c *Column, w *Window) float64 {
+	pos := 100.0 * float64(w.r.Min.Y-c.r.Min.Y) / float64(c.r.Dy())
+	if math.IsNaN(pos) || math.IsInf(pos, 0) {
+		pos = 0.
+	}
+	return pos
+}
+
+// parseposition parses the position word s and converts it to a
+// coordinate between min and min+size.
+func parseposition(s string, min, size int) (int, error) {
+	pos, err := strconv.ParseFloat(s, 64)
+	if err != nil || pos < 0 || pos >= 100 {
+		return 0, fmt.Errorf("bad position %q", s)
+	}
+	return min + int(pos*float64(size)/100.+0.5), nil
+}
+
+// movewindow moves w into column c with its top at the position word pos
+// or, if pos is empty, where c places new windows.
+func movewindow(w *Window, c *Column, pos string) error {
+	y := -1
+	if pos != "" {
+		var err error
+		if y, err = parseposition(pos, c.r.Min.Y, c.r.Dy()); err != nil {
+			return err
+		}
+	}
+	w.col.Close(w, false)
+	c.Add(w, nil, y)
+	c.logmove(w)
+	return nil
+}
+
+// ctlwrite applies the control messages written by x, one per line, with
+// f. It responds with the number of bytes consumed or the first error.
+func ctlwrite(x *Xfid, f func(words []string) error) {
+	var err error
+	n := 0
+	for _, line := range strings.Split(string(x.fcall.Data), "\n") {
+		if words := strings.Fields(line); len(words) > 0 {
+			if err = f(words); err != nil {
+				break
+			}
+		}
+		n += len(line)
+		if d := x.fcall.Data; n < len(d) && d[n] == '\n' {
+			n++
+		}
+	}
+	if err != nil {
+		n = 0
+	}
+	fc := plan9.Fcall{
+		Count: uint32(n),
+	}
+	x.respond(&fc, err)
+}
+
+// xfidrowctlread lists the columns: index, position and number of
+// windows.
+func xfidrowctlread(x *Xfid) {
+	row := &global.row
+	row.lk.Lock()
+	var sb strings.Builder
+	for i, c := range row.col {
+		fmt.Fprintf(&sb, "%11d %11.2f %11d\n", i, colposition(row, c), len(c.w))
+	}
+	row.lk.Unlock()
+
+	var fc plan9.Fcall
+	ninep.ReadString(&fc, &x.fcall, sb.String())
+	x.respond(&fc, nil)
+}
+
+// xfidrowctlwrite handles messages written to the row's ctl file:
+//
+//	addcol [pos]	add a column at pos, or where Newcol would
+//	dump [file]	dump the row as Dump does
+//	load [file]	load a dump as Load does
+func xfidrowctlwrite(x *Xfid) {
+	row := &global.row
+	row.lk.Lock()
+	defer row.lk.Unlock()
+
+	ctlwrite(x, func(words []string) error {
+		switch words[0] {
+		case "addcol":
+			px := -1
+			if len(words) > 1 {
+				var err error
+				px, err = parseposition(words[1], row.r.Min.X, row.r.Dx())
+				if err != nil {
+					return err
+				}
+			}
+			if row.Add(nil, px) == nil {
+				return fmt.Errorf("no room for column")
+			}
+		case "dump", "load":
+			file := ""
+			if len(words) > 1 {
+				file = words[1]
+			}
+			if words[0] == "dump" {
+				return row.Dump(file)
+			}
+			return row.Load(nil, file, false)
+		default:
+			return ErrBadCtl
+		}
+		return nil
+	})
+}
+
+// ctlcolumn returns the column of the col/N/ctl file opened by x, if it
+// is still in the row.
+func ctlcolumn(x *Xfid) (*Column, error) {
+	for _, c := range global.row.col {
+		if c == x.f.col {
+			return c, nil
+		}
+	}
+	return nil, ErrDeletedCol
+}
+
+// xfidcolctlread lists the windows in a column: ID and position.
+func xfidcolctlread(x *Xfid) {
+	var fc plan9.Fcall
+	global.row.lk.Lock()
+	c, err := ctlcolumn(x)
+	if err != nil {
+		global.row.lk.Unlock()
+		x.respond(&fc, err)
+		return
+	}
+	var sb strings.Builder
+	for _, w := range c.w {
+		fmt.Fprintf(&sb, "%11d %11.2f\n", w.id, winposition(c, w))
+	}
+	global.row.lk.Unlock()
+
+	ninep.ReadString(&fc, &x.fcall, sb.String())
+	x.respond(&fc, nil)
+}
+
+// xfidcolctlwrite handles messages written to a column's ctl file:
+//
+//	pos pos	move the column's left edge to pos
+//	move id [pos]	move window id into the column at pos
+//	grow id	grow window id a little
+//	growmax id	grow window id, keeping the other tags visible
+//	zoom id	make window id fill the column
+//	del	delete the column if its windows are clean
+//	delete	delete the column
+func xfidcolctlwrite(x *Xfid) {
+	row := &global.row
+	row.lk.Lock()
+	defer row.lk.Unlock()
+
+	c, err := ctlcolumn(x)
+	if err != nil {
+		x.respond(&plan9.Fcall{}, err)
+		return
+	}
+
+	// window returns the window with the ID in words[1].
+	window := func(words []string) (*Window, error) {
+		if len(words) < 2 {
+			return nil, ErrBadCtl
+		}
+		id, err := strconv.Atoi(words[1])
+		if err != nil {
+			return nil, ErrBadCtl
+		}
+		w := row.LookupWin(id)
+		if w == nil {
+			return nil, fmt.Errorf("no window %d", id)
+		}
+		return w, nil
+	}
+
+	ctlwrite(x, func(words []string) error {
+		if c == nil { // column was deleted in a previous line
+			return ErrDeletedCol
+		}
+		switch words[0] {
+		case "pos":
+			if len(words) < 2 {
+				return ErrBadCtl
+			}
+			px, err := parseposition(words[1], row.r.Min.X, row.r.Dx())
+			if err != nil {
+				return err
+			}
+			// MoveCol puts the border, rather than the column, at px.
+			if !row.MoveCol(c, px-row.display.ScaleSize(Border)) {
+				if row.colindex(c) < 0 { // no room for it anywhere
+					c = nil
+					return ErrDeletedCol
+				}
+				return fmt.Errorf("can't move the column to %s", words[1])
+			}
+		case "move":
+			w, err := window(words)
+			if err != nil {
+				return err
+			}
+			pos := ""
+			if len(words) > 2 {
+				pos = words[2]
+			}
+			return movewindow(w, c, pos)
+		case "grow", "growmax", "zoom":
+			w, err := window(words)
+			if err != nil {
+				return err
+			}
+			if w.col != c {
+				return fmt.Errorf("window %d is not in the column", w.id)
+			}
+			but := 1
+			switch words[0] {
+			case "growmax":
+				but = 2
+			case "zoom":
+				but = 3
+			}
+			c.Grow(w, but)
+		case "del":
+			for _, w := range c.w {
+				if !w.Clean(true) {
+					return fmt.Errorf("file dirty")
+				}
+			}
+			fallthrough
+		case "delete":
+			for _, w := range c.w {
+				if w.eventsopen() || w.nopen[QWaddr]+w.nopen[QWdata]+w.nopen[QWxdata] > 0 {
+					return fmt.Errorf("%s is running an external command", w.body.file.Name())
+				}
+			}
+			row.Close(c, true)
+			c = nil
+		default:
+			return ErrBadCtl
+		}
+		return nil
+	})
+}
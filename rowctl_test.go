@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/dumpfile"
+)
+
+// colCtlFid opens the ctl file of the column with index i, walking to it
+// as a client does.
+func colCtlFid(t *testing.T, i int) *Fid {
+	t.Helper()
+	f := &Fid{qid: plan9.Qid{Type: plan9.QTDIR, Path: QID(0, Qcol)}}
+	for _, name := range []string{strconv.Itoa(i), "ctl"} {
+		if ok, err := f.Walk1(name); !ok || err != nil {
+			t.Fatalf("walk to col/%d/ctl failed at %s: %v", i, name, err)
+		}
+	}
+	xfidopen(&Xfid{f: f, fs: new(mockResponder)})
+	return f
+}
+
+// ctlFields reads the ctl file open on f and splits its lines into
+// fields.
+func ctlFields(t *testing.T, f *Fid) [][]string {
+	t.Helper()
+	s, err := readFid(f, 0, 8192)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	var fields [][]string
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		fields = append(fields, strings.Fields(line))
+	}
+	return fields
+}
+
+func TestRowCtl(t *testing.T) {
+	s := newGoldenScene(t, image.Rect(0, 0, 800, 600), "one\n", "two\n")
+	w0, w1 := s.window(0), s.window(1)
+	rowctl := openFid(nil, Qctl)
+
+	if got, err := readFid(rowctl, 0, 8192); err != nil || got != fmt.Sprintf("%11d %11.2f %11d\n", 0, 0., 2) {
+		t.Errorf("row ctl is %q, %v", got, err)
+	}
+
+	if err := writeFid(rowctl, 0, "addcol 50\n"); err != nil {
+		t.Fatalf("addcol failed: %v", err)
+	}
+	cols := ctlFields(t, rowctl)
+	if len(cols) != 2 || cols[1][0] != "1" || cols[1][1] != "50.00" || cols[1][2] != "0" {
+		t.Fatalf("after addcol, row ctl is %q", cols)
+	}
+
+	// Move both windows into the new column, w1 below w0.
+	col1 := colCtlFid(t, 1)
+	if err := writeFid(col1, 0, fmt.Sprintf("move %d\nmove %d 50\n", w0.id, w1.id)); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	wins := ctlFields(t, col1)
+	if len(wins) != 2 || wins[0][0] != fmt.Sprint(w0.id) || wins[1][0] != fmt.Sprint(w1.id) {
+		t.Fatalf("after move, column ctl is %q", wins)
+	}
+	if c := global.row.col[1]; w0.col != c || w1.col != c {
+		t.Errorf("windows are not in column 1")
+	}
+
+	if err := writeFid(col1, 0, fmt.Sprintf("zoom %d", w1.id)); err != nil {
+		t.Fatalf("zoom failed: %v", err)
+	}
+	if c := global.row.col[1]; c.w[0] != w1 || w1.r.Max.Y != c.r.Max.Y {
+		t.Errorf("zoomed window %d has rectangle %v in column %v", w1.id, w1.r, c.r)
+	}
+
+	if err := writeFid(col1, 0, "pos 25"); err != nil {
+		t.Fatalf("pos failed: %v", err)
+	}
+	if got, want := ctlFields(t, rowctl)[1][1], "25.00"; got != want {
+		t.Errorf("column moved to %s; want %s", got, want)
+	}
+
+	for _, tc := range []struct {
+		f   *Fid
+		msg string
+		err string
+	}{
+		{rowctl, "addcol 100", `bad position "100"`},
+		{rowctl, "newcol", ErrBadCtl.Error()},
+		{col1, "grow", ErrBadCtl.Error()},
+		{col1, "grow 999", "no window 999"},
+		{colCtlFid(t, 0), "pos 10", "can't move the column to 10"},
+		{colCtlFid(t, 0), fmt.Sprintf("grow %d", w0.id), fmt.Sprintf("window %d is not in the column", w0.id)},
+	} {
+		if err := writeFid(tc.f, 0, tc.msg); err == nil || err.Error() != tc.err {
+			t.Errorf("writing %q got error %v; want %q", tc.msg, err, tc.err)
+		}
+	}
+
+	col0 := colCtlFid(t, 0)
+	if err := writeFid(col0, 0, "del\n"); err != nil {
+		t.Fatalf("del failed: %v", err)
+	}
+	if len(global.row.col) != 1 || w0.col != global.row.col[0] {
+		t.Errorf("column 0 was not deleted")
+	}
+	if err := writeFid(col0, 0, "pos 0"); err != ErrDeletedCol {
+		t.Errorf("writing to the deleted column got error %v; want %v", err, ErrDeletedCol)
+	}
+	// The fid for column 1 follows it to index 0.
+	if wins := ctlFields(t, col1); len(wins) != 2 {
+		t.Errorf("after del, column ctl is %q", wins)
+	}
+	if f := colCtlFid(t, 0); f.col != global.row.col[0] {
+		t.Errorf("walk to col/0/ctl got column %p; want %p", f.col, global.row.col[0])
+	}
+
+	file := filepath.Join(t.TempDir(), "edwood.dump")
+	if err := writeFid(rowctl, 0, "dump "+file); err != nil {
+		t.Fatalf("dump failed: %v", err)
+	}
+	dump, err := dumpfile.Load(file)
+	if err != nil {
+		t.Fatalf("can't load dump: %v", err)
+	}
+	if len(dump.Columns) != 1 || len(dump.Windows) != 2 {
+		t.Errorf("dump has %d columns and %d windows; want 1 and 2", len(dump.Columns), len(dump.Windows))
+	}
+}
+
+func TestRowCtlLogEvents(t *testing.T) {
+	s := newGoldenScene(t, image.Rect(0, 0, 800, 600), "one\n", "two\n")
+	w0, w1 := s.window(0), s.window(1)
+
+	x := &Xfid{f: &Fid{}}
+	xfidlogopen(x)
+	defer xfidlogclose(x)
+
+	rowctl := openFid(nil, Qctl)
+	if err := writeFid(rowctl, 0, "addcol 50\naddcol 75\n"); err != nil {
+		t.Fatalf("addcol failed: %v", err)
+	}
+	// Moving column 2 left of column 1 is one event.
+	if err := writeFid(colCtlFid(t, 2), 0, "pos 10\n"); err != nil {
+		t.Fatalf("pos failed: %v", err)
+	}
+	if err := writeFid(colCtlFid(t, 1), 0, fmt.Sprintf("move %d\nmove %d\n", w0.id, w1.id)); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	if err := writeFid(colCtlFid(t, 0), 0, "del\n"); err != nil {
+		t.Fatalf("del failed: %v", err)
+	}
+
+	eventlog.lk.Lock()
+	got := append([]string{}, eventlog.ev[x.f.logoff-eventlog.start:]...)
+	eventlog.lk.Unlock()
+	want := []string{
+		"0 coladd 1\n",
+		"0 coladd 2\n",
+		"0 colmove 2 1\n",
+		fmt.Sprintf("%d move 1 %s\n", w0.id, w0.body.file.Name()),
+		fmt.Sprintf("%d move 1 %s\n", w1.id, w1.body.file.Name()),
+		"0 coldel 0\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got log %q; want %q", got, want)
+	}
+}
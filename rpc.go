@@ -0,0 +1,733 @@
+package main
+
+// An RPC interface for plugins. Each -rpc.listen flag accepts connections
+// on a Unix socket or loopback TCP port, with the same capabilities and
+// shared secret as -fsys.listen. A connection speaks JSON-RPC 2.0 if its
+// first byte is '{' and msgpack-RPC, as used by neovim, otherwise. Either
+// way, responses to calls and notifications from subscriptions share the
+// connection. Each call runs atomically, holding the row lock, on the
+// goroutines that serve the file system.
+//
+// Methods, each taking a single object of named parameters:
+//
+//	window.list         {}                          the WindowCtl of each window
+//	window.read         {id, addr}                  {q0, q1, text} at addr (default ,)
+//	window.edit         {id, addr, text}            replace addr (default .) with text
+//	                                                and select it; returns {q0, q1}
+//	window.subscribe    {id}                        send window.event notifications
+//	window.unsubscribe  {id}
+//	window.sendevent    {id, event}                 hand a Look or Exec event back
+//	edit.run            {id, command}               run an Edit command in window id
+//	                                                (0 for none); returns dot or null
+//	log.subscribe       {}                          send log notifications
+//	log.unsubscribe     {}
+//
+// Addresses are evaluated relative to dot. Subscribing to a window's
+// events opens its event.json file, so the subscriber must hand back the
+// Look and Exec events it doesn't handle. A window.unsubscribed
+// notification reports a subscription ended by the window going away.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"github.com/rjkroege/edwood/msgpack"
+)
+
+var rpcListen listenFlag
+
+func init() {
+	flag.Var(&rpcListen, "rpc.listen", "Also serve the plugin RPC protocol on `addr[,cap...]`, as for -fsys.listen (repeatable)")
+}
+
+// rpclisten starts the listeners named by -rpc.listen.
+func rpclisten() error {
+	for _, spec := range rpcListen {
+		l, caps, secret, err := listenSpec(spec)
+		if err != nil {
+			return fmt.Errorf("-rpc.listen %q: %v", spec, err)
+		}
+		go rpcaccept(l, caps, secret)
+	}
+	return nil
+}
+
+// rpcaccept serves each connection accepted by l, as fsysaccept does.
+func rpcaccept(l net.Listener, caps *fsysCaps, secret []byte) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("rpc: accept on %v: %v", l.Addr(), err)
+			return
+		}
+		go func() {
+			if secret != nil {
+				if err := checkSecret(conn, secret); err != nil {
+					log.Printf("rpc: %v: %v", conn.RemoteAddr(), err)
+					conn.Close()
+					return
+				}
+			}
+			newRPCConn(conn, caps).serve()
+		}()
+	}
+}
+
+// Kinds of rpcMessage, numbered as in msgpack-RPC.
+const (
+	rpcRequest = iota
+	rpcResponse
+	rpcNotification
+)
+
+// rpcMessage is a message of either protocol.
+type rpcMessage struct {
+	kind   int
+	id     any // json.RawMessage for JSON-RPC
+	method string
+	params json.RawMessage
+	result any
+	err    *rpcError
+}
+
+// rpcError is the error of a response.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// Error codes, from JSON-RPC 2.0.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+// rpcCodec reads and writes the messages of one protocol.
+type rpcCodec interface {
+	read() (*rpcMessage, error)
+	write(m *rpcMessage) error
+}
+
+// jsonCodec speaks JSON-RPC 2.0.
+type jsonCodec struct {
+	dec *json.Decoder
+	w   io.Writer
+}
+
+func (jc *jsonCodec) read() (*rpcMessage, error) {
+	var m struct {
+		Version string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+	}
+	if err := jc.dec.Decode(&m); err != nil {
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			return nil, &rpcError{rpcParseError, err.Error()}
+		}
+		return nil, err
+	}
+	rm := &rpcMessage{
+		kind:   rpcRequest,
+		id:     m.ID,
+		method: m.Method,
+		params: m.Params,
+	}
+	if m.ID == nil {
+		rm.kind = rpcNotification
+	}
+	if m.Version != "2.0" || m.Method == "" {
+		rm.err = &rpcError{rpcInvalidRequest, "invalid request"}
+	}
+	return rm, nil
+}
+
+func (jc *jsonCodec) write(m *rpcMessage) error {
+	v := map[string]any{"jsonrpc": "2.0"}
+	switch m.kind {
+	case rpcResponse:
+		v["id"] = m.id
+		if m.id == nil || len(m.id.(json.RawMessage)) == 0 {
+			v["id"] = nil
+		}
+		if m.err != nil {
+			v["error"] = m.err
+		} else {
+			v["result"] = m.result
+		}
+	case rpcNotification:
+		v["method"] = m.method
+		v["params"] = m.result
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = jc.w.Write(append(b, '\n'))
+	return err
+}
+
+// msgpackCodec speaks msgpack-RPC.
+type msgpackCodec struct {
+	dec *msgpack.Decoder
+	w   io.Writer
+}
+
+func (mc *msgpackCodec) read() (*rpcMessage, error) {
+	v, err := mc.dec.Decode()
+	if err != nil {
+		if err == msgpack.ErrFormat {
+			return nil, &rpcError{rpcParseError, err.Error()}
+		}
+		return nil, err
+	}
+	a, _ := v.([]any)
+	bad := &rpcMessage{err: &rpcError{rpcInvalidRequest, "invalid request"}}
+	if len(a) < 3 {
+		return bad, nil
+	}
+	kind, _ := a[0].(int64)
+	switch {
+	case kind == rpcRequest && len(a) == 4:
+		bad.id = a[1]
+		method, ok := jsonable(a[2]).(string)
+		if !ok {
+			return bad, nil
+		}
+		params, err := json.Marshal(jsonable(a[3]))
+		if err != nil {
+			return bad, nil
+		}
+		return &rpcMessage{kind: rpcRequest, id: a[1], method: method, params: params}, nil
+	case kind == rpcNotification && len(a) == 3:
+		method, ok := jsonable(a[1]).(string)
+		params, err := json.Marshal(jsonable(a[2]))
+		if !ok || err != nil {
+			return &rpcMessage{kind: rpcNotification}, nil // ignored
+		}
+		return &rpcMessage{kind: rpcNotification, method: method, params: params}, nil
+	}
+	return bad, nil
+}
+
+func (mc *msgpackCodec) write(m *rpcMessage) error {
+	result, err := unjson(m.result)
+	if err != nil {
+		return err
+	}
+	var v []any
+	switch m.kind {
+	case rpcResponse:
+		var e any
+		if m.err != nil {
+			e = map[string]any{"code": int64(m.err.Code), "message": m.err.Message}
+		}
+		v = []any{int64(rpcResponse), m.id, e, result}
+	case rpcNotification:
+		v = []any{int64(rpcNotification), m.method, []any{result}}
+	}
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = mc.w.Write(b)
+	return err
+}
+
+// jsonable converts the byte strings in a decoded msgpack value, which
+// some clients use for all strings, to strings.
+func jsonable(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case []any:
+		for i := range v {
+			v[i] = jsonable(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = jsonable(v[k])
+		}
+	}
+	return v
+}
+
+// unjson converts v to the plain values msgpack can encode by way of its
+// JSON encoding, so that results are the same in either protocol.
+func unjson(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var u any
+	err = dec.Decode(&u)
+	return u, err
+}
+
+// rpcConn is a connection from a plugin.
+type rpcConn struct {
+	rwc   io.ReadWriteCloser
+	caps  *fsysCaps
+	codec rpcCodec
+	wlk   sync.Mutex // serializes writes
+
+	lk   sync.Mutex
+	subs map[string]*rpcSub // by "window N" or "log"
+
+	servers sync.WaitGroup // the file servers of subscriptions
+}
+
+// rpcSub is a subscription, served over its own 9P connection.
+type rpcSub struct {
+	conn *client.Conn
+	fsys *client.Fsys
+}
+
+func newRPCConn(rwc io.ReadWriteCloser, caps *fsysCaps) *rpcConn {
+	return &rpcConn{
+		rwc:  rwc,
+		caps: caps,
+		subs: make(map[string]*rpcSub),
+	}
+}
+
+// serve handles calls until the connection fails.
+func (c *rpcConn) serve() {
+	defer c.close()
+
+	br := bufio.NewReader(c.rwc)
+	b, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+	if b[0] == '{' {
+		c.codec = &jsonCodec{dec: json.NewDecoder(br), w: c.rwc}
+	} else {
+		c.codec = &msgpackCodec{dec: msgpack.NewDecoder(br), w: c.rwc}
+	}
+	for {
+		m, err := c.codec.read()
+		if err != nil {
+			var re *rpcError
+			if errors.As(err, &re) {
+				c.write(&rpcMessage{kind: rpcResponse, err: re})
+			}
+			return // can't find the next message
+		}
+		var result any
+		if m.err == nil {
+			result, err = c.call(m.method, m.params)
+			m.err = toRPCError(err)
+		}
+		if m.kind == rpcNotification {
+			continue
+		}
+		if err := c.write(&rpcMessage{kind: rpcResponse, id: m.id, result: result, err: m.err}); err != nil {
+			return
+		}
+	}
+}
+
+func (c *rpcConn) write(m *rpcMessage) error {
+	c.wlk.Lock()
+	defer c.wlk.Unlock()
+	return c.codec.write(m)
+}
+
+func (c *rpcConn) notify(method string, params any) error {
+	return c.write(&rpcMessage{kind: rpcNotification, method: method, result: params})
+}
+
+// close ends the connection and its subscriptions, waiting for their
+// file servers to finish.
+func (c *rpcConn) close() {
+	c.lk.Lock()
+	for k, s := range c.subs {
+		s.conn.Close()
+		delete(c.subs, k)
+	}
+	c.lk.Unlock()
+	c.rwc.Close()
+	c.servers.Wait()
+}
+
+func toRPCError(err error) *rpcError {
+	if err == nil {
+		return nil
+	}
+	var re *rpcError
+	if errors.As(err, &re) {
+		return re
+	}
+	return &rpcError{rpcServerError, err.Error()}
+}
+
+var rpcMethods = map[string]func(c *rpcConn, params json.RawMessage) (any, error){
+	"window.list":        (*rpcConn).windowlist,
+	"window.read":        (*rpcConn).windowread,
+	"window.edit":        (*rpcConn).windowedit,
+	"window.subscribe":   (*rpcConn).windowsubscribe,
+	"window.unsubscribe": (*rpcConn).windowunsubscribe,
+	"window.sendevent":   (*rpcConn).windowsendevent,
+	"edit.run":           (*rpcConn).editrun,
+	"log.subscribe":      (*rpcConn).logsubscribe,
+	"log.unsubscribe":    (*rpcConn).logunsubscribe,
+}
+
+func (c *rpcConn) call(method string, params json.RawMessage) (any, error) {
+	f, ok := rpcMethods[method]
+	if !ok {
+		return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q", method)}
+	}
+	return f(c, params)
+}
+
+// rpcParams decodes the parameters of a call into v. They are either an
+// object or an array whose first element is the object.
+func rpcParams(params json.RawMessage, v any) error {
+	params = bytes.TrimSpace(params)
+	if len(params) > 0 && params[0] == '[' {
+		var a []json.RawMessage
+		if err := json.Unmarshal(params, &a); err != nil {
+			return &rpcError{rpcInvalidParams, err.Error()}
+		}
+		params = nil
+		if len(a) > 0 {
+			params = a[0]
+		}
+	}
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{rpcInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+// rpcrun runs f on an Xfid's goroutine, as the file server runs requests,
+// and waits for it to finish.
+func rpcrun(f func()) {
+	x := allocxfid()
+	done := make(chan struct{})
+	x.c <- func(*Xfid) {
+		f()
+		close(done)
+	}
+	<-done
+}
+
+// lookwin returns the window with the given id if the connection may
+// reach it. The row must be locked.
+func (c *rpcConn) lookwin(id int) (*Window, error) {
+	if id <= 0 || !c.caps.canreach(plan9.Qid{Path: QID(id, Qdir)}) {
+		return nil, ErrNotExist
+	}
+	w := global.row.LookupWin(id)
+	if w == nil {
+		return nil, ErrNotExist
+	}
+	return w, nil
+}
+
+// rpcaddr evaluates the address expr in w's body relative to dot. An
+// empty expr is dot.
+func rpcaddr(w *Window, expr string) (Range, error) {
+	t := &w.body
+	dot := Range{t.q0, t.q1}
+	if expr == "" {
+		return dot, nil
+	}
+	r := []rune(expr)
+	a, eval, nr := address(false, t, Range{-1, -1}, dot, 0, len(r),
+		func(q int) rune { return r[q] }, true)
+	if nr < len(r) {
+		return Range{}, ErrBadAddr
+	}
+	if !eval {
+		return Range{}, ErrAddrRange
+	}
+	return a, nil
+}
+
+// rpcText is text in a window's body.
+type rpcText struct {
+	Q0   int    `json:"q0"`
+	Q1   int    `json:"q1"`
+	Text string `json:"text"`
+}
+
+func (c *rpcConn) windowlist(params json.RawMessage) (any, error) {
+	var ctls []*WindowCtl
+	rpcrun(func() {
+		ctls = windowctls(c.caps)
+	})
+	return ctls, nil
+}
+
+func (c *rpcConn) windowread(params json.RawMessage) (any, error) {
+	var p struct {
+		ID   int    `json:"id"`
+		Addr string `json:"addr"`
+	}
+	if err := rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Addr == "" {
+		p.Addr = ","
+	}
+	var (
+		res *rpcText
+		err error
+	)
+	rpcrun(func() {
+		global.row.lk.Lock()
+		defer global.row.lk.Unlock()
+		var w *Window
+		if w, err = c.lookwin(p.ID); err != nil {
+			return
+		}
+		w.Lock('F')
+		defer w.Unlock()
+		t := &w.body
+		w.Commit(t)
+		var a Range
+		if a, err = rpcaddr(w, p.Addr); err != nil {
+			return
+		}
+		buf := make([]rune, a.q1-a.q0)
+		t.ReadB(a.q0, buf)
+		res = &rpcText{Q0: a.q0, Q1: a.q1, Text: string(buf)}
+	})
+	return res, err
+}
+
+func (c *rpcConn) windowedit(params json.RawMessage) (any, error) {
+	var p struct {
+		ID   int    `json:"id"`
+		Addr string `json:"addr"`
+		Text string `json:"text"`
+	}
+	if err := rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	if c.caps != nil && c.caps.readonly {
+		return nil, ErrPermission
+	}
+	var (
+		res *textRange
+		err error
+	)
+	rpcrun(func() {
+		global.row.lk.Lock()
+		defer global.row.lk.Unlock()
+		var w *Window
+		if w, err = c.lookwin(p.ID); err != nil {
+			return
+		}
+		w.Lock('F')
+		defer w.Unlock()
+		t := &w.body
+		w.Commit(t)
+		var a Range
+		if a, err = rpcaddr(w, p.Addr); err != nil {
+			return
+		}
+		r := []rune(p.Text)
+		global.seq++
+		t.file.Mark(global.seq)
+		if a.q1 > a.q0 {
+			t.Delete(a.q0, a.q1, true)
+		}
+		t.Insert(a.q0, r, true)
+		t.SetSelect(a.q0, a.q0+len(r))
+		t.ScrDraw(t.fr.GetFrameFillStatus().Nchars)
+		res = &textRange{Q0: t.q0, Q1: t.q1}
+	})
+	return res, err
+}
+
+func (c *rpcConn) editrun(params json.RawMessage) (any, error) {
+	var p struct {
+		ID      int    `json:"id"`
+		Command string `json:"command"`
+	}
+	if err := rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	if c.caps != nil {
+		return nil, ErrPermission // Edit commands can reach any window
+	}
+	var (
+		res *textRange
+		err error
+	)
+	rpcrun(func() {
+		global.row.lk.Lock()
+		defer global.row.lk.Unlock()
+		ct := &global.row.tag
+		if p.ID != 0 {
+			var w *Window
+			if w, err = c.lookwin(p.ID); err != nil {
+				return
+			}
+			w.Lock('F')
+			defer w.Unlock()
+			ct = &w.body
+		}
+		global.seq++
+		err = runedit(ct, []rune(p.Command))
+		if ct.w != nil {
+			res = &textRange{Q0: ct.q0, Q1: ct.q1}
+		}
+	})
+	return res, err
+}
+
+// subscribe opens the file name over a new 9P connection, registered as
+// key, and sends each line read from it to the plugin, converted by conv,
+// as a notification. When the file ends without the subscription having
+// been cancelled, ended is called.
+func (c *rpcConn) subscribe(key, name string, conv func(line string) (method string, params any), ended func()) error {
+	conn, fsys, done, err := fsysattach(c.caps)
+	c.servers.Add(1)
+	go func() {
+		<-done
+		c.servers.Done()
+	}()
+	if err != nil {
+		return err
+	}
+	fid, err := fsys.Open(name, plan9.OREAD)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s := &rpcSub{conn: conn, fsys: fsys}
+	c.lk.Lock()
+	if c.subs[key] != nil {
+		c.lk.Unlock()
+		conn.Close()
+		return fmt.Errorf("already subscribed")
+	}
+	c.subs[key] = s
+	c.lk.Unlock()
+
+	go func() {
+		br := bufio.NewReader(fid)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				break
+			}
+			if c.notify(conv(strings.TrimSuffix(line, "\n"))) != nil {
+				break
+			}
+		}
+		c.lk.Lock()
+		cancelled := c.subs[key] != s
+		if !cancelled {
+			delete(c.subs, key)
+		}
+		c.lk.Unlock()
+		conn.Close()
+		if !cancelled && ended != nil {
+			ended()
+		}
+	}()
+	return nil
+}
+
+// unsubscribe cancels the subscription registered as key.
+func (c *rpcConn) unsubscribe(key string) error {
+	c.lk.Lock()
+	s := c.subs[key]
+	delete(c.subs, key)
+	c.lk.Unlock()
+	if s == nil {
+		return fmt.Errorf("not subscribed")
+	}
+	s.conn.Close() // ends the blocked read
+	return nil
+}
+
+// rpcWindowParams are the parameters of the window subscription methods.
+type rpcWindowParams struct {
+	ID    int             `json:"id"`
+	Event json.RawMessage `json:"event,omitempty"`
+}
+
+func (c *rpcConn) windowsubscribe(params json.RawMessage) (any, error) {
+	var p rpcWindowParams
+	if err := rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.ID <= 0 {
+		return nil, ErrNotExist
+	}
+	conv := func(line string) (string, any) {
+		return "window.event", &rpcWindowParams{ID: p.ID, Event: json.RawMessage(line)}
+	}
+	ended := func() {
+		c.notify("window.unsubscribed", &rpcWindowParams{ID: p.ID})
+	}
+	return nil, c.subscribe(fmt.Sprintf("window %d", p.ID), fmt.Sprintf("/%d/event.json", p.ID), conv, ended)
+}
+
+func (c *rpcConn) windowunsubscribe(params json.RawMessage) (any, error) {
+	var p rpcWindowParams
+	if err := rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, c.unsubscribe(fmt.Sprintf("window %d", p.ID))
+}
+
+func (c *rpcConn) windowsendevent(params json.RawMessage) (any, error) {
+	var p rpcWindowParams
+	if err := rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	c.lk.Lock()
+	s := c.subs[fmt.Sprintf("window %d", p.ID)]
+	c.lk.Unlock()
+	if s == nil {
+		return nil, fmt.Errorf("not subscribed")
+	}
+	fid, err := s.fsys.Open(fmt.Sprintf("/%d/event.json", p.ID), plan9.OWRITE)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+	_, err = fid.Write(p.Event)
+	return nil, err
+}
+
+func (c *rpcConn) logsubscribe(params json.RawMessage) (any, error) {
+	conv := func(line string) (string, any) {
+		return "log", parseLogEntry(line)
+	}
+	return nil, c.subscribe("log", "/log", conv, nil)
+}
+
+func (c *rpcConn) logunsubscribe(params json.RawMessage) (any, error) {
+	return nil, c.unsubscribe("log")
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/msgpack"
+)
+
+// startXfidThreads runs xfidallocthread until the test ends. The file
+// servers using it must have ended by then, as serveRPC ensures.
+func startXfidThreads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		xfidallocthread(global, ctx, nil)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// serveRPC serves a plugin connection limited to caps until the test ends,
+// returning the plugin's end. Cleaning up waits for the connection and
+// the file servers of its subscriptions to finish.
+func serveRPC(t *testing.T, caps *fsysCaps) net.Conn {
+	c0, c1 := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		newRPCConn(c1, caps).serve()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		c0.Close()
+		<-done
+	})
+	return c0
+}
+
+// rpcTestClient is the plugin end of a JSON-RPC connection.
+type rpcTestClient struct {
+	t      *testing.T
+	enc    *json.Encoder
+	msgs   chan map[string]json.RawMessage
+	id     int
+	events []map[string]json.RawMessage // notifications received
+}
+
+func newRPCTestClient(t *testing.T, caps *fsysCaps) *rpcTestClient {
+	c0 := serveRPC(t, caps)
+
+	c := &rpcTestClient{
+		t:    t,
+		enc:  json.NewEncoder(c0),
+		msgs: make(chan map[string]json.RawMessage, 100),
+	}
+	go func() {
+		defer close(c.msgs)
+		dec := json.NewDecoder(c0)
+		for {
+			var m map[string]json.RawMessage
+			if err := dec.Decode(&m); err != nil {
+				return
+			}
+			c.msgs <- m
+		}
+	}()
+	return c
+}
+
+// call calls method and returns its result or error, keeping any
+// notifications received meanwhile.
+func (c *rpcTestClient) call(method string, params any) (json.RawMessage, *rpcError) {
+	c.t.Helper()
+	c.id++
+	if err := c.enc.Encode(map[string]any{"jsonrpc": "2.0", "id": c.id, "method": method, "params": params}); err != nil {
+		c.t.Fatalf("%v: write failed: %v", method, err)
+	}
+	for m := range c.msgs {
+		if _, ok := m["method"]; ok {
+			c.events = append(c.events, m)
+			continue
+		}
+		var id int
+		if err := json.Unmarshal(m["id"], &id); err != nil || id != c.id {
+			c.t.Fatalf("%v: response has id %s; want %d", method, m["id"], c.id)
+		}
+		if e, ok := m["error"]; ok {
+			var re rpcError
+			json.Unmarshal(e, &re)
+			return nil, &re
+		}
+		return m["result"], nil
+	}
+	c.t.Fatalf("%v: connection closed", method)
+	return nil, nil
+}
+
+// notification waits for the next notification.
+func (c *rpcTestClient) notification() map[string]json.RawMessage {
+	c.t.Helper()
+	if len(c.events) == 0 {
+		m, ok := <-c.msgs
+		if !ok {
+			c.t.Fatalf("connection closed while waiting for a notification")
+		}
+		c.events = append(c.events, m)
+	}
+	m := c.events[0]
+	c.events = c.events[1:]
+	return m
+}
+
+func TestRPC(t *testing.T) {
+	FlexiblyMakeWindowScaffold(t, ScWin("/a/file"), ScBody("/a/file", "one\ntwo\nthree\n"))
+	w := global.row.col[0].w[0]
+	w.id = 1
+	startXfidThreads(t)
+	c := newRPCTestClient(t, nil)
+
+	body := func() string {
+		buf := make([]rune, w.body.Nc())
+		w.body.ReadB(0, buf)
+		return string(buf)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		params any
+		want   string
+		body   string
+	}{
+		{"Read", "window.read", map[string]any{"id": 1, "addr": "2"},
+			`{"q0":4,"q1":8,"text":"two\n"}`, "one\ntwo\nthree\n"},
+		{"ReadAll", "window.read", []any{map[string]any{"id": 1}},
+			`{"q0":0,"q1":14,"text":"one\ntwo\nthree\n"}`, "one\ntwo\nthree\n"},
+		{"Edit", "window.edit", map[string]any{"id": 1, "addr": "2", "text": "TWO\n2\n"},
+			`{"q0":4,"q1":10}`, "one\nTWO\n2\nthree\n"},
+		{"EditDot", "window.edit", map[string]any{"id": 1, "text": "zwei\n"},
+			`{"q0":4,"q1":9}`, "one\nzwei\nthree\n"},
+		{"EditRun", "edit.run", map[string]any{"id": 1, "command": ",x/e/c/E/"},
+			`{"q0":12,"q1":14}`, "onE\nzwEi\nthrEE\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c.t = t
+			got, err := c.call(tc.method, tc.params)
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("got result %s; want %s", got, tc.want)
+			}
+			if got := body(); got != tc.body {
+				t.Errorf("got body %q; want %q", got, tc.body)
+			}
+		})
+	}
+
+	t.Run("List", func(t *testing.T) {
+		c.t = t
+		got, err := c.call("window.list", nil)
+		if err != nil {
+			t.Fatalf("got error %v", err)
+		}
+		var ctls []WindowCtl
+		if err := json.Unmarshal(got, &ctls); err != nil {
+			t.Fatalf("bad result %s: %v", got, err)
+		}
+		if len(ctls) != 1 || ctls[0].ID != 1 || ctls[0].Name != "/a/file" {
+			t.Errorf("got windows %+v; want only /a/file", ctls)
+		}
+	})
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		params any
+		code   int
+	}{
+		{"UnknownMethod", "window.frob", nil, rpcMethodNotFound},
+		{"BadParams", "window.read", map[string]any{"id": "one"}, rpcInvalidParams},
+		{"NoWindow", "window.read", map[string]any{"id": 2}, rpcServerError},
+		{"BadAddr", "window.edit", map[string]any{"id": 1, "addr": "/nothere/"}, rpcServerError},
+		{"BadEdit", "edit.run", map[string]any{"id": 1, "command": "2,1d"}, rpcServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c.t = t
+			if _, err := c.call(tc.method, tc.params); err == nil || err.Code != tc.code {
+				t.Errorf("got error %v; want code %v", err, tc.code)
+			}
+		})
+	}
+}
+
+func TestRPCSubscribe(t *testing.T) {
+	FlexiblyMakeWindowScaffold(t, ScWin("/a/file"), ScBody("/a/file", "one\n"))
+	w := global.row.col[0].w[0]
+	w.id = 1
+	startXfidThreads(t)
+	c := newRPCTestClient(t, nil)
+
+	if _, err := c.call("window.subscribe", map[string]any{"id": 1}); err != nil {
+		t.Fatalf("window.subscribe failed: %v", err)
+	}
+	if _, err := c.call("window.subscribe", map[string]any{"id": 1}); err == nil {
+		t.Errorf("second window.subscribe succeeded")
+	}
+	if _, err := c.call("window.edit", map[string]any{"id": 1, "addr": "$", "text": "two\n"}); err != nil {
+		t.Fatalf("window.edit failed: %v", err)
+	}
+
+	m := c.notification()
+	if got, want := string(m["method"]), `"window.event"`; got != want {
+		t.Fatalf("got notification %s; want %s", got, want)
+	}
+	var p struct {
+		ID    int   `json:"id"`
+		Event Event `json:"event"`
+	}
+	if err := json.Unmarshal(m["params"], &p); err != nil {
+		t.Fatalf("bad notification params %s: %v", m["params"], err)
+	}
+	want := Event{Origin: "F", Type: "I", Q0: 4, Q1: 8, Text: "two\n"}
+	if diff := cmp.Diff(want, p.Event); p.ID != 1 || diff != "" {
+		t.Errorf("window %d event mismatch (-want +got):\n%s", p.ID, diff)
+	}
+
+	if _, err := c.call("window.unsubscribe", map[string]any{"id": 1}); err != nil {
+		t.Fatalf("window.unsubscribe failed: %v", err)
+	}
+	if _, err := c.call("window.unsubscribe", map[string]any{"id": 1}); err == nil {
+		t.Errorf("second window.unsubscribe succeeded")
+	}
+}
+
+func TestRPCReadOnly(t *testing.T) {
+	FlexiblyMakeWindowScaffold(t, ScWin("/a/file"), ScBody("/a/file", "one\n"))
+	global.row.col[0].w[0].id = 1
+	startXfidThreads(t)
+	c := newRPCTestClient(t, &fsysCaps{readonly: true})
+
+	if _, err := c.call("window.read", map[string]any{"id": 1}); err != nil {
+		t.Errorf("window.read failed: %v", err)
+	}
+	for _, method := range []string{"window.edit", "edit.run"} {
+		if _, err := c.call(method, map[string]any{"id": 1, "command": "d"}); err == nil {
+			t.Errorf("%v succeeded on a read-only connection", method)
+		}
+	}
+}
+
+func TestRPCMsgpack(t *testing.T) {
+	FlexiblyMakeWindowScaffold(t, ScWin("/a/file"), ScBody("/a/file", "one\ntwo\n"))
+	global.row.col[0].w[0].id = 1
+	startXfidThreads(t)
+
+	c0 := serveRPC(t, nil)
+
+	for _, tc := range []struct {
+		name    string
+		request []any
+		want    []any
+	}{
+		{
+			"Read",
+			[]any{int64(0), int64(7), "window.read", []any{map[string]any{"id": int64(1), "addr": "2"}}},
+			[]any{int64(1), int64(7), nil, map[string]any{"q0": int64(4), "q1": int64(8), "text": "two\n"}},
+		},
+		{
+			"BinaryStrings",
+			[]any{int64(0), int64(8), []byte("window.read"), []any{map[string]any{"id": int64(1), "addr": []byte("1")}}},
+			[]any{int64(1), int64(8), nil, map[string]any{"q0": int64(0), "q1": int64(4), "text": "one\n"}},
+		},
+		{
+			"Error",
+			[]any{int64(0), int64(9), "window.frob", []any{}},
+			[]any{int64(1), int64(9), map[string]any{"code": int64(rpcMethodNotFound), "message": `unknown method "window.frob"`}, nil},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := msgpack.Marshal(tc.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c0.Write(b); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			got, err := msgpack.NewDecoder(bufio.NewReader(c0)).Decode()
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("response mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRPCParams(t *testing.T) {
+	var p struct {
+		ID int `json:"id"`
+	}
+	for _, tc := range []struct {
+		params string
+		want   int
+		ok     bool
+	}{
+		{`{"id":3}`, 3, true},
+		{`[{"id":4}]`, 4, true},
+		{`[]`, 0, true},
+		{`null`, 0, true},
+		{``, 0, true},
+		{`{"id":"x"}`, 0, false},
+	} {
+		p.ID = 0
+		err := rpcParams(json.RawMessage(tc.params), &p)
+		if (err == nil) != tc.ok || p.ID != tc.want {
+			t.Errorf("rpcParams(%s) gave id %d, error %v; want %d, ok %v", tc.params, p.ID, err, tc.want, tc.ok)
+		}
+	}
+	if err := rpcParams(json.RawMessage(` [1] `), &bytes.Buffer{}); err == nil {
+		t.Errorf("rpcParams decoded a number into a struct")
+	}
+}
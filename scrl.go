@@ -69,7 +69,7 @@ func (t *Text) ScrDraw(nchars int) {
 		b         draw.Image
 	)
 
-	if t.w == nil || t != &t.w.body {
+	if t.w == nil || t != &t.w.body || t.deferdraw {
 		return
 	}
 	if scrtmp == nil {
@@ -116,7 +116,7 @@ func (t *Text) Scroll(but int) {
 		}
 		if !global.mouse.Point.Eq(image.Pt(x, my)) {
 			t.display.MoveTo(image.Pt(x, my))
-			global.mousectl.Read() // absorb event generated by moveto()
+			global.readmouse() // absorb event generated by moveto()
 		}
 		if but == 2 {
 			y = my
@@ -128,7 +128,7 @@ func (t *Text) Scroll(but int) {
 				t.SetOrigin(p0, false)
 			}
 			oldp0 = p0
-			global.mousectl.Read()
+			global.readmouse()
 			if global.mouse.Buttons&(1<<uint(but-1)) == 0 {
 				break
 			}
@@ -156,6 +156,6 @@ func (t *Text) Scroll(but int) {
 		}
 	}
 	for global.mouse.Buttons != 0 {
-		global.mousectl.Read()
+		global.readmouse()
 	}
 }
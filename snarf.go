@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+
+	"9fans.net/go/plan9"
+)
+
+// snarfwatch numbers the versions of the snarf buffer so that readers of
+// the fsys's acme/snarf pseudo-file can wait for it to change. Changes
+// made by other programs through the platform's clipboard are only seen
+// when Edwood next reads the clipboard, e.g. for Paste.
+var snarfwatch snarfWatch
+
+type snarfWatch struct {
+	lk sync.Mutex
+	r  sync.Cond
+
+	vers int
+
+	// active (blocked) reads waiting for a change
+	read []*Xfid
+}
+
+// changed records a new version of the snarf buffer. The row must be
+// locked.
+func (sw *snarfWatch) changed() {
+	sw.lk.Lock()
+	defer sw.lk.Unlock()
+	sw.vers++
+	if sw.r.L != nil {
+		sw.r.Broadcast()
+	}
+}
+
+// gotsnarf sets the snarf buffer to b, read from the platform's
+// clipboard.
+func gotsnarf(b []byte) {
+	if !bytes.Equal(b, global.snarfbuf) {
+		snarfwatch.changed()
+	}
+	global.snarfbuf = b
+}
+
+func (sw *snarfWatch) version() int {
+	sw.lk.Lock()
+	defer sw.lk.Unlock()
+	return sw.vers
+}
+
+// wait blocks x until the snarf buffer is newer than version vers. It
+// reports false if x was flushed.
+func (sw *snarfWatch) wait(x *Xfid, vers int) bool {
+	sw.lk.Lock()
+	defer sw.lk.Unlock()
+	if sw.r.L == nil {
+		sw.r.L = &sw.lk
+	}
+
+	sw.read = append(sw.read, x)
+	x.flushed = false
+	for sw.vers == vers && !x.flushed {
+		sw.r.Wait()
+	}
+	for i, rx := range sw.read {
+		if rx == x {
+			sw.read[i] = sw.read[len(sw.read)-1]
+			sw.read = sw.read[:len(sw.read)-1]
+			break
+		}
+	}
+	return !x.flushed
+}
+
+func xfidsnarfflush(x *Xfid) {
+	snarfwatch.lk.Lock()
+	defer snarfwatch.lk.Unlock()
+	for _, rx := range snarfwatch.read {
+		if rx.fs == x.fs && rx.fcall.Tag == x.fcall.Oldtag {
+			rx.flushed = true
+			snarfwatch.r.Broadcast()
+		}
+	}
+}
+
+// xfidsnarfhangup ends the snarf reads left blocked by a client of fs
+// that went away.
+func xfidsnarfhangup(fs *fileServer) {
+	snarfwatch.lk.Lock()
+	defer snarfwatch.lk.Unlock()
+	for _, rx := range snarfwatch.read {
+		if rx.fs == fs {
+			rx.flushed = true
+			snarfwatch.r.Broadcast()
+		}
+	}
+}
+
+// xfidsnarfread reads the snarf buffer. A read at offset 0 takes a new
+// copy of the buffer for the fid to read from, except that once the fid
+// has read all of a copy, the read waits until the snarf changes.
+func xfidsnarfread(x *Xfid) {
+	var fc plan9.Fcall
+	off := x.fcall.Offset
+	if off == 0 {
+		if x.f.snarfeof && !snarfwatch.wait(x, x.f.snarfvers) {
+			return
+		}
+		global.row.lk.Lock()
+		acmegetsnarf()
+		x.f.snarf = global.snarfbuf
+		x.f.snarfvers = snarfwatch.version()
+		global.row.lk.Unlock()
+	}
+
+	b := x.f.snarf
+	if off > uint64(len(b)) {
+		off = uint64(len(b))
+	}
+	b = b[off:]
+	if len(b) > int(x.fcall.Count) {
+		b = b[:x.fcall.Count]
+	}
+	x.f.snarfeof = int(off)+len(b) == len(x.f.snarf)
+	fc.Data = b
+	fc.Count = uint32(len(b))
+	x.respond(&fc, nil)
+}
+
+// xfidsnarfwrite replaces the snarf buffer with the data written at
+// offset 0 and appends the data written at other offsets.
+func xfidsnarfwrite(x *Xfid) {
+	global.row.lk.Lock()
+	b := global.snarfbuf
+	if x.fcall.Offset == 0 {
+		b = nil
+	}
+	// Copy rather than append in place: fids may be reading the old buffer.
+	global.snarfbuf = append(b[:len(b):len(b)], x.fcall.Data...)
+	acmeputsnarf()
+	global.row.lk.Unlock()
+
+	fc := plan9.Fcall{
+		Count: uint32(len(x.fcall.Data)),
+	}
+	x.respond(&fc, nil)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/edwoodtest"
+)
+
+func TestSnarf(t *testing.T) {
+	defer func(d draw.Display, b []byte) {
+		global.row.display, global.snarfbuf = d, b
+	}(global.row.display, global.snarfbuf)
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	global.row.display = display
+	global.snarfbuf = nil
+
+	wf := openFid(nil, Qsnarf)
+	writeFid(wf, 0, "hel")
+	writeFid(wf, 3, "lo")
+	if got, want := string(global.snarfbuf), "hello"; got != want {
+		t.Errorf("snarf buffer is %q; want %q", got, want)
+	}
+	b := make([]byte, 10)
+	n, _, _ := display.ReadSnarf(b)
+	if got, want := string(b[:n]), "hello"; got != want {
+		t.Errorf("display's snarf buffer is %q; want %q", got, want)
+	}
+
+	rf := openFid(nil, Qsnarf)
+	if got, err := readFid(rf, 0, 3); err != nil || got != "hel" {
+		t.Errorf("first read got %q, %v; want %q", got, err, "hel")
+	}
+	if got, err := readFid(rf, 3, 3); err != nil || got != "lo" {
+		t.Errorf("second read got %q, %v; want %q", got, err, "lo")
+	}
+
+	// Having read all of the snarf, a read from the start waits for a change.
+	done := make(chan string)
+	go func() {
+		s, _ := readFid(rf, 0, 100)
+		done <- s
+	}()
+	select {
+	case s := <-done:
+		t.Fatalf("read returned %q before the snarf changed", s)
+	case <-time.After(10 * time.Millisecond):
+	}
+	writeFid(wf, 0, "world")
+	select {
+	case s := <-done:
+		if s != "world" {
+			t.Errorf("waiting read got %q; want %q", s, "world")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read did not return")
+	}
+
+	// The snarf is read afresh from the display.
+	display.WriteSnarf([]byte("pasted"))
+	if got, err := readFid(openFid(nil, Qsnarf), 0, 100); err != nil || got != "pasted" {
+		t.Errorf("read got %q, %v; want %q", got, err, "pasted")
+	}
+
+	// Flush ends a waiting read without a response, but only a flush
+	// from the reader's own connection.
+	readFid(rf, 0, 100) // catch up with "pasted"
+	mr := new(mockResponder)
+	go func() {
+		xfidread(&Xfid{f: rf, fcall: plan9.Fcall{Tag: 2, Count: 100}, fs: mr})
+		if mr.fcall != nil {
+			done <- "responded"
+			return
+		}
+		done <- ""
+	}()
+	time.Sleep(10 * time.Millisecond)
+	xfidsnarfflush(&Xfid{fcall: plan9.Fcall{Oldtag: 2}, fs: new(mockResponder)})
+	select {
+	case s := <-done:
+		t.Fatalf("read ended by another connection's flush: %q", s)
+	case <-time.After(10 * time.Millisecond):
+	}
+	xfidsnarfflush(&Xfid{fcall: plan9.Fcall{Oldtag: 2}, fs: mr})
+	select {
+	case s := <-done:
+		if s != "" {
+			t.Errorf("flushed read %s", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("flushed read did not return")
+	}
+}
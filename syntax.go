@@ -0,0 +1,99 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// A syntaxExpander finds the smallest syntactic unit of the source src
+// that strictly encloses the bytes p0 to p1, returning its byte extent.
+// It returns false when there's none, as when p0 to p1 is already the
+// whole file.
+type syntaxExpander func(src []byte, p0, p1 int) (int, int, bool)
+
+// syntaxExpanders holds the syntax expander of each language, by the
+// extension of the names of its files. Adding a language is adding an
+// expander here.
+var syntaxExpanders = map[string]syntaxExpander{
+	".go": goExpand,
+}
+
+// ExpandSyntax returns the selection q0 to q1 of the body grown to the
+// syntactic unit enclosing it, if the language of the file is known.
+func (t *Text) ExpandSyntax(q0, q1 int) (int, int, bool) {
+	if t.what != Body {
+		return q0, q1, false
+	}
+	expand := syntaxExpanders[filepath.Ext(t.file.Name())]
+	if expand == nil {
+		return q0, q1, false
+	}
+	s := t.file.String()
+	p0, p1, ok := expand([]byte(s), byteoffset(s, q0), byteoffset(s, q1))
+	if !ok {
+		return q0, q1, false
+	}
+	r0 := utf8.RuneCountInString(s[:p0])
+	return r0, r0 + utf8.RuneCountInString(s[p0:p1]), true
+}
+
+// grow implements the Grow command: it expands the selection in the body
+// of et's window to the syntactic unit enclosing it.
+func grow(et, _, _ *Text, _, _ bool, _ string) {
+	if et == nil || et.w == nil {
+		return
+	}
+	t := &et.w.body
+	if syntaxExpanders[filepath.Ext(t.file.Name())] == nil {
+		warning(nil, "Grow: no syntax known for %s\n", t.file.Name())
+		return
+	}
+	if q0, q1, ok := t.ExpandSyntax(t.q0, t.q1); ok {
+		t.Show(q0, q1, true)
+	}
+}
+
+// byteoffset returns the offset in bytes of the rune offset q in s.
+func byteoffset(s string, q int) int {
+	for i := range s {
+		if q == 0 {
+			return i
+		}
+		q--
+	}
+	return len(s)
+}
+
+// goExpand is the syntax expander of Go. The units are the nodes of the
+// syntax tree, so an identifier grows to the expressions holding it, then
+// its statement, the enclosing blocks, the function and its file. Syntax
+// errors are tolerated, expanding within what can be parsed.
+func goExpand(src []byte, p0, p1 int) (int, int, bool) {
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if f == nil || !f.Package.IsValid() {
+		// Without its package clause, the file has no positions.
+		return 0, 0, false
+	}
+	base := fset.File(f.Package).Base()
+	q0, q1, ok := 0, 0, false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		n0, n1 := int(n.Pos())-base, int(n.End())-base
+		if n0 > p0 || n1 < p1 {
+			return false
+		}
+		// The innermost larger node wins. Where nodes abut, keep the first
+		// found that holds an empty selection.
+		if n1-n0 > p1-p0 && (!ok || q0 <= n0 && n1 <= q1) {
+			q0, q1, ok = n0, n1, true
+		}
+		return true
+	})
+	return q0, q1, ok
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/file"
+)
+
+func TestExpandSyntax(t *testing.T) {
+	const src = `package p
+
+// ☺
+func F(a, b int) int {
+	if a > 0 {
+		return a + b*2
+	}
+	return 0
+}
+`
+	for _, tc := range []struct {
+		name, file, src string
+		at              string // the selection starts empty before this
+		want            []string
+	}{
+		{
+			"go",
+			"x.go", src, "b*2",
+			[]string{
+				"b",
+				"b*2",
+				"a + b*2",
+				"return a + b*2",
+				"{\n\t\treturn a + b*2\n\t}",
+				"if a > 0 {\n\t\treturn a + b*2\n\t}",
+				src[strings.Index(src, "{") : strings.LastIndex(src, "}")+1],
+				src[strings.Index(src, "func") : strings.LastIndex(src, "}")+1],
+				src[:strings.LastIndex(src, "}")+1],
+			},
+		},
+		{
+			"errors",
+			"x.go", "package p\n\nvar = 3\n\nfunc G() { g(1) }\n", "1)",
+			[]string{"1", "g(1)", "{ g(1) }", "func G() { g(1) }", "package p\n\nvar = 3\n\nfunc G() { g(1) }"},
+		},
+		{"not go", "x.c", src, "b*2", nil},
+		{"empty", "x.go", "", "", nil},
+		{"no package", "x.go", "x := 1\n", "1", nil},
+		{"package only", "x.go", "package", "age", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			text := &Text{
+				what: Body,
+				file: file.MakeObservableEditableBuffer(tc.file, []rune(tc.src)),
+			}
+			q := len([]rune(tc.src[:strings.Index(tc.src, tc.at)]))
+			q0, q1 := q, q
+			var got []string
+			for {
+				var ok bool
+				q0, q1, ok = text.ExpandSyntax(q0, q1)
+				if !ok {
+					break
+				}
+				got = append(got, string([]rune(tc.src)[q0:q1]))
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("expansions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDoubleClickGrows(t *testing.T) {
+	text := &Text{
+		what: Body,
+		file: file.MakeObservableEditableBuffer("x.go", []rune("package p\n\nvar x = f(ab.cd)\n")),
+	}
+	dbltext, clicktext = nil, nil
+	defer func() { dbltext, clicktext = nil, nil }()
+	q := strings.Index(text.file.String(), "cd") + 1
+
+	// click puts the selection at q, as the first click of a double-click
+	// does, remembering the selection before.
+	click := func() {
+		clickq0, clickq1 = text.q0, text.q1
+		text.q0, text.q1 = q, q
+	}
+	for _, want := range []string{"cd", "ab.cd", "f(ab.cd)", "x = f(ab.cd)", "var x = f(ab.cd)"} {
+		click()
+		q0, q1 := text.doubleclick(q)
+		if got := text.file.String()[q0:q1]; got != want {
+			t.Errorf("double-click selected %q; want %q", got, want)
+		}
+		text.q0, text.q1 = q0, q1
+	}
+
+	// Double-clicking after another selection starts over.
+	text.q0, text.q1 = 0, 1
+	click()
+	q0, q1 := text.doubleclick(q)
+	if got, want := text.file.String()[q0:q1], "cd"; got != want {
+		t.Errorf("double-click after selecting elsewhere selected %q; want %q", got, want)
+	}
+}
@@ -80,7 +80,8 @@ type Text struct {
 	iq1 int
 	eq0 int // When 0, typing has started
 
-	nofill bool // When true, updates to the Text shouldn't update the frame.
+	nofill    bool // When true, updates to the Text shouldn't update the frame.
+	deferdraw bool // When true, the frame is stale until redrawdeferred.
 
 	lk sync.Mutex
 }
@@ -196,6 +197,7 @@ func (t *Text) Close() {
 	if err := t.file.DelObserver(t); err != nil {
 		log.Panicf("acme: %s: %v\n", err.Error(), nil)
 	}
+	lspclose(t)
 	t.file = nil
 	if global.argtext == t {
 		global.argtext = nil
@@ -212,6 +214,9 @@ func (t *Text) Close() {
 	if global.barttext == t {
 		global.barttext = nil
 	}
+	if global.completion != nil && global.completion.t == t {
+		global.completion = nil
+	}
 }
 
 func (t *Text) Columnate(names []string, widths []int) {
@@ -370,7 +375,11 @@ func (t *Text) Load(q0 int, filename string, setqid bool) (nread int, err error)
 		q1 := t.file.Nr()
 		return q1 - q0, nil
 	}
-	return t.loadReader(q0, filename, fd, setqid && q0 == 0)
+	nread, err = t.loadReader(q0, filename, fd, setqid && q0 == 0)
+	if err == nil && q0 == 0 {
+		lspopen(t)
+	}
+	return nread, err
 }
 
 // appendReadmeContent reads a README file from the directory and
@@ -490,6 +499,13 @@ func (t *Text) Inserted(oq0 file.OffsetTuple, b []byte, nr int) {
 	}
 	if t.what == Body {
 		t.w.utflastqid = -1
+		if t.w.changes != nil {
+			t.w.changes.Inserted(oq0, b, nr)
+		}
+		lspinserted(t, q0, b)
+		if t.w.outline != nil {
+			t.w.outline.changed()
+		}
 	}
 
 	if q0 < t.iq1 {
@@ -504,7 +520,7 @@ func (t *Text) Inserted(oq0 file.OffsetTuple, b []byte, nr int) {
 	if q0 < t.org {
 		t.org += nr
 	} else {
-		if t.fr != nil && q0 <= t.org+(t.fr.GetFrameFillStatus().Nchars) {
+		if t.fr != nil && !t.deferdraw && q0 <= t.org+(t.fr.GetFrameFillStatus().Nchars) {
 			t.fr.InsertByte(b, q0-t.org)
 		}
 	}
@@ -537,6 +553,9 @@ func (t *Text) logInsert(oq0 file.OffsetTuple, b []byte, nr int) {
 		} else {
 			t.w.Eventf("%c%d %d 0 0 \n", c, q0, q0+nr)
 		}
+		if t.w.nopen[QWeventjson] > 0 {
+			t.w.EventJSON(&Event{Type: string(c), Q0: q0, Q1: q0 + nr, Text: string(b)})
+		}
 	}
 }
 
@@ -634,6 +653,13 @@ func (t *Text) Deleted(oq0, oq1 file.OffsetTuple) {
 	n := q1 - q0
 	if t.what == Body {
 		t.w.utflastqid = -1
+		if t.w.changes != nil {
+			t.w.changes.Deleted(oq0, oq1)
+		}
+		lspdeleted(t, q0, q1)
+		if t.w.outline != nil {
+			t.w.outline.changed()
+		}
 	}
 	if q0 < t.iq1 {
 		t.iq1 -= min(n, t.iq1-q0)
@@ -646,6 +672,8 @@ func (t *Text) Deleted(oq0, oq1 file.OffsetTuple) {
 	}
 	if q1 <= t.org {
 		t.org -= n
+	} else if t.deferdraw {
+		t.org = min(t.org, q0)
 	} else if t.fr != nil && q0 < t.org+(t.fr.GetFrameFillStatus().Nchars) {
 		p1 := q1 - t.org
 		if p1 > (t.fr.GetFrameFillStatus().Nchars) {
@@ -678,6 +706,7 @@ func (t *Text) logInsertDelete(q0, q1 int) {
 			c = 'D'
 		}
 		t.w.Eventf("%c%d %d 0 0 \n", c, q0, q1)
+		t.w.EventJSON(&Event{Type: string(c), Q0: q0, Q1: q1})
 	}
 }
 
@@ -742,6 +771,9 @@ func (t *Text) Complete() []rune {
 	if t.q0 < t.Nc() && t.file.ReadC(t.q0) > ' ' { // must be at end of word
 		return nil
 	}
+	if r, ok := t.completeFromProvider(); ok {
+		return r
+	}
 	str := make([]rune, t.FileWidth(t.q0, true))
 	q := t.q0 - len(str)
 	for i := range str {
@@ -766,6 +798,14 @@ func (t *Text) Complete() []rune {
 	}
 
 	c, err := complete.Complete(dir, string(str))
+	if (err != nil || c.NMatch == 0) && len(path) == 0 {
+		// no file matches; try the words in the open windows
+		if prefix := t.wordPrefix(); prefix != "" {
+			if wc := t.completeWord(prefix); wc.NMatch > 0 {
+				return t.listWords(prefix, wc)
+			}
+		}
+	}
 	if err != nil {
 		warning(nil, "error attempting completion: %v\n", err)
 		return nil
@@ -773,6 +813,9 @@ func (t *Text) Complete() []rune {
 	if c.Advance {
 		return []rune(c.String)
 	}
+	if c.NMatch > 0 && t.popupCompletion(t.q0-len(str), t.q0, c.Filename) {
+		return nil
+	}
 	var b bytes.Buffer
 	b.WriteString(dir)
 	if len(dir) > 0 && dir[len(dir)-1] != filepath.Separator {
@@ -789,12 +832,42 @@ func (t *Text) Complete() []rune {
 	return nil
 }
 
+// maxWordList bounds the number of candidates listed by word completion.
+const maxWordList = 50
+
+// listWords returns the extension of prefix from word completion c or, if
+// there is none, shows the candidates in a popup or failing that lists
+// them in +Errors.
+func (t *Text) listWords(prefix string, c *complete.Completion) []rune {
+	if c.Advance {
+		return []rune(c.String)
+	}
+	if t.popupCompletion(t.q0-len([]rune(prefix)), t.q0, c.Filename) {
+		return nil
+	}
+	warning(nil, "%s*\n", prefix)
+	for i, w := range c.Filename {
+		if i == maxWordList {
+			warning(nil, " ...\n")
+			break
+		}
+		warning(nil, " %s\n", w)
+	}
+	return nil
+}
+
 func (t *Text) Type(r rune) {
 	var (
 		q0, q1    int
 		nnb, n, i int
 		nr        int
 	)
+	if p := global.completion; p != nil && p.t == t {
+		if p.Type(r) {
+			return
+		}
+		p.Close()
+	}
 	// Avoid growing column and row tags.
 	if t.what != Body && t.what != Tag && r == '\n' {
 		return
@@ -970,7 +1043,9 @@ func (t *Text) Type(r rune) {
 	}
 
 	// Note the use of eq0 to always force an undo point at the start typing.
-	if t.what == Body && t.eq0 == -1 {
+	// Typing ends a transaction holding the latest changes, starting one
+	// too.
+	if t.what == Body && (t.breaktxn() || t.eq0 == -1) {
 		setUndoPoint()
 	}
 
@@ -1155,6 +1230,12 @@ func (t *Text) FrameScroll(fr frame.SelectScrollUpdater, dl int) {
 var (
 	clicktext *Text
 	clickmsec uint32
+	// The selection in clicktext before it was clicked.
+	clickq0, clickq1 int
+	// The last selection made by double-clicking, which double-clicking
+	// inside again grows.
+	dbltext      *Text
+	dblq0, dblq1 int
 	// TODO(rjk): Replace with closure.
 	selecttext *Text
 	selectq    int
@@ -1177,11 +1258,12 @@ func (t *Text) Select() {
 	b := global.mouse.Buttons
 	q0 := t.q0
 	q1 := t.q1
+	sq0, sq1 := q0, q1
 	selectq = t.org + t.fr.Charofpt(global.mouse.Point)
 	//	fmt.Printf("Text.Select: mouse.Msec %v, clickmsec %v\n", mouse.Msec, clickmsec)
 	//	fmt.Printf("clicktext==t %v, (q0==q1 && selectq==q0): %v", clicktext == t, q0 == q1 && selectq == q0)
 	if (clicktext == t && global.mouse.Msec-clickmsec < 500) && (q0 == q1 && selectq == q0) {
-		q0, q1 = t.DoubleClick(q0, q1)
+		q0, q1 = t.doubleclick(q0)
 		t.SetSelect(q0, q1)
 		t.display.Flush()
 		x := global.mouse.Point.X
@@ -1189,7 +1271,7 @@ func (t *Text) Select() {
 		// stay here until something interesting happens
 		// TODO(rjk): Ack. This is horrible? Layering violation?
 		for {
-			global.mousectl.Read()
+			global.readmouse()
 			if !(global.mouse.Buttons == b && max(global.mouse.Point.X-x, -(global.mouse.Point.X-x)) < 3 && max(global.mouse.Point.Y-y, -(global.mouse.Point.Y-y)) < 3) {
 				break
 			}
@@ -1220,11 +1302,12 @@ func (t *Text) Select() {
 	}
 	if q0 == q1 {
 		if q0 == t.q0 && clicktext == t && global.mouse.Msec-clickmsec < 500 {
-			q0, q1 = t.DoubleClick(q0, q1)
+			q0, q1 = t.doubleclick(q0)
 			clicktext = nil
 		} else {
 			clicktext = t
 			clickmsec = global.mouse.Msec
+			clickq0, clickq1 = sq0, sq1
 		}
 	} else {
 		clicktext = nil
@@ -1268,7 +1351,7 @@ func (t *Text) Select() {
 		}
 		t.display.Flush()
 		for global.mouse.Buttons == b {
-			global.mousectl.Read()
+			global.readmouse()
 		}
 		clicktext = nil
 	}
@@ -1284,7 +1367,7 @@ func (t *Text) Show(q0, q1 int, doselect bool) {
 		nc  int
 		q   int
 	)
-	if t.what != Body {
+	if t.what != Body || t.deferdraw {
 		if doselect {
 			t.SetSelect(q0, q1)
 		}
@@ -1317,7 +1400,7 @@ func (t *Text) Show(q0, q1 int, doselect bool) {
 	if tsd {
 		t.ScrDraw(t.fr.GetFrameFillStatus().Nchars)
 	} else {
-		if t.w.nopen[QWevent] > 0 {
+		if t.w.eventsopen() {
 			nl = 3 * t.fr.GetFrameFillStatus().Maxlines / 4
 		} else {
 			nl = t.fr.GetFrameFillStatus().Maxlines / 4
@@ -1355,7 +1438,7 @@ func (t *Text) SetSelect(q0, q1 int) {
 		ticked = false
 		p1 = 0
 	}
-	if t.fr == nil {
+	if t.fr == nil || t.deferdraw {
 		return
 	}
 	if p0 > (t.fr.GetFrameFillStatus().Nchars) {
@@ -1383,7 +1466,7 @@ func (t *Text) Select23(high draw.Image, mask uint) (q0, q1 int, buts uint) {
 		q1 = p1 + t.org
 	}
 	for global.mousectl.Mouse.Buttons != 0 {
-		global.mousectl.Read()
+		global.readmouse()
 	}
 	return q0, q1, buts
 }
@@ -1404,6 +1487,22 @@ func (t *Text) Select3() (q0, q1 int, r bool) {
 	return q0, q1, buts == 0
 }
 
+// doubleclick returns the selection made by double-clicking at q. That's
+// what DoubleClick selects, unless q is in the selection made by the
+// previous double-click, which is then grown to the syntactic unit
+// enclosing it.
+func (t *Text) doubleclick(q int) (q0, q1 int) {
+	ok := false
+	if dbltext == t && dblq0 < dblq1 && clickq0 == dblq0 && clickq1 == dblq1 && dblq0 <= q && q <= dblq1 {
+		q0, q1, ok = t.ExpandSyntax(dblq0, dblq1)
+	}
+	if !ok {
+		q0, q1 = t.DoubleClick(q, q)
+	}
+	dbltext, dblq0, dblq1 = t, q0, q1
+	return q0, q1
+}
+
 func (t *Text) DoubleClick(inq0, inq1 int) (q0, q1 int) {
 	q0 = inq0
 	q1 = inq1
@@ -1626,6 +1725,22 @@ func (t *Text) BackNL(p, n int) int {
 	return p
 }
 
+// ScrollLines scrolls t down n lines, or up if n is negative.
+func (t *Text) ScrollLines(n int) {
+	for n > 0 {
+		m := min(n, max(t.fr.GetFrameFillStatus().Maxlines, 1))
+		q0 := t.org + t.fr.Charofpt(image.Pt(t.fr.Rect().Min.X, t.fr.Rect().Min.Y+m*t.fr.DefaultFontHeight()))
+		if q0 == t.org {
+			return
+		}
+		t.SetOrigin(q0, true)
+		n -= m
+	}
+	if n < 0 {
+		t.SetOrigin(t.BackNL(t.org, -n), true)
+	}
+}
+
 func (t *Text) SetOrigin(org int, exact bool) {
 	t.setorigin(t.fr, org, exact, false)
 }
@@ -1675,6 +1790,19 @@ func (t *Text) setorigin(fr frame.SelectScrollUpdater, org int, exact bool, call
 	}
 }
 
+// redrawdeferred ends deferred drawing and refills t's frame from the file.
+func (t *Text) redrawdeferred() {
+	t.deferdraw = false
+	if t.fr == nil {
+		return
+	}
+	t.fr.Delete(0, t.fr.GetFrameFillStatus().Nchars)
+	t.org = min(t.org, t.file.Nr())
+	t.fill(t.fr)
+	t.ScrDraw(t.fr.GetFrameFillStatus().Nchars)
+	t.SetSelect(t.q0, t.q1)
+}
+
 func (t *Text) Reset() {
 	t.eq0 = ^0
 	t.fr.Delete(0, t.fr.GetFrameFillStatus().Nchars)
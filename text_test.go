@@ -487,3 +487,28 @@ func TestTextTypeTabInTag(t *testing.T) {
 		return text
 	})
 }
+
+func TestTextCompleteWord(t *testing.T) {
+	body := "alphabet alpha alphanumeric\n\nal"
+	FlexiblyMakeWindowScaffold(t,
+		ScWin("/a/file"),
+		ScBody("/a/file", body),
+		ScBodyRange("/a/file", Range{len(body), len(body)}),
+		ScWin("/a/other"),
+		ScBody("/a/other", "alphanumeric alphanumeric alphonse al\n"),
+	)
+	text := &global.row.col[0].w[0].body
+
+	// /a doesn't exist, so ^F falls back to the words in the windows.
+	if got, want := string(text.Complete()), "ph"; got != want {
+		t.Errorf("Complete returned %q; want %q", got, want)
+	}
+
+	// Nearby and frequent words rank first; the word being completed
+	// isn't a candidate.
+	c := text.completeWord("al")
+	if diff := cmp.Diff([]string{"alphanumeric", "alpha", "alphabet", "alphonse"}, c.Filename); diff != "" {
+		t.Errorf("candidates mismatch (-want +got):\n%s", diff)
+	}
+
+}
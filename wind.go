@@ -2,15 +2,18 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"image"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/rjkroege/edwood/draw"
 	"github.com/rjkroege/edwood/file"
+	"github.com/rjkroege/edwood/runes"
 )
 
 type Window struct {
@@ -25,19 +28,27 @@ type Window struct {
 	filemenu   bool
 	autoindent bool
 	showdel    bool
+	dirty      bool // Put state last reported to the log file
 
 	id    int
 	addr  Range
+	addrs []Range // when the addr file holds several ranges, all of them; addr is the first
 	limit Range
 
 	nopen      [QMAX]byte // number of open Fid for each file in the file server
 	nomark     bool
+	txn        *bodyTxn // the transaction open on the body, if any
 	wrselrange Range
 	rdselfd    *os.File // temporary file for rdsel read requests
 
-	col    *Column
-	eventx *Xfid
-	events []byte
+	col        *Column
+	eventx     *Xfid
+	events     []byte
+	jsoneventx *Xfid
+	jsonevents []byte
+	changes    *changeFeed // started when the changes file is first opened
+	completer  *completer  // started when the complete file is first opened
+	outline    *outline    // the +Outline window following the body, if any
 
 	owner         int // TODO(fhs): change type to rune
 	maxlines      int
@@ -395,6 +406,23 @@ func (w *Window) Delete() {
 		w.eventx = nil
 		x.c <- nil // wake him up
 	}
+	x = w.jsoneventx
+	if x != nil {
+		w.jsonevents = w.jsonevents[0:0]
+		w.jsoneventx = nil
+		x.c <- nil
+	}
+	if w.changes != nil {
+		w.changes.close()
+	}
+	if w.completer != nil {
+		w.completer.close()
+	}
+	if w.txn != nil {
+		// The fid's transaction ends when it's committed, aborted or clunked.
+		w.txn = nil
+		w.body.deferdraw = false
+	}
 }
 
 func (w *Window) Undo(isundo bool) {
@@ -403,15 +431,125 @@ func (w *Window) Undo(isundo bool) {
 	if q0, q1, ok := body.file.Undo(isundo); ok {
 		body.q0, body.q1 = q0, q1
 	}
+	lspsync(body)
 
 	// TODO(rjk): Updates the scrollbar and selection.
 	// Be sure not to do this inside of the Undo operation's callbacks.
 	body.Show(body.q0, body.q1, true)
 }
 
+// bodyTxn is a transaction on a window's body, begun by writing begin to
+// the window's ctl file. It belongs to that ctl fid, and collects the
+// changes the same client makes through the fsys as one undo step. Any
+// other change to the body ends it, since the step can no longer be
+// undone alone.
+type bodyTxn struct {
+	fs     responder // the client's connection
+	attach int       // the client's attach on the connection
+	seq    int       // the undo seq of the transaction's changes
+	broken bool      // ended by another change
+}
+
+// BeginTxn starts a transaction owned by the ctl fid of x: the changes the
+// client then makes to the body through the fsys are undone as one and
+// are drawn only at CommitTxn or AbortTxn.
+func (w *Window) BeginTxn(x *Xfid) error {
+	if w.txn != nil {
+		return fmt.Errorf("transaction in progress")
+	}
+	w.Commit(&w.body)
+	global.seq++
+	w.body.file.Mark(global.seq)
+	w.txn = &bodyTxn{fs: x.fs, attach: x.f.attach, seq: global.seq}
+	x.f.txn = w.txn
+	w.body.deferdraw = true
+	return nil
+}
+
+// CommitTxn ends the transaction of the ctl fid f and draws its changes.
+func (w *Window) CommitTxn(f *Fid) error {
+	if f.txn == nil {
+		return fmt.Errorf("no transaction")
+	}
+	ended := f.txn != w.txn // by deleting the window or another change
+	f.txn = nil
+	if ended {
+		return nil
+	}
+	w.txn = nil
+	w.body.redrawdeferred()
+	return nil
+}
+
+// AbortTxn undoes the changes made to the body in the transaction of the
+// ctl fid f, and ends it. If another change ended the transaction, the
+// body is left alone.
+func (w *Window) AbortTxn(f *Fid) error {
+	if f.txn != nil && f.txn == w.txn {
+		if w.body.file.Seq() != f.txn.seq {
+			w.breakTxn()
+		} else {
+			for w.body.file.HasUndoableChanges() && w.body.file.UndoSeq() == f.txn.seq {
+				w.Undo(true)
+			}
+		}
+	}
+	if f.txn != nil && f.txn.broken {
+		f.txn = nil
+		return fmt.Errorf("transaction ended by another change")
+	}
+	return w.CommitTxn(f)
+}
+
+// breakTxn ends the transaction open on w because of another change to
+// the body, keeping its changes.
+func (w *Window) breakTxn() {
+	w.txn.broken = true
+	w.txn = nil
+	w.body.redrawdeferred()
+}
+
+// breaktxn ends any transaction open on a window of the file of t,
+// reporting whether one held the file's latest changes.
+func (t *Text) breaktxn() bool {
+	broke := false
+	t.file.AllObservers(func(i interface{}) {
+		if u, ok := i.(*Text); ok && u.w != nil && u == &u.w.body && u.w.txn != nil {
+			if u.file.Seq() == u.w.txn.seq {
+				broke = true
+			}
+			u.w.breakTxn()
+		}
+	})
+	return broke
+}
+
+// intxn reports whether a change to the body by the client of x belongs to
+// the transaction open on w. A change by anyone else, or one by the
+// client after others have changed the body, ends the transaction.
+func (w *Window) intxn(x *Xfid) bool {
+	if w.txn == nil {
+		return false
+	}
+	if w.txn.fs != x.fs || w.txn.attach != x.f.attach || w.body.file.Seq() != w.txn.seq {
+		w.breakTxn()
+		return false
+	}
+	return true
+}
+
 func (w *Window) SetName(name string) {
 	t := &w.body
+	old := t.file.Name()
 	t.file.SetName(name)
+	if old == "" || old == name {
+		return
+	}
+	t.file.AllObservers(func(i interface{}) {
+		if t, ok := i.(*Text); ok && t.w != nil {
+			xfidlog(t.w, "rename")
+		}
+	})
 }
 
 func (w *Window) Type(t *Text, r rune) {
@@ -485,7 +623,7 @@ func (w *Window) Clean(conservative bool) bool {
 	if w.body.file.IsDirOrScratch() { // don't whine if it's a guide file, error window, etc.
 		return true
 	}
-	if !conservative && w.nopen[QWevent] > 0 {
+	if !conservative && w.eventsopen() {
 		return true
 	}
 	if w.body.file.TreatAsDirty() {
@@ -518,13 +656,67 @@ func (w *Window) CtlPrint(fonts bool) string {
 	buf := fmt.Sprintf("%11d %11d %11d %11d %11d ", w.id, w.tag.Nc(),
 		w.body.Nc(), isdir, dirty)
 	if fonts {
-		// fsys exposes the actual physical font name.
-		buf = fmt.Sprintf("%s%11d %s %11d ", buf, w.body.fr.Rect().Dx(),
-			quote(fontget(w.body.font, w.display).Name()), w.body.fr.GetMaxtab())
+		// fsys exposes the actual physical font name. The body's origin
+		// follows acme's fields.
+		buf = fmt.Sprintf("%s%11d %s %11d %11d ", buf, w.body.fr.Rect().Dx(),
+			quote(fontget(w.body.font, w.display).Name()), w.body.fr.GetMaxtab(), w.body.org)
 	}
 	return buf
 }
 
+// WindowCtl holds the properties of a window served as JSON by the
+// fsys's acme/<id>/ctl.json and acme/index.json pseudo-files.
+type WindowCtl struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Tag        string `json:"tag"` // first line of the tag
+	TagLen     int    `json:"taglen"`
+	BodyLen    int    `json:"bodylen"`
+	IsDir      bool   `json:"isdir"`
+	Dirty      bool   `json:"dirty"`
+	Column     int    `json:"column"` // index of the column in the row, or -1
+	Q0         int    `json:"q0"`     // body selection
+	Q1         int    `json:"q1"`
+	Addr       [2]int `json:"addr"`
+	Width      int    `json:"width"`
+	Font       string `json:"font"`
+	Tab        int    `json:"tab"`
+	Origin     int    `json:"origin"` // first character shown in the body
+	AutoIndent bool   `json:"autoindent"`
+	CanUndo    bool   `json:"canundo"`
+	CanRedo    bool   `json:"canredo"`
+}
+
+// Ctl returns the window's properties for the JSON pseudo-files. col is
+// the index of the window's column, found with the row locked.
+func (w *Window) Ctl(col int) *WindowCtl {
+	tag := make([]rune, min(BUFSIZE/utf8.UTFMax, w.tag.Nc()))
+	w.tag.file.Read(0, tag)
+	if i := runes.IndexRune(tag, '\n'); i >= 0 {
+		tag = tag[:i]
+	}
+	return &WindowCtl{
+		ID:         w.id,
+		Name:       w.body.file.Name(),
+		Tag:        string(tag),
+		TagLen:     w.tag.Nc(),
+		BodyLen:    w.body.Nc(),
+		IsDir:      w.body.file.IsDir(),
+		Dirty:      w.body.file.Dirty(),
+		Column:     col,
+		Q0:         w.body.q0,
+		Q1:         w.body.q1,
+		Addr:       [2]int{w.addr.q0, w.addr.q1},
+		Width:      w.body.fr.Rect().Dx(),
+		Font:       fontget(w.body.font, w.display).Name(),
+		Tab:        w.body.fr.GetMaxtab(),
+		Origin:     w.body.org,
+		AutoIndent: w.autoindent,
+		CanUndo:    w.body.file.HasUndoableChanges(),
+		CanRedo:    w.body.file.HasRedoableChanges(),
+	}
+}
+
 func (w *Window) Eventf(format string, args ...interface{}) {
 	var (
 		x *Xfid
@@ -550,23 +742,98 @@ func (w *Window) Eventf(format string, args ...interface{}) {
 	}
 }
 
+// Event is a window event in the form read from and written to the
+// fsys's acme/<id>/event.json pseudo-file. Unlike in the event file, the
+// text is never elided and an Exec or Look event carries its expansion
+// and argument instead of being followed by further events.
+type Event struct {
+	Origin    string      `json:"origin"`
+	Type      string      `json:"type"`
+	Q0        int         `json:"q0"`
+	Q1        int         `json:"q1"`
+	Flag      int         `json:"flag"`
+	Text      string      `json:"text"`
+	Expansion *EventRange `json:"expansion,omitempty"`
+	Arg       string      `json:"arg,omitempty"`    // Exec argument
+	ArgLoc    string      `json:"argloc,omitempty"` // where the argument came from
+}
+
+// EventRange is the expansion of the text selected by a Look or Exec
+// event.
+type EventRange struct {
+	Q0   int    `json:"q0"`
+	Q1   int    `json:"q1"`
+	Text string `json:"text"`
+}
+
+// EventJSON queues ev for the reader of the event.json file.
+func (w *Window) EventJSON(ev *Event) {
+	if w.nopen[QWeventjson] == 0 {
+		return
+	}
+	if w.owner == 0 {
+		log.Panicf("acme: %s: %v\n", "no window owner", nil)
+	}
+	ev.Origin = string(rune(w.owner))
+	b, err := json.Marshal(ev)
+	if err != nil {
+		log.Panicf("acme: can't marshal event: %v", err)
+	}
+	w.jsonevents = append(w.jsonevents, b...)
+	w.jsonevents = append(w.jsonevents, '\n')
+	if x := w.jsoneventx; x != nil {
+		w.jsoneventx = nil
+		x.c <- nil
+	}
+}
+
+// eventsopen reports whether a client has opened the window's event or
+// event.json file and so handles its Look and Exec actions.
+func (w *Window) eventsopen() bool {
+	return w.nopen[QWevent] > 0 || w.nopen[QWeventjson] > 0
+}
+
 // ClampAddr clamps address range based on the body buffer.
 func (w *Window) ClampAddr() {
-	if w.addr.q0 < 0 {
-		w.addr.q0 = 0
+	clamp := func(a Range) Range {
+		return Range{
+			q0: max(0, min(a.q0, w.body.Nc())),
+			q1: max(0, min(a.q1, w.body.Nc())),
+		}
 	}
-	if w.addr.q1 < 0 {
-		w.addr.q1 = 0
+	w.addr = clamp(w.addr)
+	for i, a := range w.addrs {
+		w.addrs[i] = clamp(a)
 	}
-	if w.addr.q0 > w.body.Nc() {
-		w.addr.q0 = w.body.Nc()
+}
+
+// Addrs returns the ranges held by the addr file, in order.
+func (w *Window) Addrs() []Range {
+	if w.addrs != nil {
+		return w.addrs
 	}
-	if w.addr.q1 > w.body.Nc() {
-		w.addr.q1 = w.body.Nc()
+	return []Range{w.addr}
+}
+
+// SetAddrs sets the ranges held by the addr file. The ranges must be in
+// order and must not overlap.
+func (w *Window) SetAddrs(addrs []Range) {
+	w.addr = addrs[0]
+	w.addrs = nil
+	if len(addrs) > 1 {
+		w.addrs = addrs
 	}
 }
 
 func (w *Window) UpdateTag(newtagstatus file.TagStatus) {
 	// log.Printf("Window.UpdateTag, status %+v, %d", newtagstatus, global.seq)
+	if newtagstatus.SaveableAndDirty != w.dirty {
+		w.dirty = newtagstatus.SaveableAndDirty
+		if w.dirty {
+			xfidlog(w, "dirty")
+		} else {
+			xfidlog(w, "clean")
+		}
+	}
 	w.setTag1()
 }
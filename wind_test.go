@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"image"
 	"reflect"
 	"testing"
@@ -57,6 +58,50 @@ func TestSetTag1(t *testing.T) {
 	}
 }
 
+func TestWindowLogEvents(t *testing.T) {
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	global.configureGlobals(display)
+
+	x := &Xfid{f: &Fid{}}
+	xfidlogopen(x)
+	defer xfidlogclose(x)
+
+	w := NewWindow().initHeadless(nil)
+	w.display = display
+	w.body = Text{
+		display: display,
+		fr:      &MockFrame{},
+		file:    file.MakeObservableEditableBuffer("/a/old.go", nil),
+		w:       w,
+	}
+	w.body.file.AddObserver(&w.body)
+	w.tag = Text{
+		display: display,
+		fr:      &MockFrame{},
+		file:    file.MakeObservableEditableBuffer("", nil),
+	}
+	w.col = &Column{
+		safe: true,
+	}
+
+	w.UpdateTag(file.TagStatus{SaveableAndDirty: true})
+	w.UpdateTag(file.TagStatus{SaveableAndDirty: true, UndoableChanges: true})
+	w.SetName("/a/new.go")
+	w.UpdateTag(file.TagStatus{})
+
+	eventlog.lk.Lock()
+	got := append([]string{}, eventlog.ev[x.f.logoff-eventlog.start:]...)
+	eventlog.lk.Unlock()
+	want := []string{
+		fmt.Sprintf("%d dirty /a/old.go\n", w.id),
+		fmt.Sprintf("%d rename /a/new.go\n", w.id),
+		fmt.Sprintf("%d clean /a/new.go\n", w.id),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got log %q; want %q", got, want)
+	}
+}
+
 func TestWindowClampAddr(t *testing.T) {
 	const hello_世界 = "Hello, 世界"
 	runic_hello_世界 := []rune(hello_世界)
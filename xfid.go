@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -21,6 +25,7 @@ const Ctlsize = 5 * 12
 // Errors returned by file server.
 var (
 	ErrDeletedWin = fmt.Errorf("deleted window")
+	ErrDeletedCol = fmt.Errorf("deleted column")
 	ErrBadCtl     = fmt.Errorf("ill-formed control message")
 	ErrBadAddr    = fmt.Errorf("bad address syntax")
 	ErrAddrRange  = fmt.Errorf("address out of range")
@@ -49,6 +54,7 @@ func xfidflush(x *Xfid) {
 	// defer log.Println("done xfidflush")
 
 	xfidlogflush(x)
+	xfidsnarfflush(x)
 
 	// search windows for matching tag
 	global.row.lk.Lock()
@@ -56,13 +62,21 @@ func xfidflush(x *Xfid) {
 	for _, c := range global.row.col {
 		for _, w := range c.w {
 			w.Lock('E')
-			wx := w.eventx
-			if wx != nil && wx.fcall.Tag == x.fcall.Oldtag {
-				w.eventx = nil
-				wx.flushed = true
-				wx.c <- nil
-				w.Unlock()
-				goto out
+			if w.changes != nil {
+				xfidchangesflush(x, w.changes)
+			}
+			if w.completer != nil {
+				xfidcompleteflush(x, w.completer)
+			}
+			for _, eventx := range []**Xfid{&w.eventx, &w.jsoneventx} {
+				wx := *eventx
+				if wx != nil && wx.fs == x.fs && wx.fcall.Tag == x.fcall.Oldtag {
+					*eventx = nil
+					wx.flushed = true
+					wx.c <- nil
+					w.Unlock()
+					goto out
+				}
 			}
 			w.Unlock()
 		}
@@ -71,6 +85,36 @@ out:
 	x.respond(&plan9.Fcall{}, nil)
 }
 
+// xfidhangup ends the event, completion and log reads left blocked by a
+// client of fs that went away.
+func xfidhangup(fs *fileServer) {
+	xfidloghangup(fs)
+	xfidsnarfhangup(fs)
+
+	global.row.lk.Lock()
+	defer global.row.lk.Unlock()
+	for _, c := range global.row.col {
+		for _, w := range c.w {
+			w.Lock('E')
+			for _, eventx := range []**Xfid{&w.eventx, &w.jsoneventx} {
+				wx := *eventx
+				if wx != nil && wx.fs == fs {
+					*eventx = nil
+					wx.flushed = true
+					wx.c <- nil
+				}
+			}
+			if w.changes != nil {
+				xfidchangeshangup(fs, w.changes)
+			}
+			if w.completer != nil {
+				xfidcompletehangup(fs, w.completer)
+			}
+			w.Unlock()
+		}
+	}
+}
+
 // These variables are only used for testing.
 var (
 	testTempFileFail bool
@@ -90,14 +134,27 @@ func xfidopen(x *Xfid) {
 		switch q {
 		case QWaddr:
 			if w.nopen[q] == 0 {
-				w.addr = Range{0, 0}
+				w.SetAddrs([]Range{{0, 0}})
 				w.limit = Range{-1, -1}
 			}
 			w.nopen[q]++
 		case QWdata, QWxdata:
 			w.nopen[q]++
-		case QWevent:
-			if w.nopen[q] == 0 {
+		case QWchanges:
+			xfidchangesopen(x, w)
+		case QWcomplete:
+			// A window has one provider, which gets every request.
+			if w.nopen[q] > 0 {
+				w.Unlock()
+				x.respond(&fc, ErrInUse)
+				return
+			}
+			if w.completer == nil {
+				w.completer = newCompleter()
+			}
+			w.nopen[q]++
+		case QWevent, QWeventjson:
+			if !w.eventsopen() {
 				if !w.body.file.IsDir() && w.col != nil {
 					w.filemenu = false
 				}
@@ -134,8 +191,10 @@ func xfidopen(x *Xfid) {
 			w.rdselfd = tmp
 		case QWwrsel:
 			w.nopen[q]++
-			global.seq++
-			t.file.Mark(global.seq)
+			if !w.intxn(x) {
+				global.seq++
+				t.file.Mark(global.seq)
+			}
 			cut(t, t, nil, false, true, "")
 			w.wrselrange = Range{t.q1, t.q1}
 			w.nomark = true
@@ -159,6 +218,9 @@ func xfidopen(x *Xfid) {
 		switch q {
 		case Qlog:
 			xfidlogopen(x)
+		case Qsnarf:
+			x.f.snarf = nil
+			x.f.snarfeof = false
 		case Qeditout:
 			select {
 			case global.editoutlk <- true:
@@ -204,25 +266,31 @@ func xfidclose(x *Xfid) {
 				w.ctlfid = MaxFid
 				w.ctrllock.Unlock()
 			}
+			if x.f.txn != nil { // the client didn't commit
+				w.AbortTxn(x.f)
+			}
 		case QWdata, QWxdata:
 			w.nomark = false
 			fallthrough
 		case QWaddr:
 			fallthrough
-		case QWevent: // BUG: do we need to shut down Xfid?
+		case QWevent, QWeventjson: // BUG: do we need to shut down Xfid?
 			w.nopen[q]--
 			if w.nopen[q] == 0 {
 				if q == QWdata || q == QWxdata {
 					w.nomark = false
 				}
-				if q == QWevent && !w.body.file.IsDir() && w.col != nil {
-					w.filemenu = true
-				}
-				if q == QWevent {
+				if (q == QWevent || q == QWeventjson) && !w.eventsopen() {
+					if !w.body.file.IsDir() && w.col != nil {
+						w.filemenu = true
+					}
 					w.dumpstr = ""
 					w.dumpdir = ""
 				}
 			}
+		case QWcomplete:
+			w.nopen[q]--
+			x.f.completebuf, x.f.completewbuf = nil, nil
 		case QWrdsel:
 			w.rdselfd.Close()
 			w.rdselfd = nil
@@ -262,6 +330,18 @@ func xfidread(x *Xfid) {
 		case Qindex:
 			xfidindexread(x)
 			return
+		case Qindexjson:
+			xfidindexjsonread(x)
+			return
+		case Qctl:
+			xfidrowctlread(x)
+			return
+		case QCctl:
+			xfidcolctlread(x)
+			return
+		case Qsnarf:
+			xfidsnarfread(x)
+			return
 		case Qlog:
 			xfidlogread(x)
 			return
@@ -272,6 +352,20 @@ func xfidread(x *Xfid) {
 		x.respond(&fc, nil)
 		return
 	}
+	if q == QWchanges {
+		// Reads block without holding the window lock.
+		xfidchangesread(x, w.changes)
+		return
+	}
+	if q == QWcomplete {
+		// ^F holds the window lock while it waits for the provider.
+		xfidcompleteread(x, w.completer)
+		return
+	}
+	if q == QWctljson {
+		xfidctljsonread(x, w)
+		return
+	}
 	w.Lock('F')
 	defer w.Unlock()
 	if w.col == nil {
@@ -283,8 +377,11 @@ func xfidread(x *Xfid) {
 	case QWaddr:
 		w.body.Commit()
 		w.ClampAddr()
-		buf := fmt.Sprintf("%11d %11d ", w.addr.q0, w.addr.q1)
-		ninep.ReadString(&fc, &x.fcall, buf)
+		var sb strings.Builder
+		for _, a := range w.Addrs() {
+			fmt.Fprintf(&sb, "%11d %11d ", a.q0, a.q1)
+		}
+		ninep.ReadString(&fc, &x.fcall, sb.String())
 		x.respond(&fc, nil)
 
 	case QWbody:
@@ -294,7 +391,7 @@ func xfidread(x *Xfid) {
 		ninep.ReadString(&fc, &x.fcall, w.CtlPrint(true))
 		x.respond(&fc, nil)
 
-	case QWevent:
+	case QWevent, QWeventjson:
 		xfideventread(x, w)
 
 	case QWdata:
@@ -305,6 +402,7 @@ func xfidread(x *Xfid) {
 		}
 		w.addr.q0 += xfidruneread(x, &w.body, w.addr.q0, w.body.Nc())
 		w.addr.q1 = w.addr.q0
+		w.addrs = nil // reads use only the first range
 
 	case QWxdata:
 		// BUG: what should happen if q1 > q0?
@@ -313,6 +411,7 @@ func xfidread(x *Xfid) {
 			break
 		}
 		w.addr.q0 += xfidruneread(x, &w.body, w.addr.q0, w.addr.q1)
+		w.addrs = nil
 
 	case QWtag:
 		xfidutfread(x, &w.tag, w.tag.Nc(), int(QWtag))
@@ -337,6 +436,44 @@ func xfidread(x *Xfid) {
 	}
 }
 
+// xfidaddrs evaluates the addresses written to w's addr file, separated
+// by newlines. Each address is relative to the range of the one before, the
+// first to the current address, so that repeating /re/ finds successive
+// matches. It returns the ranges in order.
+func xfidaddrs(w *Window, data string) ([]Range, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var addrs []Range
+	a := w.addr
+	eval := true
+	for _, line := range strings.Split(data, "\n") {
+		r := []rune(line)
+		var nr int
+		a, eval, nr = address(false, &w.body, w.limit, a, 0, len(r),
+			func(q int) rune { return r[q] }, eval)
+		if len(r) == 0 || nr < len(r) {
+			return nil, ErrBadAddr
+		}
+		addrs = append(addrs, a)
+	}
+	if !eval {
+		return nil, ErrAddrRange
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i].q0 != addrs[j].q0 {
+			return addrs[i].q0 < addrs[j].q0
+		}
+		return addrs[i].q1 < addrs[j].q1
+	})
+	for i := 1; i < len(addrs); i++ {
+		if addrs[i].q0 < addrs[i-1].q1 {
+			return nil, fmt.Errorf("overlapping addresses")
+		}
+	}
+	return addrs, nil
+}
+
 func shouldscroll(t *Text, q0 int, qid uint64) bool {
 	if qid == Qcons {
 		return true
@@ -378,6 +515,12 @@ func xfidwrite(x *Xfid) {
 
 	qid := FILE(x.f.qid)
 	w := x.f.w
+	if w != nil && qid == QWcomplete {
+		// ^F holds the window lock while it waits for the provider.
+		x.fcall.Count = uint32(len(x.fcall.Data))
+		xfidcompletewrite(x, w.completer)
+		return
+	}
 	if w != nil {
 		c := 'F'
 		if qid == QWtag || qid == QWbody {
@@ -410,7 +553,7 @@ func xfidwrite(x *Xfid) {
 			if qid == QWtag {
 				t.Insert(q0, r, true)
 			} else {
-				if !w.nomark {
+				if !w.intxn(x) && !w.nomark {
 					global.seq++
 					t.file.Mark(global.seq)
 				}
@@ -449,22 +592,26 @@ func xfidwrite(x *Xfid) {
 		fc.Count = x.fcall.Count
 		x.respond(&fc, nil)
 
+	case Qctl:
+		xfidrowctlwrite(x)
+
+	case QCctl:
+		xfidcolctlwrite(x)
+
+	case Qsnarf:
+		xfidsnarfwrite(x)
+
 	case QWaddr:
-		r := []rune(string(x.fcall.Data))
 		t := &w.body
 		w.Commit(t)
-		eval := true
-		a, eval, nr := address(false, t, w.limit, w.addr, 0, len(r),
-			func(q int) rune { return r[q] }, eval)
-		if nr < len(r) {
-			x.respond(&fc, ErrBadAddr)
+		addrs, err := xfidaddrs(w, string(x.fcall.Data))
+		if err != nil {
+			x.respond(&fc, err)
 			break
 		}
-		if !eval {
-			x.respond(&fc, ErrAddrRange)
-			break
+		if len(addrs) > 0 {
+			w.SetAddrs(addrs)
 		}
-		w.addr = a
 		fc.Count = x.fcall.Count
 		x.respond(&fc, nil)
 
@@ -494,45 +641,62 @@ func xfidwrite(x *Xfid) {
 		xfidctlwrite(x, w)
 
 	case QWdata:
-		a := w.addr
+		addrs := w.Addrs()
 		t := &w.body
 		w.Commit(t)
-		if a.q0 > t.Nc() || a.q1 > t.Nc() {
+		if a := addrs[len(addrs)-1]; a.q0 > t.Nc() || a.q1 > t.Nc() {
 			x.respond(&fc, ErrAddrRange)
 			break
 		}
 		r, _, _ := util.Cvttorunes(x.fcall.Data, int(x.fcall.Count))
-		if !w.nomark {
+		if !w.intxn(x) && !w.nomark {
 			global.seq++
 			t.file.Mark(global.seq)
 		}
-		q0 := a.q0
-		if a.q1 > q0 {
-			t.Delete(q0, a.q1, true)
-			w.addr.q1 = q0
-		}
-		tq0 := t.q0
-		tq1 := t.q1
-		t.Insert(q0, r, true)
-		if tq0 >= q0 {
-			tq0 += len(r)
-		}
-		if tq1 >= q0 {
-			tq1 += len(r)
+		// Replace the ranges from last to first so that the earlier ones
+		// stay where they are.
+		for i := len(addrs) - 1; i >= 0; i-- {
+			a := addrs[i]
+			if a.q1 > a.q0 {
+				t.Delete(a.q0, a.q1, true)
+			}
+			tq0 := t.q0
+			tq1 := t.q1
+			t.Insert(a.q0, r, true)
+			if tq0 >= a.q0 {
+				tq0 += len(r)
+			}
+			if tq1 >= a.q0 {
+				tq1 += len(r)
+			}
+			t.SetSelect(tq0, tq1)
 		}
-		t.SetSelect(tq0, tq1)
+		q0 := addrs[0].q0
 		if shouldscroll(t, q0, qid) {
 			t.Show(q0+len(r), q0+len(r), false)
 		}
 		t.ScrDraw(t.fr.GetFrameFillStatus().Nchars)
-		w.addr.q0 += len(r)
-		w.addr.q1 = w.addr.q0
+		// Each range is now empty, after the text written to it.
+		next := make([]Range, len(addrs))
+		delta := 0
+		for i, a := range addrs {
+			q := a.q0 + delta + len(r)
+			next[i] = Range{q, q}
+			delta += len(r) - (a.q1 - a.q0)
+		}
+		w.SetAddrs(next)
 		fc.Count = x.fcall.Count
 		x.respond(&fc, nil)
 
+	case QWchanges:
+		xfidchangeswrite(x, w.changes)
+
 	case QWevent:
 		xfideventwrite(x, w)
 
+	case QWeventjson:
+		xfideventjsonwrite(x, w)
+
 	case QWtag:
 		updateText(&w.tag)
 
@@ -610,7 +774,7 @@ forloop:
 			}
 
 			// TODO(rjk): There should be some nicer way to do this.
-			if !w.nomark {
+			if !w.intxn(x) && !w.nomark {
 				global.seq++
 				w.body.file.Mark(global.seq)
 			}
@@ -658,8 +822,7 @@ forloop:
 			w.body.q1 = w.addr.q1
 			w.body.SetSelect(w.body.q0, w.body.q1)
 		case "addr=dot": // set addr
-			w.addr.q0 = w.body.q0
-			w.addr.q1 = w.body.q1
+			w.SetAddrs([]Range{{w.body.q0, w.body.q1}})
 		case "limit=addr": // set limit
 			w.body.Commit()
 			w.ClampAddr()
@@ -684,12 +847,133 @@ forloop:
 			// and the code in text.go should be appropriately structured to make it
 			// easy to reason about and to test.
 			// TODO(rjk): The premise is wrong. The first edit does not.
+		case "begin": // collect changes into one undo step, drawn at commit
+			if err = w.BeginTxn(x); err != nil {
+				break forloop
+			}
+		case "commit": // end the transaction and draw its changes
+			if err = w.CommitTxn(x.f); err != nil {
+				break forloop
+			}
+		case "abort": // undo the changes made in the transaction
+			if err = w.AbortTxn(x.f); err != nil {
+				break forloop
+			}
 		case "nomenu": // turn off automatic menu
 			w.filemenu = false
 		case "menu": // enable automatic menu
 			w.filemenu = true
 		case "cleartag": // wipe tag right of bar
 			w.ClearTag()
+		case "origin": // set the origin of the body
+			q, ok := ctlint(words)
+			if !ok {
+				err = ErrBadCtl
+				break forloop
+			}
+			if q < 0 || q > w.body.Nc() {
+				err = ErrAddrRange
+				break forloop
+			}
+			w.body.SetOrigin(q, true)
+		case "origin=addr": // put the line holding addr at the top
+			w.body.Commit()
+			w.ClampAddr()
+			w.body.SetOrigin(w.body.BackNL(w.addr.q0, 0), true)
+		case "scroll": // scroll the body by lines
+			n, ok := ctlint(words)
+			if !ok {
+				err = ErrBadCtl
+				break forloop
+			}
+			w.body.ScrollLines(n)
+		case "focus": // make current and move the mouse into the body
+			withrowlocked(w, func() {
+				if w.col == nil {
+					err = ErrDeletedWin
+					return
+				}
+				t := &w.body
+				if t.fr.GetFrameFillStatus().Maxlines == 0 { // obscured
+					w.col.Grow(w, 1)
+				}
+				global.activewin = w
+				global.activecol = w.col
+				global.barttext = t
+				w.display.MoveTo(t.fr.Ptofchar(getP0(t.fr)).Add(image.Pt(4, t.fr.DefaultFontHeight()-4)))
+			})
+			if err != nil {
+				break forloop
+			}
+		case "grow", "growmax", "zoom": // as buttons 1, 2 and 3 on the tag's box
+			but := 1
+			switch words[0] {
+			case "growmax":
+				but = 2
+			case "zoom":
+				but = 3
+			}
+			withrowlocked(w, func() {
+				if w.col == nil {
+					err = ErrDeletedWin
+					return
+				}
+				w.col.Grow(w, but)
+			})
+			if err != nil {
+				break forloop
+			}
+		case "height": // show n lines of the body
+			n, ok := ctlint(words)
+			if !ok || n < 0 {
+				err = ErrBadCtl
+				break forloop
+			}
+			withrowlocked(w, func() {
+				if w.col == nil {
+					err = ErrDeletedWin
+					return
+				}
+				if !w.col.SetLines(w, n) {
+					err = fmt.Errorf("can't resize window")
+				}
+			})
+			if err != nil {
+				break forloop
+			}
+		case "move": // move to column N at position pos
+			if len(words) < 2 {
+				err = ErrBadCtl
+				break forloop
+			}
+			args := strings.Fields(words[1])
+			if len(args) == 0 || len(args) > 2 {
+				err = ErrBadCtl
+				break forloop
+			}
+			i, e := strconv.Atoi(args[0])
+			if e != nil {
+				err = ErrBadCtl
+				break forloop
+			}
+			withrowlocked(w, func() {
+				if w.col == nil {
+					err = ErrDeletedWin
+					return
+				}
+				if i < 0 || i >= len(global.row.col) {
+					err = fmt.Errorf("no column %d", i)
+					return
+				}
+				pos := ""
+				if len(args) > 1 {
+					pos = args[1]
+				}
+				err = movewindow(w, global.row.col[i], pos)
+			})
+			if err != nil {
+				break forloop
+			}
 		case "font":
 			if len(words) < 2 {
 				err = ErrBadCtl
@@ -725,22 +1009,18 @@ forloop:
 	}
 }
 
+// ctlint parses the integer argument of a ctl message.
+func ctlint(words []string) (int, bool) {
+	if len(words) < 2 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(words[1]))
+	return n, err == nil
+}
+
 func xfideventwrite(x *Xfid, w *Window) {
 	var err error
 
-	// We can't lock row while we have a window locked
-	// because that can create deadlock with mousethread.
-	rowLock := func() {
-		defer w.Lock(w.owner)
-		w.Unlock() // sets w.owner to 0
-		global.row.lk.Lock()
-	}
-	rowUnlock := func() {
-		defer w.Lock(w.owner)
-		w.Unlock() // sets w.owner to 0
-		global.row.lk.Unlock()
-	}
-
 	// The messages have a fixed format: a character indicating the
 	// origin or cause of the action, a character indicating
 	// the type of the action, four free-format blank-terminated
@@ -751,7 +1031,6 @@ func xfideventwrite(x *Xfid, w *Window) {
 	// text, which may itself contain newlines.
 	// %c%c%d %d %d %d %s\n
 	lines := strings.Split(string(x.fcall.Data), "\n")
-forloop:
 	for _, events := range lines {
 		if events == "" {
 			continue
@@ -760,7 +1039,7 @@ forloop:
 			err = ErrBadEvent
 			break
 		}
-		w.owner = int(events[0])
+		owner := int(events[0])
 		c := events[1]
 		words := strings.Fields(events[2:])
 		if len(words) < 2 {
@@ -780,43 +1059,96 @@ forloop:
 			break
 		}
 		q1 := int(num)
+		if err = eventwrite(w, owner, c, q0, q1); err != nil {
+			break
+		}
+	}
 
-		var t *Text
-		switch {
-		case 'a' <= c && c <= 'z':
-			t = &w.tag
-		case 'A' <= c && c <= 'Z':
-			t = &w.body
-		default:
-			err = ErrBadEvent
-			break forloop
+	var fc plan9.Fcall
+	if err != nil {
+		fc.Count = 0
+	} else {
+		fc.Count = uint32(len(x.fcall.Data))
+	}
+	x.respond(&fc, err)
+}
+
+// xfideventjsonwrite hands back Look and Exec events written to
+// event.json as a sequence of JSON objects. Only the origin, type, q0 and
+// q1 of each Event are used.
+func xfideventjsonwrite(x *Xfid, w *Window) {
+	var err error
+	dec := json.NewDecoder(bytes.NewReader(x.fcall.Data))
+	for {
+		var ev Event
+		if err = dec.Decode(&ev); err == io.EOF {
+			err = nil
+			break
 		}
-		if q0 > t.Nc() || q1 > t.Nc() || q0 > q1 {
+		if err != nil || len(ev.Origin) != 1 || len(ev.Type) != 1 {
 			err = ErrBadEvent
 			break
 		}
+		if err = eventwrite(w, int(ev.Origin[0]), ev.Type[0], ev.Q0, ev.Q1); err != nil {
+			break
+		}
+	}
+
+	var fc plan9.Fcall
+	if err == nil {
+		fc.Count = uint32(len(x.fcall.Data))
+	}
+	x.respond(&fc, err)
+}
+
+// eventwrite performs the Look or Exec action of type c on q0, q1 for an
+// event handed back by the client of the event or event.json file.
+func eventwrite(w *Window, owner int, c byte, q0, q1 int) error {
+	w.owner = owner
+
+	var t *Text
+	switch {
+	case 'a' <= c && c <= 'z':
+		t = &w.tag
+	case 'A' <= c && c <= 'Z':
+		t = &w.body
+	default:
+		return ErrBadEvent
+	}
+	if q0 < 0 || q0 > t.Nc() || q1 > t.Nc() || q0 > q1 {
+		return ErrBadEvent
+	}
+	if c != 'x' && c != 'X' && c != 'l' && c != 'L' {
+		return ErrBadEvent
+	}
 
-		rowLock() // just like mousethread
+	withrowlocked(w, func() {
 		switch c {
 		case 'x', 'X':
 			execute(t, q0, q1, true, nil)
 		case 'l', 'L':
 			look3(t, q0, q1, true)
-		default:
-			rowUnlock()
-			err = ErrBadEvent
-			break forloop
 		}
-		rowUnlock()
-	}
+	})
+	return nil
+}
 
-	var fc plan9.Fcall
-	if err != nil {
-		fc.Count = 0
-	} else {
-		fc.Count = uint32(len(x.fcall.Data))
-	}
-	x.respond(&fc, err)
+// withrowlocked runs f with the row locked as well as window w. We can't
+// lock row while we have a window locked because that can create
+// deadlock with mousethread, so w is unlocked until the row is locked.
+// The window may have been deleted meanwhile.
+func withrowlocked(w *Window, f func()) {
+	func() {
+		defer w.Lock(w.owner)
+		w.Unlock() // sets w.owner to 0
+		global.row.lk.Lock()
+	}()
+	f()
+	func() {
+		defer w.Lock(w.owner)
+		w.Unlock() // sets w.owner to 0
+		global.row.lk.Unlock()
+	}()
 }
 
 // xfidutfread reads x.fcall.Count bytes from offset x.fcall.Offset in
@@ -937,31 +1269,35 @@ func xfideventread(x *Xfid, w *Window) {
 	// defer log.Println("done xfideventread")
 	var fc plan9.Fcall
 
+	events, eventx := &w.events, &w.eventx
+	if FILE(x.f.qid) == QWeventjson {
+		events, eventx = &w.jsonevents, &w.jsoneventx
+	}
 	i := 0
 	x.flushed = false
-	for len(w.events) == 0 {
+	for len(*events) == 0 {
 		if i != 0 {
 			if !x.flushed {
 				x.respond(&fc, fmt.Errorf("window shut down"))
 			}
 			return
 		}
-		w.eventx = x
+		*eventx = x
 		w.Unlock()
 		<-x.c
 		w.Lock('F')
 		i++
 	}
 
-	n := len(w.events)
+	n := len(*events)
 	if uint32(n) > x.fcall.Count {
 		n = int(x.fcall.Count)
 	}
 	fc.Count = uint32(n)
-	fc.Data = w.events[:n]
+	fc.Data = (*events)[:n]
 	x.respond(&fc, nil)
 
-	w.events = w.events[n:]
+	*events = (*events)[n:]
 }
 
 func xfidindexread(x *Xfid) {
@@ -1007,3 +1343,59 @@ func xfidindexread(x *Xfid) {
 	ninep.ReadString(&fc, &x.fcall, sb.String())
 	x.respond(&fc, nil)
 }
+
+// xfidindexjsonread serves index.json: the windows listed in index as a
+// JSON array of WindowCtl.
+func xfidindexjsonread(x *Xfid) {
+	var fc plan9.Fcall
+	b, err := json.Marshal(windowctls(nil))
+	if err != nil {
+		x.respond(&fc, err)
+		return
+	}
+	ninep.ReadString(&fc, &x.fcall, string(b)+"\n")
+	x.respond(&fc, nil)
+}
+
+// xfidctljsonread responds to x with the WindowCtl of w as JSON. The
+// row is locked before the window, as in mousethread, to find the
+// window's column.
+func xfidctljsonread(x *Xfid, w *Window) {
+	var fc plan9.Fcall
+	global.row.lk.Lock()
+	defer global.row.lk.Unlock()
+	w.Lock('F')
+	defer w.Unlock()
+	if w.col == nil {
+		x.respond(&fc, ErrDeletedWin)
+		return
+	}
+	b, err := json.Marshal(w.Ctl(global.row.colindex(w.col)))
+	if err != nil {
+		x.respond(&fc, err)
+		return
+	}
+	ninep.ReadString(&fc, &x.fcall, string(b)+"\n")
+	x.respond(&fc, nil)
+}
+
+// windowctls returns the WindowCtl of each window listed in index that
+// caps can reach.
+func windowctls(caps *fsysCaps) []*WindowCtl {
+	global.row.lk.Lock()
+	defer global.row.lk.Unlock()
+	ctls := []*WindowCtl{}
+	for i, c := range global.row.col {
+		for _, w := range c.w {
+			// only show the currently active window of a set
+			if w.body.file.GetCurObserver().(*Text) != &w.body {
+				continue
+			}
+			if !caps.canreach(plan9.Qid{Path: QID(w.id, Qdir)}) {
+				continue
+			}
+			ctls = append(ctls, w.Ctl(i))
+		}
+	}
+	return ctls
+}
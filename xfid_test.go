@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"image"
 	"os"
@@ -22,32 +23,39 @@ import (
 )
 
 func TestXfidallocthread(t *testing.T) {
-	global.cxfidalloc = make(chan *Xfid)
-	global.cxfidfree = make(chan *Xfid)
+	g := &globals{
+		cxfidalloc: make(chan chan *Xfid),
+		cxfidfree:  make(chan *Xfid),
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	d := (draw.Display)(nil)
 	done := make(chan struct{})
 	go func() {
-		xfidallocthread(global, ctx, d)
-		close(global.cxfidalloc)
-		close(global.cxfidfree)
-		global.cxfidalloc = nil
-		global.cxfidfree = nil
+		xfidallocthread(g, ctx, d)
 		close(done)
 	}()
 
-	global.cxfidalloc <- (*Xfid)(nil) // Request an xfid
-	x := <-global.cxfidalloc
-	if x == nil {
-		t.Errorf("Failed to get an Xfid")
+	// Requests from several goroutines at once each get their own Xfid.
+	xs := make(chan *Xfid)
+	for range 2 {
+		go func() {
+			c := make(chan *Xfid, 1)
+			g.cxfidalloc <- c // Request an xfid
+			xs <- <-c
+		}()
+	}
+	x, y := <-xs, <-xs
+	if x == nil || y == nil || x == y {
+		t.Errorf("Failed to get two Xfids: got %p and %p", x, y)
 	}
-	global.cxfidfree <- x
+	g.cxfidfree <- x
+	g.cxfidfree <- y
 
 	cancel() // Ask xfidallocthread to finish up.
 
-	// Wait for xfidallocthread to return and global channels to be reset.
+	// Wait for xfidallocthread to return.
 	<-done
 }
 
@@ -124,6 +132,40 @@ func (mr *mockResponder) respond(x *Xfid, t *plan9.Fcall, err error) *Xfid {
 
 func (mr *mockResponder) msize() int { return 8192 }
 
+// openFid opens the file q of window w or, if w is nil, the file q at
+// the root.
+func openFid(w *Window, q uint64) *Fid {
+	id := 0
+	if w != nil {
+		id = w.id
+	}
+	f := &Fid{qid: plan9.Qid{Path: QID(id, q)}, w: w}
+	xfidopen(&Xfid{f: f, fs: new(mockResponder)})
+	return f
+}
+
+// readFid reads up to count bytes at offset off of the file open on f.
+func readFid(f *Fid, off uint64, count uint32) (string, error) {
+	mr := new(mockResponder)
+	xfidread(&Xfid{
+		f:     f,
+		fcall: plan9.Fcall{Offset: off, Count: count},
+		fs:    mr,
+	})
+	return string(mr.fcall.Data), mr.err
+}
+
+// writeFid writes s at offset off to the file open on f.
+func writeFid(f *Fid, off uint64, s string) error {
+	mr := new(mockResponder)
+	xfidwrite(&Xfid{
+		f:     f,
+		fcall: plan9.Fcall{Offset: off, Data: []byte(s), Count: uint32(len(s))},
+		fs:    mr,
+	})
+	return mr.err
+}
+
 func TestXfidflush(t *testing.T) {
 	mr := new(mockResponder)
 	w1 := NewWindow().initHeadless(nil)
@@ -253,6 +295,54 @@ func TestXfidwriteQWaddr(t *testing.T) {
 	}
 }
 
+func TestXfidwriteQWaddrList(t *testing.T) {
+	s := newGoldenScene(t, image.Rect(0, 0, 800, 600), "one two one three one\n")
+	w := s.window(0)
+	addr := openFid(w, QWaddr)
+
+	for _, tc := range []struct {
+		addr string
+		err  error
+	}{
+		{"/one/\n/zzz/", ErrAddrRange},
+		{"/one/\n", ErrBadAddr},
+		{"#0,#3\n#2,#5", fmt.Errorf("overlapping addresses")},
+	} {
+		if err := writeFid(addr, 0, tc.addr); err == nil || err.Error() != tc.err.Error() {
+			t.Errorf("writing %q got error %v; want %v", tc.addr, err, tc.err)
+		}
+	}
+
+	// Each address is relative to the one before; the list is sorted.
+	if err := writeFid(addr, 0, "#18,#21\n#0,#3\n/one/"); err != nil {
+		t.Fatalf("writing addresses failed: %v", err)
+	}
+	want := fmt.Sprintf("%11d %11d %11d %11d %11d %11d ", 0, 3, 8, 11, 18, 21)
+	if got, err := readFid(addr, 0, 8192); err != nil || got != want {
+		t.Errorf("addr is %q, %v; want %q", got, err, want)
+	}
+
+	if err := writeFid(openFid(w, QWdata), 0, "1"); err != nil {
+		t.Fatalf("writing data failed: %v", err)
+	}
+	if err := writeFid(openFid(w, QWdata), 0, "!"); err != nil {
+		t.Fatalf("writing data failed: %v", err)
+	}
+	if got, want := w.body.file.String(), "1! two 1! three 1!\n"; got != want {
+		t.Errorf("body is %q; want %q", got, want)
+	}
+	want = fmt.Sprintf("%11d %11d %11d %11d %11d %11d ", 2, 2, 9, 9, 18, 18)
+	if got, err := readFid(addr, 0, 8192); err != nil || got != want {
+		t.Errorf("after writing data, addr is %q, %v; want %q", got, err, want)
+	}
+
+	// Each write to data is undone in one step.
+	w.Undo(true)
+	if got, want := w.body.file.String(), "1 two 1 three 1\n"; got != want {
+		t.Errorf("after undo, body is %q; want %q", got, want)
+	}
+}
+
 func TestXfidopen(t *testing.T) {
 	display := edwoodtest.NewDisplay(image.Rectangle{})
 	global.configureGlobals(display)
@@ -940,6 +1030,14 @@ func TestXfidwriteQWctl(t *testing.T) {
 		{ErrBadCtl, "font"},
 		{fmt.Errorf("nulls in font name"), "font /path/with/\x00nulls"},
 		{nil, "font /path/to/font"},
+		{ErrBadCtl, "origin"},
+		{ErrBadCtl, "origin x"},
+		{ErrAddrRange, "origin 1000"},
+		{ErrBadCtl, "scroll"},
+		{ErrBadCtl, "height -1"},
+		{ErrBadCtl, "move"},
+		{ErrBadCtl, "move x"},
+		{ErrBadCtl, "move 0 10 20"},
 	} {
 		t.Run(fmt.Sprintf("Data=%q", tc.data), func(t *testing.T) {
 			mr := new(mockResponder)
@@ -991,6 +1089,225 @@ func TestXfidwriteQWctl(t *testing.T) {
 	}
 }
 
+func TestXfidwriteQWctlLayout(t *testing.T) {
+	var body strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&body, "line %02d\n", i) // 8 runes per line
+	}
+	s := newGoldenScene(t, image.Rect(0, 0, 800, 600), body.String(), "other\n")
+	w0, w1 := s.window(0), s.window(1)
+	ctl := openFid(w0, QWctl)
+
+	for _, tc := range []struct {
+		msg  string
+		want int
+	}{
+		{"origin 7", 7},
+		{"origin 0\nscroll 3", 24},
+		{"scroll -1", 16},
+		{"scroll 1000", 800},
+		{"scroll -2", 784},
+	} {
+		if err := writeFid(ctl, 0, tc.msg); err != nil {
+			t.Fatalf("writing %q failed: %v", tc.msg, err)
+		}
+		if got := w0.body.org; got != tc.want {
+			t.Errorf("after %q, origin is %d; want %d", tc.msg, got, tc.want)
+		}
+		st, err := readFid(ctl, 0, 8192)
+		if err != nil {
+			t.Fatalf("reading ctl failed: %v", err)
+		}
+		if got := strings.Fields(st)[8]; got != fmt.Sprint(tc.want) {
+			t.Errorf("after %q, ctl reports origin %s; want %d", tc.msg, got, tc.want)
+		}
+	}
+
+	w0.addr = Range{400, 403} // in line 50
+	if err := writeFid(ctl, 0, "origin=addr"); err != nil {
+		t.Fatalf("origin=addr failed: %v", err)
+	}
+	if got, want := w0.body.org, 400; got != want {
+		t.Errorf("after origin=addr, origin is %d; want %d", got, want)
+	}
+
+	if err := writeFid(ctl, 0, "height 5"); err != nil {
+		t.Fatalf("height failed: %v", err)
+	}
+	if got, want := w0.body.fr.GetFrameFillStatus().Maxlines, 5; got != want {
+		t.Errorf("after height, window shows %d lines; want %d", got, want)
+	}
+
+	if err := writeFid(ctl, 0, "zoom"); err != nil {
+		t.Fatalf("zoom failed: %v", err)
+	}
+	if c := w0.col; c.w[0] != w0 || w0.r.Max.Y != c.r.Max.Y {
+		t.Errorf("zoomed window has rectangle %v in column %v", w0.r, c.r)
+	}
+
+	if err := writeFid(openFid(nil, Qctl), 0, "addcol"); err != nil {
+		t.Fatalf("addcol failed: %v", err)
+	}
+	w1ctl := openFid(w1, QWctl)
+	if err := writeFid(w1ctl, 0, "move 2"); err == nil || err.Error() != "no column 2" {
+		t.Errorf("move to missing column got error %v", err)
+	}
+	if err := writeFid(w1ctl, 0, "move 1 10\nfocus"); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	if w1.col != global.row.col[1] {
+		t.Errorf("window was not moved to column 1")
+	}
+	if global.activewin != w1 || global.activecol != w1.col || global.barttext != &w1.body {
+		t.Errorf("window was not focused")
+	}
+}
+
+func TestXfidwriteQWctlTxn(t *testing.T) {
+	s := newGoldenScene(t, image.Rect(0, 0, 800, 600), "hello\n")
+	w := s.window(0)
+	client, other := new(mockResponder), new(mockResponder)
+	ctl := &Fid{qid: plan9.Qid{Path: QID(w.id, QWctl)}, w: w, open: true}
+	// send writes data to the file q of w for the client of fs.
+	send := func(fs *mockResponder, f *Fid, data string) error {
+		xfidwrite(&Xfid{
+			f:     f,
+			fcall: plan9.Fcall{Data: []byte(data), Count: uint32(len(data))},
+			fs:    fs,
+		})
+		return fs.err
+	}
+	writeCtl := func(data string) error { return send(client, ctl, data) }
+	writefs := func(fs *mockResponder, q uint64, data string) {
+		t.Helper()
+		if err := send(fs, &Fid{qid: plan9.Qid{Path: QID(w.id, q)}, w: w}, data); err != nil {
+			t.Fatalf("write of %q failed: %v", data, err)
+		}
+	}
+	write := func(q uint64, data string) { t.Helper(); writefs(client, q, data) }
+	body := func(want string) {
+		t.Helper()
+		if got := w.body.file.String(); got != want {
+			t.Errorf("body is %q; want %q", got, want)
+		}
+	}
+	nchars := func() int { return w.body.fr.GetFrameFillStatus().Nchars }
+
+	if err := writeCtl("commit"); err == nil || err.Error() != "no transaction" {
+		t.Errorf("commit without begin got error %v", err)
+	}
+	if err := writeCtl("begin"); err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	if err := writeCtl("begin"); err == nil || err.Error() != "transaction in progress" {
+		t.Errorf("second begin got error %v", err)
+	}
+	write(QWbody, "world\n")
+	w.addr = Range{0, 5}
+	write(QWdata, "HELLO")
+	write(QWbody, "again\n")
+	body("HELLO\nworld\nagain\n")
+	if got, want := nchars(), 6; got != want {
+		t.Errorf("frame shows %d runes before commit; want %d", got, want)
+	}
+	if err := writeCtl("commit"); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if got, want := nchars(), w.body.Nc(); got != want {
+		t.Errorf("frame shows %d runes after commit; want %d", got, want)
+	}
+
+	// The transaction is undone in one step.
+	w.Undo(true)
+	body("hello\n")
+	w.Undo(false)
+
+	if err := writeCtl("begin"); err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	write(QWbody, "more\n")
+	w.addr = Range{0, 6}
+	write(QWdata, "")
+	if err := writeCtl("abort"); err != nil {
+		t.Fatalf("abort failed: %v", err)
+	}
+	body("HELLO\nworld\nagain\n")
+	if got, want := nchars(), w.body.Nc(); got != want {
+		t.Errorf("frame shows %d runes after abort; want %d", got, want)
+	}
+
+	// Aborting an empty transaction leaves the previous changes alone.
+	if err := writeCtl("begin\nabort"); err != nil {
+		t.Fatalf("begin and abort failed: %v", err)
+	}
+	body("HELLO\nworld\nagain\n")
+
+	// A change by another client ends the transaction, and abort leaves
+	// it alone, with the changes before and after it.
+	ended := "transaction ended by another change"
+	if err := writeCtl("begin"); err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	write(QWbody, "1\n")
+	writefs(other, QWbody, "2\n")
+	write(QWbody, "3\n")
+	if got, want := nchars(), w.body.Nc(); got != want {
+		t.Errorf("frame shows %d runes after another client's change; want %d", got, want)
+	}
+	if err := writeCtl("abort"); err == nil || err.Error() != ended {
+		t.Errorf("abort after another client's change got error %v", err)
+	}
+	body("HELLO\nworld\nagain\n1\n2\n3\n")
+	w.Undo(true)
+	body("HELLO\nworld\nagain\n1\n2\n")
+
+	// So does a change by another client sharing the connection.
+	if err := writeCtl("begin"); err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	write(QWbody, "3\n")
+	if err := send(client, &Fid{qid: plan9.Qid{Path: QID(w.id, QWbody)}, w: w, attach: ctl.attach + 1}, "4\n"); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := writeCtl("abort"); err == nil || err.Error() != ended {
+		t.Errorf("abort after a change on the same connection got error %v", err)
+	}
+	body("HELLO\nworld\nagain\n1\n2\n3\n4\n")
+
+	// And so does typing, even with no change since.
+	if err := writeCtl("begin"); err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	write(QWbody, "5\n")
+	w.body.SetSelect(w.body.Nc(), w.body.Nc())
+	w.body.Type('6')
+	if err := writeCtl("abort"); err == nil || err.Error() != ended {
+		t.Errorf("abort after typing got error %v", err)
+	}
+	body("HELLO\nworld\nagain\n1\n2\n3\n4\n5\n6")
+	w.Undo(true)
+	body("HELLO\nworld\nagain\n1\n2\n3\n4\n5\n")
+	for i := 0; i < 5; i++ { // 5, 4, 3, 2 and 1 each went in its own step
+		w.Undo(true)
+	}
+	body("HELLO\nworld\nagain\n")
+
+	// Clunking the ctl file ends the transaction, discarding its changes.
+	if err := writeCtl("begin"); err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	write(QWbody, "lost\n")
+	w.ref.Inc() // held by the open ctl fid
+	xfidclose(&Xfid{f: ctl, fs: client})
+	if w.txn != nil || ctl.txn != nil {
+		t.Errorf("transaction still open after clunk")
+	}
+	body("HELLO\nworld\nagain\n")
+	if got, want := nchars(), w.body.Nc(); got != want {
+		t.Errorf("frame shows %d runes after clunk; want %d", got, want)
+	}
+}
+
 func TestXfidwriteQWevent(t *testing.T) {
 	for _, tc := range []struct {
 		err  error
@@ -1030,6 +1347,102 @@ func TestXfidwriteQWevent(t *testing.T) {
 	}
 }
 
+func TestXfidwriteQWeventjson(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		data string
+	}{
+		{ErrBadEvent, `{`},
+		{ErrBadEvent, `{"origin":"M"}`},
+		{ErrBadEvent, `{"origin":"M","type":"L","q0":1,"q1":1}`},
+		{ErrBadEvent, `{"origin":"M","type":"L","q0":-1,"q1":0}`},
+		{ErrBadEvent, `{"origin":"","type":"L"}`},
+		{ErrBadEvent, `{"origin":"M","type":"%"}`},
+		{ErrBadEvent, `{"origin":"M","type":"z"}`},
+		{ErrBadEvent, `{"origin":"M","type":"X"} {"origin":"M","type":"Q"}`},
+		{nil, `{"origin":"M","type":"L","q0":0,"q1":0}`},
+		{nil, `{"origin":"M","type":"l"}`},
+		{nil, `{"origin":"M","type":"X","text":"ignored"}` + "\n" + `{"origin":"M","type":"x"}`},
+		{nil, "\n\n"},
+	} {
+		w := NewWindow().initHeadless(nil)
+		w.col = new(Column)
+		mr := new(mockResponder)
+		x := &Xfid{
+			fcall: plan9.Fcall{
+				Data:  []byte(tc.data),
+				Count: uint32(len(tc.data)),
+			},
+			f: &Fid{
+				qid: plan9.Qid{Path: QID(0, QWeventjson)},
+				w:   w,
+			},
+			fs: mr,
+		}
+		xfidwrite(x)
+		if got, want := mr.err, tc.err; got != want {
+			t.Errorf("event %q: got error %v; want %v", tc.data, got, want)
+		}
+	}
+}
+
+func TestEventJSONInsertDelete(t *testing.T) {
+	FlexiblyMakeWindowScaffold(t, ScWin("file"))
+	w := global.row.col[0].w[0]
+	w.owner = 'F'
+	w.nopen[QWeventjson] = 1
+	long := strings.Repeat("x", EVENTSIZE+1)
+
+	w.body.Insert(0, []rune("hello "+long), true)
+	w.body.Delete(0, 6, true)
+
+	var got []Event
+	dec := json.NewDecoder(bytes.NewReader(w.jsonevents))
+	for dec.More() {
+		var ev Event
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("bad JSON events %q: %v", w.jsonevents, err)
+		}
+		got = append(got, ev)
+	}
+	want := []Event{
+		{Origin: "F", Type: "I", Q0: 0, Q1: EVENTSIZE + 7, Text: "hello " + long},
+		{Origin: "F", Type: "D", Q0: 0, Q1: 6},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+	if len(w.events) != 0 {
+		t.Errorf("got events %q without an event reader", w.events)
+	}
+}
+
+func TestXfidreadQWeventjson(t *testing.T) {
+	w := NewWindow().initHeadless(nil)
+	w.col = new(Column)
+	w.events = []byte("text")
+	w.jsonevents = []byte("{}\n")
+	mr := new(mockResponder)
+	xfidread(&Xfid{
+		f: &Fid{
+			qid: plan9.Qid{Path: QID(1, QWeventjson)},
+			w:   w,
+		},
+		fcall: plan9.Fcall{Count: 100},
+		c:     make(chan func(*Xfid)),
+		fs:    mr,
+	})
+	if mr.err != nil {
+		t.Fatalf("got error %v; want nil", mr.err)
+	}
+	if got, want := string(mr.fcall.Data), "{}\n"; got != want {
+		t.Errorf("got data %q; want %q", got, want)
+	}
+	if len(w.jsonevents) != 0 || string(w.events) != "text" {
+		t.Errorf("events left are %q and %q", w.events, w.jsonevents)
+	}
+}
+
 // Issue https://github.com/rjkroege/edwood/issues/285
 func TestXfidwriteQWeventExecuteSend(t *testing.T) {
 	// Setup a new window with "Send" in the tag.
@@ -1307,7 +1720,7 @@ func TestXfidreadQWaddr(t *testing.T) {
 
 func TestXfidreadQWctl(t *testing.T) {
 	const prewant = "          1          32          14           0           0           0 "
-	const postwant = "           0 "
+	const postwant = "           0           0 "
 	want := prewant + edwoodtest.Plan9FontPath(edwoodtest.MockFontName) + postwant
 	if len(want) > 128 {
 		want = want[:128]
@@ -1558,3 +1971,98 @@ func readIndexFile(t *testing.T, filename string) []byte {
 	}
 	return replacePathsForTesting(t, b, false)
 }
+
+func TestXfidreadQWctljson(t *testing.T) {
+	global.WinID = 0
+	w := NewWindow().initHeadless(nil)
+	w.col = new(Column)
+	w.display = edwoodtest.NewDisplay(image.Rectangle{})
+	w.body.fr = &MockFrame{}
+	w.tag.file = file.MakeObservableEditableBuffer("", []rune(("/etc/hosts Del Snarf | Look Get \nsecond line")))
+	w.body.file = file.MakeObservableEditableBuffer("/etc/hosts", []rune("Hello, world!\n"))
+	w.body.q0, w.body.q1 = 7, 12
+
+	mr := new(mockResponder)
+	xfidread(&Xfid{
+		f: &Fid{
+			qid: plan9.Qid{Path: QID(1, QWctljson)},
+			w:   w,
+		},
+		fcall: plan9.Fcall{Count: 1024},
+		fs:    mr,
+	})
+	if mr.err != nil {
+		t.Fatalf("got error %v; want nil", mr.err)
+	}
+	var got WindowCtl
+	if err := json.Unmarshal(mr.fcall.Data, &got); err != nil {
+		t.Fatalf("bad JSON %q: %v", mr.fcall.Data, err)
+	}
+	want := WindowCtl{
+		ID:      1,
+		Name:    "/etc/hosts",
+		Tag:     "/etc/hosts Del Snarf | Look Get ",
+		TagLen:  44,
+		BodyLen: 14,
+		Column:  -1,
+		Q0:      7,
+		Q1:      12,
+		Font:    edwoodtest.Plan9FontPath(edwoodtest.MockFontName),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ctl.json mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestXfidreadQindexjson(t *testing.T) {
+	filename := editDumpFileForTesting(t, filepath.Join("testdata", "example.dump"))
+	defer os.Remove(filename)
+	setGlobalsForLoadTesting()
+	if err := global.row.Load(nil, filename, true); err != nil {
+		t.Fatalf("Row.Load failed: %v", err)
+	}
+
+	mr := new(mockResponder)
+	xfidread(&Xfid{
+		f: &Fid{
+			qid: plan9.Qid{Path: QID(0, Qindexjson)},
+		},
+		fcall: plan9.Fcall{Count: 1 << 16},
+		fs:    mr,
+	})
+	if mr.err != nil {
+		t.Fatalf("xfidindexjsonread returned error %v", mr.err)
+	}
+	var got []WindowCtl
+	if err := json.Unmarshal(mr.fcall.Data, &got); err != nil {
+		t.Fatalf("bad JSON %q: %v", mr.fcall.Data, err)
+	}
+
+	// index.json lists the same windows as index.
+	mr = new(mockResponder)
+	xfidread(&Xfid{
+		f: &Fid{
+			qid: plan9.Qid{Path: QID(0, Qindex)},
+		},
+		fcall: plan9.Fcall{Count: 1 << 16},
+		fs:    mr,
+	})
+	lines := strings.Split(strings.TrimSuffix(string(mr.fcall.Data), "\n"), "\n")
+	if len(got) != len(lines) {
+		t.Fatalf("index.json has %d windows; index has %d", len(got), len(lines))
+	}
+	for i, line := range lines {
+		var id, taglen, bodylen, isdir, dirty int
+		fmt.Sscan(line, &id, &taglen, &bodylen, &isdir, &dirty)
+		c := got[i]
+		if c.ID != id || c.TagLen != taglen || c.BodyLen != bodylen || c.IsDir != (isdir == 1) || c.Dirty != (dirty == 1) {
+			t.Errorf("index.json window %d is %+v; index has %q", i, c, line)
+		}
+		if !strings.HasSuffix(line, c.Tag) {
+			t.Errorf("index.json window %d has tag %q; index has %q", i, c.Tag, line)
+		}
+		if c.Column < 0 || global.row.col[c.Column] != global.row.LookupWin(c.ID).col {
+			t.Errorf("index.json window %d has wrong column %d", i, c.Column)
+		}
+	}
+}